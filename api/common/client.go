@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"fmt"
 	"io"
 	"net/http"
 	"time"
@@ -43,6 +42,22 @@ type Client struct {
 	// Authentication credentials
 	Username string
 	Password string
+
+	// Retry is the policy for transient failures, no retries by default
+	Retry RetryPolicy
+
+	// Limiter throttles the requests, nil sends them right away
+	Limiter *Limiter
+
+	// Middlewares wrap every attempt, see WithMiddleware
+	Middlewares []Middleware
+
+	// OperationMiddlewares wrap every request once, around its attempts, see
+	// WithOperationMiddleware
+	OperationMiddlewares []Middleware
+
+	// limits set by WithLimits, resolved to a shared Limiter once the base URL is known
+	limits *Limits
 }
 
 // Option is a function that configures a Client
@@ -97,80 +112,181 @@ func NewClient(username, password string, options ...Option) *Client {
 		option(client)
 	}
 
+	if client.limits != nil && client.Limiter == nil {
+		client.Limiter = SharedLimiter(client.BaseURL, client.Username, *client.limits)
+	}
+
 	return client
 }
 
-// Request makes an HTTP request to the Facturama API
+// Request makes an HTTP request to the Facturama API. Every attempt goes
+// through the client middlewares, transient failures are retried according
+// to the client RetryPolicy.
 func (c *Client) Request(ctx context.Context, method, path string, body interface{}, response interface{}) error {
 	const op = "common.Request"
 
+	resp, err := c.do(ctx, method, path, body, nil)
+	if err != nil {
+		return err
+	}
+
+	// Parse response if provided
+	if response != nil && resp != nil && len(resp.Body) > 0 {
+		if err := json.Unmarshal(resp.Body, response); err != nil {
+			return ez.New(op, ez.EINTERNAL, "Error unmarshaling response", err)
+		}
+	}
+
+	return nil
+}
+
+// Stream makes an HTTP request like Request but passes the body of a
+// successful response to read as it arrives instead of buffering it, for
+// large responses such as CFDI files. Middlewares see a Response without
+// Body. Attempts are retried only until read is called, a partly read
+// response is not sent twice.
+func (c *Client) Stream(ctx context.Context, method, path string, body interface{}, read func(io.Reader) error) error {
+	_, err := c.do(ctx, method, path, body, read)
+	return err
+}
+
+// do sends a request through the operation middlewares, which wrap the
+// attempts
+func (c *Client) do(ctx context.Context, method, path string, body interface{}, stream func(io.Reader) error) (*Response, error) {
+	handler := Handler(c.attempts)
+	for i := len(c.OperationMiddlewares) - 1; i >= 0; i-- {
+		handler = c.OperationMiddlewares[i](handler)
+	}
+
+	return handler(ctx, &Request{
+		Op:     OpFromContext(ctx),
+		Method: method,
+		Path:   path,
+		Body:   body,
+		Stream: stream,
+	})
+}
+
+// attempts sends a request through the middlewares, retrying transient failures
+func (c *Client) attempts(ctx context.Context, request *Request) (*Response, error) {
+	handler := c.chain(c.send)
+	retries := c.Retry.Retries(request.Method) && !retryDisabled(ctx)
+
+	started := false
+	stream := request.Stream
+	if read := stream; read != nil {
+		stream = func(r io.Reader) error {
+			started = true
+			return read(r)
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		req := &Request{
+			Op:      request.Op,
+			Method:  request.Method,
+			Path:    request.Path,
+			Body:    request.Body,
+			Header:  request.Header.Clone(),
+			Attempt: attempt,
+			Stream:  stream,
+		}
+
+		resp, err := handler(ctx, req)
+		if err == nil {
+			return resp, nil
+		}
+
+		if !retries || started || attempt >= c.Retry.MaxAttempts || !IsRetryable(err) {
+			return resp, err
+		}
+
+		// Stop retrying when the context is done, the last failure is more useful
+		if c.Retry.Wait(ctx, attempt, err) != nil {
+			return resp, err
+		}
+	}
+}
+
+// send is the innermost handler, it makes a single attempt of a request
+func (c *Client) send(ctx context.Context, request *Request) (*Response, error) {
+	const op = "common.Request"
+
 	// Create full URL
-	url := c.BaseURL + path
+	url := c.BaseURL + request.Path
 
 	// Create request body if provided
 	var bodyReader io.Reader
-	if body != nil {
-		bodyData, err := json.Marshal(body)
+	if request.Body != nil {
+		bodyData, err := json.Marshal(request.Body)
 		if err != nil {
-			return ez.New(op, ez.EINTERNAL, "Error marshaling request body request", err)
+			return nil, ez.New(op, ez.EINTERNAL, "Error marshaling request body request", err)
 		}
 		bodyReader = bytes.NewReader(bodyData)
 	}
 
+	// Wait for the limiter, the slot is held until the response is read
+	release, err := c.Limiter.Acquire(ctx)
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+	defer release()
+
 	// Create request
-	req, err := http.NewRequestWithContext(ctx, method, url, bodyReader)
+	req, err := http.NewRequestWithContext(ctx, request.Method, url, bodyReader)
 	if err != nil {
-		return ez.New(op, ez.EINTERNAL, "Error creating request", err)
+		return nil, ez.New(op, ez.EINTERNAL, "Error creating request", err)
+	}
+
+	// Add the headers set by middlewares, the credentials always win
+	for key, values := range request.Header {
+		req.Header[key] = values
 	}
 
 	// Create Basic Authentication header
 	req.SetBasicAuth(c.Username, c.Password)
 
 	// Add other headers
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "application/json")
+	if req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if req.Header.Get("Accept") == "" {
+		req.Header.Set("Accept", "application/json")
+	}
 
 	// Execute request
 	resp, err := c.HTTPClient.Do(req)
 	if err != nil {
-		return ez.New(op, ez.EINTERNAL, "Error executing request", err)
+		// Timeouts, refused and dropped connections are transient failures
+		return nil, ez.New(op, ez.EUNAVAILABLE, "Error executing request", err)
 	}
 	defer resp.Body.Close()
 
+	// Successful streamed responses are read by the caller
+	if request.Stream != nil && resp.StatusCode < 400 {
+		err = request.Stream(resp.Body)
+		if err != nil {
+			return nil, ez.Wrap(op, err)
+		}
+
+		return &Response{StatusCode: resp.StatusCode, Header: resp.Header}, nil
+	}
+
 	// Read response body
 	responseBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return ez.New(op, ez.EINTERNAL, "Error reading response body", err)
+		return nil, ez.New(op, ez.EUNAVAILABLE, "Error reading response body", err)
 	}
 
+	response := &Response{StatusCode: resp.StatusCode, Header: resp.Header, Body: responseBody}
+
 	// Check for error response
 	if resp.StatusCode >= 400 {
-
-		var errResp ErrorResponse
-		if err := json.Unmarshal(responseBody, &errResp); err != nil {
-			// fallback to raw string if JSON is malformed
-			return &APIError{
-				RawBody:    string(responseBody),
-				StatusCode: resp.StatusCode,
-			}
-		}
-
-		return &APIError{
-			ErrorResponse: errResp,
-			RawBody:       string(responseBody),
-			StatusCode:    resp.StatusCode,
-		}
-
+		apiErr := newAPIError(resp.StatusCode, resp.Header, responseBody)
+		return response, ez.New(op, apiErr.Code(), apiErr.Summary(), apiErr)
 	}
 
-	// Parse response if provided
-	if response != nil && len(responseBody) > 0 {
-		if err := json.Unmarshal(responseBody, response); err != nil {
-			return ez.New(op, ez.EINTERNAL, "Error unmarshaling response", err)
-		}
-	}
-
-	return nil
+	return response, nil
 }
 
 // Get makes a GET request to the API
@@ -192,39 +308,3 @@ func (c *Client) Put(ctx context.Context, path string, body, response interface{
 func (c *Client) Delete(ctx context.Context, path string) error {
 	return c.Request(ctx, http.MethodDelete, path, nil, nil)
 }
-
-// APIError represents an error returned by the Facturama API
-type APIError struct {
-	ErrorResponse // the structured error payload
-
-	// Original error response body
-	RawBody string
-
-	// HTTP status code
-	StatusCode int
-}
-
-// ErrorResponse models the JSON payload returned on HTTP 4xx/5xx
-type ErrorResponse struct {
-	Message    string     `json:"Message"`
-	ModelState ModelState `json:"ModelState"`
-}
-
-// ModelState captures all of the possible ModelState arrays.
-// Add or omit fields as needed; use `omitempty` so zero slices disappear.
-type ModelState struct {
-	Message     []string `json:"Message,omitempty"`
-	Certificate []string `json:"Certificate,omitempty"`
-	Key         []string `json:"Key,omitempty"`
-}
-
-// Error makes APIError implement the error interface.
-func (e *APIError) Error() string {
-	// you can format this however you like—here’s a concise version:
-	return fmt.Sprintf(
-		"Facturama API error %d: %s — %v",
-		e.StatusCode,
-		e.Message,
-		e.ModelState,
-	)
-}
@@ -0,0 +1,246 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vanclief/ez"
+)
+
+// APIError represents an error returned by the Facturama API. Client.Request
+// returns it as the cause of an ez error whose code is APIError.Code, use
+// AsAPIError to get it back.
+type APIError struct {
+	ErrorResponse // the structured error payload
+
+	// Original error response body
+	RawBody string
+
+	// HTTP status code
+	StatusCode int
+
+	// RetryAfter is the wait requested by the Retry-After header, if any
+	RetryAfter time.Duration
+}
+
+// ErrorResponse models the JSON payload returned on HTTP 4xx/5xx
+type ErrorResponse struct {
+	Message    string     `json:"Message"`
+	ModelState ModelState `json:"ModelState,omitempty"`
+}
+
+// ModelState holds the validation messages of the API keyed by field, e.g.
+// "Certificate", "Key" or "cfdiToCreate.Receiver.Rfc"
+type ModelState map[string][]string
+
+// Get returns the messages of a field. Keys are matched ignoring case and the
+// prefix of the request parameter, so "Receiver.Rfc" also matches
+// "cfdiToCreate.Receiver.Rfc".
+func (m ModelState) Get(field string) []string {
+	if messages, ok := m[field]; ok {
+		return messages
+	}
+
+	field = strings.ToLower(field)
+	for key, messages := range m {
+		key = strings.ToLower(key)
+		if key == field || strings.HasSuffix(key, "."+field) {
+			return messages
+		}
+	}
+
+	return nil
+}
+
+// Fields returns the fields with messages, sorted
+func (m ModelState) Fields() []string {
+	fields := make([]string, 0, len(m))
+	for field := range m {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	return fields
+}
+
+// Messages returns every message, sorted by field
+func (m ModelState) Messages() []string {
+	var messages []string
+	for _, field := range m.Fields() {
+		messages = append(messages, m[field]...)
+	}
+
+	return messages
+}
+
+// newAPIError builds the APIError of an HTTP error response, keeping the raw
+// body when the payload is not the expected JSON
+func newAPIError(statusCode int, header http.Header, body []byte) *APIError {
+	apiErr := &APIError{
+		RawBody:    string(body),
+		StatusCode: statusCode,
+		RetryAfter: parseRetryAfter(header.Get("Retry-After"), time.Now()),
+	}
+
+	// Malformed payloads only keep the raw body
+	_ = json.Unmarshal(body, &apiErr.ErrorResponse)
+
+	return apiErr
+}
+
+// Code returns the ez error code for the HTTP status of the error
+func (e *APIError) Code() string {
+	switch {
+	case e.StatusCode == http.StatusUnauthorized:
+		return ez.ENOTAUTHENTICATED
+	case e.StatusCode == http.StatusForbidden:
+		return ez.ENOTAUTHORIZED
+	case e.StatusCode == http.StatusNotFound:
+		return ez.ENOTFOUND
+	case e.StatusCode == http.StatusConflict:
+		return ez.ECONFLICT
+	case e.StatusCode == http.StatusTooManyRequests:
+		return ez.ERESOURCEEXHAUSTED
+	case e.StatusCode == http.StatusNotImplemented:
+		return ez.ENOTIMPLEMENTED
+	case e.StatusCode >= 500:
+		return ez.EUNAVAILABLE
+	case e.StatusCode >= 400:
+		return ez.EINVALID
+	default:
+		return ez.EINTERNAL
+	}
+}
+
+// Summary returns the message of the API followed by the ModelState messages
+func (e *APIError) Summary() string {
+	parts := []string{}
+	if e.Message != "" {
+		parts = append(parts, e.Message)
+	}
+	parts = append(parts, e.ModelState.Messages()...)
+
+	if len(parts) == 0 {
+		if e.RawBody != "" {
+			return e.RawBody
+		}
+
+		return http.StatusText(e.StatusCode)
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// satCodePattern matches the SAT validation codes, e.g. CFDI40147
+var satCodePattern = regexp.MustCompile(`CFDI\d{5}`)
+
+// SATCodes returns the SAT validation codes (CFDI40xxx) mentioned in the
+// message, the ModelState or the raw body of the error, sorted and without
+// duplicates
+func (e *APIError) SATCodes() []string {
+	var codes []string
+	seen := make(map[string]bool)
+
+	for _, text := range append([]string{e.Message, e.RawBody}, e.ModelState.Messages()...) {
+		for _, code := range satCodePattern.FindAllString(text, -1) {
+			if !seen[code] {
+				seen[code] = true
+				codes = append(codes, code)
+			}
+		}
+	}
+	sort.Strings(codes)
+
+	return codes
+}
+
+// Error makes APIError implement the error interface
+func (e *APIError) Error() string {
+	return fmt.Sprintf("Facturama API error %d: %s", e.StatusCode, e.Summary())
+}
+
+// AsAPIError returns the Facturama API error wrapped by err, if any
+func AsAPIError(err error) (*APIError, bool) {
+	for err != nil {
+		switch e := err.(type) {
+		case *APIError:
+			return e, true
+		case *ez.Error:
+			err = e.Err
+		default:
+			return nil, false
+		}
+	}
+
+	return nil, false
+}
+
+// IsNotFound reports whether the error is a missing resource (HTTP 404)
+func IsNotFound(err error) bool {
+	return ez.ErrorCode(err) == ez.ENOTFOUND
+}
+
+// IsAuth reports whether the error is an authentication or authorization
+// failure (HTTP 401 or 403), usually wrong credentials
+func IsAuth(err error) bool {
+	code := ez.ErrorCode(err)
+	return code == ez.ENOTAUTHENTICATED || code == ez.ENOTAUTHORIZED
+}
+
+// IsUnavailable reports whether the error is a transient failure: a transport
+// error or an HTTP 5xx
+func IsUnavailable(err error) bool {
+	return ez.ErrorCode(err) == ez.EUNAVAILABLE
+}
+
+// IsSATRejection reports whether the API rejected a CFDI because it breaks a
+// SAT validation rule, see SATCodes
+func IsSATRejection(err error) bool {
+	return len(SATCodes(err)) > 0
+}
+
+// SATCodes returns the SAT validation codes (CFDI40xxx) of an API error
+func SATCodes(err error) []string {
+	apiErr, ok := AsAPIError(err)
+	if !ok {
+		return nil
+	}
+
+	return apiErr.SATCodes()
+}
+
+// csdFields are the ModelState keys of CSD errors
+var csdFields = []string{"Certificate", "Key", "PrivateKey", "PrivateKeyPassword"}
+
+// csdKeywords are the words of the API messages about missing, invalid or
+// expired CSDs
+var csdKeywords = []string{"csd", "certificado", "sello digital", "llave privada"}
+
+// IsCSDError reports whether the error is caused by the CSD: an invalid
+// certificate, key or password, or a CSD missing or expired for the issuer
+func IsCSDError(err error) bool {
+	apiErr, ok := AsAPIError(err)
+	if !ok || apiErr.StatusCode >= 500 {
+		return false
+	}
+
+	for _, field := range csdFields {
+		if len(apiErr.ModelState.Get(field)) > 0 {
+			return true
+		}
+	}
+
+	summary := strings.ToLower(apiErr.Summary())
+	for _, keyword := range csdKeywords {
+		if strings.Contains(summary, keyword) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,100 @@
+package common_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vanclief/ez"
+	"github.com/vanclief/go-facturama/api/common"
+	"github.com/vanclief/go-facturama/api/facturamatest"
+)
+
+func TestErrorCodes(t *testing.T) {
+	srv := facturamatest.NewServer()
+	defer srv.Close()
+
+	client := common.NewClient(srv.Username, srv.Password, common.WithBaseURL(srv.URL))
+	ctx := context.Background()
+
+	err := client.Get(ctx, "/api-lite/csds/EKU9003173C9", nil)
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+	assert.True(t, common.IsNotFound(err))
+
+	err = common.NewClient("wrong", "wrong", common.WithBaseURL(srv.URL)).Get(ctx, "/api-lite/csds", nil)
+	assert.Equal(t, ez.ENOTAUTHENTICATED, ez.ErrorCode(err))
+	assert.True(t, common.IsAuth(err))
+
+	cases := map[int]string{
+		http.StatusBadRequest:          ez.EINVALID,
+		http.StatusForbidden:           ez.ENOTAUTHORIZED,
+		http.StatusConflict:            ez.ECONFLICT,
+		http.StatusTooManyRequests:     ez.ERESOURCEEXHAUSTED,
+		http.StatusInternalServerError: ez.EUNAVAILABLE,
+		http.StatusBadGateway:          ez.EUNAVAILABLE,
+	}
+	for status, code := range cases {
+		srv.InjectFailure(facturamatest.Failure{StatusCode: status})
+		err = client.Get(ctx, "/api-lite/csds", nil)
+		assert.Equal(t, code, ez.ErrorCode(err), status)
+
+		apiErr, ok := common.AsAPIError(err)
+		require.True(t, ok, status)
+		assert.Equal(t, status, apiErr.StatusCode)
+	}
+
+	// Transport failures are transient
+	srv.InjectFailure(facturamatest.Failure{Drop: true, Times: -1})
+	err = client.Get(ctx, "/api-lite/csds", nil)
+	assert.True(t, common.IsUnavailable(err))
+	_, ok := common.AsAPIError(err)
+	assert.False(t, ok)
+}
+
+func TestSATRejection(t *testing.T) {
+	srv := facturamatest.NewServer()
+	defer srv.Close()
+
+	client := common.NewClient(srv.Username, srv.Password, common.WithBaseURL(srv.URL))
+
+	srv.InjectFailure(facturamatest.Failure{
+		StatusCode: http.StatusBadRequest,
+		Response: &common.ErrorResponse{
+			Message: "La solicitud no es válida.",
+			ModelState: common.ModelState{
+				"cfdiToCreate.Receiver.Rfc": {"CFDI40145 - El campo Nombre del receptor, debe pertenecer al nombre asociado al RFC registrado en el campo Rfc del Receptor."},
+				"cfdiToCreate.Receiver":     {"CFDI40147 - El campo DomicilioFiscalReceptor no es válido. CFDI40145"},
+			},
+		},
+	})
+
+	err := client.Post(context.Background(), "/api-lite/3/cfdis", struct{}{}, nil)
+	assert.Equal(t, ez.EINVALID, ez.ErrorCode(err))
+	assert.True(t, common.IsSATRejection(err))
+	assert.False(t, common.IsCSDError(err))
+	assert.Equal(t, []string{"CFDI40145", "CFDI40147"}, common.SATCodes(err))
+
+	apiErr, ok := common.AsAPIError(err)
+	require.True(t, ok)
+	assert.Len(t, apiErr.ModelState.Get("Receiver.Rfc"), 1)
+	assert.Contains(t, ez.ErrorMessage(err), "CFDI40145")
+
+	// A CSD missing for the issuer
+	srv.InjectFailure(facturamatest.Failure{
+		StatusCode: http.StatusBadRequest,
+		Response:   &common.ErrorResponse{Message: "No se encontró el CSD del emisor EKU9003173C9"},
+	})
+	err = client.Post(context.Background(), "/api-lite/3/cfdis", struct{}{}, nil)
+	assert.True(t, common.IsCSDError(err))
+	assert.False(t, common.IsSATRejection(err))
+
+	// Malformed payloads keep the raw body
+	srv.InjectFailure(facturamatest.Failure{StatusCode: http.StatusBadGateway, RawBody: "<html>Bad gateway</html>"})
+	err = client.Get(context.Background(), "/api-lite/csds", nil)
+	apiErr, ok = common.AsAPIError(err)
+	require.True(t, ok)
+	assert.Equal(t, "<html>Bad gateway</html>", apiErr.RawBody)
+	assert.Equal(t, "<html>Bad gateway</html>", ez.ErrorMessage(err))
+}
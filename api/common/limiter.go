@@ -0,0 +1,245 @@
+package common
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/vanclief/ez"
+)
+
+// Limits configures the client side rate limiting of requests
+type Limits struct {
+	// Rate is the sustained number of requests per second, zero disables the rate limit
+	Rate float64
+	// Burst is the number of requests that can be sent at once after being
+	// idle, defaults to Rate rounded up
+	Burst int
+	// MaxInFlight is the maximum number of concurrent requests, zero disables the limit
+	MaxInFlight int
+}
+
+// LimiterStats are the counters of a Limiter
+type LimiterStats struct {
+	// Admitted is the number of requests allowed to be sent
+	Admitted int64
+	// Queued is the number of admitted requests that had to wait
+	Queued int64
+	// Canceled is the number of requests whose context was done while waiting
+	Canceled int64
+	// InFlight is the number of requests being sent
+	InFlight int
+	// QueuedTime is the total time requests spent waiting
+	QueuedTime time.Duration
+	// MaxQueuedTime is the longest time a request spent waiting
+	MaxQueuedTime time.Duration
+}
+
+// AverageQueuedTime returns the average time admitted requests spent waiting
+func (stats LimiterStats) AverageQueuedTime() time.Duration {
+	if stats.Admitted == 0 {
+		return 0
+	}
+
+	return stats.QueuedTime / time.Duration(stats.Admitted)
+}
+
+// Limiter combines a token bucket rate limiter with a limit of concurrent
+// requests. It is safe for concurrent use and can be shared by many clients.
+type Limiter struct {
+	limits Limits
+	slots  chan struct{}
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+	stats  LimiterStats
+}
+
+// NewLimiter creates a limiter that starts with a full bucket
+func NewLimiter(limits Limits) *Limiter {
+	if limits.Rate > 0 && limits.Burst <= 0 {
+		limits.Burst = int(math.Ceil(limits.Rate))
+	}
+
+	limiter := &Limiter{
+		limits: limits,
+		tokens: float64(limits.Burst),
+		last:   time.Now(),
+	}
+
+	if limits.MaxInFlight > 0 {
+		limiter.slots = make(chan struct{}, limits.MaxInFlight)
+	}
+
+	return limiter
+}
+
+// Limits returns the configuration of the limiter
+func (l *Limiter) Limits() Limits {
+	return l.limits
+}
+
+// Stats returns a snapshot of the limiter counters
+func (l *Limiter) Stats() LimiterStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.stats
+}
+
+// Acquire waits until a request can be sent and returns the function that
+// must be called once it finishes. It returns an ERESOURCEEXHAUSTED error if
+// the context is done first, in which case the request must not be sent.
+// A nil limiter admits every request immediately.
+func (l *Limiter) Acquire(ctx context.Context) (release func(), err error) {
+	const op = "common.Limiter.Acquire"
+
+	if l == nil {
+		return func() {}, nil
+	}
+
+	start := time.Now()
+	queued := false
+
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		default:
+			queued = true
+			select {
+			case l.slots <- struct{}{}:
+			case <-ctx.Done():
+				l.cancel()
+				return nil, ez.New(op, ez.ERESOURCEEXHAUSTED, "Context done while waiting for a request slot", ctx.Err())
+			}
+		}
+	}
+
+	if wait := l.reserve(); wait > 0 {
+		queued = true
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			l.unreserve()
+			l.freeSlot()
+			l.cancel()
+			return nil, ez.New(op, ez.ERESOURCEEXHAUSTED, "Context done while waiting for the rate limit", ctx.Err())
+		}
+	}
+
+	l.admit(queued, time.Since(start))
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			l.stats.InFlight--
+			l.mu.Unlock()
+			l.freeSlot()
+		})
+	}, nil
+}
+
+// reserve takes a token from the bucket and returns how long to wait until
+// it is available. Tokens may go negative, which queues later requests.
+func (l *Limiter) reserve() time.Duration {
+	if l.limits.Rate <= 0 {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.tokens = min(float64(l.limits.Burst), l.tokens+now.Sub(l.last).Seconds()*l.limits.Rate)
+	l.last = now
+
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-l.tokens / l.limits.Rate * float64(time.Second))
+}
+
+// unreserve gives back a token of a request that was not sent
+func (l *Limiter) unreserve() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.tokens++
+}
+
+// freeSlot releases the concurrency slot, if any
+func (l *Limiter) freeSlot() {
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+// admit records an admitted request
+func (l *Limiter) admit(queued bool, waited time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.stats.Admitted++
+	l.stats.InFlight++
+	if queued {
+		l.stats.Queued++
+		l.stats.QueuedTime += waited
+		l.stats.MaxQueuedTime = max(l.stats.MaxQueuedTime, waited)
+	}
+}
+
+// cancel records a request whose context was done while waiting
+func (l *Limiter) cancel() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.stats.Canceled++
+}
+
+// limiters are the limiters shared by clients, keyed by base URL and username
+var limiters = struct {
+	sync.Mutex
+	byKey map[string]*Limiter
+}{byKey: make(map[string]*Limiter)}
+
+// SharedLimiter returns the limiter shared by every client of the base URL
+// and username, creating it with the limits on first use. Later calls return
+// the existing limiter and ignore the limits.
+func SharedLimiter(baseURL, username string, limits Limits) *Limiter {
+	limiters.Lock()
+	defer limiters.Unlock()
+
+	key := baseURL + "\x00" + username
+	if limiter, ok := limiters.byKey[key]; ok {
+		return limiter
+	}
+
+	limiter := NewLimiter(limits)
+	limiters.byKey[key] = limiter
+
+	return limiter
+}
+
+// WithLimits limits the rate and concurrency of requests. The limiter is
+// shared by every client with the same base URL and username, so separate
+// multiemissor clients of the same account are throttled together.
+func WithLimits(limits Limits) Option {
+	return func(c *Client) {
+		c.limits = &limits
+	}
+}
+
+// WithLimiter makes the client use the given limiter
+func WithLimiter(limiter *Limiter) Option {
+	return func(c *Client) {
+		c.Limiter = limiter
+	}
+}
@@ -0,0 +1,103 @@
+package common_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vanclief/ez"
+	"github.com/vanclief/go-facturama/api/common"
+)
+
+func TestLimiterConcurrency(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := inFlight.Add(1)
+		defer inFlight.Add(-1)
+
+		for {
+			seen := maxInFlight.Load()
+			if current <= seen || maxInFlight.CompareAndSwap(seen, current) {
+				break
+			}
+		}
+
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	limits := common.Limits{MaxInFlight: 2}
+	first := common.NewClient("concurrency", "secret", common.WithBaseURL(srv.URL), common.WithLimits(limits))
+	second := common.NewClient("concurrency", "secret", common.WithBaseURL(srv.URL), common.WithLimits(limits))
+	other := common.NewClient("other", "secret", common.WithBaseURL(srv.URL), common.WithLimits(limits))
+
+	// Clients with the same credentials share the limiter
+	require.NotNil(t, first.Limiter)
+	assert.Same(t, first.Limiter, second.Limiter)
+	assert.NotSame(t, first.Limiter, other.Limiter)
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		client := first
+		if i%2 == 1 {
+			client = second
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, client.Get(context.Background(), "/api-lite/csds", nil))
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(2), maxInFlight.Load())
+
+	stats := first.Limiter.Stats()
+	assert.Equal(t, int64(8), stats.Admitted)
+	assert.Equal(t, 0, stats.InFlight)
+	assert.Positive(t, stats.Queued)
+	assert.Positive(t, stats.QueuedTime)
+	assert.GreaterOrEqual(t, stats.MaxQueuedTime, stats.AverageQueuedTime())
+}
+
+func TestLimiterRate(t *testing.T) {
+	limiter := common.NewLimiter(common.Limits{Rate: 50, Burst: 2})
+	ctx := context.Background()
+
+	start := time.Now()
+	for range 6 {
+		release, err := limiter.Acquire(ctx)
+		require.NoError(t, err)
+		release()
+	}
+
+	// The burst is immediate, the other 4 requests are spaced 20ms apart
+	assert.GreaterOrEqual(t, time.Since(start), 70*time.Millisecond)
+	assert.Equal(t, int64(4), limiter.Stats().Queued)
+
+	// Waiting stops when the context is done
+	slow := common.NewLimiter(common.Limits{Rate: 0.1, Burst: 1})
+	release, err := slow.Acquire(ctx)
+	require.NoError(t, err)
+	release()
+
+	timeout, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+
+	_, err = slow.Acquire(timeout)
+	assert.Equal(t, ez.ERESOURCEEXHAUSTED, ez.ErrorCode(err))
+	assert.Equal(t, int64(1), slow.Stats().Canceled)
+
+	// A nil limiter does not wait
+	var none *common.Limiter
+	release, err = none.Acquire(ctx)
+	require.NoError(t, err)
+	release()
+}
@@ -0,0 +1,189 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/vanclief/ez"
+)
+
+// Redacted replaces secret values in logged bodies and headers
+const Redacted = "[REDACTED]"
+
+// maxLoggedBody is the number of bytes of a body dumped in debug mode, CFDI
+// files are large base64 strings
+const maxLoggedBody = 4096
+
+// secretFields are the JSON keys whose values are never logged, compared
+// ignoring case: the CSD files and passwords of TaxEntityCSD and
+// CreateCSDRequest
+var secretFields = map[string]bool{
+	"certificate":        true,
+	"privatekey":         true,
+	"privatekeypassword": true,
+	"password":           true,
+}
+
+// secretHeaders are the headers whose values are never logged
+var secretHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+}
+
+// LoggingMiddleware logs every attempt with its op, method, path, status,
+// latency and, for API errors, the message, ModelState fields and SAT codes.
+// Successful attempts are logged at Info and failed ones at Error. In debug
+// mode the redacted request and response bodies are logged at Debug too.
+func LoggingMiddleware(logger *slog.Logger, debug bool) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, req *Request) (*Response, error) {
+			start := time.Now()
+			resp, err := next(ctx, req)
+
+			attrs := []slog.Attr{
+				slog.String("op", req.Op),
+				slog.String("method", req.Method),
+				slog.String("path", req.Path),
+				slog.Int("attempt", req.Attempt),
+				slog.Duration("latency", time.Since(start)),
+			}
+			if resp != nil {
+				attrs = append(attrs, slog.Int("status", resp.StatusCode))
+			}
+
+			level := slog.LevelInfo
+			if err != nil {
+				level = slog.LevelError
+				attrs = append(attrs, errorAttrs(err)...)
+			}
+
+			logger.LogAttrs(ctx, level, "facturama request", attrs...)
+
+			if debug && logger.Enabled(ctx, slog.LevelDebug) {
+				dump := []slog.Attr{
+					slog.String("op", req.Op),
+					slog.String("method", req.Method),
+					slog.String("path", req.Path),
+					slog.Any("request_headers", RedactHeader(req.Header)),
+				}
+				if req.Body != nil {
+					body, marshalErr := json.Marshal(req.Body)
+					if marshalErr == nil {
+						dump = append(dump, slog.String("request_body", RedactJSON(body)))
+					}
+				}
+				if resp != nil {
+					dump = append(dump,
+						slog.Any("response_headers", RedactHeader(resp.Header)),
+						slog.String("response_body", RedactJSON(resp.Body)))
+				}
+
+				logger.LogAttrs(ctx, slog.LevelDebug, "facturama exchange", dump...)
+			}
+
+			return resp, err
+		}
+	}
+}
+
+// WithLogger logs the requests of the client, see LoggingMiddleware
+func WithLogger(logger *slog.Logger, debug bool) Option {
+	return WithMiddleware(LoggingMiddleware(logger, debug))
+}
+
+// errorAttrs returns the log attributes of a failed attempt
+func errorAttrs(err error) []slog.Attr {
+	attrs := []slog.Attr{
+		slog.String("error", ez.ErrorMessage(err)),
+		slog.String("error_code", ez.ErrorCode(err)),
+	}
+
+	apiErr, ok := AsAPIError(err)
+	if !ok {
+		return attrs
+	}
+
+	if apiErr.Message != "" {
+		attrs = append(attrs, slog.String("api_message", apiErr.Message))
+	}
+	if len(apiErr.ModelState) > 0 {
+		attrs = append(attrs, slog.Any("model_state", apiErr.ModelState.Fields()))
+	}
+	if codes := apiErr.SATCodes(); len(codes) > 0 {
+		attrs = append(attrs, slog.Any("sat_codes", codes))
+	}
+
+	return attrs
+}
+
+// RedactJSON returns the JSON body with the values of secret fields, such as
+// the CSD certificate, private key and password, replaced by Redacted. Long
+// bodies are truncated, bodies that are not JSON are only truncated.
+func RedactJSON(body []byte) string {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err == nil {
+		redacted, err := json.Marshal(redactValue(value))
+		if err == nil {
+			body = redacted
+		}
+	}
+
+	if len(body) > maxLoggedBody {
+		return string(body[:maxLoggedBody]) + "...(truncated)"
+	}
+
+	return string(body)
+}
+
+// redactValue replaces the secret fields of a decoded JSON value
+func redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if secretFields[strings.ToLower(key)] {
+				v[key] = Redacted
+			} else {
+				v[key] = redactValue(field)
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactValue(item)
+		}
+	}
+
+	return value
+}
+
+// RedactHeader returns a copy of the header with credentials replaced by Redacted
+func RedactHeader(header http.Header) http.Header {
+	redacted := make(http.Header, len(header))
+	for key, values := range header {
+		if secretHeaders[http.CanonicalHeaderKey(key)] {
+			redacted[key] = []string{Redacted}
+		} else {
+			redacted[key] = values
+		}
+	}
+
+	return redacted
+}
+
+// LogValue implements slog.LogValuer so logging a client never shows the password
+func (c *Client) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("base_url", c.BaseURL),
+		slog.String("username", c.Username),
+		slog.String("password", Redacted),
+	)
+}
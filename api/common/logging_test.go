@@ -0,0 +1,75 @@
+package common_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vanclief/go-facturama/api/common"
+	"github.com/vanclief/go-facturama/api/facturamatest"
+	"github.com/vanclief/go-facturama/api/models"
+)
+
+func TestLogging(t *testing.T) {
+	srv := facturamatest.NewServer()
+	defer srv.Close()
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	client := common.NewClient(srv.Username, srv.Password,
+		common.WithBaseURL(srv.URL),
+		common.WithMiddleware(common.HeaderMiddleware(http.Header{"Authorization": {"Bearer token"}})),
+		common.WithLogger(logger, true),
+	)
+
+	csd := models.TaxEntityCSD{
+		RFC:                "EKU9003173C9",
+		Certificate:        "Y2VydGlmaWNhdGU=",
+		PrivateKey:         "cHJpdmF0ZSBrZXk=",
+		PrivateKeyPassword: "12345678a",
+	}
+
+	ctx := common.WithOp(context.Background(), "multiemissor.CreateCSD")
+	require.NoError(t, client.Post(ctx, "/api-lite/csds", csd, nil))
+	require.NoError(t, client.Get(ctx, "/api-lite/csds/EKU9003173C9", nil))
+
+	srv.InjectFailure(facturamatest.Failure{
+		StatusCode: http.StatusBadRequest,
+		Response: &common.ErrorResponse{
+			Message:    "La solicitud no es válida.",
+			ModelState: common.ModelState{"cfdiToCreate.Receiver.Rfc": {"CFDI40145 - El RFC del receptor no es válido."}},
+		},
+	})
+	assert.Error(t, client.Get(ctx, "/api-lite/csds", nil))
+
+	logs := buf.String()
+	for _, secret := range []string{csd.Certificate, csd.PrivateKey, csd.PrivateKeyPassword, srv.Password, "Bearer token"} {
+		assert.NotContains(t, logs, secret)
+	}
+
+	assert.Contains(t, logs, `"op":"multiemissor.CreateCSD"`)
+	assert.Contains(t, logs, `"status":200`)
+	assert.Contains(t, logs, `"request_body":"{\"Certificate\":\"[REDACTED]\"`)
+	assert.Contains(t, logs, `"sat_codes":["CFDI40145"]`)
+	assert.Contains(t, logs, `"model_state":["cfdiToCreate.Receiver.Rfc"]`)
+
+	// Logging the client hides the password
+	buf.Reset()
+	logger.Info("client", "client", client)
+	assert.Contains(t, buf.String(), `"password":"[REDACTED]"`)
+}
+
+func TestRedactJSON(t *testing.T) {
+	body := `[{"Rfc":"EKU9003173C9","privateKey":"secret","Total":1234.567890123456789,"Nested":{"Password":"secret"}}]`
+	assert.Equal(t,
+		`[{"Nested":{"Password":"[REDACTED]"},"Rfc":"EKU9003173C9","Total":1234.567890123456789,"privateKey":"[REDACTED]"}]`,
+		common.RedactJSON([]byte(body)))
+
+	assert.Equal(t, "<html>Bad gateway</html>", common.RedactJSON([]byte("<html>Bad gateway</html>")))
+	assert.Len(t, common.RedactJSON(bytes.Repeat([]byte("a"), 10000)), 4096+len("...(truncated)"))
+}
@@ -0,0 +1,142 @@
+package common
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Request is a request to the Facturama API as seen by middlewares. Middlewares
+// may change it before calling the next handler.
+type Request struct {
+	// Op is the operation that made the request, e.g. multiemissor.CreateCfdiV4,
+	// see WithOp
+	Op string
+	// Method is the HTTP method
+	Method string
+	// Path is the path and query relative to the base URL
+	Path string
+	// Body is the value sent as JSON, nil for requests without a body
+	Body interface{}
+	// Header holds extra headers sent with the request
+	Header http.Header
+	// Attempt is the attempt number, starting at 1, see RetryPolicy. It is
+	// zero for operation middlewares.
+	Attempt int
+	// Stream reads the body of a successful response, which is then not
+	// buffered in Response.Body, see Client.Stream
+	Stream func(body io.Reader) error
+}
+
+// Response is a response of the Facturama API as seen by middlewares
+type Response struct {
+	// StatusCode is the HTTP status code
+	StatusCode int
+	// Header holds the response headers
+	Header http.Header
+	// Body is the raw response body, nil for streamed responses
+	Body []byte
+}
+
+// Handler sends a request. For HTTP error statuses it returns the response
+// along with the error, transport failures return a nil response.
+type Handler func(ctx context.Context, req *Request) (*Response, error)
+
+// Middleware wraps a handler to observe or change requests and responses.
+// Middlewares run on every attempt, after the retry policy and before the
+// limiter.
+type Middleware func(next Handler) Handler
+
+// WithMiddleware adds middlewares to the client. The first middleware is the
+// outermost, it sees the request first and the response last.
+func WithMiddleware(middlewares ...Middleware) Option {
+	return func(c *Client) {
+		c.Middlewares = append(c.Middlewares, middlewares...)
+	}
+}
+
+// WithOperationMiddleware adds middlewares that run once per request, around
+// the retries, e.g. to trace the request as a whole. Their Request has no
+// Attempt and the headers they set are sent with every attempt. The first
+// middleware is the outermost.
+func WithOperationMiddleware(middlewares ...Middleware) Option {
+	return func(c *Client) {
+		c.OperationMiddlewares = append(c.OperationMiddlewares, middlewares...)
+	}
+}
+
+// chain wraps the handler with the client middlewares
+func (c *Client) chain(handler Handler) Handler {
+	for i := len(c.Middlewares) - 1; i >= 0; i-- {
+		handler = c.Middlewares[i](handler)
+	}
+
+	return handler
+}
+
+type opKey struct{}
+
+// WithOp returns a context that names the operation of the requests made with
+// it, so middlewares can tell them apart. Client methods set it to their op.
+func WithOp(ctx context.Context, op string) context.Context {
+	return context.WithValue(ctx, opKey{}, op)
+}
+
+// OpFromContext returns the operation set with WithOp, if any
+func OpFromContext(ctx context.Context) string {
+	op, _ := ctx.Value(opKey{}).(string)
+	return op
+}
+
+// HeaderMiddleware sets the headers on every request, e.g. a User-Agent
+func HeaderMiddleware(header http.Header) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, req *Request) (*Response, error) {
+			if req.Header == nil {
+				req.Header = make(http.Header)
+			}
+			for key, values := range header {
+				req.Header[http.CanonicalHeaderKey(key)] = values
+			}
+
+			return next(ctx, req)
+		}
+	}
+}
+
+// TimeoutMiddleware limits the duration of each attempt. Unlike the HTTP
+// client timeout it leaves time for the retries within the caller deadline.
+func TimeoutMiddleware(timeout time.Duration) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, req *Request) (*Response, error) {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			return next(ctx, req)
+		}
+	}
+}
+
+// Exchange is a finished attempt, as reported by ObserveMiddleware
+type Exchange struct {
+	Request  *Request
+	Response *Response
+	Err      error
+	Duration time.Duration
+}
+
+// ObserveMiddleware calls the function after every attempt, for metrics or
+// auditing. The function must not modify the exchange.
+func ObserveMiddleware(observe func(ctx context.Context, exchange Exchange)) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, req *Request) (*Response, error) {
+			start := time.Now()
+			resp, err := next(ctx, req)
+
+			observe(ctx, Exchange{Request: req, Response: resp, Err: err, Duration: time.Since(start)})
+
+			return resp, err
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package common_test
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vanclief/go-facturama/api/common"
+	"github.com/vanclief/go-facturama/api/facturamatest"
+	"github.com/vanclief/go-facturama/api/models"
+)
+
+func TestMiddleware(t *testing.T) {
+	srv := facturamatest.NewServer(facturamatest.WithCSD(models.TaxEntityCSD{RFC: "EKU9003173C9"}))
+	defer srv.Close()
+
+	var mu sync.Mutex
+	var order []string
+	var exchanges []common.Exchange
+
+	trace := func(name string) common.Middleware {
+		return func(next common.Handler) common.Handler {
+			return func(ctx context.Context, req *common.Request) (*common.Response, error) {
+				mu.Lock()
+				order = append(order, name)
+				mu.Unlock()
+
+				return next(ctx, req)
+			}
+		}
+	}
+
+	// A middleware that rewrites the path of a request
+	rewrite := func(next common.Handler) common.Handler {
+		return func(ctx context.Context, req *common.Request) (*common.Response, error) {
+			if req.Path == "/old/csds" {
+				req.Path = "/api-lite/csds"
+			}
+
+			return next(ctx, req)
+		}
+	}
+
+	policy := fastRetry()
+	client := common.NewClient(srv.Username, srv.Password,
+		common.WithBaseURL(srv.URL),
+		common.WithRetry(policy),
+		common.WithMiddleware(trace("outer"), trace("inner"), rewrite),
+		common.WithOperationMiddleware(trace("operation"), func(next common.Handler) common.Handler {
+			return func(ctx context.Context, req *common.Request) (*common.Response, error) {
+				assert.Zero(t, req.Attempt)
+				req.Header = http.Header{"X-Operation": {req.Op}}
+				return next(ctx, req)
+			}
+		}),
+		common.WithMiddleware(common.ObserveMiddleware(func(ctx context.Context, exchange common.Exchange) {
+			mu.Lock()
+			defer mu.Unlock()
+			exchanges = append(exchanges, exchange)
+		})),
+	)
+
+	ctx := common.WithOp(context.Background(), "test.ListCSDs")
+	srv.InjectFailure(facturamatest.Failure{StatusCode: http.StatusServiceUnavailable})
+
+	var csds []models.TaxEntityCSD
+	require.NoError(t, client.Get(ctx, "/old/csds", &csds))
+	assert.Len(t, csds, 1)
+
+	// Operation middlewares run once around the attempts, middlewares run in
+	// order on every attempt
+	assert.Equal(t, []string{"operation", "outer", "inner", "outer", "inner"}, order)
+	require.Len(t, exchanges, 2)
+
+	failed := exchanges[0]
+	assert.Equal(t, "test.ListCSDs", failed.Request.Op)
+	assert.Equal(t, "/api-lite/csds", failed.Request.Path)
+	assert.Equal(t, 1, failed.Request.Attempt)
+	assert.Equal(t, "test.ListCSDs", failed.Request.Header.Get("X-Operation"))
+	assert.Equal(t, http.StatusServiceUnavailable, failed.Response.StatusCode)
+	assert.True(t, common.IsUnavailable(failed.Err))
+
+	assert.Equal(t, 2, exchanges[1].Request.Attempt)
+	assert.Equal(t, "test.ListCSDs", exchanges[1].Request.Header.Get("X-Operation"))
+	assert.Equal(t, http.StatusOK, exchanges[1].Response.StatusCode)
+	assert.NoError(t, exchanges[1].Err)
+	assert.Positive(t, exchanges[1].Duration)
+}
+
+func TestBuiltinMiddlewares(t *testing.T) {
+	var mu sync.Mutex
+	var userAgent string
+
+	srv := facturamatest.NewServer()
+	defer srv.Close()
+
+	capture := func(next common.Handler) common.Handler {
+		return func(ctx context.Context, req *common.Request) (*common.Response, error) {
+			mu.Lock()
+			userAgent = req.Header.Get("User-Agent")
+			mu.Unlock()
+
+			return next(ctx, req)
+		}
+	}
+
+	client := common.NewClient(srv.Username, srv.Password,
+		common.WithBaseURL(srv.URL),
+		common.WithMiddleware(
+			common.HeaderMiddleware(http.Header{"user-agent": {"billing/1.0"}}),
+			capture,
+			common.TimeoutMiddleware(20*time.Millisecond),
+		),
+	)
+
+	ctx := context.Background()
+	require.NoError(t, client.Get(ctx, "/api-lite/csds", nil))
+	assert.Equal(t, "billing/1.0", userAgent)
+
+	// The attempt times out before the delayed response
+	srv.InjectFailure(facturamatest.Failure{Delay: 200 * time.Millisecond, StatusCode: http.StatusOK})
+	err := client.Get(ctx, "/api-lite/csds", nil)
+	assert.True(t, common.IsUnavailable(err))
+}
@@ -0,0 +1,161 @@
+package common
+
+import (
+	"context"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vanclief/ez"
+)
+
+// Default values of the retry policy
+const (
+	// DefaultMaxAttempts is the number of attempts, including the first one
+	DefaultMaxAttempts = 3
+	// DefaultInitialBackoff is the wait before the first retry
+	DefaultInitialBackoff = 500 * time.Millisecond
+	// DefaultMaxBackoff caps the wait between attempts
+	DefaultMaxBackoff = 10 * time.Second
+	// DefaultJitter is the random fraction subtracted from each wait
+	DefaultJitter = 0.2
+)
+
+// RetryPolicy configures how Client.Request retries transient failures:
+// transport errors, HTTP 5xx and HTTP 429. The zero value makes a single
+// attempt.
+//
+// Only idempotent methods should be retried. A POST that times out may have
+// been processed: CreateCfdiV4 retries only when Methods includes POST,
+// looking up the CFDI before posting it again, and sends each attempt
+// WithoutRetry.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts, including the first one. Values
+	// below 2 disable retries
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry, doubled on each retry
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait between attempts, including Retry-After
+	MaxBackoff time.Duration
+	// Jitter is the random fraction, between 0 and 1, subtracted from each wait
+	// so concurrent clients do not retry in lockstep
+	Jitter float64
+	// Methods are the HTTP methods that are retried
+	Methods []string
+}
+
+// DefaultRetryPolicy returns a policy that retries GET and HEAD requests up
+// to 3 times with exponential backoff starting at 500ms
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    DefaultMaxAttempts,
+		InitialBackoff: DefaultInitialBackoff,
+		MaxBackoff:     DefaultMaxBackoff,
+		Jitter:         DefaultJitter,
+		Methods:        []string{http.MethodGet, http.MethodHead},
+	}
+}
+
+// WithRetry sets the retry policy of the client
+func WithRetry(policy RetryPolicy) Option {
+	return func(c *Client) {
+		c.Retry = policy
+	}
+}
+
+type noRetryKey struct{}
+
+// WithoutRetry returns a context whose requests make a single attempt
+// whatever the retry policy, for callers that retry on their own
+func WithoutRetry(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noRetryKey{}, true)
+}
+
+// retryDisabled reports whether the context was made with WithoutRetry
+func retryDisabled(ctx context.Context) bool {
+	disabled, _ := ctx.Value(noRetryKey{}).(bool)
+	return disabled
+}
+
+// Retries reports whether requests with the method are retried
+func (policy RetryPolicy) Retries(method string) bool {
+	return policy.MaxAttempts > 1 && slices.ContainsFunc(policy.Methods, func(m string) bool {
+		return strings.EqualFold(m, method)
+	})
+}
+
+// Backoff returns the wait after the given failed attempt, starting at 1. A
+// Retry-After sent by the API replaces the exponential backoff.
+func (policy RetryPolicy) Backoff(attempt int, err error) time.Duration {
+	if apiErr, ok := AsAPIError(err); ok && apiErr.RetryAfter > 0 {
+		return policy.limit(apiErr.RetryAfter)
+	}
+
+	wait := policy.InitialBackoff
+	for i := 1; i < attempt && wait < policy.MaxBackoff; i++ {
+		wait *= 2
+	}
+	wait = policy.limit(wait)
+
+	if policy.Jitter > 0 && wait > 0 {
+		wait -= time.Duration(rand.Float64() * min(policy.Jitter, 1) * float64(wait))
+	}
+
+	return wait
+}
+
+// Wait sleeps for the backoff of the failed attempt, returning early with an
+// error if the context is done
+func (policy RetryPolicy) Wait(ctx context.Context, attempt int, err error) error {
+	const op = "common.RetryPolicy.Wait"
+
+	timer := time.NewTimer(policy.Backoff(attempt, err))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ez.New(op, ez.EUNAVAILABLE, "Context done while waiting to retry", ctx.Err())
+	case <-timer.C:
+		return nil
+	}
+}
+
+// limit caps a wait at MaxBackoff when set
+func (policy RetryPolicy) limit(wait time.Duration) time.Duration {
+	if policy.MaxBackoff > 0 && wait > policy.MaxBackoff {
+		return policy.MaxBackoff
+	}
+
+	return wait
+}
+
+// IsRetryable reports whether a request that failed with the error may succeed
+// if sent again: transport errors, HTTP 5xx other than 501, and HTTP 429
+func IsRetryable(err error) bool {
+	switch ez.ErrorCode(err) {
+	case ez.EUNAVAILABLE, ez.ERESOURCEEXHAUSTED:
+		return true
+	default:
+		return false
+	}
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+
+	return 0
+}
@@ -0,0 +1,113 @@
+package common_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vanclief/ez"
+	"github.com/vanclief/go-facturama/api/common"
+	"github.com/vanclief/go-facturama/api/facturamatest"
+)
+
+// fastRetry is a retry policy with short waits for tests
+func fastRetry() common.RetryPolicy {
+	policy := common.DefaultRetryPolicy()
+	policy.InitialBackoff = time.Millisecond
+	policy.MaxBackoff = 5 * time.Millisecond
+
+	return policy
+}
+
+func TestRetry(t *testing.T) {
+	srv := facturamatest.NewServer()
+	defer srv.Close()
+
+	client := common.NewClient(srv.Username, srv.Password, common.WithBaseURL(srv.URL), common.WithRetry(fastRetry()))
+	ctx := context.Background()
+
+	// Transient failures of GET requests are retried
+	srv.InjectFailure(facturamatest.Failure{StatusCode: http.StatusServiceUnavailable, Times: 2})
+	assert.NoError(t, client.Get(ctx, "/api-lite/csds", nil))
+	assert.Equal(t, 3, srv.RequestCount(http.MethodGet, "/api-lite/csds"))
+
+	// The last failure is returned once the attempts are exhausted
+	srv.InjectFailure(facturamatest.Failure{StatusCode: http.StatusInternalServerError, Times: 3})
+	err := client.Get(ctx, "/api-lite/csds", nil)
+	assert.True(t, common.IsUnavailable(err))
+	assert.Equal(t, 6, srv.RequestCount(http.MethodGet, "/api-lite/csds"))
+
+	// Client errors are not retried
+	srv.InjectFailure(facturamatest.Failure{StatusCode: http.StatusBadRequest, Times: -1})
+	err = client.Get(ctx, "/api-lite/csds", nil)
+	assert.Equal(t, ez.EINVALID, ez.ErrorCode(err))
+	assert.Equal(t, 7, srv.RequestCount(http.MethodGet, "/api-lite/csds"))
+	srv.ClearFailures()
+
+	// POST is not in the default methods
+	srv.InjectFailure(facturamatest.Failure{StatusCode: http.StatusBadGateway})
+	err = client.Post(ctx, "/api-lite/csds", map[string]string{}, nil)
+	assert.True(t, common.IsUnavailable(err))
+	assert.Equal(t, 1, srv.RequestCount(http.MethodPost, "/api-lite/csds"))
+
+	// A context made WithoutRetry makes a single attempt
+	srv.InjectFailure(facturamatest.Failure{StatusCode: http.StatusServiceUnavailable})
+	err = client.Get(common.WithoutRetry(ctx), "/api-lite/csds", nil)
+	assert.True(t, common.IsUnavailable(err))
+	assert.Equal(t, 8, srv.RequestCount(http.MethodGet, "/api-lite/csds"))
+
+	// The wait stops when the context is done
+	policy := fastRetry()
+	policy.InitialBackoff = time.Hour
+	policy.MaxBackoff = time.Hour
+	slow := common.NewClient(srv.Username, srv.Password, common.WithBaseURL(srv.URL), common.WithRetry(policy))
+
+	srv.InjectFailure(facturamatest.Failure{StatusCode: http.StatusServiceUnavailable})
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err = slow.Get(timeout, "/api-lite/csds", nil)
+	assert.True(t, common.IsUnavailable(err))
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestRetryAfter(t *testing.T) {
+	srv := facturamatest.NewServer()
+	defer srv.Close()
+
+	client := common.NewClient(srv.Username, srv.Password, common.WithBaseURL(srv.URL))
+
+	srv.InjectFailure(facturamatest.Failure{
+		StatusCode: http.StatusTooManyRequests,
+		Header:     http.Header{"Retry-After": {"2"}},
+	})
+	err := client.Get(context.Background(), "/api-lite/csds", nil)
+	assert.True(t, common.IsRetryable(err))
+
+	apiErr, ok := common.AsAPIError(err)
+	require.True(t, ok)
+	assert.Equal(t, 2*time.Second, apiErr.RetryAfter)
+
+	// Retry-After replaces the exponential backoff, up to MaxBackoff
+	policy := common.RetryPolicy{InitialBackoff: time.Millisecond, MaxBackoff: time.Minute}
+	assert.Equal(t, 2*time.Second, policy.Backoff(1, err))
+	policy.MaxBackoff = time.Second
+	assert.Equal(t, time.Second, policy.Backoff(1, err))
+
+	// Without Retry-After the backoff doubles on each attempt
+	policy = common.RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond}
+	assert.Equal(t, 100*time.Millisecond, policy.Backoff(1, nil))
+	assert.Equal(t, 200*time.Millisecond, policy.Backoff(2, nil))
+	assert.Equal(t, 300*time.Millisecond, policy.Backoff(3, nil))
+
+	policy.Jitter = 0.5
+	for range 10 {
+		wait := policy.Backoff(1, nil)
+		assert.GreaterOrEqual(t, wait, 50*time.Millisecond)
+		assert.LessOrEqual(t, wait, 100*time.Millisecond)
+	}
+}
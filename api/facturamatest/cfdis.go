@@ -0,0 +1,408 @@
+package facturamatest
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/vanclief/go-facturama/api/models"
+	"github.com/vanclief/go-facturama/decimal"
+)
+
+const (
+	// CertNumber is the issuer certificate number reported for stamped CFDIs
+	CertNumber = "30001000000500003416"
+	// SatCertNumber is the SAT certificate number reported in the tax stamp
+	SatCertNumber = "30001000000500003456"
+	// RfcProvCertif is the RFC of the PAC reported in the tax stamp
+	RfcProvCertif = "SPR190613I52"
+)
+
+// Lifecycle statuses of a stored CFDI, the ones the API reports. A CFDI
+// whose cancellation waits for the receiver is still active.
+const (
+	StatusActive   = "active"
+	StatusCanceled = "canceled"
+)
+
+// CancelStatusPending is the Status of a cancellation response that waits
+// for the receiver to accept it
+const CancelStatusPending = "pending"
+
+// cfdiTypeNames maps the CFDI type codes to the names returned by Facturama
+var cfdiTypeNames = map[string]string{
+	"I": "ingreso",
+	"E": "egreso",
+	"T": "traslado",
+	"N": "nomina",
+	"P": "pago",
+}
+
+// cfdiBody is the subset of the CFDI v4 creation payload the fake server uses
+type cfdiBody struct {
+	Date                 string                        `json:"Date"`
+	Serie                string                        `json:"Serie"`
+	Folio                string                        `json:"Folio"`
+	CfdiType             string                        `json:"CfdiType"`
+	Currency             string                        `json:"Currency"`
+	CurrencyExchangeRate decimal.Decimal               `json:"CurrencyExchangeRate"`
+	ExpeditionPlace      string                        `json:"ExpeditionPlace"`
+	PaymentForm          string                        `json:"PaymentForm"`
+	PaymentMethod        string                        `json:"PaymentMethod"`
+	PaymentConditions    string                        `json:"PaymentConditions"`
+	PaymentAccountNumber string                        `json:"PaymentAccountNumber"`
+	PaymentBankName      string                        `json:"PaymentBankName"`
+	Observations         string                        `json:"Observations"`
+	OrderNumber          string                        `json:"OrderNumber"`
+	Issuer               models.IssuerV4BindingModel   `json:"Issuer"`
+	Receiver             models.ReceiverV4BindingModel `json:"Receiver"`
+	Items                []models.ItemFullBindingModel `json:"Items"`
+	Relations            *models.Cfdiv4Relations       `json:"Relations"`
+}
+
+// AddCfdi stores a CFDI as if it had been stamped through the API and returns its ID.
+// Missing ID, UUID and Date values are generated.
+func (s *Server) AddCfdi(cfdi models.CfdiInfoModel) string {
+	if cfdi.ID == "" {
+		cfdi.ID = newID()
+	}
+	if cfdi.Date == "" {
+		cfdi.Date = formatDate(time.Now())
+	}
+	if cfdi.Complement.TaxStamp.UUID == "" {
+		cfdi.Complement.TaxStamp = newTaxStamp()
+	}
+	if cfdi.Status == "" {
+		cfdi.Status = StatusActive
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.cfdis[cfdi.ID]; !exists {
+		s.order = append(s.order, cfdi.ID)
+	}
+	s.cfdis[cfdi.ID] = &cfdiRecord{Info: cfdi}
+
+	return cfdi.ID
+}
+
+// Cfdi returns the CFDI stored with the given ID
+func (s *Server) Cfdi(id string) (models.CfdiInfoModel, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.cfdis[id]
+	if !ok {
+		return models.CfdiInfoModel{}, false
+	}
+
+	return record.Info, true
+}
+
+// CfdiStatus returns the lifecycle status of the CFDI stored with the given ID
+func (s *Server) CfdiStatus(id string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.cfdis[id]
+	if !ok {
+		return "", false
+	}
+
+	return record.Info.Status, true
+}
+
+// ResolveCancellation ends the pending cancellation of a CFDI as the receiver
+// would: accepted cancels it, otherwise it is rejected and the CFDI stays active.
+// It reports whether there was a pending cancellation.
+func (s *Server) ResolveCancellation(id string, accepted bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.cfdis[id]
+	if !ok || !record.Pending {
+		return false
+	}
+
+	record.Pending = false
+	if accepted {
+		record.Info.Status = StatusCanceled
+	}
+
+	return true
+}
+
+// validate returns the ModelState errors for a CFDI payload, if any
+func (body *cfdiBody) validate() map[string][]string {
+	modelState := make(map[string][]string)
+
+	if _, ok := cfdiTypeNames[body.CfdiType]; !ok {
+		modelState["cfdiToCreate.CfdiType"] = []string{"El tipo de CFDI no es válido."}
+	}
+	if body.ExpeditionPlace == "" {
+		modelState["cfdiToCreate.ExpeditionPlace"] = []string{"El campo ExpeditionPlace es obligatorio."}
+	}
+	if body.Issuer.Rfc == "" {
+		modelState["cfdiToCreate.Issuer.Rfc"] = []string{"El campo Rfc es obligatorio."}
+	}
+	if body.Receiver.Rfc == "" {
+		modelState["cfdiToCreate.Receiver.Rfc"] = []string{"El campo Rfc es obligatorio."}
+	}
+	if len(body.Items) == 0 {
+		modelState["cfdiToCreate.Items"] = []string{"Se requiere al menos un concepto."}
+	}
+
+	return modelState
+}
+
+func (s *Server) createCfdi(w http.ResponseWriter, r *http.Request) {
+	var body cfdiBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeModelState(w, map[string][]string{"Message": {"El cuerpo de la solicitud no es válido."}})
+		return
+	}
+
+	if modelState := body.validate(); len(modelState) > 0 {
+		writeModelState(w, modelState)
+		return
+	}
+
+	if _, ok := s.CSD(body.Issuer.Rfc); !ok {
+		writeModelState(w, map[string][]string{
+			"Message": {fmt.Sprintf("No se encontró un CSD para el RFC emisor %s", normalizeRFC(body.Issuer.Rfc))},
+		})
+		return
+	}
+
+	cfdi := body.toCfdiInfo()
+	s.AddCfdi(cfdi)
+
+	s.mu.Lock()
+	s.cfdis[cfdi.ID].Relations = body.Relations
+	s.mu.Unlock()
+
+	stored, _ := s.Cfdi(cfdi.ID)
+	writeJSON(w, http.StatusOK, stored)
+}
+
+func (s *Server) getCfdi(w http.ResponseWriter, r *http.Request) {
+	cfdi, ok := s.Cfdi(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, notFound("No se encontró el CFDI con Id %s", r.PathValue("id")))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, cfdi)
+}
+
+func (s *Server) cancelCfdi(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	motive := r.URL.Query().Get("motive")
+	uuidReplacement := r.URL.Query().Get("uuidReplacement")
+
+	if motive == "01" && uuidReplacement == "" {
+		writeModelState(w, map[string][]string{
+			"uuidReplacement": {"El UUID de sustitución es obligatorio para el motivo 01."},
+		})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.cfdis[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, notFound("No se encontró el CFDI con Id %s", id))
+		return
+	}
+
+	if record.Info.Status == StatusCanceled {
+		writeModelState(w, map[string][]string{
+			"Message": {fmt.Sprintf("El CFDI %s ya se encuentra cancelado", record.Info.Complement.TaxStamp.UUID)},
+		})
+		return
+	}
+	if record.Pending {
+		writeModelState(w, map[string][]string{
+			"Message": {fmt.Sprintf("El CFDI %s ya tiene una solicitud de cancelación en proceso", record.Info.Complement.TaxStamp.UUID)},
+		})
+		return
+	}
+
+	now := time.Now()
+	uuid := record.Info.Complement.TaxStamp.UUID
+
+	// The SAT only accepts a replacement that substitutes the cancelled CFDI
+	if motive == "01" && !s.substitutes(uuidReplacement, uuid) {
+		writeModelState(w, map[string][]string{
+			"uuidReplacement": {fmt.Sprintf("El CFDI %s no sustituye al CFDI %s", uuidReplacement, uuid)},
+		})
+		return
+	}
+
+	// Cancellations that need the receiver acceptance leave the CFDI active
+	// until resolved
+	if s.CancelStatus == CancelStatusPending {
+		record.Pending = true
+	} else {
+		record.Info.Status = StatusCanceled
+	}
+	record.Cancelation = &models.CancelationStatusLite{
+		Status:          s.CancelStatus,
+		Message:         "Solicitud de cancelación recibida",
+		UUID:            uuid,
+		RequestDate:     formatDate(now),
+		AcuseXmlBase64:  base64.StdEncoding.EncodeToString(acuseXML(uuid, record.Info.Issuer.Rfc, now)),
+		CancelationDate: formatDate(now),
+	}
+
+	writeJSON(w, http.StatusOK, record.Cancelation)
+}
+
+// substitutes reports whether the active CFDI with the replacement UUID has a
+// 04 relation to the original UUID. The caller must hold the lock.
+func (s *Server) substitutes(replacement, original string) bool {
+	for _, record := range s.cfdis {
+		if !strings.EqualFold(record.Info.Complement.TaxStamp.UUID, replacement) || record.Info.Status != StatusActive {
+			continue
+		}
+		if record.Relations == nil || record.Relations.Type != "04" {
+			return false
+		}
+		for _, related := range record.Relations.Cfdis {
+			if strings.EqualFold(related.Uuid, original) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// toCfdiInfo builds the stamped CFDI returned for a creation payload
+func (body *cfdiBody) toCfdiInfo() models.CfdiInfoModel {
+	cfdi := models.CfdiInfoModel{
+		ID:                   newID(),
+		CfdiType:             cfdiTypeNames[body.CfdiType],
+		Type:                 "issued",
+		Serie:                body.Serie,
+		Folio:                body.Folio,
+		Date:                 body.Date,
+		CertNumber:           CertNumber,
+		PaymentTerms:         body.PaymentForm,
+		PaymentConditions:    body.PaymentConditions,
+		PaymentMethod:        body.PaymentMethod,
+		PaymentAccountNumber: body.PaymentAccountNumber,
+		PaymentBankName:      body.PaymentBankName,
+		ExpeditionPlace:      body.ExpeditionPlace,
+		ExchangeRate:         body.CurrencyExchangeRate,
+		Currency:             body.Currency,
+		Observations:         body.Observations,
+		OrderNumber:          body.OrderNumber,
+		Issuer: models.TaxEntityInfoViewModel{
+			FiscalRegime: body.Issuer.FiscalRegime,
+			Rfc:          normalizeRFC(body.Issuer.Rfc),
+			TaxName:      body.Issuer.Name,
+		},
+		Receiver: models.ReceiverViewModel{
+			Rfc:  normalizeRFC(body.Receiver.Rfc),
+			Name: body.Receiver.Name,
+		},
+		Complement: models.CfdiComplement{TaxStamp: newTaxStamp()},
+	}
+
+	if cfdi.Date == "" {
+		cfdi.Date = formatDate(time.Now())
+	}
+	if cfdi.Currency == "" {
+		cfdi.Currency = "MXN"
+	}
+	if cfdi.ExchangeRate.IsZero() {
+		cfdi.ExchangeRate = decimal.One
+	}
+
+	taxes := make(map[string]*models.TaxInfoModel)
+	var taxOrder []string
+
+	for _, item := range body.Items {
+		cfdi.Subtotal = cfdi.Subtotal.Add(item.Subtotal)
+		cfdi.Discount = cfdi.Discount.Add(item.Discount)
+		cfdi.Total = cfdi.Total.Add(item.Total)
+
+		cfdi.Items = append(cfdi.Items, models.ItemInfoModel{
+			Discount:    item.Discount,
+			Quantity:    item.Quantity,
+			Unit:        item.Unit,
+			Description: item.Description,
+			UnitValue:   item.UnitPrice,
+			Total:       item.Subtotal,
+		})
+
+		for _, tax := range item.Taxes {
+			taxType := "transferred"
+			if tax.IsRetention {
+				taxType = "retained"
+			}
+
+			key := fmt.Sprintf("%s|%s|%s", tax.Name, taxType, tax.Rate)
+			aggregated, ok := taxes[key]
+			if !ok {
+				aggregated = &models.TaxInfoModel{Name: tax.Name, Rate: tax.Rate, Type: taxType}
+				taxes[key] = aggregated
+				taxOrder = append(taxOrder, key)
+			}
+			aggregated.Total = aggregated.Total.Add(tax.Total)
+		}
+	}
+
+	for _, key := range taxOrder {
+		cfdi.Taxes = append(cfdi.Taxes, *taxes[key])
+	}
+
+	return cfdi
+}
+
+// newTaxStamp builds a random TimbreFiscalDigital summary
+func newTaxStamp() models.CfdiTaxStamp {
+	return models.CfdiTaxStamp{
+		UUID:          newUUID(),
+		Date:          formatDate(time.Now()),
+		CfdiSign:      randomBase64(256),
+		SatCertNumber: SatCertNumber,
+		SatSign:       randomBase64(256),
+		RfcProvCertif: RfcProvCertif,
+	}
+}
+
+// newID returns a random identifier shaped like the ones assigned by Facturama
+func newID() string {
+	return strings.TrimRight(base64.URLEncoding.EncodeToString(randomBytes(16)), "=")
+}
+
+// newUUID returns a random version 4 UUID in upper case, as used by the SAT
+func newUUID() string {
+	b := randomBytes(16)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	h := hex.EncodeToString(b)
+	return strings.ToUpper(fmt.Sprintf("%s-%s-%s-%s-%s", h[0:8], h[8:12], h[12:16], h[16:20], h[20:]))
+}
+
+func randomBase64(size int) string {
+	return base64.StdEncoding.EncodeToString(randomBytes(size))
+}
+
+func randomBytes(size int) []byte {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b
+}
@@ -0,0 +1,192 @@
+package facturamatest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/vanclief/go-facturama/api/models"
+)
+
+// csdValidity is how long a CSD uploaded to the fake server is valid for
+const csdValidity = 4 * 365 * 24 * time.Hour
+
+// csdView is the wire representation of a CSD, using Facturama's date format
+type csdView struct {
+	RFC                string `json:"Rfc"`
+	Certificate        string `json:"Certificate"`
+	PrivateKey         string `json:"PrivateKey"`
+	PrivateKeyPassword string `json:"PrivateKeyPassword"`
+	CsdExpirationDate  string `json:"CsdExpirationDate,omitempty"`
+	UploadDate         string `json:"UploadDate,omitempty"`
+}
+
+// csdBody is the payload used to create or update a CSD
+type csdBody struct {
+	RFC                string `json:"Rfc"`
+	Certificate        string `json:"Certificate"`
+	PrivateKey         string `json:"PrivateKey"`
+	PrivateKeyPassword string `json:"PrivateKeyPassword"`
+}
+
+// AddCSD stores a CSD as if it had been uploaded through the API
+func (s *Server) AddCSD(csd models.TaxEntityCSD) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if csd.UploadDate.IsZero() {
+		csd.UploadDate = models.FacturamaTime{Time: time.Now().UTC().Truncate(time.Second)}
+	}
+	if csd.CsdExpirationDate.IsZero() {
+		csd.CsdExpirationDate = models.FacturamaTime{Time: csd.UploadDate.Add(csdValidity)}
+	}
+
+	s.csds[normalizeRFC(csd.RFC)] = csd
+}
+
+// CSD returns the CSD stored for an RFC
+func (s *Server) CSD(rfc string) (models.TaxEntityCSD, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	csd, ok := s.csds[normalizeRFC(rfc)]
+	return csd, ok
+}
+
+func newCSDView(csd models.TaxEntityCSD) csdView {
+	return csdView{
+		RFC:                csd.RFC,
+		Certificate:        csd.Certificate,
+		PrivateKey:         csd.PrivateKey,
+		PrivateKeyPassword: csd.PrivateKeyPassword,
+		CsdExpirationDate:  formatDate(csd.CsdExpirationDate.Time),
+		UploadDate:         formatDate(csd.UploadDate.Time),
+	}
+}
+
+// validate returns the ModelState errors for a CSD payload, if any
+func (body *csdBody) validate() map[string][]string {
+	modelState := make(map[string][]string)
+
+	if body.RFC == "" {
+		modelState["Rfc"] = []string{"El campo Rfc es obligatorio."}
+	}
+
+	if body.Certificate == "" {
+		modelState["Certificate"] = []string{"El campo Certificate es obligatorio."}
+	} else if _, err := base64.StdEncoding.DecodeString(body.Certificate); err != nil {
+		modelState["Certificate"] = []string{"El certificado no tiene un formato base64 válido."}
+	}
+
+	if body.PrivateKey == "" {
+		modelState["Key"] = []string{"El campo PrivateKey es obligatorio."}
+	} else if _, err := base64.StdEncoding.DecodeString(body.PrivateKey); err != nil {
+		modelState["Key"] = []string{"La llave privada no tiene un formato base64 válido."}
+	}
+
+	if body.PrivateKeyPassword == "" {
+		modelState["Key"] = append(modelState["Key"], "La contraseña de la llave privada es obligatoria.")
+	}
+
+	return modelState
+}
+
+func (s *Server) listCSDs(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	views := make([]csdView, 0, len(s.csds))
+	for _, csd := range s.csds {
+		views = append(views, newCSDView(csd))
+	}
+	s.mu.Unlock()
+
+	sort.Slice(views, func(i, j int) bool { return views[i].RFC < views[j].RFC })
+
+	writeJSON(w, http.StatusOK, views)
+}
+
+func (s *Server) getCSD(w http.ResponseWriter, r *http.Request) {
+	csd, ok := s.CSD(r.PathValue("rfc"))
+	if !ok {
+		writeError(w, http.StatusNotFound, notFound("No se encontró un CSD para el RFC %s", r.PathValue("rfc")))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newCSDView(csd))
+}
+
+func (s *Server) createCSD(w http.ResponseWriter, r *http.Request) {
+	var body csdBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeModelState(w, map[string][]string{"Message": {"El cuerpo de la solicitud no es válido."}})
+		return
+	}
+
+	if modelState := body.validate(); len(modelState) > 0 {
+		writeModelState(w, modelState)
+		return
+	}
+
+	if _, exists := s.CSD(body.RFC); exists {
+		writeModelState(w, map[string][]string{
+			"Message": {fmt.Sprintf("Ya existe un CSD registrado para el RFC %s", normalizeRFC(body.RFC))},
+		})
+		return
+	}
+
+	s.AddCSD(models.TaxEntityCSD{
+		RFC:                normalizeRFC(body.RFC),
+		Certificate:        body.Certificate,
+		PrivateKey:         body.PrivateKey,
+		PrivateKeyPassword: body.PrivateKeyPassword,
+	})
+
+	writeJSON(w, http.StatusOK, nil)
+}
+
+func (s *Server) updateCSD(w http.ResponseWriter, r *http.Request) {
+	rfc := r.PathValue("rfc")
+
+	var body csdBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeModelState(w, map[string][]string{"Message": {"El cuerpo de la solicitud no es válido."}})
+		return
+	}
+
+	if modelState := body.validate(); len(modelState) > 0 {
+		writeModelState(w, modelState)
+		return
+	}
+
+	if _, exists := s.CSD(rfc); !exists {
+		writeError(w, http.StatusNotFound, notFound("No se encontró un CSD para el RFC %s", rfc))
+		return
+	}
+
+	s.AddCSD(models.TaxEntityCSD{
+		RFC:                normalizeRFC(rfc),
+		Certificate:        body.Certificate,
+		PrivateKey:         body.PrivateKey,
+		PrivateKeyPassword: body.PrivateKeyPassword,
+	})
+
+	writeJSON(w, http.StatusOK, nil)
+}
+
+func (s *Server) deleteCSD(w http.ResponseWriter, r *http.Request) {
+	rfc := normalizeRFC(r.PathValue("rfc"))
+
+	s.mu.Lock()
+	_, exists := s.csds[rfc]
+	delete(s.csds, rfc)
+	s.mu.Unlock()
+
+	if !exists {
+		writeError(w, http.StatusNotFound, notFound("No se encontró un CSD para el RFC %s", rfc))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, nil)
+}
@@ -0,0 +1,60 @@
+package facturamatest
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/vanclief/go-facturama/api/models"
+)
+
+// SentEmail is a CFDI sent by email through the server
+type SentEmail struct {
+	CfdiID   string
+	CfdiType string
+	Email    string
+}
+
+// SentEmails returns every CFDI sent by email, in order
+func (s *Server) SentEmails() []SentEmail {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	emails := make([]SentEmail, len(s.emails))
+	copy(emails, s.emails)
+
+	return emails
+}
+
+func (s *Server) sendCfdiByEmail(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	cfdiType := query.Get("cfdiType")
+	id := query.Get("cfdiId")
+	email := query.Get("email")
+
+	if !validFileTypes[cfdiType] || cfdiType == "received" {
+		writeModelState(w, map[string][]string{"cfdiType": {fmt.Sprintf("El tipo %s no es válido.", cfdiType)}})
+		return
+	}
+
+	if _, ok := s.Cfdi(id); !ok {
+		writeError(w, http.StatusNotFound, notFound("No se encontró el CFDI con Id %s", id))
+		return
+	}
+
+	// Undeliverable addresses are reported in the payload, not as an HTTP error.
+	// The fake rejects the reserved .invalid domain.
+	if !strings.Contains(email, "@") || strings.HasSuffix(strings.ToLower(email), ".invalid") {
+		writeJSON(w, http.StatusOK, models.SendEmailResponse{Message: fmt.Sprintf("El correo %s no es válido", email)})
+		return
+	}
+
+	s.mu.Lock()
+	s.emails = append(s.emails, SentEmail{CfdiID: id, CfdiType: cfdiType, Email: email})
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, models.SendEmailResponse{
+		Success: true,
+		Message: fmt.Sprintf("Se envió el CFDI al correo %s", email),
+	})
+}
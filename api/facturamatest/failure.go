@@ -0,0 +1,126 @@
+package facturamatest
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/vanclief/go-facturama/api/common"
+)
+
+// Failure describes an error the server returns instead of handling a request
+type Failure struct {
+	// Method to match, empty matches any method
+	Method string
+	// Path prefix to match, empty matches any path
+	Path string
+
+	// StatusCode returned to the client, defaults to 500
+	StatusCode int
+	// Response is the JSON error payload, defaults to a generic message for the status
+	Response *common.ErrorResponse
+	// RawBody is written as is instead of Response when set, useful for malformed payloads
+	RawBody string
+	// Header is added to the response, e.g. Retry-After
+	Header http.Header
+
+	// Delay waits before answering, useful to trigger client timeouts
+	Delay time.Duration
+	// Drop closes the connection without writing a response
+	Drop bool
+	// Handle processes the request before failing, as when the response is
+	// lost after the API stamped a CFDI
+	Handle bool
+
+	// Times is how many requests fail, zero means once and negative means always
+	Times int
+}
+
+// InjectFailure makes the next matching requests fail as described
+func (s *Server) InjectFailure(failure Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if failure.Times == 0 {
+		failure.Times = 1
+	}
+
+	s.failures = append(s.failures, &failure)
+}
+
+// ClearFailures removes every pending injected failure
+func (s *Server) ClearFailures() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures = nil
+}
+
+// matchFailure returns and consumes the first failure matching the request
+func (s *Server) matchFailure(r *http.Request) *Failure {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, failure := range s.failures {
+		if failure.Method != "" && !strings.EqualFold(failure.Method, r.Method) {
+			continue
+		}
+		if failure.Path != "" && !strings.HasPrefix(r.URL.Path, failure.Path) {
+			continue
+		}
+
+		if failure.Times > 0 {
+			failure.Times--
+			if failure.Times == 0 {
+				s.failures = append(s.failures[:i], s.failures[i+1:]...)
+			}
+		}
+
+		return failure
+	}
+
+	return nil
+}
+
+// write sends the failure to the client
+func (failure *Failure) write(w http.ResponseWriter) {
+	if failure.Delay > 0 {
+		time.Sleep(failure.Delay)
+	}
+
+	if failure.Drop {
+		if hijacker, ok := w.(http.Hijacker); ok {
+			conn, _, err := hijacker.Hijack()
+			if err == nil {
+				conn.Close()
+				return
+			}
+		}
+		panic(http.ErrAbortHandler)
+	}
+
+	for key, values := range failure.Header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+
+	status := failure.StatusCode
+	if status == 0 {
+		status = http.StatusInternalServerError
+	}
+
+	if failure.RawBody != "" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(failure.RawBody))
+		return
+	}
+
+	response := common.ErrorResponse{Message: http.StatusText(status)}
+	if failure.Response != nil {
+		response = *failure.Response
+	}
+
+	writeError(w, status, response)
+}
@@ -0,0 +1,184 @@
+package facturamatest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/vanclief/go-facturama/api/models"
+)
+
+// validFileTypes are the CFDI types accepted by the file endpoint
+var validFileTypes = map[string]bool{
+	"payroll":    true,
+	"received":   true,
+	"issued":     true,
+	"issuedLite": true,
+}
+
+func (s *Server) getCfdiFile(w http.ResponseWriter, r *http.Request) {
+	format := strings.ToLower(r.PathValue("format"))
+	cfdiType := r.PathValue("type")
+	id := r.PathValue("id")
+
+	if !validFileTypes[cfdiType] {
+		writeModelState(w, map[string][]string{"type": {fmt.Sprintf("El tipo %s no es válido.", cfdiType)}})
+		return
+	}
+
+	s.mu.Lock()
+	record, ok := s.cfdis[id]
+	var cfdi models.CfdiInfoModel
+	var relations *models.Cfdiv4Relations
+	if ok {
+		cfdi, relations = record.Info, record.Relations
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, notFound("No se encontró el CFDI con Id %s", id))
+		return
+	}
+
+	var content []byte
+	switch format {
+	case "xml":
+		content = cfdiXML(cfdi, relations)
+	case "html":
+		content = cfdiHTML(cfdi)
+	case "pdf":
+		content = cfdiPDF(cfdi)
+	default:
+		writeModelState(w, map[string][]string{"format": {fmt.Sprintf("El formato %s no es válido.", format)}})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, models.FileViewModel{
+		ContentEncoding: "base64",
+		ContentType:     format,
+		ContentLength:   len(content),
+		Content:         base64.StdEncoding.EncodeToString(content),
+	})
+}
+
+func (s *Server) getAcuse(w http.ResponseWriter, r *http.Request) {
+	format := strings.ToLower(r.PathValue("format"))
+	cfdiType := r.PathValue("type")
+	id := r.PathValue("id")
+
+	if !validFileTypes[cfdiType] || cfdiType == "received" {
+		writeModelState(w, map[string][]string{"type": {fmt.Sprintf("El tipo %s no es válido.", cfdiType)}})
+		return
+	}
+
+	s.mu.Lock()
+	record, ok := s.cfdis[id]
+	var cancelation *models.CancelationStatusLite
+	if ok {
+		cancelation = record.Cancelation
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, notFound("No se encontró el CFDI con Id %s", id))
+		return
+	}
+	if cancelation == nil {
+		writeError(w, http.StatusNotFound, notFound("El CFDI con Id %s no tiene acuse de cancelación", id))
+		return
+	}
+
+	acuse, _ := base64.StdEncoding.DecodeString(cancelation.AcuseXmlBase64)
+
+	var content []byte
+	switch format {
+	case "xml":
+		content = acuse
+	case "html":
+		content = []byte(fmt.Sprintf(`<!DOCTYPE html><html><head><title>Acuse %s</title></head><body><h1>Acuse de cancelación</h1><p>UUID: %s</p><p>Fecha: %s</p></body></html>`,
+			cancelation.UUID, cancelation.UUID, escape(cancelation.CancelationDate)))
+	case "pdf":
+		content = minimalPDF(fmt.Sprintf("Acuse de cancelacion %s %s", cancelation.UUID, cancelation.CancelationDate))
+	default:
+		writeModelState(w, map[string][]string{"format": {fmt.Sprintf("El formato %s no es válido.", format)}})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, models.FileViewModel{
+		ContentEncoding: "base64",
+		ContentType:     format,
+		ContentLength:   len(content),
+		Content:         base64.StdEncoding.EncodeToString(content),
+	})
+}
+
+// cfdiXML renders a minimal stamped CFDI 4.0 document with its relations
+func cfdiXML(cfdi models.CfdiInfoModel, relations *models.Cfdiv4Relations) []byte {
+	var buf bytes.Buffer
+
+	buf.WriteString(xml.Header)
+	fmt.Fprintf(&buf, `<cfdi:Comprobante xmlns:cfdi="http://www.sat.gob.mx/cfd/4" xmlns:tfd="http://www.sat.gob.mx/TimbreFiscalDigital" Version="4.0" Serie="%s" Folio="%s" Fecha="%s" NoCertificado="%s" SubTotal="%s" Descuento="%s" Moneda="%s" Total="%s" LugarExpedicion="%s">`,
+		escape(cfdi.Serie), escape(cfdi.Folio), escape(cfdi.Date), cfdi.CertNumber, cfdi.Subtotal.StringFixed(2), cfdi.Discount.StringFixed(2), escape(cfdi.Currency), cfdi.Total.StringFixed(2), escape(cfdi.ExpeditionPlace))
+	if relations != nil && len(relations.Cfdis) > 0 {
+		fmt.Fprintf(&buf, `<cfdi:CfdiRelacionados TipoRelacion="%s">`, escape(relations.Type))
+		for _, related := range relations.Cfdis {
+			fmt.Fprintf(&buf, `<cfdi:CfdiRelacionado UUID="%s"/>`, escape(related.Uuid))
+		}
+		buf.WriteString(`</cfdi:CfdiRelacionados>`)
+	}
+	fmt.Fprintf(&buf, `<cfdi:Emisor Rfc="%s" Nombre="%s" RegimenFiscal="%s"/>`, escape(cfdi.Issuer.Rfc), escape(cfdi.Issuer.TaxName), escape(cfdi.Issuer.FiscalRegime))
+	fmt.Fprintf(&buf, `<cfdi:Receptor Rfc="%s" Nombre="%s"/>`, escape(cfdi.Receiver.Rfc), escape(cfdi.Receiver.Name))
+	buf.WriteString(`<cfdi:Conceptos>`)
+	for _, item := range cfdi.Items {
+		fmt.Fprintf(&buf, `<cfdi:Concepto Cantidad="%s" Unidad="%s" Descripcion="%s" ValorUnitario="%s" Importe="%s" Descuento="%s"/>`,
+			item.Quantity, escape(item.Unit), escape(item.Description), item.UnitValue, item.Total.StringFixed(2), item.Discount.StringFixed(2))
+	}
+	buf.WriteString(`</cfdi:Conceptos>`)
+
+	stamp := cfdi.Complement.TaxStamp
+	fmt.Fprintf(&buf, `<cfdi:Complemento><tfd:TimbreFiscalDigital Version="1.1" UUID="%s" FechaTimbrado="%s" RfcProvCertif="%s" SelloCFD="%s" NoCertificadoSAT="%s" SelloSAT="%s"/></cfdi:Complemento>`,
+		stamp.UUID, escape(stamp.Date), stamp.RfcProvCertif, stamp.CfdiSign, stamp.SatCertNumber, stamp.SatSign)
+	buf.WriteString(`</cfdi:Comprobante>`)
+
+	return buf.Bytes()
+}
+
+// cfdiHTML renders a minimal HTML representation of a CFDI
+func cfdiHTML(cfdi models.CfdiInfoModel) []byte {
+	return []byte(fmt.Sprintf(`<!DOCTYPE html><html><head><title>%s%s</title></head><body><h1>CFDI %s</h1><p>Emisor: %s</p><p>Receptor: %s</p><p>Total: %s %s</p></body></html>`,
+		escape(cfdi.Serie), escape(cfdi.Folio), cfdi.Complement.TaxStamp.UUID, escape(cfdi.Issuer.Rfc), escape(cfdi.Receiver.Rfc), cfdi.Total.StringFixed(2), escape(cfdi.Currency)))
+}
+
+// cfdiPDF renders a minimal single page PDF document describing a CFDI
+func cfdiPDF(cfdi models.CfdiInfoModel) []byte {
+	return minimalPDF(fmt.Sprintf("CFDI %s Total %s %s", cfdi.Complement.TaxStamp.UUID, cfdi.Total.StringFixed(2), cfdi.Currency))
+}
+
+// minimalPDF renders a single page PDF document with a line of text
+func minimalPDF(text string) []byte {
+	stream := fmt.Sprintf("BT /F1 12 Tf 72 720 Td (%s) Tj ET", text)
+
+	return []byte(fmt.Sprintf("%%PDF-1.4\n"+
+		"1 0 obj << /Type /Catalog /Pages 2 0 R >> endobj\n"+
+		"2 0 obj << /Type /Pages /Kids [3 0 R] /Count 1 >> endobj\n"+
+		"3 0 obj << /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Contents 4 0 R >> endobj\n"+
+		"4 0 obj << /Length %d >> stream\n%s\nendstream endobj\n"+
+		"trailer << /Root 1 0 R >>\n%%EOF\n", len(stream), stream))
+}
+
+// acuseXML renders a minimal SAT cancellation acknowledgment
+func acuseXML(uuid, rfcEmisor string, date time.Time) []byte {
+	return []byte(fmt.Sprintf(`<?xml version="1.0" encoding="utf-8"?><Acuse xmlns="http://cancelacfd.sat.gob.mx" Fecha="%s" RfcEmisor="%s"><Folios><UUID>%s</UUID><EstatusUUID>201</EstatusUUID></Folios><Signature Id="SelloSAT" xmlns="http://www.w3.org/2000/09/xmldsig#"><SignatureValue>%s</SignatureValue></Signature></Acuse>`,
+		formatDate(date), escape(rfcEmisor), uuid, randomBase64(64)))
+}
+
+// escape escapes a string to be used inside XML or HTML
+func escape(s string) string {
+	var buf bytes.Buffer
+	_ = xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
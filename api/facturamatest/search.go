@@ -0,0 +1,194 @@
+package facturamatest
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vanclief/go-facturama/api/models"
+)
+
+// searchDateLayout is the date format used by the CFDI search filters
+const searchDateLayout = "02/01/2006"
+
+// searchFilter holds the parsed filters of a CFDI search
+type searchFilter struct {
+	cfdiType   string
+	keyword    string
+	status     string
+	issuerRfc  string
+	rfc        string
+	dateStart  time.Time
+	dateEnd    time.Time
+	folioStart string
+	folioEnd   string
+	serie      string
+	page       int
+}
+
+func (s *Server) listCfdis(w http.ResponseWriter, r *http.Request) {
+	filter, modelState := parseSearchFilter(r.URL.Query())
+	if len(modelState) > 0 {
+		writeModelState(w, modelState)
+		return
+	}
+
+	s.mu.Lock()
+	var matches []models.CfdiSearchViewModel
+	for _, id := range s.order {
+		cfdi := s.cfdis[id].Info
+		if filter.matches(cfdi) {
+			matches = append(matches, newSearchView(cfdi))
+		}
+	}
+	pageSize := s.PageSize
+	s.mu.Unlock()
+
+	start := filter.page * pageSize
+	if start > len(matches) {
+		start = len(matches)
+	}
+	end := start + pageSize
+	if end > len(matches) {
+		end = len(matches)
+	}
+
+	writeJSON(w, http.StatusOK, append([]models.CfdiSearchViewModel{}, matches[start:end]...))
+}
+
+// parseSearchFilter reads the search filters from the query string
+func parseSearchFilter(query url.Values) (searchFilter, map[string][]string) {
+	modelState := make(map[string][]string)
+
+	filter := searchFilter{
+		cfdiType:   query.Get("type"),
+		keyword:    strings.ToLower(query.Get("keyword")),
+		status:     query.Get("status"),
+		issuerRfc:  normalizeRFC(query.Get("rfcIssuer")),
+		rfc:        normalizeRFC(query.Get("rfc")),
+		folioStart: query.Get("folioStart"),
+		folioEnd:   query.Get("folioEnd"),
+		serie:      query.Get("serie"),
+	}
+
+	if filter.cfdiType != "" && !validFileTypes[filter.cfdiType] {
+		modelState["type"] = []string{"El tipo no es válido."}
+	}
+
+	if page := query.Get("page"); page != "" {
+		var err error
+		filter.page, err = strconv.Atoi(page)
+		if err != nil || filter.page < 0 {
+			modelState["page"] = []string{"La página no es válida."}
+		}
+	}
+
+	for key, target := range map[string]*time.Time{"dateStart": &filter.dateStart, "dateEnd": &filter.dateEnd} {
+		if value := query.Get(key); value != "" {
+			date, err := time.Parse(searchDateLayout, value)
+			if err != nil {
+				modelState[key] = []string{"La fecha debe tener el formato dd/mm/aaaa."}
+			}
+			*target = date
+		}
+	}
+
+	return filter, modelState
+}
+
+// matches reports whether a CFDI satisfies the filter
+func (filter *searchFilter) matches(cfdi models.CfdiInfoModel) bool {
+	switch filter.cfdiType {
+	case "received":
+		return false
+	case "payroll":
+		if cfdi.CfdiType != "nomina" {
+			return false
+		}
+	}
+
+	if filter.status != "" && filter.status != "all" && filter.status != cfdi.Status {
+		return false
+	}
+	if filter.issuerRfc != "" && filter.issuerRfc != normalizeRFC(cfdi.Issuer.Rfc) {
+		return false
+	}
+	if filter.rfc != "" && filter.rfc != normalizeRFC(cfdi.Receiver.Rfc) {
+		return false
+	}
+	if filter.serie != "" && filter.serie != cfdi.Serie {
+		return false
+	}
+	if filter.folioStart != "" && compareFolios(cfdi.Folio, filter.folioStart) < 0 {
+		return false
+	}
+	if filter.folioEnd != "" && compareFolios(cfdi.Folio, filter.folioEnd) > 0 {
+		return false
+	}
+
+	if !filter.dateStart.IsZero() || !filter.dateEnd.IsZero() {
+		date, err := time.Parse(dateLayout, cfdi.Date)
+		if err != nil {
+			return false
+		}
+		day := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+		if !filter.dateStart.IsZero() && day.Before(filter.dateStart) {
+			return false
+		}
+		if !filter.dateEnd.IsZero() && day.After(filter.dateEnd) {
+			return false
+		}
+	}
+
+	if filter.keyword != "" {
+		fields := []string{cfdi.Folio, cfdi.Serie, cfdi.Issuer.Rfc, cfdi.Issuer.TaxName, cfdi.Receiver.Rfc, cfdi.Receiver.Name, cfdi.Complement.TaxStamp.UUID}
+		found := false
+		for _, field := range fields {
+			if strings.Contains(strings.ToLower(field), filter.keyword) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// compareFolios compares folios numerically when possible, otherwise lexically
+func compareFolios(a, b string) int {
+	x, errA := strconv.Atoi(a)
+	y, errB := strconv.Atoi(b)
+	if errA == nil && errB == nil {
+		return x - y
+	}
+
+	return strings.Compare(a, b)
+}
+
+// newSearchView builds the search result for a stored CFDI
+func newSearchView(cfdi models.CfdiInfoModel) models.CfdiSearchViewModel {
+	return models.CfdiSearchViewModel{
+		ID:            cfdi.ID,
+		CfdiType:      cfdi.CfdiType,
+		Type:          cfdi.Type,
+		Serie:         cfdi.Serie,
+		Folio:         cfdi.Folio,
+		Date:          cfdi.Date,
+		Subtotal:      cfdi.Subtotal,
+		Discount:      cfdi.Discount,
+		Total:         cfdi.Total,
+		Currency:      cfdi.Currency,
+		PaymentMethod: cfdi.PaymentMethod,
+		Rfc:           cfdi.Receiver.Rfc,
+		TaxName:       cfdi.Receiver.Name,
+		IssuerRfc:     cfdi.Issuer.Rfc,
+		IssuerName:    cfdi.Issuer.TaxName,
+		UUID:          cfdi.Complement.TaxStamp.UUID,
+		Status:        cfdi.Status,
+	}
+}
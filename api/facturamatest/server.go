@@ -0,0 +1,240 @@
+// Package facturamatest provides an in-memory fake of the Facturama Multiemissor
+// API so clients can be exercised end to end without network access.
+//
+// A typical test starts a server and points a client at it:
+//
+//	srv := facturamatest.NewServer()
+//	defer srv.Close()
+//
+//	client := multiemissor.NewClient(srv.Username, srv.Password, common.WithBaseURL(srv.URL))
+package facturamatest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vanclief/go-facturama/api/common"
+	"github.com/vanclief/go-facturama/api/models"
+)
+
+const (
+	// DefaultUsername is the username accepted by a server created without WithCredentials
+	DefaultUsername = "facturamatest"
+	// DefaultPassword is the password accepted by a server created without WithCredentials
+	DefaultPassword = "facturamatest"
+	// DefaultPageSize is the number of CFDIs returned per page by the search endpoint
+	DefaultPageSize = 100
+
+	// dateLayout is the date format used by Facturama in JSON payloads
+	dateLayout = "2006-01-02T15:04:05"
+)
+
+// Server is a fake Facturama API backed by in-memory state
+type Server struct {
+	*httptest.Server
+
+	// Credentials accepted through Basic Authentication
+	Username string
+	Password string
+
+	// CancelStatus is the Status returned when a CFDI is cancelled. With
+	// CancelStatusPending the CFDI stays active until ResolveCancellation is
+	// called.
+	CancelStatus string
+
+	// PageSize is the number of CFDIs returned per page by the search endpoint
+	PageSize int
+
+	mu       sync.Mutex
+	csds     map[string]models.TaxEntityCSD
+	cfdis    map[string]*cfdiRecord
+	order    []string
+	failures []*Failure
+	requests []Request
+	emails   []SentEmail
+}
+
+// cfdiRecord holds a stored CFDI and its lifecycle data
+type cfdiRecord struct {
+	Info        models.CfdiInfoModel
+	Relations   *models.Cfdiv4Relations
+	Cancelation *models.CancelationStatusLite
+	// Pending is a cancellation waiting for the receiver, the CFDI is still active
+	Pending bool
+}
+
+// Request is a request received by the server
+type Request struct {
+	Method string
+	Path   string
+	Query  string
+}
+
+// Option is a function that configures a Server
+type Option func(*Server)
+
+// WithCredentials sets the credentials accepted by the server
+func WithCredentials(username, password string) Option {
+	return func(s *Server) {
+		s.Username = username
+		s.Password = password
+	}
+}
+
+// WithCSD seeds the server with a CSD
+func WithCSD(csd models.TaxEntityCSD) Option {
+	return func(s *Server) {
+		s.AddCSD(csd)
+	}
+}
+
+// WithCfdi seeds the server with an already stamped CFDI
+func WithCfdi(cfdi models.CfdiInfoModel) Option {
+	return func(s *Server) {
+		s.AddCfdi(cfdi)
+	}
+}
+
+// NewServer starts a new fake Facturama server. The caller must call Close when finished.
+func NewServer(options ...Option) *Server {
+	s := &Server{
+		Username:     DefaultUsername,
+		Password:     DefaultPassword,
+		CancelStatus: "Ok",
+		PageSize:     DefaultPageSize,
+		csds:         make(map[string]models.TaxEntityCSD),
+		cfdis:        make(map[string]*cfdiRecord),
+	}
+
+	for _, option := range options {
+		option(s)
+	}
+
+	s.Server = httptest.NewServer(s.handler())
+
+	return s
+}
+
+// handler builds the HTTP handler with authentication, failure injection and routing
+func (s *Server) handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /api-lite/csds", s.listCSDs)
+	mux.HandleFunc("POST /api-lite/csds", s.createCSD)
+	mux.HandleFunc("GET /api-lite/csds/{rfc}", s.getCSD)
+	mux.HandleFunc("PUT /api-lite/csds/{rfc}", s.updateCSD)
+	mux.HandleFunc("DELETE /api-lite/csds/{rfc}", s.deleteCSD)
+
+	mux.HandleFunc("GET /api-lite/cfdis", s.listCfdis)
+	mux.HandleFunc("POST /api-lite/3/cfdis", s.createCfdi)
+	mux.HandleFunc("GET /api-lite/cfdis/{id}", s.getCfdi)
+	mux.HandleFunc("DELETE /api-lite/cfdis/{id}", s.cancelCfdi)
+
+	mux.HandleFunc("GET /cfdi/{format}/{type}/{id}", s.getCfdiFile)
+	mux.HandleFunc("POST /cfdi", s.sendCfdiByEmail)
+	mux.HandleFunc("GET /acuse/{format}/{type}/{id}", s.getAcuse)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.record(r)
+
+		if failure := s.matchFailure(r); failure != nil {
+			if failure.Handle && s.authorized(r) {
+				mux.ServeHTTP(httptest.NewRecorder(), r)
+			}
+			failure.write(w)
+			return
+		}
+
+		if !s.authorized(r) {
+			writeError(w, http.StatusUnauthorized, common.ErrorResponse{
+				Message: "Authorization has been denied for this request.",
+			})
+			return
+		}
+
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// authorized reports whether the request has the server credentials
+func (s *Server) authorized(r *http.Request) bool {
+	username, password, ok := r.BasicAuth()
+	return ok && username == s.Username && password == s.Password
+}
+
+// Requests returns every request received by the server, in order
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	requests := make([]Request, len(s.requests))
+	copy(requests, s.requests)
+
+	return requests
+}
+
+// RequestCount returns how many requests matched the method and path
+func (s *Server) RequestCount(method, path string) int {
+	count := 0
+	for _, r := range s.Requests() {
+		if r.Method == method && r.Path == path {
+			count++
+		}
+	}
+
+	return count
+}
+
+func (s *Server) record(r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, Request{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Query:  r.URL.RawQuery,
+	})
+}
+
+// writeJSON writes a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+
+	if body != nil {
+		_ = json.NewEncoder(w).Encode(body)
+	}
+}
+
+// writeError writes a Facturama style error response
+func writeError(w http.ResponseWriter, status int, body common.ErrorResponse) {
+	writeJSON(w, status, body)
+}
+
+// writeModelState writes a 400 response with ModelState errors keyed by field
+func writeModelState(w http.ResponseWriter, modelState map[string][]string) {
+	writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+		"Message":    "La solicitud no es válida.",
+		"ModelState": modelState,
+	})
+}
+
+// formatDate formats a time using Facturama's JSON date layout
+func formatDate(t time.Time) string {
+	return t.Format(dateLayout)
+}
+
+// normalizeRFC upper cases and trims an RFC so it can be used as a map key
+func normalizeRFC(rfc string) string {
+	return strings.ToUpper(strings.TrimSpace(rfc))
+}
+
+// notFound builds the error payload returned when an entity does not exist
+func notFound(format string, args ...interface{}) common.ErrorResponse {
+	return common.ErrorResponse{Message: fmt.Sprintf(format, args...)}
+}
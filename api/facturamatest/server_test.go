@@ -0,0 +1,268 @@
+package facturamatest_test
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vanclief/ez"
+	"github.com/vanclief/go-facturama/api/common"
+	"github.com/vanclief/go-facturama/api/facturamatest"
+	"github.com/vanclief/go-facturama/api/models"
+	"github.com/vanclief/go-facturama/api/multiemissor"
+	"github.com/vanclief/go-facturama/decimal"
+)
+
+// apiError returns the Facturama API error wrapped by err, if any
+func apiError(err error) *common.APIError {
+	apiErr, _ := common.AsAPIError(err)
+	return apiErr
+}
+
+func newClient(srv *facturamatest.Server, options ...common.Option) *multiemissor.Client {
+	options = append(options, common.WithBaseURL(srv.URL))
+	return multiemissor.NewClient(srv.Username, srv.Password, options...)
+}
+
+func TestCSDLifecycle(t *testing.T) {
+	srv := facturamatest.NewServer()
+	defer srv.Close()
+
+	client := newClient(srv)
+	ctx := context.Background()
+
+	request := multiemissor.CreateCSDRequest{
+		RFC:                "EKU9003173C9",
+		Certificate:        base64.StdEncoding.EncodeToString([]byte("certificate")),
+		PrivateKey:         base64.StdEncoding.EncodeToString([]byte("private key")),
+		PrivateKeyPassword: "12345678a",
+	}
+
+	err := client.CreateCSD(ctx, request)
+	require.NoError(t, err)
+
+	err = client.CreateCSD(ctx, request)
+	assert.Error(t, err, "Should not allow a second CSD for the same RFC")
+
+	csd, err := client.GetCSDByRFC(ctx, multiemissor.GetCSDByRFCRequest{RFC: request.RFC})
+	require.NoError(t, err)
+	assert.Equal(t, request.RFC, csd.RFC)
+	assert.False(t, csd.UploadDate.IsZero())
+	assert.True(t, csd.CsdExpirationDate.After(csd.UploadDate.Time))
+
+	csds, err := client.ListCSDs(ctx)
+	require.NoError(t, err)
+	assert.Len(t, csds, 1)
+
+	err = client.DeleteCSD(ctx, multiemissor.DeleteCSDRequest{RFC: request.RFC})
+	require.NoError(t, err)
+
+	_, err = client.GetCSDByRFC(ctx, multiemissor.GetCSDByRFCRequest{RFC: request.RFC})
+	apiErr := apiError(err)
+	require.NotNil(t, apiErr)
+	assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+}
+
+func TestCSDModelState(t *testing.T) {
+	srv := facturamatest.NewServer()
+	defer srv.Close()
+
+	err := newClient(srv).CreateCSD(context.Background(), multiemissor.CreateCSDRequest{
+		RFC:                "EKU9003173C9",
+		Certificate:        "not base64!",
+		PrivateKey:         "not base64!",
+		PrivateKeyPassword: "12345678a",
+	})
+
+	apiErr := apiError(err)
+	require.NotNil(t, apiErr)
+	assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+	assert.NotEmpty(t, apiErr.ModelState.Get("Certificate"))
+	assert.NotEmpty(t, apiErr.ModelState.Get("Key"))
+	assert.Equal(t, ez.EINVALID, ez.ErrorCode(err))
+	assert.True(t, common.IsCSDError(err))
+}
+
+func TestAuthentication(t *testing.T) {
+	srv := facturamatest.NewServer(facturamatest.WithCredentials("user", "secret"))
+	defer srv.Close()
+
+	client := multiemissor.NewClient("user", "wrong", common.WithBaseURL(srv.URL))
+	_, err := client.ListCSDs(context.Background())
+
+	apiErr := apiError(err)
+	require.NotNil(t, apiErr)
+	assert.Equal(t, http.StatusUnauthorized, apiErr.StatusCode)
+}
+
+func TestInjectFailure(t *testing.T) {
+	srv := facturamatest.NewServer()
+	defer srv.Close()
+
+	client := newClient(srv, common.WithTimeout(50*time.Millisecond))
+	ctx := context.Background()
+
+	srv.InjectFailure(facturamatest.Failure{
+		Method:     http.MethodGet,
+		Path:       "/api-lite/csds",
+		StatusCode: http.StatusServiceUnavailable,
+		Times:      2,
+	})
+
+	for i := 0; i < 2; i++ {
+		_, err := client.ListCSDs(ctx)
+		apiErr := apiError(err)
+		require.NotNil(t, apiErr)
+		assert.Equal(t, http.StatusServiceUnavailable, apiErr.StatusCode)
+	}
+
+	_, err := client.ListCSDs(ctx)
+	assert.NoError(t, err, "Failure should only trigger twice")
+	assert.Equal(t, 3, srv.RequestCount(http.MethodGet, "/api-lite/csds"))
+
+	srv.InjectFailure(facturamatest.Failure{Drop: true, Times: -1})
+	_, err = client.ListCSDs(ctx)
+	assert.Error(t, err, "Dropped connections should fail")
+	srv.ClearFailures()
+
+	srv.InjectFailure(facturamatest.Failure{Delay: 200 * time.Millisecond})
+	_, err = client.ListCSDs(ctx)
+	assert.Error(t, err, "Slow responses should time out")
+}
+
+func TestCfdiLifecycle(t *testing.T) {
+	srv := facturamatest.NewServer()
+	defer srv.Close()
+
+	client := newClient(srv)
+	ctx := context.Background()
+
+	request := multiemissor.CreateCfdiV4Request{
+		ExpeditionPlace: "78116",
+		Folio:           "100",
+		CfdiType:        "I",
+		PaymentForm:     "01",
+		PaymentMethod:   "PUE",
+		GlobalInformation: &models.GlobalInformationV4Model{
+			Periodicity: "04",
+			Months:      "05",
+			Year:        2025,
+		},
+		Issuer: models.IssuerV4BindingModel{Rfc: "EKU9003173C9", FiscalRegime: "601", Name: "ESCUELA KEMPER URGATE"},
+		Receiver: models.ReceiverV4BindingModel{
+			Rfc:          "XAXX010101000",
+			Name:         "PUBLICO EN GENERAL",
+			CfdiUse:      "S01",
+			FiscalRegime: "616",
+			TaxZipCode:   "78116",
+		},
+		Items: []models.ItemFullBindingModel{
+			{
+				ProductCode: "01010101",
+				Description: "Test product",
+				Unit:        "PIECE",
+				UnitCode:    "H87",
+				UnitPrice:   decimal.NewFromInt(100),
+				Quantity:    decimal.NewFromInt(1),
+				Subtotal:    decimal.NewFromInt(100),
+				Total:       decimal.NewFromInt(116),
+				TaxObject:   "02",
+				Taxes: []models.TaxBindingModel{
+					{Name: "IVA", Base: decimal.NewFromInt(100), Rate: decimal.RequireFromString("0.16"), Total: decimal.NewFromInt(16)},
+				},
+			},
+		},
+	}
+
+	// Without a CSD for the issuer the API rejects the CFDI
+	_, err := client.CreateCfdiV4(ctx, request)
+	apiErr := apiError(err)
+	require.NotNil(t, apiErr)
+	assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+
+	srv.AddCSD(models.TaxEntityCSD{RFC: "EKU9003173C9"})
+
+	cfdi, err := client.CreateCfdiV4(ctx, request)
+	require.NoError(t, err)
+	assert.Equal(t, "ingreso", cfdi.CfdiType)
+	assert.Equal(t, "116", cfdi.Total.String())
+	assert.NotEmpty(t, cfdi.Complement.TaxStamp.UUID)
+
+	file, err := client.GetCfdiFile(ctx, multiemissor.GetCfdiFileRequest{ID: cfdi.ID, Format: "xml", CfdiType: "issuedLite"})
+	require.NoError(t, err)
+	content, err := base64.StdEncoding.DecodeString(file.Content)
+	require.NoError(t, err)
+	assert.Equal(t, file.ContentLength, len(content))
+	assert.Contains(t, string(content), cfdi.Complement.TaxStamp.UUID)
+
+	status, err := client.CancelCfdi(ctx, multiemissor.CancelCfdiRequest{ID: cfdi.ID, Motive: "02"})
+	require.NoError(t, err)
+	assert.Equal(t, "Ok", status.Status)
+	assert.NotEmpty(t, status.AcuseXmlBase64)
+
+	_, err = client.CancelCfdi(ctx, multiemissor.CancelCfdiRequest{ID: cfdi.ID, Motive: "02"})
+	assert.Error(t, err, "Should not cancel twice")
+
+	_, err = client.GetCfdiById(ctx, multiemissor.GetCfdiByIdRequest{ID: "missing"})
+	apiErr = apiError(err)
+	require.NotNil(t, apiErr)
+	assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+}
+
+func TestSearchPagination(t *testing.T) {
+	srv := facturamatest.NewServer()
+	defer srv.Close()
+	srv.PageSize = 2
+
+	for i := 1; i <= 5; i++ {
+		srv.AddCfdi(models.CfdiInfoModel{
+			Folio:    strconv.Itoa(i),
+			Serie:    "A",
+			Date:     fmt.Sprintf("2025-01-%02dT10:00:00", i),
+			Issuer:   models.TaxEntityInfoViewModel{Rfc: "EKU9003173C9"},
+			Receiver: models.ReceiverViewModel{Rfc: "XAXX010101000", Name: "PUBLICO EN GENERAL"},
+		})
+	}
+	srv.AddCfdi(models.CfdiInfoModel{Folio: "1", Issuer: models.TaxEntityInfoViewModel{Rfc: "AAA010101AAA"}})
+
+	client := newClient(srv)
+	ctx := context.Background()
+
+	var folios []string
+	for cfdi, err := range client.AllCfdis(ctx, multiemissor.ListCfdisRequest{IssuerRfc: "EKU9003173C9"}) {
+		require.NoError(t, err)
+		assert.Equal(t, "XAXX010101000", cfdi.Receiver.Rfc)
+		assert.NotEmpty(t, cfdi.Complement.TaxStamp.UUID)
+		folios = append(folios, cfdi.Folio)
+	}
+	assert.Equal(t, []string{"1", "2", "3", "4", "5"}, folios)
+
+	cfdis, err := client.ListCfdis(ctx, multiemissor.ListCfdisRequest{
+		IssuerRfc: "EKU9003173C9",
+		DateStart: time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC),
+		DateEnd:   time.Date(2025, 1, 3, 0, 0, 0, 0, time.UTC),
+	})
+	require.NoError(t, err)
+	require.Len(t, cfdis, 2)
+	assert.Equal(t, "2", cfdis[0].Folio)
+	assert.Equal(t, "3", cfdis[1].Folio)
+
+	cfdis, err = client.ListCfdis(ctx, multiemissor.ListCfdisRequest{Serie: "A", FolioStart: "4", FolioEnd: "4"})
+	require.NoError(t, err)
+	require.Len(t, cfdis, 1)
+	assert.Equal(t, "4", cfdis[0].Folio)
+
+	srv.InjectFailure(facturamatest.Failure{Path: "/api-lite/cfdis", StatusCode: http.StatusInternalServerError})
+	count := 0
+	for _, err := range client.AllCfdis(ctx, multiemissor.ListCfdisRequest{}) {
+		assert.Error(t, err)
+		count++
+	}
+	assert.Equal(t, 1, count, "Iteration should stop after yielding the error")
+}
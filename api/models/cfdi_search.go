@@ -0,0 +1,55 @@
+package models
+
+import "github.com/vanclief/go-facturama/decimal"
+
+// CfdiSearchViewModel represents a CFDI as returned by the CFDI search endpoint
+type CfdiSearchViewModel struct {
+	ID            string          `json:"Id"`
+	CfdiType      string          `json:"CfdiType"`
+	Type          string          `json:"Type"`
+	Serie         string          `json:"Serie"`
+	Folio         string          `json:"Folio"`
+	Date          string          `json:"Date"`
+	Subtotal      decimal.Decimal `json:"Subtotal"`
+	Discount      decimal.Decimal `json:"Discount"`
+	Total         decimal.Decimal `json:"Total"`
+	Currency      string          `json:"Currency"`
+	PaymentMethod string          `json:"PaymentMethod"`
+	Rfc           string          `json:"Rfc"`
+	TaxName       string          `json:"TaxName"`
+	Email         string          `json:"Email"`
+	IssuerRfc     string          `json:"RfcIssuer"`
+	IssuerName    string          `json:"TaxEntityName"`
+	UUID          string          `json:"Uuid"`
+	Status        string          `json:"Status"`
+}
+
+// ToCfdiInfo converts a search result into a CfdiInfoModel, filling the fields
+// the search endpoint provides
+func (cfdi *CfdiSearchViewModel) ToCfdiInfo() CfdiInfoModel {
+	return CfdiInfoModel{
+		ID:            cfdi.ID,
+		CfdiType:      cfdi.CfdiType,
+		Type:          cfdi.Type,
+		Serie:         cfdi.Serie,
+		Folio:         cfdi.Folio,
+		Date:          cfdi.Date,
+		PaymentMethod: cfdi.PaymentMethod,
+		Currency:      cfdi.Currency,
+		Subtotal:      cfdi.Subtotal,
+		Discount:      cfdi.Discount,
+		Total:         cfdi.Total,
+		Status:        cfdi.Status,
+		Issuer: TaxEntityInfoViewModel{
+			Rfc:     cfdi.IssuerRfc,
+			TaxName: cfdi.IssuerName,
+		},
+		Receiver: ReceiverViewModel{
+			Rfc:  cfdi.Rfc,
+			Name: cfdi.TaxName,
+		},
+		Complement: CfdiComplement{
+			TaxStamp: CfdiTaxStamp{UUID: cfdi.UUID},
+		},
+	}
+}
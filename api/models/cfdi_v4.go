@@ -1,5 +1,7 @@
 package models
 
+import "github.com/vanclief/go-facturama/decimal"
+
 // GlobalInformationV4Model represents global information for a CFDI v4
 type GlobalInformationV4Model struct {
 	Periodicity string `json:"Periodicity"`
@@ -63,29 +65,29 @@ type ItemFullBindingModel struct {
 	Description          string                  `json:"Description"`
 	Unit                 string                  `json:"Unit"`
 	UnitCode             string                  `json:"UnitCode"`
-	UnitPrice            float64                 `json:"UnitPrice"`
-	Quantity             float64                 `json:"Quantity"`
-	Subtotal             float64                 `json:"Subtotal"`
-	Discount             float64                 `json:"Discount,omitempty"`
+	UnitPrice            decimal.Decimal         `json:"UnitPrice"`
+	Quantity             decimal.Decimal         `json:"Quantity"`
+	Subtotal             decimal.Decimal         `json:"Subtotal"`
+	Discount             decimal.Decimal         `json:"Discount,omitzero"`
 	TaxObject            string                  `json:"TaxObject"`
 	Taxes                []TaxBindingModel       `json:"Taxes,omitempty"`
 	ThirdPartyAccount    *ThirdPartyAccountModel `json:"ThirdPartyAccount,omitempty"`
 	PropertyTaxIDNumber  []string                `json:"PropertyTaxIDNumber,omitempty"`
 	NumerosPedimento     []string                `json:"NumerosPedimento,omitempty"`
 	Parts                []ItemPartBindingModel  `json:"Parts,omitempty"`
-	Total                float64                 `json:"Total"`
+	Total                decimal.Decimal         `json:"Total"`
 	Complement           *ItemComplementModel    `json:"Complement,omitempty"`
 }
 
 // TaxBindingModel represents a tax in a CFDI v4
 type TaxBindingModel struct {
-	Total       float64 `json:"Total"`
-	Name        string  `json:"Name"`
-	Base        float64 `json:"Base"`
-	Rate        float64 `json:"Rate"`
-	IsRetention bool    `json:"IsRetention"`
-	IsQuota     bool    `json:"IsQuota"`
-	TaxObject   string  `json:"TaxObject,omitempty"`
+	Total       decimal.Decimal `json:"Total"`
+	Name        string          `json:"Name"`
+	Base        decimal.Decimal `json:"Base"`
+	Rate        decimal.Decimal `json:"Rate"`
+	IsRetention bool            `json:"IsRetention"`
+	IsQuota     bool            `json:"IsQuota"`
+	TaxObject   string          `json:"TaxObject,omitempty"`
 }
 
 // ThirdPartyAccountModel represents a third party account in a CFDI v4
@@ -98,13 +100,13 @@ type ThirdPartyAccountModel struct {
 
 // ItemPartBindingModel represents a part of an item in a CFDI v4
 type ItemPartBindingModel struct {
-	Quantity             float64                   `json:"Quantity"`
+	Quantity             decimal.Decimal           `json:"Quantity"`
 	UnitCode             string                    `json:"UnitCode"`
 	ProductCode          string                    `json:"ProductCode,omitempty"`
 	IdentificationNumber string                    `json:"IdentificationNumber,omitempty"`
 	Description          string                    `json:"Description"`
-	UnitPrice            float64                   `json:"UnitPrice"`
-	Amount               float64                   `json:"Amount"`
+	UnitPrice            decimal.Decimal           `json:"UnitPrice"`
+	Amount               decimal.Decimal           `json:"Amount"`
 	CustomsInformation   []CustomsInformationModel `json:"CustomsInformation,omitempty"`
 }
 
@@ -160,20 +162,20 @@ type ThirdTaxInformationModel struct {
 
 // PartModel represents a part in a CFDI v4
 type PartModel struct {
-	Quantity             float64                   `json:"Quantity"`
+	Quantity             decimal.Decimal           `json:"Quantity"`
 	Unit                 string                    `json:"Unit"`
 	IdentificationNumber string                    `json:"IdentificationNumber,omitempty"`
 	Description          string                    `json:"Description"`
-	UnitPrce             float64                   `json:"UnitPrce"`
-	Amount               float64                   `json:"Amount"`
+	UnitPrce             decimal.Decimal           `json:"UnitPrce"`
+	Amount               decimal.Decimal           `json:"Amount"`
 	CustomsInformation   []CustomsInformationModel `json:"CustomsInformation,omitempty"`
 }
 
 // ThirdPartyTaxModel represents third party tax in a CFDI v4
 type ThirdPartyTaxModel struct {
-	Name   string  `json:"Name"`
-	Rate   float64 `json:"Rate"`
-	Amount float64 `json:"Amount"`
+	Name   string          `json:"Name"`
+	Rate   decimal.Decimal `json:"Rate"`
+	Amount decimal.Decimal `json:"Amount"`
 }
 
 // Complementv4 represents complement in a CFDI v4
@@ -221,15 +223,15 @@ type PaymentModel struct {
 	Date                          string                 `json:"Date"`
 	PaymentForm                   string                 `json:"PaymentForm"`
 	Currency                      string                 `json:"Currency"`
-	ExchangeRate                  float64                `json:"ExchangeRate,omitempty"`
-	Amount                        float64                `json:"Amount"`
+	ExchangeRate                  decimal.Decimal        `json:"ExchangeRate,omitzero"`
+	Amount                        decimal.Decimal        `json:"Amount"`
 	OperationNumber               string                 `json:"OperationNumber,omitempty"`
 	RfcIssuerPayerAccount         string                 `json:"RfcIssuerPayerAccount,omitempty"`
 	ForeignAccountNamePayer       string                 `json:"ForeignAccountNamePayer,omitempty"`
 	PayerAccount                  string                 `json:"PayerAccount,omitempty"`
 	RfcReceiverBeneficiaryAccount string                 `json:"RfcReceiverBeneficiaryAccount,omitempty"`
 	BeneficiaryAccount            string                 `json:"BeneficiaryAccount,omitempty"`
-	ExpectedPaid                  float64                `json:"ExpectedPaid,omitempty"`
+	ExpectedPaid                  decimal.Decimal        `json:"ExpectedPaid,omitzero"`
 }
 
 // RelatedDocumentModel represents related document in a payment
@@ -238,12 +240,12 @@ type RelatedDocumentModel struct {
 	Serie                 string            `json:"Serie,omitempty"`
 	Folio                 string            `json:"Folio,omitempty"`
 	Currency              string            `json:"Currency,omitempty"`
-	EquivalenceDocRel     float64           `json:"EquivalenceDocRel,omitempty"`
-	ExchangeRate          float64           `json:"ExchangeRate,omitempty"`
+	EquivalenceDocRel     decimal.Decimal   `json:"EquivalenceDocRel,omitzero"`
+	ExchangeRate          decimal.Decimal   `json:"ExchangeRate,omitzero"`
 	PaymentMethod         string            `json:"PaymentMethod,omitempty"`
 	PartialityNumber      int               `json:"PartialityNumber,omitempty"`
-	PreviousBalanceAmount float64           `json:"PreviousBalanceAmount,omitempty"`
-	AmountPaid            float64           `json:"AmountPaid"`
+	PreviousBalanceAmount decimal.Decimal   `json:"PreviousBalanceAmount,omitzero"`
+	AmountPaid            decimal.Decimal   `json:"AmountPaid"`
 	TaxObject             string            `json:"TaxObject,omitempty"`
 	Taxes                 []TaxBindingModel `json:"Taxes,omitempty"`
 }
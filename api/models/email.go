@@ -0,0 +1,7 @@
+package models
+
+// SendEmailResponse represents the response of the endpoint that sends a CFDI by email
+type SendEmailResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"msj"`
+}
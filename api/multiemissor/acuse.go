@@ -0,0 +1,154 @@
+package multiemissor
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"strings"
+	"time"
+
+	"github.com/vanclief/ez"
+	"github.com/vanclief/go-facturama/cfdixml"
+)
+
+// acuseDateLayout is the date format of the SAT acknowledgments, the fractional
+// seconds they may carry are accepted when parsing
+const acuseDateLayout = "2006-01-02T15:04:05"
+
+// Cancellation status codes (EstatusUUID) of the SAT acknowledgment
+const (
+	AcuseStatusReceived           = "201"
+	AcuseStatusPreviouslyCanceled = "202"
+	AcuseStatusNotIssuer          = "203"
+	AcuseStatusNotApplicable      = "204"
+	AcuseStatusNotFound           = "205"
+)
+
+// acuseStatusDescriptions describe the EstatusUUID codes
+var acuseStatusDescriptions = map[string]string{
+	AcuseStatusReceived:           "Solicitud de cancelación recibida",
+	AcuseStatusPreviouslyCanceled: "Folio fiscal previamente cancelado",
+	AcuseStatusNotIssuer:          "Folio fiscal no corresponde al emisor",
+	AcuseStatusNotApplicable:      "Folio fiscal no aplicable a cancelación",
+	AcuseStatusNotFound:           "Folio fiscal no existente",
+}
+
+// Acuse is a SAT cancellation acknowledgment, the proof that the cancellation
+// of one or more CFDIs was requested
+type Acuse struct {
+	// RfcEmisor is the RFC of the issuer that requested the cancellation
+	RfcEmisor string
+	// Fecha is the date the SAT received the request, in Mexico City time
+	// (cfdixml.MexicoCentral) as written in the acuse
+	Fecha time.Time
+	// Folios are the cancelled CFDIs and their status
+	Folios []AcuseFolio
+	// SignatureValue is the SAT seal (SelloSAT) of the acuse, base64 encoded
+	SignatureValue string
+	// Raw is the acuse XML
+	Raw []byte
+}
+
+// AcuseFolio is the cancellation status of a CFDI in an acuse
+type AcuseFolio struct {
+	UUID string
+	// StatusCode is the EstatusUUID, see the AcuseStatus constants
+	StatusCode string
+}
+
+// Description returns the SAT description of the status code
+func (folio AcuseFolio) Description() string {
+	return acuseStatusDescriptions[folio.StatusCode]
+}
+
+// Accepted reports whether the SAT accepted the cancellation request of the CFDI
+func (folio AcuseFolio) Accepted() bool {
+	return folio.StatusCode == AcuseStatusReceived || folio.StatusCode == AcuseStatusPreviouslyCanceled
+}
+
+// UUID returns the UUID of the first folio, acuses from Facturama have one
+func (acuse *Acuse) UUID() string {
+	if len(acuse.Folios) == 0 {
+		return ""
+	}
+
+	return acuse.Folios[0].UUID
+}
+
+// StatusCode returns the status code of the first folio
+func (acuse *Acuse) StatusCode() string {
+	if len(acuse.Folios) == 0 {
+		return ""
+	}
+
+	return acuse.Folios[0].StatusCode
+}
+
+// acuseXML is the XML structure of the acuse. Elements are matched by local
+// name since the SAT has used several namespaces over time.
+type acuseXML struct {
+	XMLName   xml.Name `xml:"Acuse"`
+	Fecha     string   `xml:"Fecha,attr"`
+	RfcEmisor string   `xml:"RfcEmisor,attr"`
+	Folios    []struct {
+		UUID        string `xml:"UUID"`
+		EstatusUUID string `xml:"EstatusUUID"`
+	} `xml:"Folios"`
+	Signature struct {
+		SignatureValue string `xml:"SignatureValue"`
+	} `xml:"Signature"`
+}
+
+// ParseAcuse decodes a SAT cancellation acknowledgment XML
+func ParseAcuse(data []byte) (*Acuse, error) {
+	const op = "multiemissor.ParseAcuse"
+
+	var doc acuseXML
+	err := xml.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, ez.New(op, ez.EINVALID, "The acuse is not a valid XML document", err)
+	}
+
+	acuse := &Acuse{
+		RfcEmisor:      strings.TrimSpace(doc.RfcEmisor),
+		SignatureValue: strings.Join(strings.Fields(doc.Signature.SignatureValue), ""),
+		Raw:            data,
+	}
+
+	for _, folio := range doc.Folios {
+		acuse.Folios = append(acuse.Folios, AcuseFolio{
+			UUID:       strings.ToUpper(strings.TrimSpace(folio.UUID)),
+			StatusCode: strings.TrimSpace(folio.EstatusUUID),
+		})
+	}
+
+	if len(acuse.Folios) == 0 {
+		return nil, ez.New(op, ez.EINVALID, "The acuse does not have any folio", nil)
+	}
+
+	if doc.Fecha != "" {
+		acuse.Fecha, err = time.ParseInLocation(acuseDateLayout, strings.TrimSpace(doc.Fecha), cfdixml.MexicoCentral)
+		if err != nil {
+			return nil, ez.New(op, ez.EINVALID, "The acuse has an invalid Fecha", err)
+		}
+	}
+
+	return acuse, nil
+}
+
+// ParseAcuseBase64 decodes a base64 encoded acuse, such as
+// CancelationStatusLite.AcuseXmlBase64 or the content of GetCancellationAcuse
+func ParseAcuseBase64(encoded string) (*Acuse, error) {
+	const op = "multiemissor.ParseAcuseBase64"
+
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ez.New(op, ez.EINVALID, "The acuse is not valid base64", err)
+	}
+
+	acuse, err := ParseAcuse(data)
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	return acuse, nil
+}
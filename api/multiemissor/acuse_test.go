@@ -0,0 +1,95 @@
+package multiemissor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vanclief/ez"
+	"github.com/vanclief/go-facturama/api/common"
+	"github.com/vanclief/go-facturama/api/facturamatest"
+	"github.com/vanclief/go-facturama/api/models"
+	"github.com/vanclief/go-facturama/cfdixml"
+)
+
+// satAcuse is a cancellation acknowledgment as returned by the SAT
+const satAcuse = `<?xml version="1.0" encoding="utf-8"?>
+<Acuse xmlns:xsd="http://www.w3.org/2001/XMLSchema" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" Fecha="2024-05-14T10:32:07.2517437" RfcEmisor="EKU9003173C9" xmlns="http://cancelacfd.sat.gob.mx">
+  <Folios>
+    <UUID>6b1b3c4e-1f2a-4b5c-9d8e-7f6a5b4c3d2e</UUID>
+    <EstatusUUID>201</EstatusUUID>
+  </Folios>
+  <Signature Id="SelloSAT" xmlns="http://www.w3.org/2000/09/xmldsig#">
+    <SignedInfo>
+      <CanonicalizationMethod Algorithm="http://www.w3.org/TR/2001/REC-xml-c14n-20010315" />
+      <SignatureMethod Algorithm="http://www.w3.org/2001/04/xmldsig-more#hmac-sha512" />
+    </SignedInfo>
+    <SignatureValue>kqQ1bD3Z
+      9aXo7Q==</SignatureValue>
+  </Signature>
+</Acuse>`
+
+func TestParseAcuse(t *testing.T) {
+	acuse, err := ParseAcuse([]byte(satAcuse))
+	require.NoError(t, err)
+
+	assert.Equal(t, "EKU9003173C9", acuse.RfcEmisor)
+	assert.Equal(t, time.Date(2024, 5, 14, 10, 32, 7, 251743700, cfdixml.MexicoCentral), acuse.Fecha)
+	assert.True(t, acuse.Fecha.Equal(time.Date(2024, 5, 14, 16, 32, 7, 251743700, time.UTC)))
+	assert.Equal(t, "6B1B3C4E-1F2A-4B5C-9D8E-7F6A5B4C3D2E", acuse.UUID())
+	assert.Equal(t, AcuseStatusReceived, acuse.StatusCode())
+	assert.True(t, acuse.Folios[0].Accepted())
+	assert.Equal(t, "Solicitud de cancelación recibida", acuse.Folios[0].Description())
+	assert.Equal(t, "kqQ1bD3Z9aXo7Q==", acuse.SignatureValue)
+
+	_, err = ParseAcuse([]byte("<html></html>"))
+	assert.Equal(t, ez.EINVALID, ez.ErrorCode(err))
+
+	_, err = ParseAcuse([]byte(`<Acuse Fecha="2024-05-14T10:32:07" RfcEmisor="EKU9003173C9"></Acuse>`))
+	assert.Equal(t, ez.EINVALID, ez.ErrorCode(err))
+
+	_, err = ParseAcuseBase64("not base64")
+	assert.Equal(t, ez.EINVALID, ez.ErrorCode(err))
+}
+
+func TestGetCancellationAcuse(t *testing.T) {
+	srv := facturamatest.NewServer()
+	defer srv.Close()
+
+	id := srv.AddCfdi(models.CfdiInfoModel{Issuer: models.TaxEntityInfoViewModel{Rfc: "EKU9003173C9"}})
+	client := NewClient(srv.Username, srv.Password, common.WithBaseURL(srv.URL))
+	ctx := context.Background()
+
+	// There is no acuse until the CFDI is cancelled
+	_, err := client.GetCancellationAcuse(ctx, GetCancellationAcuseRequest{Format: "xml", ID: id})
+	assert.True(t, common.IsNotFound(err))
+
+	status, err := client.CancelCfdi(ctx, CancelCfdiRequest{ID: id, Motive: "02"})
+	require.NoError(t, err)
+
+	fromCancel, err := ParseAcuseBase64(status.AcuseXmlBase64)
+	require.NoError(t, err)
+	assert.Equal(t, status.UUID, fromCancel.UUID())
+
+	file, err := client.GetCancellationAcuse(ctx, GetCancellationAcuseRequest{Format: "XML", ID: id})
+	require.NoError(t, err)
+
+	acuse, err := ParseAcuseBase64(file.Content)
+	require.NoError(t, err)
+	assert.Equal(t, status.UUID, acuse.UUID())
+	assert.Equal(t, "EKU9003173C9", acuse.RfcEmisor)
+	assert.NotEmpty(t, acuse.SignatureValue)
+
+	for _, format := range []string{"pdf", "html"} {
+		file, err = client.GetCancellationAcuse(ctx, GetCancellationAcuseRequest{Format: format, ID: id})
+		require.NoError(t, err, format)
+		assert.Equal(t, format, file.ContentType)
+	}
+
+	request := GetCancellationAcuseRequest{Format: "docx", CfdiType: "received"}
+	errs, ok := AsValidationErrors(request.Validate())
+	require.True(t, ok)
+	assert.Equal(t, []string{"ID", "Format", "CfdiType"}, errs.Fields())
+}
@@ -2,19 +2,31 @@ package multiemissor
 
 import (
 	"context"
+	"encoding/base64"
 	"os"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/suite"
 	"github.com/vanclief/go-facturama/api/common"
+	"github.com/vanclief/go-facturama/api/facturamatest"
+	"github.com/vanclief/go-facturama/api/models"
+	"github.com/vanclief/go-facturama/decimal"
 	"github.com/vanclief/go-facturama/utils"
 )
 
+const (
+	// fakeRFC is the issuer RFC used when running against the fake server
+	fakeRFC = "EKU9003173C9"
+	// fakeCfdiID is the ID of the CFDI seeded in the fake server, matching the sandbox one
+	fakeCfdiID = "VRHXPSsy-Xx0i0LyHNziJA2"
+)
+
 // APIClientSuite is a test suite for Facturama API client tests
 type APIClientSuite struct {
 	suite.Suite
 	Client             *Client
+	Server             *facturamatest.Server
 	Context            context.Context
 	Cancel             context.CancelFunc
 	RFC                string
@@ -32,9 +44,10 @@ func (s *APIClientSuite) SetupSuite() {
 	privateKeyPath := os.Getenv("FACTURAMA_PK_PATH")
 	privateKeyPassword := os.Getenv("FACTURAMA_PK_PASSWORD")
 
-	// Require credentials to be set
+	// Without credentials the suite runs against an in-memory fake of the API
 	if username == "" {
-		s.T().Fatal("FACTURAMA_USERNAME environment variable must be set to run tests")
+		s.setupFakeServer()
+		return
 	}
 
 	if password == "" {
@@ -78,9 +91,46 @@ func (s *APIClientSuite) SetupSuite() {
 	s.Context, s.Cancel = context.WithTimeout(context.Background(), 30*time.Second)
 }
 
+// setupFakeServer initializes the suite against a facturamatest server seeded
+// with the same data the tests expect from the sandbox
+func (s *APIClientSuite) setupFakeServer() {
+	s.RFC = fakeRFC
+	s.Certificate = base64.StdEncoding.EncodeToString([]byte("certificate"))
+	s.PrivateKey = base64.StdEncoding.EncodeToString([]byte("private key"))
+	s.PrivateKeyPassword = "12345678a"
+
+	s.Server = facturamatest.NewServer(
+		facturamatest.WithCSD(models.TaxEntityCSD{
+			RFC:                s.RFC,
+			Certificate:        s.Certificate,
+			PrivateKey:         s.PrivateKey,
+			PrivateKeyPassword: s.PrivateKeyPassword,
+		}),
+		facturamatest.WithCfdi(models.CfdiInfoModel{
+			ID:              fakeCfdiID,
+			CfdiType:        "ingreso",
+			Type:            "issued",
+			Folio:           "Test-000",
+			ExpeditionPlace: "78116",
+			Currency:        "MXN",
+			Subtotal:        decimal.NewFromInt(100),
+			Total:           decimal.NewFromInt(116),
+			Issuer:          models.TaxEntityInfoViewModel{Rfc: s.RFC, FiscalRegime: "601"},
+			Receiver:        models.ReceiverViewModel{Rfc: "XAXX010101000", Name: "PUBLICO EN GENERAL"},
+		}),
+	)
+
+	s.Client = NewClient(s.Server.Username, s.Server.Password, common.WithBaseURL(s.Server.URL))
+	s.Context, s.Cancel = context.WithTimeout(context.Background(), 30*time.Second)
+}
+
 // TearDownSuite cleans up after all tests have run
 func (s *APIClientSuite) TearDownSuite() {
 	s.Cancel()
+
+	if s.Server != nil {
+		s.Server.Close()
+	}
 }
 
 func TestAPIClientSuite(t *testing.T) {
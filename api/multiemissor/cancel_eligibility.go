@@ -0,0 +1,315 @@
+package multiemissor
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/vanclief/go-facturama/api/models"
+	"github.com/vanclief/go-facturama/cfdixml"
+	"github.com/vanclief/go-facturama/decimal"
+	"github.com/vanclief/go-facturama/rfc"
+)
+
+// CancellationEligibility is how a CFDI can be cancelled according to the SAT rules
+type CancellationEligibility string
+
+const (
+	// EligibleDirect is a CFDI the SAT cancels without the receiver acceptance
+	EligibleDirect CancellationEligibility = "direct"
+	// EligibleWithAcceptance is a CFDI whose cancellation the receiver must accept
+	EligibleWithAcceptance CancellationEligibility = "needs_acceptance"
+	// EligibleBlocked is a CFDI that cannot be cancelled, e.g. until its
+	// dependent CFDIs are cancelled
+	EligibleBlocked CancellationEligibility = "blocked"
+)
+
+// Cancellation eligibility reason codes
+const (
+	// ReasonAlreadyCancelled is a CFDI that is already cancelled
+	ReasonAlreadyCancelled = "already_cancelled"
+	// ReasonCancellationInProgress is a CFDI with a cancellation waiting for the receiver
+	ReasonCancellationInProgress = "cancellation_in_progress"
+	// ReasonDeadlinePassed is a CFDI issued in a fiscal year whose annual
+	// return deadline has passed
+	ReasonDeadlinePassed = "deadline_passed"
+	// ReasonActiveDependents is a CFDI with active related CFDIs, such as the
+	// payment CFDIs of a PPD invoice
+	ReasonActiveDependents = "active_dependents"
+	// ReasonLowAmount is a CFDI of up to MaxDirectCancellationAmount MXN
+	ReasonLowAmount = "low_amount"
+	// ReasonPayroll is a payroll CFDI (nómina)
+	ReasonPayroll = "payroll"
+	// ReasonEgreso is an egreso CFDI, such as a credit note
+	ReasonEgreso = "egreso"
+	// ReasonTraslado is a traslado CFDI
+	ReasonTraslado = "traslado"
+	// ReasonGeneralPublic is a CFDI issued to the general public, including global invoices
+	ReasonGeneralPublic = "general_public"
+	// ReasonForeignReceiver is a CFDI issued to a foreign resident
+	ReasonForeignReceiver = "foreign_receiver"
+	// ReasonRecentlyStamped is a CFDI cancelled within DirectCancellationWindow of its stamping
+	ReasonRecentlyStamped = "recently_stamped"
+	// ReasonReceiverAcceptance is a CFDI that matches none of the rules for a
+	// direct cancellation
+	ReasonReceiverAcceptance = "receiver_acceptance"
+)
+
+// Limits of the SAT rules for cancelling without the receiver acceptance
+var (
+	// MaxDirectCancellationAmount is the total, in MXN, up to which a CFDI is
+	// cancelled without acceptance
+	MaxDirectCancellationAmount = decimal.NewFromInt(1000)
+	// DirectCancellationWindow is the time after the stamping in which a CFDI
+	// is cancelled without acceptance
+	DirectCancellationWindow = 24 * time.Hour
+)
+
+// RelationSubstitution is the c_TipoRelacion of a CFDI that replaces another
+const RelationSubstitution = "04"
+
+// EligibilityReason explains a CancellationCheck
+type EligibilityReason struct {
+	// Code is one of the Reason constants
+	Code string `json:"code"`
+	// Message describes the reason in English
+	Message string `json:"message"`
+	// MessageES describes the reason in Spanish
+	MessageES string `json:"message_es"`
+}
+
+// CancellationCheck is the result of CheckCancellation
+type CancellationCheck struct {
+	Eligibility CancellationEligibility
+	// Reasons are all the rules that matched. A direct cancellation can match
+	// several of them.
+	Reasons []EligibilityReason
+	// Dependents are the active related CFDIs that must be cancelled first
+	Dependents []models.CfdiInfoModel
+}
+
+// Has reports whether the check matched the reason
+func (check CancellationCheck) Has(code string) bool {
+	for _, reason := range check.Reasons {
+		if reason.Code == code {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RelatedCfdi is a CFDI that references the one being cancelled
+type RelatedCfdi struct {
+	Cfdi models.CfdiInfoModel
+	// RelationType is the c_TipoRelacion used to reference it, empty for the
+	// payment CFDIs that pay it
+	RelationType string
+}
+
+// CheckCancellation reports whether a CFDI can be cancelled at the time now
+// without the receiver acceptance, needs it, or is blocked until its
+// dependents are cancelled. The related CFDIs are the ones that reference the
+// CFDI: its payment CFDIs, credit notes and substitutes. It applies the SAT
+// rules locally; the SAT has the final word.
+func CheckCancellation(cfdi models.CfdiInfoModel, related []RelatedCfdi, now time.Time) CancellationCheck {
+	var check CancellationCheck
+
+	switch stateFromStatus(cfdi.Status, StateActive) {
+	case StateCancelled:
+		check.block(ReasonAlreadyCancelled,
+			"The CFDI is already cancelled",
+			"El CFDI ya está cancelado")
+		return check
+	case StateCancelRequested:
+		check.block(ReasonCancellationInProgress,
+			"The CFDI has a cancellation waiting for the receiver",
+			"El CFDI tiene una cancelación en espera del receptor")
+		return check
+	}
+
+	issued, issuedOk := parseCfdiDate(cfdi.Date)
+	if issuedOk {
+		deadline := cancellationDeadline(issued, cfdi.Issuer.Rfc)
+		if !deadline.IsZero() && !now.Before(deadline) {
+			last := deadline.AddDate(0, 0, -1).Format(time.DateOnly)
+			check.block(ReasonDeadlinePassed,
+				fmt.Sprintf("CFDIs of %d can only be cancelled until %s", issued.Year(), last),
+				fmt.Sprintf("Los CFDI de %d solo se pueden cancelar hasta el %s", issued.Year(), last))
+		}
+	}
+
+	for _, dependent := range related {
+		if dependent.RelationType == RelationSubstitution {
+			continue
+		}
+		if stateFromStatus(dependent.Cfdi.Status, StateActive) == StateCancelled {
+			continue
+		}
+		check.Dependents = append(check.Dependents, dependent.Cfdi)
+	}
+	if len(check.Dependents) > 0 {
+		message := fmt.Sprintf("The CFDI has %d active related CFDIs that must be cancelled first", len(check.Dependents))
+		messageES := fmt.Sprintf("El CFDI tiene %d CFDI relacionados vigentes que se deben cancelar primero", len(check.Dependents))
+		if isPPD(cfdi) {
+			message = fmt.Sprintf("The PPD invoice has %d active payment or related CFDIs that must be cancelled first", len(check.Dependents))
+			messageES = fmt.Sprintf("La factura PPD tiene %d CFDI de pago o relacionados vigentes que se deben cancelar primero", len(check.Dependents))
+		}
+		check.block(ReasonActiveDependents, message, messageES)
+	}
+
+	if check.Eligibility == EligibleBlocked {
+		return check
+	}
+
+	switch cfdiTypeCode(cfdi) {
+	case "N":
+		check.direct(ReasonPayroll,
+			"Payroll CFDIs do not need the receiver acceptance",
+			"Los CFDI de nómina no requieren aceptación del receptor")
+	case "E":
+		check.direct(ReasonEgreso,
+			"Egreso CFDIs do not need the receiver acceptance",
+			"Los CFDI de egreso no requieren aceptación del receptor")
+	case "T":
+		check.direct(ReasonTraslado,
+			"Traslado CFDIs do not need the receiver acceptance",
+			"Los CFDI de traslado no requieren aceptación del receptor")
+	}
+
+	if total, ok := totalMXN(cfdi); ok && !total.GreaterThan(MaxDirectCancellationAmount) {
+		check.direct(ReasonLowAmount,
+			fmt.Sprintf("CFDIs of up to %s MXN do not need the receiver acceptance", MaxDirectCancellationAmount),
+			fmt.Sprintf("Los CFDI de hasta %s MXN no requieren aceptación del receptor", MaxDirectCancellationAmount))
+	}
+
+	switch rfc.Normalize(cfdi.Receiver.Rfc) {
+	case rfc.GenericNational:
+		check.direct(ReasonGeneralPublic,
+			"CFDIs issued to the general public do not need the receiver acceptance",
+			"Los CFDI emitidos al público en general no requieren aceptación del receptor")
+	case rfc.GenericForeign:
+		check.direct(ReasonForeignReceiver,
+			"CFDIs issued to foreign residents do not need the receiver acceptance",
+			"Los CFDI emitidos a residentes en el extranjero no requieren aceptación del receptor")
+	}
+
+	stamped, stampedOk := parseCfdiDate(cfdi.Complement.TaxStamp.Date)
+	if !stampedOk {
+		stamped, stampedOk = issued, issuedOk
+	}
+	if stampedOk && now.Sub(stamped) <= DirectCancellationWindow {
+		hours := int(DirectCancellationWindow.Hours())
+		check.direct(ReasonRecentlyStamped,
+			fmt.Sprintf("CFDIs cancelled within %d hours of their stamping do not need the receiver acceptance", hours),
+			fmt.Sprintf("Los CFDI cancelados dentro de las %d horas siguientes a su timbrado no requieren aceptación del receptor", hours))
+	}
+
+	if check.Eligibility == "" {
+		check.Eligibility = EligibleWithAcceptance
+		check.add(ReasonReceiverAcceptance,
+			"The receiver must accept the cancellation within 72 hours",
+			"El receptor debe aceptar la cancelación dentro de las 72 horas")
+	}
+
+	return check
+}
+
+// add appends a reason
+func (check *CancellationCheck) add(code, message, messageES string) {
+	check.Reasons = append(check.Reasons, EligibilityReason{Code: code, Message: message, MessageES: messageES})
+}
+
+// block appends a reason that prevents the cancellation
+func (check *CancellationCheck) block(code, message, messageES string) {
+	check.Eligibility = EligibleBlocked
+	check.add(code, message, messageES)
+}
+
+// direct appends a reason for a cancellation without acceptance
+func (check *CancellationCheck) direct(code, message, messageES string) {
+	check.Eligibility = EligibleDirect
+	check.add(code, message, messageES)
+}
+
+// cancellationDeadline returns the start of the day after the last one a CFDI
+// issued at the date can be cancelled: the end of the month of the annual
+// return of its fiscal year, March for personas morales and April for
+// personas físicas. It is zero when the issuer RFC is invalid.
+func cancellationDeadline(issued time.Time, issuerRfc string) time.Time {
+	issuer, err := rfc.Parse(issuerRfc)
+	if err != nil {
+		return time.Time{}
+	}
+
+	month := time.May
+	if issuer.IsMoral() {
+		month = time.April
+	}
+
+	return time.Date(issued.Year()+1, month, 1, 0, 0, 0, 0, cfdixml.MexicoCentral)
+}
+
+// parseCfdiDate parses a CFDI date, which is in the time of central Mexico
+func parseCfdiDate(value string) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, false
+	}
+
+	// Dates may have fractional seconds or an offset
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, true
+	}
+	if i := strings.IndexByte(value, '.'); i >= 0 {
+		value = value[:i]
+	}
+	t, err := time.ParseInLocation(cfdixml.DateLayout, value, cfdixml.MexicoCentral)
+
+	return t, err == nil
+}
+
+// cfdiTypeNames maps the CFDI type names returned by Facturama to their codes
+var cfdiTypeNames = map[string]string{
+	"ingreso":  "I",
+	"egreso":   "E",
+	"traslado": "T",
+	"nomina":   "N",
+	"nómina":   "N",
+	"pago":     "P",
+}
+
+// cfdiTypeCode returns the c_TipoDeComprobante code of a CFDI, Facturama
+// reports the type name
+func cfdiTypeCode(cfdi models.CfdiInfoModel) string {
+	for _, value := range []string{cfdi.CfdiType, cfdi.Type} {
+		value = strings.ToLower(strings.TrimSpace(value))
+		if code, ok := cfdiTypeNames[value]; ok {
+			return code
+		}
+		if code := strings.ToUpper(value); len(code) == 1 && strings.Contains("IETNP", code) {
+			return code
+		}
+	}
+
+	return ""
+}
+
+// isPPD reports whether the CFDI is paid in installments or deferred
+func isPPD(cfdi models.CfdiInfoModel) bool {
+	return strings.EqualFold(strings.TrimSpace(cfdi.PaymentMethod), "PPD")
+}
+
+// totalMXN returns the total of the CFDI in MXN. It is false for a foreign
+// currency without exchange rate.
+func totalMXN(cfdi models.CfdiInfoModel) (decimal.Decimal, bool) {
+	currency := strings.ToUpper(strings.TrimSpace(cfdi.Currency))
+	if currency == "" || currency == "MXN" {
+		return cfdi.Total, true
+	}
+	if cfdi.ExchangeRate.IsZero() {
+		return decimal.Decimal{}, false
+	}
+
+	return cfdi.Total.Mul(cfdi.ExchangeRate), true
+}
@@ -0,0 +1,173 @@
+package multiemissor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vanclief/go-facturama/api/models"
+	"github.com/vanclief/go-facturama/cfdixml"
+	"github.com/vanclief/go-facturama/decimal"
+	"github.com/vanclief/go-facturama/rfc"
+)
+
+func TestCheckCancellation(t *testing.T) {
+	now := time.Date(2025, time.June, 10, 12, 0, 0, 0, cfdixml.MexicoCentral)
+
+	invoice := func(change func(*models.CfdiInfoModel)) models.CfdiInfoModel {
+		cfdi := models.CfdiInfoModel{
+			CfdiType:      "ingreso",
+			Date:          "2025-06-01T10:00:00",
+			PaymentMethod: "PUE",
+			Currency:      "MXN",
+			Total:         decimal.NewFromInt(5000),
+			Status:        "active",
+			Issuer:        models.TaxEntityInfoViewModel{Rfc: "EKU9003173C9"},
+			Receiver:      models.ReceiverViewModel{Rfc: "XOJI740919U48"},
+		}
+		if change != nil {
+			change(&cfdi)
+		}
+		return cfdi
+	}
+
+	payment := RelatedCfdi{Cfdi: models.CfdiInfoModel{ID: "pay-1", CfdiType: "pago", Status: "active"}}
+
+	tests := []struct {
+		name        string
+		cfdi        models.CfdiInfoModel
+		related     []RelatedCfdi
+		now         time.Time
+		eligibility CancellationEligibility
+		reasons     []string
+	}{
+		{
+			name:        "needs acceptance",
+			cfdi:        invoice(nil),
+			eligibility: EligibleWithAcceptance,
+			reasons:     []string{ReasonReceiverAcceptance},
+		},
+		{
+			name:        "low amount",
+			cfdi:        invoice(func(c *models.CfdiInfoModel) { c.Total = decimal.NewFromInt(1000) }),
+			eligibility: EligibleDirect,
+			reasons:     []string{ReasonLowAmount},
+		},
+		{
+			name: "foreign currency over the amount",
+			cfdi: invoice(func(c *models.CfdiInfoModel) {
+				c.Currency = "USD"
+				c.Total = decimal.NewFromInt(100)
+				c.ExchangeRate = decimal.NewFromInt(20)
+			}),
+			eligibility: EligibleWithAcceptance,
+			reasons:     []string{ReasonReceiverAcceptance},
+		},
+		{
+			name:        "egreso",
+			cfdi:        invoice(func(c *models.CfdiInfoModel) { c.CfdiType = "egreso" }),
+			eligibility: EligibleDirect,
+			reasons:     []string{ReasonEgreso},
+		},
+		{
+			name:        "payroll code",
+			cfdi:        invoice(func(c *models.CfdiInfoModel) { c.CfdiType = "N" }),
+			eligibility: EligibleDirect,
+			reasons:     []string{ReasonPayroll},
+		},
+		{
+			name:        "global invoice",
+			cfdi:        invoice(func(c *models.CfdiInfoModel) { c.Receiver.Rfc = rfc.GenericNational }),
+			eligibility: EligibleDirect,
+			reasons:     []string{ReasonGeneralPublic},
+		},
+		{
+			name:        "foreign receiver",
+			cfdi:        invoice(func(c *models.CfdiInfoModel) { c.Receiver.Rfc = rfc.GenericForeign }),
+			eligibility: EligibleDirect,
+			reasons:     []string{ReasonForeignReceiver},
+		},
+		{
+			name:        "recently stamped",
+			cfdi:        invoice(func(c *models.CfdiInfoModel) { c.Complement.TaxStamp.Date = "2025-06-09T13:00:00" }),
+			eligibility: EligibleDirect,
+			reasons:     []string{ReasonRecentlyStamped},
+		},
+		{
+			name:        "several rules",
+			cfdi:        invoice(func(c *models.CfdiInfoModel) { c.CfdiType = "traslado"; c.Total = decimal.Zero }),
+			eligibility: EligibleDirect,
+			reasons:     []string{ReasonTraslado, ReasonLowAmount},
+		},
+		{
+			name:        "ppd with payments",
+			cfdi:        invoice(func(c *models.CfdiInfoModel) { c.PaymentMethod = "PPD"; c.Total = decimal.NewFromInt(10) }),
+			related:     []RelatedCfdi{payment},
+			eligibility: EligibleBlocked,
+			reasons:     []string{ReasonActiveDependents},
+		},
+		{
+			name: "cancelled dependents and substitutes",
+			cfdi: invoice(nil),
+			related: []RelatedCfdi{
+				{Cfdi: models.CfdiInfoModel{ID: "pay-2", CfdiType: "pago", Status: "canceled"}},
+				{Cfdi: models.CfdiInfoModel{ID: "sub-1", Status: "active"}, RelationType: RelationSubstitution},
+			},
+			eligibility: EligibleWithAcceptance,
+			reasons:     []string{ReasonReceiverAcceptance},
+		},
+		{
+			name:        "already cancelled",
+			cfdi:        invoice(func(c *models.CfdiInfoModel) { c.Status = "canceled" }),
+			eligibility: EligibleBlocked,
+			reasons:     []string{ReasonAlreadyCancelled},
+		},
+		{
+			name:        "cancellation in progress",
+			cfdi:        invoice(func(c *models.CfdiInfoModel) { c.Status = "pending" }),
+			eligibility: EligibleBlocked,
+			reasons:     []string{ReasonCancellationInProgress},
+		},
+		{
+			name:        "moral after march",
+			cfdi:        invoice(func(c *models.CfdiInfoModel) { c.Date = "2024-12-31T10:00:00" }),
+			eligibility: EligibleBlocked,
+			reasons:     []string{ReasonDeadlinePassed},
+		},
+		{
+			name: "fisica in april",
+			cfdi: invoice(func(c *models.CfdiInfoModel) {
+				c.Date = "2024-12-31T10:00:00"
+				c.Issuer.Rfc = "XOJI740919U48"
+			}),
+			now:         time.Date(2025, time.April, 30, 23, 0, 0, 0, cfdixml.MexicoCentral),
+			eligibility: EligibleWithAcceptance,
+			reasons:     []string{ReasonReceiverAcceptance},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkNow := now
+			if !tt.now.IsZero() {
+				checkNow = tt.now
+			}
+
+			check := CheckCancellation(tt.cfdi, tt.related, checkNow)
+			assert.Equal(t, tt.eligibility, check.Eligibility)
+
+			codes := make([]string, len(check.Reasons))
+			for i, reason := range check.Reasons {
+				codes[i] = reason.Code
+				assert.NotEmpty(t, reason.Message)
+				assert.NotEmpty(t, reason.MessageES)
+			}
+			assert.Equal(t, tt.reasons, codes)
+		})
+	}
+
+	check := CheckCancellation(invoice(func(c *models.CfdiInfoModel) { c.PaymentMethod = "PPD" }), []RelatedCfdi{payment}, now)
+	assert.Equal(t, []models.CfdiInfoModel{payment.Cfdi}, check.Dependents)
+	assert.True(t, check.Has(ReasonActiveDependents))
+	assert.Contains(t, check.Reasons[0].Message, "PPD")
+}
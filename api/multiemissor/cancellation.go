@@ -0,0 +1,185 @@
+package multiemissor
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CancellationState is a state of the invoice lifecycle:
+//
+//	active → cancel requested → cancelled
+//	                          → cancel rejected → cancel requested ...
+//
+// CFDIs that do not need the receiver acceptance go from active to cancelled.
+type CancellationState string
+
+const (
+	// StateActive is a valid CFDI without a cancellation in progress
+	StateActive CancellationState = "active"
+	// StateCancelRequested is a cancellation waiting for the receiver acceptance
+	StateCancelRequested CancellationState = "cancel_requested"
+	// StateCancelled is a cancelled CFDI, final
+	StateCancelled CancellationState = "cancelled"
+	// StateCancelRejected is a cancellation the receiver rejected, the CFDI is still valid
+	StateCancelRejected CancellationState = "cancel_rejected"
+)
+
+// cancellationTransitions are the allowed transitions between states
+var cancellationTransitions = map[CancellationState][]CancellationState{
+	StateActive:          {StateCancelRequested, StateCancelled},
+	StateCancelRequested: {StateCancelled, StateCancelRejected},
+	StateCancelRejected:  {StateCancelRequested, StateCancelled},
+	StateCancelled:       {},
+}
+
+// CanTransition reports whether the lifecycle allows going from the state to another
+func (state CancellationState) CanTransition(to CancellationState) bool {
+	for _, next := range cancellationTransitions[state] {
+		if next == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Final reports whether the cancellation is over: the CFDI was cancelled or
+// the receiver rejected the cancellation
+func (state CancellationState) Final() bool {
+	return state == StateCancelled || state == StateCancelRejected
+}
+
+// CancellationOutcome is how a finished cancellation ended
+type CancellationOutcome string
+
+const (
+	// OutcomeAccepted is a cancellation accepted by the receiver, or one that did not need it
+	OutcomeAccepted CancellationOutcome = "accepted"
+	// OutcomeRejected is a cancellation rejected by the receiver
+	OutcomeRejected CancellationOutcome = "rejected"
+	// OutcomeUnknown is a cancellation that completed between a poll inside
+	// the receiver deadline and a poll after it, so it may have been accepted
+	// or expired
+	OutcomeUnknown CancellationOutcome = "unknown"
+	// OutcomeExpired is a cancellation the receiver did not answer within the
+	// SAT deadline, which cancels the CFDI
+	OutcomeExpired CancellationOutcome = "expired"
+)
+
+// Cancellation is the progress of the cancellation of a CFDI, as saved in a CancellationStore
+type Cancellation struct {
+	CfdiID string
+	UUID   string
+	Motive string
+	State  CancellationState
+	// Outcome is set once the state is final. Unless the status tells it, an
+	// accepted and an expired cancellation are told apart by the time of the
+	// polls, see CancellationTracker.Check.
+	Outcome CancellationOutcome
+	// Status is the last status reported by Facturama
+	Status string
+	// AcuseXmlBase64 is the acknowledgment returned when the cancellation was requested
+	AcuseXmlBase64 string
+	RequestedAt    time.Time
+	UpdatedAt      time.Time
+	// CheckedAt is the time of the last poll and Checks the number of polls
+	CheckedAt time.Time
+	Checks    int
+}
+
+// SAT cancellation statuses (EstatusCancelacion) that tell the outcome
+const (
+	statusConAceptacion = "cancelado con aceptación"
+	statusSinAceptacion = "cancelado sin aceptación"
+	statusPlazoVencido  = "plazo vencido"
+)
+
+// outcomeFromStatus returns the outcome told by a SAT cancellation status, if any
+func outcomeFromStatus(status string) (CancellationOutcome, bool) {
+	switch strings.ToLower(strings.TrimSpace(status)) {
+	case statusConAceptacion, "cancelado con aceptacion", statusSinAceptacion, "cancelado sin aceptacion":
+		return OutcomeAccepted, true
+	case statusPlazoVencido:
+		return OutcomeExpired, true
+	default:
+		return "", false
+	}
+}
+
+// stateFromStatus maps a status reported by Facturama, for the cancellation or
+// for the CFDI, to a lifecycle state. A CFDI is reported active while its
+// cancellation waits for the receiver, so active keeps the current state:
+// only a SAT cancellation status tells a rejection, see
+// CancellationTracker.Check. Unknown statuses keep the current state.
+func stateFromStatus(status string, current CancellationState) CancellationState {
+	switch strings.ToLower(strings.TrimSpace(status)) {
+	case "canceled", "cancelled", "cancelado", "cancelada", statusPlazoVencido,
+		statusConAceptacion, "cancelado con aceptacion", statusSinAceptacion, "cancelado sin aceptacion":
+		return StateCancelled
+	case "pending", "requested", "inprocess", "en proceso", "en proceso de cancelacion", "en proceso de cancelación":
+		return StateCancelRequested
+	case "rejected", "rechazado", "rechazada", "solicitud rechazada":
+		return StateCancelRejected
+	default:
+		return current
+	}
+}
+
+// CancellationStore persists the progress of cancellations so tracking can
+// resume after a restart
+type CancellationStore interface {
+	// Save creates or replaces the cancellation of a CFDI
+	Save(ctx context.Context, cancellation Cancellation) error
+	// Load returns the cancellation of a CFDI, if any
+	Load(ctx context.Context, cfdiID string) (Cancellation, bool, error)
+	// Pending returns the cancellations that are not final
+	Pending(ctx context.Context) ([]Cancellation, error)
+}
+
+// MemoryCancellationStore is an in-memory CancellationStore
+type MemoryCancellationStore struct {
+	mu            sync.Mutex
+	cancellations map[string]Cancellation
+}
+
+// NewMemoryCancellationStore creates an empty in-memory store
+func NewMemoryCancellationStore() *MemoryCancellationStore {
+	return &MemoryCancellationStore{cancellations: make(map[string]Cancellation)}
+}
+
+// Save creates or replaces the cancellation of a CFDI
+func (store *MemoryCancellationStore) Save(ctx context.Context, cancellation Cancellation) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.cancellations[cancellation.CfdiID] = cancellation
+
+	return nil
+}
+
+// Load returns the cancellation of a CFDI, if any
+func (store *MemoryCancellationStore) Load(ctx context.Context, cfdiID string) (Cancellation, bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	cancellation, ok := store.cancellations[cfdiID]
+
+	return cancellation, ok, nil
+}
+
+// Pending returns the cancellations that are not final
+func (store *MemoryCancellationStore) Pending(ctx context.Context) ([]Cancellation, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	var pending []Cancellation
+	for _, cancellation := range store.cancellations {
+		if !cancellation.State.Final() {
+			pending = append(pending, cancellation)
+		}
+	}
+
+	return pending, nil
+}
@@ -0,0 +1,214 @@
+package multiemissor
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vanclief/ez"
+	"github.com/vanclief/go-facturama/api/common"
+	"github.com/vanclief/go-facturama/api/facturamatest"
+	"github.com/vanclief/go-facturama/api/models"
+)
+
+func TestCancellationStates(t *testing.T) {
+	assert.True(t, StateActive.CanTransition(StateCancelRequested))
+	assert.True(t, StateActive.CanTransition(StateCancelled))
+	assert.True(t, StateCancelRequested.CanTransition(StateCancelRejected))
+	assert.True(t, StateCancelRejected.CanTransition(StateCancelRequested))
+	assert.False(t, StateActive.CanTransition(StateCancelRejected))
+	assert.False(t, StateCancelled.CanTransition(StateActive))
+
+	assert.Equal(t, StateCancelled, stateFromStatus("Cancelado", StateCancelRequested))
+	assert.Equal(t, StateCancelRequested, stateFromStatus("En proceso", StateActive))
+	assert.Equal(t, StateCancelRequested, stateFromStatus("active", StateCancelRequested))
+	assert.Equal(t, StateCancelRejected, stateFromStatus("Solicitud rechazada", StateCancelRequested))
+	assert.Equal(t, StateActive, stateFromStatus("active", StateActive))
+	assert.Equal(t, StateCancelRequested, stateFromStatus("Ok", StateCancelRequested))
+}
+
+func TestCancellationTracker(t *testing.T) {
+	srv := facturamatest.NewServer()
+	defer srv.Close()
+	srv.CancelStatus = facturamatest.CancelStatusPending
+
+	accepted := srv.AddCfdi(models.CfdiInfoModel{Folio: "1"})
+	rejected := srv.AddCfdi(models.CfdiInfoModel{Folio: "2"})
+
+	var mu sync.Mutex
+	var callbacks []CancellationEvent
+	events := make(chan CancellationEvent, 10)
+
+	client := NewClient(srv.Username, srv.Password, common.WithBaseURL(srv.URL))
+	tracker := NewCancellationTracker(client,
+		WithPollInterval(5*time.Millisecond),
+		WithCancellationEvents(events),
+		WithCancellationCallback(func(event CancellationEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			callbacks = append(callbacks, event)
+		}),
+	)
+	ctx := context.Background()
+
+	cancellation, err := tracker.Cancel(ctx, CancelCfdiRequest{ID: accepted, Motive: "02"})
+	require.NoError(t, err)
+	assert.Equal(t, StateCancelRequested, cancellation.State)
+	assert.NotEmpty(t, cancellation.AcuseXmlBase64)
+	assert.Equal(t, CancellationEvent{Cancellation: cancellation, From: StateActive}, <-events)
+
+	// A pending cancellation cannot be requested again
+	_, err = tracker.Cancel(ctx, CancelCfdiRequest{ID: accepted, Motive: "02"})
+	assert.Equal(t, ez.ECONFLICT, ez.ErrorCode(err))
+
+	// Checks without a change are saved but not reported, the CFDI is active
+	// while the receiver has not answered
+	cancellation, err = tracker.Check(ctx, accepted)
+	require.NoError(t, err)
+	assert.Equal(t, StateCancelRequested, cancellation.State)
+	assert.Equal(t, 1, cancellation.Checks)
+	assert.Empty(t, events)
+
+	// The receiver accepts while the cancellation is tracked, polls that fail
+	// are retried
+	srv.InjectFailure(facturamatest.Failure{Method: http.MethodGet, Path: "/api-lite/cfdis/" + accepted, Times: 2})
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		srv.ResolveCancellation(accepted, true)
+	}()
+
+	cancellation, err = tracker.Track(ctx, accepted)
+	require.NoError(t, err)
+	assert.Equal(t, StateCancelled, cancellation.State)
+	assert.Equal(t, OutcomeAccepted, cancellation.Outcome)
+
+	event := <-events
+	assert.Equal(t, StateCancelRequested, event.From)
+	assert.Equal(t, StateCancelled, event.Cancellation.State)
+
+	// The receiver rejects, the CFDI is still active after the deadline and
+	// the cancellation can be requested again
+	_, err = tracker.Cancel(ctx, CancelCfdiRequest{ID: rejected, Motive: "02"})
+	require.NoError(t, err)
+	<-events
+	srv.ResolveCancellation(rejected, false)
+
+	cancellation, err = tracker.Check(ctx, rejected)
+	require.NoError(t, err)
+	assert.Equal(t, StateCancelRequested, cancellation.State)
+
+	tracker.now = func() time.Time { return time.Now().Add(ReceiverDeadline + RejectionGrace) }
+	cancellation, err = tracker.Track(ctx, rejected)
+	require.NoError(t, err)
+	assert.Equal(t, StateCancelRejected, cancellation.State)
+	assert.Equal(t, OutcomeRejected, cancellation.Outcome)
+	<-events
+
+	cancellation, err = tracker.Cancel(ctx, CancelCfdiRequest{ID: rejected, Motive: "02"})
+	require.NoError(t, err)
+	assert.Equal(t, StateCancelRequested, cancellation.State)
+	assert.Equal(t, StateCancelRejected, (<-events).From)
+
+	mu.Lock()
+	assert.Len(t, callbacks, 5)
+	mu.Unlock()
+
+	// Tracking stops when the context is done
+	timeout, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	_, err = tracker.Track(timeout, rejected)
+	assert.Error(t, err)
+
+	_, err = tracker.Check(ctx, "untracked")
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+
+	// The acuse shows the SAT did not receive the request
+	notFound := srv.AddCfdi(models.CfdiInfoModel{Folio: "3"})
+	acuse := `<Acuse Fecha="2025-06-02T10:00:00" RfcEmisor="EKU9003173C9"><Folios><UUID>A</UUID><EstatusUUID>205</EstatusUUID></Folios></Acuse>`
+	err = tracker.store.Save(ctx, Cancellation{
+		CfdiID:         notFound,
+		State:          StateCancelRequested,
+		AcuseXmlBase64: base64.StdEncoding.EncodeToString([]byte(acuse)),
+		RequestedAt:    tracker.now(),
+	})
+	require.NoError(t, err)
+
+	cancellation, err = tracker.Check(ctx, notFound)
+	require.NoError(t, err)
+	assert.Equal(t, StateCancelRejected, cancellation.State)
+	assert.Equal(t, OutcomeRejected, cancellation.Outcome)
+}
+
+func TestCancellationTrackerResume(t *testing.T) {
+	srv := facturamatest.NewServer()
+	defer srv.Close()
+	srv.CancelStatus = facturamatest.CancelStatusPending
+
+	first := srv.AddCfdi(models.CfdiInfoModel{Folio: "1"})
+	second := srv.AddCfdi(models.CfdiInfoModel{Folio: "2"})
+
+	client := NewClient(srv.Username, srv.Password, common.WithBaseURL(srv.URL))
+	store := NewMemoryCancellationStore()
+	ctx := context.Background()
+
+	// A process requests the cancellations and stops
+	tracker := NewCancellationTracker(client, WithCancellationStore(store))
+	for _, id := range []string{first, second} {
+		_, err := tracker.Cancel(ctx, CancelCfdiRequest{ID: id, Motive: "02"})
+		require.NoError(t, err)
+	}
+
+	// The receiver does not answer within the deadline and the SAT cancels
+	srv.ResolveCancellation(first, true)
+	srv.ResolveCancellation(second, false)
+
+	// Another process resumes them from the store days later, the last poll
+	// was before the deadline so acceptance and expiry cannot be told apart,
+	// and the CFDI still active is rejected
+	resumed := NewCancellationTracker(client, WithCancellationStore(store), WithPollInterval(time.Millisecond))
+	resumed.now = func() time.Time { return time.Now().Add(ReceiverDeadline + RejectionGrace + time.Hour) }
+	require.NoError(t, resumed.Resume(ctx))
+
+	pending, err := store.Pending(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+
+	cancellation, _, _ := store.Load(ctx, first)
+	assert.Equal(t, OutcomeUnknown, cancellation.Outcome)
+	cancellation, _, _ = store.Load(ctx, second)
+	assert.Equal(t, OutcomeRejected, cancellation.Outcome)
+}
+
+func TestCancellationOutcome(t *testing.T) {
+	requested := time.Date(2025, time.June, 2, 10, 0, 0, 0, time.UTC)
+	deadline := requested.Add(ReceiverDeadline)
+
+	cases := map[string]struct {
+		state     CancellationState
+		status    string
+		pendingAt time.Time
+		updatedAt time.Time
+		outcome   CancellationOutcome
+	}{
+		"rejected":               {StateCancelRejected, "active", requested, deadline, OutcomeRejected},
+		"seen before deadline":   {StateCancelled, "canceled", requested, deadline.Add(-time.Minute), OutcomeAccepted},
+		"pending past deadline":  {StateCancelled, "canceled", deadline, deadline.Add(time.Minute), OutcomeExpired},
+		"deadline between polls": {StateCancelled, "canceled", deadline.Add(-time.Minute), deadline.Add(time.Minute), OutcomeUnknown},
+		"status plazo vencido":   {StateCancelled, "Plazo vencido", deadline.Add(-time.Minute), deadline.Add(time.Minute), OutcomeExpired},
+		"status con aceptación":  {StateCancelled, "Cancelado con aceptación", deadline, deadline.Add(time.Minute), OutcomeAccepted},
+		"status sin aceptacion":  {StateCancelled, "Cancelado sin aceptacion", requested, requested, OutcomeAccepted},
+		"not final":              {StateCancelRequested, "pending", requested, requested, ""},
+	}
+
+	for name, tc := range cases {
+		cancellation := Cancellation{State: tc.state, Status: tc.status, RequestedAt: requested, UpdatedAt: tc.updatedAt}
+		assert.Equal(t, tc.outcome, outcome(cancellation, tc.pendingAt), name)
+	}
+
+	assert.Equal(t, StateCancelled, stateFromStatus("Plazo vencido", StateCancelRequested))
+}
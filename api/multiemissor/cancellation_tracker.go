@@ -0,0 +1,342 @@
+package multiemissor
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/vanclief/ez"
+	"github.com/vanclief/go-facturama/api/common"
+)
+
+const (
+	// DefaultPollInterval is the time between checks of a pending cancellation
+	DefaultPollInterval = time.Minute
+	// ReceiverDeadline is the time the receiver has to answer a cancellation
+	// request, after which the SAT cancels the CFDI
+	ReceiverDeadline = 72 * time.Hour
+	// RejectionGrace is the time after the ReceiverDeadline a CFDI that is
+	// still active is taken as rejected, since the SAT cancels the requests
+	// the receiver does not answer
+	RejectionGrace = 24 * time.Hour
+)
+
+// CancellationEvent reports a change of state of a tracked cancellation
+type CancellationEvent struct {
+	Cancellation Cancellation
+	// From is the previous state
+	From CancellationState
+}
+
+// CancellationTracker requests cancellations and follows the ones that need
+// the receiver acceptance until they are accepted, rejected or expire. The
+// progress is saved in a CancellationStore and every change of state is
+// reported as a CancellationEvent.
+type CancellationTracker struct {
+	client   *Client
+	store    CancellationStore
+	interval time.Duration
+	events   chan<- CancellationEvent
+	callback func(CancellationEvent)
+	now      func() time.Time
+}
+
+// TrackerOption is a function that configures a CancellationTracker
+type TrackerOption func(*CancellationTracker)
+
+// WithPollInterval sets the time between checks, defaults to DefaultPollInterval
+func WithPollInterval(interval time.Duration) TrackerOption {
+	return func(t *CancellationTracker) {
+		t.interval = interval
+	}
+}
+
+// WithCancellationStore sets the store, defaults to a MemoryCancellationStore
+func WithCancellationStore(store CancellationStore) TrackerOption {
+	return func(t *CancellationTracker) {
+		t.store = store
+	}
+}
+
+// WithCancellationEvents sends the events to the channel. Sending blocks
+// until the event is received or the context is done.
+func WithCancellationEvents(events chan<- CancellationEvent) TrackerOption {
+	return func(t *CancellationTracker) {
+		t.events = events
+	}
+}
+
+// WithCancellationCallback calls the function with every event
+func WithCancellationCallback(callback func(CancellationEvent)) TrackerOption {
+	return func(t *CancellationTracker) {
+		t.callback = callback
+	}
+}
+
+// NewCancellationTracker creates a tracker that uses the client
+func NewCancellationTracker(client *Client, options ...TrackerOption) *CancellationTracker {
+	tracker := &CancellationTracker{
+		client:   client,
+		store:    NewMemoryCancellationStore(),
+		interval: DefaultPollInterval,
+		now:      time.Now,
+	}
+
+	for _, option := range options {
+		option(tracker)
+	}
+
+	return tracker
+}
+
+// Cancel requests the cancellation of a CFDI and saves its progress. The
+// returned cancellation is cancelled when the receiver acceptance is not
+// needed, otherwise it is requested and can be followed with Track.
+func (t *CancellationTracker) Cancel(ctx context.Context, request CancelCfdiRequest) (Cancellation, error) {
+	const op = "multiemissor.CancellationTracker.Cancel"
+
+	previous, _, err := t.store.Load(ctx, request.ID)
+	if err != nil {
+		return Cancellation{}, ez.Wrap(op, err)
+	}
+
+	from := previous.State
+	if from == "" {
+		from = StateActive
+	}
+	if from == StateCancelled || from == StateCancelRequested {
+		msg := fmt.Sprintf("The CFDI %s is already in state %s", request.ID, from)
+		return previous, ez.New(op, ez.ECONFLICT, msg, nil)
+	}
+
+	status, err := t.client.CancelCfdi(ctx, request)
+	if err != nil {
+		return Cancellation{}, ez.Wrap(op, err)
+	}
+
+	// Statuses that do not tell the outcome are followed by polling the CFDI
+	state := stateFromStatus(status.Status, StateCancelRequested)
+	if state == StateCancelRejected {
+		state = StateCancelRequested
+	}
+
+	now := t.now()
+	cancellation := Cancellation{
+		CfdiID:         request.ID,
+		UUID:           status.UUID,
+		Motive:         request.Motive,
+		State:          state,
+		Status:         status.Status,
+		AcuseXmlBase64: status.AcuseXmlBase64,
+		RequestedAt:    now,
+		UpdatedAt:      now,
+	}
+	if state == StateCancelled {
+		cancellation.Outcome = OutcomeAccepted
+	}
+
+	err = t.save(ctx, cancellation, from, true)
+	if err != nil {
+		return cancellation, ez.Wrap(op, err)
+	}
+
+	return cancellation, nil
+}
+
+// Check polls the status of a tracked cancellation once, saving and reporting
+// a change of state.
+//
+// Facturama reports a cancelled CFDI without telling whether the receiver
+// accepted it or the deadline expired, unless its status is a SAT
+// cancellation status. Otherwise the outcome is a heuristic on the time of the
+// polls: a change seen before the deadline was accepted, one seen after a poll
+// that was still pending past the deadline expired, and anything in between
+// is OutcomeUnknown. Poll at least once near the deadline to tell them apart.
+//
+// The CFDI is reported active while the receiver has not answered, so an
+// active CFDI is still pending. The cancellation is rejected when the status
+// is a SAT rejection, when the acuse shows the SAT did not receive the
+// request, or when the CFDI is still active RejectionGrace after the
+// deadline.
+func (t *CancellationTracker) Check(ctx context.Context, cfdiID string) (Cancellation, error) {
+	const op = "multiemissor.CancellationTracker.Check"
+
+	cancellation, ok, err := t.store.Load(ctx, cfdiID)
+	if err != nil {
+		return Cancellation{}, ez.Wrap(op, err)
+	}
+	if !ok {
+		msg := fmt.Sprintf("The cancellation of the CFDI %s is not tracked", cfdiID)
+		return Cancellation{}, ez.New(op, ez.ENOTFOUND, msg, nil)
+	}
+	if cancellation.State.Final() {
+		return cancellation, nil
+	}
+
+	cfdi, err := t.client.GetCfdiById(ctx, GetCfdiByIdRequest{ID: cfdiID})
+	if err != nil {
+		return cancellation, ez.Wrap(op, err)
+	}
+
+	from := cancellation.State
+	now := t.now()
+
+	// The last time the cancellation was seen pending
+	pendingAt := cancellation.CheckedAt
+	if pendingAt.IsZero() {
+		pendingAt = cancellation.RequestedAt
+	}
+
+	cancellation.Checks++
+	cancellation.CheckedAt = now
+	cancellation.Status = cfdi.Status
+
+	state := stateFromStatus(cfdi.Status, from)
+	if state == StateCancelRequested && rejected(cancellation, now) {
+		state = StateCancelRejected
+	}
+	changed := state != from && from.CanTransition(state)
+	if changed {
+		cancellation.State = state
+		cancellation.UpdatedAt = now
+		cancellation.Outcome = outcome(cancellation, pendingAt)
+	}
+
+	err = t.save(ctx, cancellation, from, changed)
+	if err != nil {
+		return cancellation, ez.Wrap(op, err)
+	}
+
+	return cancellation, nil
+}
+
+// Track polls a tracked cancellation until its state is final or the context
+// is done. Transient failures of a poll are retried at the next one.
+func (t *CancellationTracker) Track(ctx context.Context, cfdiID string) (Cancellation, error) {
+	const op = "multiemissor.CancellationTracker.Track"
+
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	for {
+		cancellation, err := t.Check(ctx, cfdiID)
+		switch {
+		case err != nil && !common.IsRetryable(err):
+			return cancellation, ez.Wrap(op, err)
+		case err == nil && cancellation.State.Final():
+			return cancellation, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			msg := fmt.Sprintf("Stopped tracking the cancellation of the CFDI %s", cfdiID)
+			return cancellation, ez.New(op, ez.EUNAVAILABLE, msg, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// Resume tracks every pending cancellation of the store concurrently, until
+// all of them are final or the context is done. It returns the first error.
+func (t *CancellationTracker) Resume(ctx context.Context) error {
+	const op = "multiemissor.CancellationTracker.Resume"
+
+	pending, err := t.store.Pending(ctx)
+	if err != nil {
+		return ez.Wrap(op, err)
+	}
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var first error
+
+	for _, cancellation := range pending {
+		wg.Add(1)
+		go func(cfdiID string) {
+			defer wg.Done()
+
+			_, err := t.Track(ctx, cfdiID)
+			if err != nil {
+				once.Do(func() { first = err })
+			}
+		}(cancellation.CfdiID)
+	}
+	wg.Wait()
+
+	if first != nil {
+		return ez.Wrap(op, first)
+	}
+
+	return nil
+}
+
+// outcome returns the outcome of a cancellation that reached a final state,
+// given the last time it was seen pending, see Check
+func outcome(cancellation Cancellation, pendingAt time.Time) CancellationOutcome {
+	switch cancellation.State {
+	case StateCancelRejected:
+		return OutcomeRejected
+	case StateCancelled:
+		if outcome, ok := outcomeFromStatus(cancellation.Status); ok {
+			return outcome
+		}
+
+		deadline := cancellation.RequestedAt.Add(ReceiverDeadline)
+		switch {
+		case cancellation.UpdatedAt.Before(deadline):
+			return OutcomeAccepted
+		case !pendingAt.Before(deadline):
+			return OutcomeExpired
+		default:
+			return OutcomeUnknown
+		}
+	default:
+		return ""
+	}
+}
+
+// rejected reports whether a requested cancellation whose CFDI is still
+// active was rejected: the acuse shows the SAT did not receive the request or
+// the CFDI is still active RejectionGrace after the receiver deadline
+func rejected(cancellation Cancellation, now time.Time) bool {
+	if cancellation.AcuseXmlBase64 != "" {
+		acuse, err := ParseAcuseBase64(cancellation.AcuseXmlBase64)
+		if err == nil && !acuse.Folios[0].Accepted() {
+			return true
+		}
+	}
+
+	return !now.Before(cancellation.RequestedAt.Add(ReceiverDeadline + RejectionGrace))
+}
+
+// save persists the cancellation and reports the change of state, if any
+func (t *CancellationTracker) save(ctx context.Context, cancellation Cancellation, from CancellationState, changed bool) error {
+	const op = "multiemissor.CancellationTracker.save"
+
+	err := t.store.Save(ctx, cancellation)
+	if err != nil {
+		return ez.Wrap(op, err)
+	}
+
+	// Checks without a change of state are saved but not reported
+	if !changed {
+		return nil
+	}
+
+	event := CancellationEvent{Cancellation: cancellation, From: from}
+
+	if t.callback != nil {
+		t.callback(event)
+	}
+
+	if t.events != nil {
+		select {
+		case t.events <- event:
+		case <-ctx.Done():
+			return ez.New(op, ez.EUNAVAILABLE, "Context done while sending a cancellation event", ctx.Err())
+		}
+	}
+
+	return nil
+}
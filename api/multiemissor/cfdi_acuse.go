@@ -0,0 +1,67 @@
+package multiemissor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/vanclief/ez"
+	"github.com/vanclief/go-facturama/api/common"
+	"github.com/vanclief/go-facturama/api/models"
+)
+
+// GetCancellationAcuseRequest represents a request to get the cancellation
+// acknowledgment (acuse) of a CFDI
+type GetCancellationAcuseRequest struct {
+	Format   string
+	CfdiType string
+	ID       string
+}
+
+// Validate validates the request to get a cancellation acuse
+func (request *GetCancellationAcuseRequest) Validate() error {
+	const op = "GetCancellationAcuseRequest.Validate"
+
+	var errs ValidationErrors
+
+	// Validate required parameters
+	if request.ID == "" {
+		errs.Add("ID", CodeRequired, "CFDI ID is required", "El ID del CFDI es obligatorio")
+	}
+
+	// Validate format parameter
+	request.Format = strings.ToLower(request.Format)
+	errs.oneOf("Format", request.Format, "pdf", "html", "xml")
+
+	// Validate cfdiType parameter
+	if request.CfdiType == "" {
+		request.CfdiType = "issuedLite"
+	}
+	errs.oneOf("CfdiType", request.CfdiType, "payroll", "issued", "issuedLite")
+
+	return errs.Err(op)
+}
+
+// GetCancellationAcuse retrieves the cancellation acknowledgment of a
+// cancelled CFDI in the specified format. The XML can be read with ParseAcuse.
+// Endpoint: GET /acuse/{format}/{type}/{id}
+func (c *Client) GetCancellationAcuse(ctx context.Context, request GetCancellationAcuseRequest) (*models.FileViewModel, error) {
+	const op = "multiemissor.GetCancellationAcuse"
+	ctx = common.WithOp(ctx, op)
+
+	// Validate request
+	err := request.Validate()
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	path := fmt.Sprintf("/acuse/%s/%s/%s", request.Format, request.CfdiType, request.ID)
+	var result models.FileViewModel
+
+	err = c.Get(ctx, path, &result)
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	return &result, nil
+}
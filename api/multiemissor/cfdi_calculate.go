@@ -0,0 +1,314 @@
+package multiemissor
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vanclief/ez"
+	"github.com/vanclief/go-facturama/api/models"
+	"github.com/vanclief/go-facturama/decimal"
+)
+
+// Tax names accepted by Facturama in TaxBindingModel.Name
+const (
+	TaxIVA       = "IVA"
+	TaxISR       = "ISR"
+	TaxIEPS      = "IEPS"
+	TaxIVAExempt = "IVA Exento"
+)
+
+// Tax object codes (c_ObjetoImp)
+const (
+	TaxObjectNo             = "01" // No objeto de impuesto
+	TaxObjectYes            = "02" // Sí objeto de impuesto
+	TaxObjectYesNoBreakdown = "03" // Sí objeto del impuesto y no obligado al desglose
+	TaxObjectYesNoTax       = "04" // Sí objeto del impuesto y no causa impuesto
+)
+
+var (
+	// ivaRates are the IVA rates in c_TasaOCuota: general, border region and zero rate
+	ivaRates = []decimal.Decimal{
+		decimal.RequireFromString("0.16"),
+		decimal.RequireFromString("0.08"),
+		decimal.Zero,
+	}
+	// maxIVARetentionRate is the highest IVA retention rate allowed by c_TasaOCuota
+	maxIVARetentionRate = decimal.RequireFromString("0.16")
+	// maxISRRetentionRate is the highest ISR retention rate allowed by c_TasaOCuota
+	maxISRRetentionRate = decimal.RequireFromString("0.35")
+)
+
+// CfdiSummary is the invoice level result of calculating a CFDI
+type CfdiSummary struct {
+	Currency string
+
+	// Subtotal is the sum of the items amounts (SubTotal)
+	Subtotal decimal.Decimal
+	// Discount is the sum of the items discounts (Descuento)
+	Discount decimal.Decimal
+	// TransferredTaxes is the sum of transferred taxes (TotalImpuestosTrasladados)
+	TransferredTaxes decimal.Decimal
+	// RetainedTaxes is the sum of retained taxes (TotalImpuestosRetenidos)
+	RetainedTaxes decimal.Decimal
+	// Total is Subtotal - Discount + TransferredTaxes - RetainedTaxes
+	Total decimal.Decimal
+
+	// Transferred groups transferred taxes by tax, factor and rate
+	Transferred []TaxSummary
+	// Retained groups retained taxes by tax
+	Retained []TaxSummary
+}
+
+// TaxSummary is an invoice level tax line
+type TaxSummary struct {
+	Name    string
+	Rate    decimal.Decimal
+	IsQuota bool
+	Base    decimal.Decimal
+	Total   decimal.Decimal
+}
+
+// Calculate fills the derived amounts of every item (Subtotal, Taxes[].Base,
+// Taxes[].Total and Total) from UnitPrice, Quantity, Discount, TaxObject and
+// the tax definitions (Name, Rate, IsRetention, IsQuota), following the
+// Anexo 20 rules: amounts are rounded once to the currency precision, each
+// tax is computed per item and invoice totals are sums of item amounts.
+// Transferred IEPS is part of the IVA base.
+func (request *CreateCfdiV4Request) Calculate() (summary *CfdiSummary, err error) {
+	const op = "CreateCfdiV4Request.Calculate"
+	defer decimal.Recover(op, &err)
+
+	currency := request.Currency
+	if currency == "" {
+		currency = "MXN"
+	}
+
+	summary = &CfdiSummary{Currency: currency}
+
+	for i := range request.Items {
+		item := &request.Items[i]
+
+		err = CalculateItem(item, currency)
+		if err != nil {
+			return nil, ez.New(op, ez.EINVALID, fmt.Sprintf("Items[%d]: %s", i, ez.ErrorMessage(err)), err)
+		}
+
+		summary.add(item)
+	}
+
+	summary.Total = summary.Subtotal.
+		Sub(summary.Discount).
+		Add(summary.TransferredTaxes).
+		Sub(summary.RetainedTaxes)
+
+	return summary, nil
+}
+
+// CalculateItem fills the derived amounts of a single item for the currency
+func CalculateItem(item *models.ItemFullBindingModel, currency string) (err error) {
+	const op = "multiemissor.CalculateItem"
+	defer decimal.Recover(op, &err)
+
+	places := decimal.CurrencyPlaces(currency)
+
+	if !item.Quantity.GreaterThan(decimal.Zero) {
+		return ez.New(op, ez.EINVALID, "Quantity must be greater than zero", nil)
+	}
+	if item.UnitPrice.IsNegative() {
+		return ez.New(op, ez.EINVALID, "UnitPrice must not be negative", nil)
+	}
+	if item.Discount.IsNegative() {
+		return ez.New(op, ez.EINVALID, "Discount must not be negative", nil)
+	}
+
+	item.Subtotal = item.UnitPrice.MulRound(item.Quantity, places)
+	item.Discount = item.Discount.RoundCurrency(currency)
+
+	if item.Discount.GreaterThan(item.Subtotal) {
+		return ez.New(op, ez.EINVALID, "Discount must not be greater than the item amount", nil)
+	}
+
+	base := item.Subtotal.Sub(item.Discount)
+
+	switch item.TaxObject {
+	case TaxObjectYes:
+		if len(item.Taxes) == 0 {
+			return ez.New(op, ez.EINVALID, "TaxObject 02 requires at least one tax", nil)
+		}
+	case TaxObjectNo, TaxObjectYesNoBreakdown, TaxObjectYesNoTax:
+		if len(item.Taxes) > 0 {
+			return ez.New(op, ez.EINVALID, fmt.Sprintf("TaxObject %s must not include taxes", item.TaxObject), nil)
+		}
+	default:
+		return ez.New(op, ez.EINVALID, "TaxObject must be one of: 01, 02, 03, 04", nil)
+	}
+
+	// IEPS goes first because transferred IEPS is part of the IVA base
+	ieps := decimal.Zero
+	for i := range item.Taxes {
+		tax := &item.Taxes[i]
+		if normalizeTaxName(tax.Name) != TaxIEPS {
+			continue
+		}
+
+		err := calculateTax(tax, base, item.Quantity, places)
+		if err != nil {
+			return ez.New(op, ez.EINVALID, fmt.Sprintf("Taxes[%d]: %s", i, ez.ErrorMessage(err)), err)
+		}
+
+		if !tax.IsRetention {
+			ieps = ieps.Add(tax.Total)
+		}
+	}
+
+	transferred := decimal.Zero
+	retained := decimal.Zero
+
+	for i := range item.Taxes {
+		tax := &item.Taxes[i]
+		tax.Name = normalizeTaxName(tax.Name)
+
+		if tax.Name != TaxIEPS {
+			taxBase := base
+			if tax.Name == TaxIVA || tax.Name == TaxIVAExempt {
+				taxBase = base.Add(ieps)
+			}
+
+			err := calculateTax(tax, taxBase, item.Quantity, places)
+			if err != nil {
+				return ez.New(op, ez.EINVALID, fmt.Sprintf("Taxes[%d]: %s", i, ez.ErrorMessage(err)), err)
+			}
+		}
+
+		if tax.IsRetention {
+			retained = retained.Add(tax.Total)
+		} else {
+			transferred = transferred.Add(tax.Total)
+		}
+	}
+
+	item.Total = base.Add(transferred).Sub(retained)
+
+	return nil
+}
+
+// calculateTax validates a tax definition and fills its Base and Total
+func calculateTax(tax *models.TaxBindingModel, base, quantity decimal.Decimal, places int) error {
+	const op = "multiemissor.calculateTax"
+
+	tax.Name = normalizeTaxName(tax.Name)
+
+	if tax.Rate.IsNegative() {
+		return ez.New(op, ez.EINVALID, "Rate must not be negative", nil)
+	}
+
+	switch tax.Name {
+	case TaxIVA:
+		if tax.IsQuota {
+			return ez.New(op, ez.EINVALID, "IVA must be a rate, not a quota", nil)
+		}
+		if tax.IsRetention && tax.Rate.GreaterThan(maxIVARetentionRate) {
+			return ez.New(op, ez.EINVALID, "IVA retention rate must not exceed 0.16", nil)
+		}
+		if !tax.IsRetention && !containsDecimal(ivaRates, tax.Rate) {
+			return ez.New(op, ez.EINVALID, "IVA rate must be one of: 0.16, 0.08, 0", nil)
+		}
+	case TaxIVAExempt:
+		if tax.IsRetention || tax.IsQuota || !tax.Rate.IsZero() {
+			return ez.New(op, ez.EINVALID, "IVA Exento must be a transferred tax without rate", nil)
+		}
+	case TaxISR:
+		if !tax.IsRetention {
+			return ez.New(op, ez.EINVALID, "ISR can only be retained", nil)
+		}
+		if tax.IsQuota || tax.Rate.GreaterThan(maxISRRetentionRate) {
+			return ez.New(op, ez.EINVALID, "ISR retention rate must not exceed 0.35", nil)
+		}
+	case TaxIEPS:
+		// IEPS accepts rates and quotas (amount per unit)
+	default:
+		return ez.New(op, ez.EINVALID, "Tax name must be one of: IVA, ISR, IEPS, IVA Exento", nil)
+	}
+
+	if tax.IsQuota {
+		// Quotas are charged per unit, the base is the quantity unless provided
+		if tax.Base.IsZero() {
+			tax.Base = quantity
+		}
+	} else {
+		tax.Base = base
+	}
+
+	tax.Total = tax.Base.MulRound(tax.Rate, places)
+
+	return nil
+}
+
+// add accumulates an already calculated item into the summary
+func (summary *CfdiSummary) add(item *models.ItemFullBindingModel) {
+	summary.Subtotal = summary.Subtotal.Add(item.Subtotal)
+	summary.Discount = summary.Discount.Add(item.Discount)
+
+	for _, tax := range item.Taxes {
+		if tax.IsRetention {
+			summary.RetainedTaxes = summary.RetainedTaxes.Add(tax.Total)
+			summary.Retained = addTaxSummary(summary.Retained, TaxSummary{Name: tax.Name, Base: tax.Base, Total: tax.Total}, false)
+			continue
+		}
+
+		summary.TransferredTaxes = summary.TransferredTaxes.Add(tax.Total)
+		summary.Transferred = addTaxSummary(summary.Transferred, TaxSummary{
+			Name:    tax.Name,
+			Rate:    tax.Rate,
+			IsQuota: tax.IsQuota,
+			Base:    tax.Base,
+			Total:   tax.Total,
+		}, true)
+	}
+}
+
+// addTaxSummary merges a tax line into the list, grouping by name and, when
+// byRate is set, by factor and rate
+func addTaxSummary(lines []TaxSummary, line TaxSummary, byRate bool) []TaxSummary {
+	for i := range lines {
+		if lines[i].Name != line.Name {
+			continue
+		}
+		if byRate && (lines[i].IsQuota != line.IsQuota || !lines[i].Rate.Equal(line.Rate)) {
+			continue
+		}
+
+		lines[i].Base = lines[i].Base.Add(line.Base)
+		lines[i].Total = lines[i].Total.Add(line.Total)
+		return lines
+	}
+
+	return append(lines, line)
+}
+
+// normalizeTaxName returns the canonical spelling of a tax name
+func normalizeTaxName(name string) string {
+	switch strings.ToUpper(strings.TrimSpace(name)) {
+	case "IVA":
+		return TaxIVA
+	case "ISR":
+		return TaxISR
+	case "IEPS":
+		return TaxIEPS
+	case "IVA EXENTO":
+		return TaxIVAExempt
+	default:
+		return name
+	}
+}
+
+// containsDecimal reports whether value is in values
+func containsDecimal(values []decimal.Decimal, value decimal.Decimal) bool {
+	for _, v := range values {
+		if v.Equal(value) {
+			return true
+		}
+	}
+
+	return false
+}
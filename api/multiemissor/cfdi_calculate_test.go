@@ -0,0 +1,153 @@
+package multiemissor
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vanclief/go-facturama/api/models"
+	"github.com/vanclief/go-facturama/decimal"
+)
+
+func d(value string) decimal.Decimal {
+	return decimal.RequireFromString(value)
+}
+
+func TestCalculate(t *testing.T) {
+	request := CreateCfdiV4Request{
+		Currency: "MXN",
+		Items: []models.ItemFullBindingModel{
+			{
+				UnitPrice: d("100"),
+				Quantity:  d("1"),
+				TaxObject: TaxObjectYes,
+				Taxes:     []models.TaxBindingModel{{Name: "IVA", Rate: d("0.16")}},
+			},
+			{
+				// 3 × 33.333333 = 99.999999, rounded once to 100.00
+				UnitPrice: d("33.333333"),
+				Quantity:  d("3"),
+				Discount:  d("10"),
+				TaxObject: TaxObjectYes,
+				Taxes: []models.TaxBindingModel{
+					{Name: "IVA", Rate: d("0.16")},
+					{Name: "ISR", Rate: d("0.10"), IsRetention: true},
+					{Name: "IVA", Rate: d("0.106667"), IsRetention: true},
+				},
+			},
+			{
+				UnitPrice: d("50"),
+				Quantity:  d("2"),
+				TaxObject: TaxObjectNo,
+			},
+		},
+	}
+
+	summary, err := request.Calculate()
+	require.NoError(t, err)
+
+	first := request.Items[0]
+	assert.Equal(t, "100", first.Subtotal.String())
+	assert.Equal(t, "100", first.Taxes[0].Base.String())
+	assert.Equal(t, "16", first.Taxes[0].Total.String())
+	assert.Equal(t, "116", first.Total.String())
+
+	second := request.Items[1]
+	assert.Equal(t, "100", second.Subtotal.String())
+	assert.Equal(t, "90", second.Taxes[0].Base.String())
+	assert.Equal(t, "14.4", second.Taxes[0].Total.String())
+	assert.Equal(t, "9", second.Taxes[1].Total.String())
+	assert.Equal(t, "9.6", second.Taxes[2].Total.String())
+	assert.Equal(t, "85.8", second.Total.String())
+
+	assert.Equal(t, "100", request.Items[2].Total.String())
+
+	assert.Equal(t, "300", summary.Subtotal.String())
+	assert.Equal(t, "10", summary.Discount.String())
+	assert.Equal(t, "30.4", summary.TransferredTaxes.String())
+	assert.Equal(t, "18.6", summary.RetainedTaxes.String())
+	assert.Equal(t, "301.8", summary.Total.String())
+
+	require.Len(t, summary.Transferred, 1)
+	assert.Equal(t, "190", summary.Transferred[0].Base.String())
+	require.Len(t, summary.Retained, 2)
+}
+
+func TestCalculateIEPS(t *testing.T) {
+	request := CreateCfdiV4Request{
+		Items: []models.ItemFullBindingModel{
+			{
+				UnitPrice: d("100"),
+				Quantity:  d("2"),
+				TaxObject: TaxObjectYes,
+				Taxes: []models.TaxBindingModel{
+					{Name: "IVA", Rate: d("0.16")},
+					{Name: "IEPS", Rate: d("0.265")},
+					{Name: "IEPS", Rate: d("1.5"), IsQuota: true},
+				},
+			},
+			{
+				UnitPrice: d("80"),
+				Quantity:  d("1"),
+				TaxObject: TaxObjectYes,
+				Taxes:     []models.TaxBindingModel{{Name: "iva exento"}},
+			},
+		},
+	}
+
+	summary, err := request.Calculate()
+	require.NoError(t, err)
+
+	taxes := request.Items[0].Taxes
+	assert.Equal(t, "53", taxes[1].Total.String(), "IEPS rate over the amount")
+	assert.Equal(t, "2", taxes[2].Base.String(), "IEPS quota base is the quantity")
+	assert.Equal(t, "3", taxes[2].Total.String())
+	assert.Equal(t, "256", taxes[0].Base.String(), "IVA base includes IEPS")
+	assert.Equal(t, "40.96", taxes[0].Total.String())
+
+	exempt := request.Items[1].Taxes[0]
+	assert.Equal(t, TaxIVAExempt, exempt.Name)
+	assert.Equal(t, "80", exempt.Base.String())
+	assert.True(t, exempt.Total.IsZero())
+
+	assert.Equal(t, "376.96", summary.Total.String())
+}
+
+func TestCalculateCurrencyPrecision(t *testing.T) {
+	request := CreateCfdiV4Request{
+		Currency: "JPY",
+		Items: []models.ItemFullBindingModel{
+			{
+				UnitPrice: d("1234.5"),
+				Quantity:  d("1"),
+				TaxObject: TaxObjectYes,
+				Taxes:     []models.TaxBindingModel{{Name: "IVA", Rate: d("0.16")}},
+			},
+		},
+	}
+
+	summary, err := request.Calculate()
+	require.NoError(t, err)
+	assert.Equal(t, "1235", summary.Subtotal.String())
+	assert.Equal(t, "198", summary.TransferredTaxes.String())
+}
+
+func TestCalculateErrors(t *testing.T) {
+	cases := map[string]models.ItemFullBindingModel{
+		"zero quantity":           {UnitPrice: d("1"), TaxObject: TaxObjectNo},
+		"discount above amount":   {UnitPrice: d("1"), Quantity: d("1"), Discount: d("2"), TaxObject: TaxObjectNo},
+		"taxes on non taxable":    {UnitPrice: d("1"), Quantity: d("1"), TaxObject: TaxObjectNo, Taxes: []models.TaxBindingModel{{Name: "IVA", Rate: d("0.16")}}},
+		"missing taxes":           {UnitPrice: d("1"), Quantity: d("1"), TaxObject: TaxObjectYes},
+		"invalid IVA rate":        {UnitPrice: d("1"), Quantity: d("1"), TaxObject: TaxObjectYes, Taxes: []models.TaxBindingModel{{Name: "IVA", Rate: d("0.15")}}},
+		"transferred ISR":         {UnitPrice: d("1"), Quantity: d("1"), TaxObject: TaxObjectYes, Taxes: []models.TaxBindingModel{{Name: "ISR", Rate: d("0.10")}}},
+		"unknown tax":             {UnitPrice: d("1"), Quantity: d("1"), TaxObject: TaxObjectYes, Taxes: []models.TaxBindingModel{{Name: "VAT", Rate: d("0.16")}}},
+		"invalid tax object code": {UnitPrice: d("1"), Quantity: d("1"), TaxObject: "05"},
+		"amount out of range":     {UnitPrice: d("9000000000000"), Quantity: d("2"), TaxObject: TaxObjectNo},
+	}
+
+	for name, item := range cases {
+		request := CreateCfdiV4Request{Items: []models.ItemFullBindingModel{item}}
+		_, err := request.Calculate()
+		assert.Error(t, err, name)
+	}
+}
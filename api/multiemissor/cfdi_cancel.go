@@ -6,6 +6,7 @@ import (
 	"net/http"
 
 	"github.com/vanclief/ez"
+	"github.com/vanclief/go-facturama/api/common"
 	"github.com/vanclief/go-facturama/api/models"
 )
 
@@ -20,38 +21,31 @@ type CancelCfdiRequest struct {
 func (request *CancelCfdiRequest) Validate() error {
 	const op = "CancelCfdiRequest.Validate"
 
+	var errs ValidationErrors
+
 	// Validate required parameters
 	if request.ID == "" {
-		return ez.New(op, ez.EINVALID, "CFDI ID is required", nil)
+		errs.Add("ID", CodeRequired, "CFDI ID is required", "El ID del CFDI es obligatorio")
 	}
 
 	// Validate motive parameter (if provided)
 	if request.Motive != "" {
-		validMotives := []string{"01", "02", "03", "04"}
-		isValid := false
-		for _, v := range validMotives {
-			if request.Motive == v {
-				isValid = true
-				break
-			}
-		}
-		if !isValid {
-			return ez.New(op, ez.EINVALID, "Motive must be one of: 01, 02, 03, 04", nil)
-		}
+		errs.oneOf("Motive", request.Motive, "01", "02", "03", "04")
 	}
 
 	// Validate uuidReplacement if motive is "01" (CFDI issued with errors with relation)
 	if request.Motive == "01" && request.UUIDReplacement == "" {
-		return ez.New(op, ez.EINVALID, "UUID replacement is required when motive is 01", nil)
+		errs.Add("UUIDReplacement", CodeRequired, "UUID replacement is required when motive is 01", "El UUID de sustitución es obligatorio cuando el motivo es 01")
 	}
 
-	return nil
+	return errs.Err(op)
 }
 
 // CancelCfdi cancels a CFDI (Version 2018)
 // Endpoint: DELETE /api-lite/cfdis/{id}?motive={motive}&uuidReplacement={uuidReplacement}
 func (c *Client) CancelCfdi(ctx context.Context, request CancelCfdiRequest) (*models.CancelationStatusLite, error) {
 	const op = "multiemissor.CancelCfdi"
+	ctx = common.WithOp(ctx, op)
 
 	// Validate request
 	err := request.Validate()
@@ -0,0 +1,670 @@
+package multiemissor
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/vanclief/ez"
+	"github.com/vanclief/go-facturama/api/models"
+	"github.com/vanclief/go-facturama/cfdixml"
+	"github.com/vanclief/go-facturama/decimal"
+)
+
+// ConvertOption adjusts the request built from an existing CFDI
+type ConvertOption func(*convertOptions)
+
+type convertOptions struct {
+	folio        string
+	nextFolio    bool
+	date         time.Time
+	relationType string
+}
+
+// WithFolio sets the folio of the new CFDI
+func WithFolio(folio string) ConvertOption {
+	return func(o *convertOptions) {
+		o.folio = folio
+	}
+}
+
+// WithNextFolio increments the number at the end of the original folio,
+// keeping its width, e.g. A-099 becomes A-100
+func WithNextFolio() ConvertOption {
+	return func(o *convertOptions) {
+		o.nextFolio = true
+	}
+}
+
+// WithDate sets the date of the new CFDI. Without it the date is empty and
+// the CFDI is issued with the current date.
+func WithDate(date time.Time) ConvertOption {
+	return func(o *convertOptions) {
+		o.date = date
+	}
+}
+
+// WithRelation relates the new CFDI to the original UUID with the relation
+// type (c_TipoRelacion), e.g. RelationSubstitution. The request holds a single
+// type of relation, so a CFDI related with another type returns an error.
+func WithRelation(relationType string) ConvertOption {
+	return func(o *convertOptions) {
+		o.relationType = relationType
+	}
+}
+
+// RequestFromXML builds the request to issue again a parsed CFDI, such as a
+// stamped xml file: issuer, receiver, items, taxes and relations are copied
+// and the derived amounts are calculated again with Calculate. The stamp,
+// seal and addenda are not copied. The complements the request models are
+// copied: the Pagos 2.0 complement, whose taxes and totals by payment are
+// derived from the related documents, and the iedu and terceros item
+// complements. Other complements return an ENOTIMPLEMENTED error.
+func RequestFromXML(c *cfdixml.Comprobante, options ...ConvertOption) (CreateCfdiV4Request, error) {
+	const op = "multiemissor.RequestFromXML"
+
+	var errs ValidationErrors
+
+	request := CreateCfdiV4Request{
+		Serie:             c.Serie,
+		Folio:             c.Folio,
+		CfdiType:          c.TipoDeComprobante,
+		PaymentForm:       c.FormaPago,
+		PaymentMethod:     c.MetodoPago,
+		PaymentConditions: c.CondicionesDePago,
+		Currency:          c.Moneda,
+		Exportation:       c.Exportacion,
+		ExpeditionPlace:   c.LugarExpedicion,
+		Issuer: models.IssuerV4BindingModel{
+			Rfc:            c.Emisor.Rfc,
+			Name:           c.Emisor.Nombre,
+			FiscalRegime:   c.Emisor.RegimenFiscal,
+			FacAtrAcquirer: c.Emisor.FacAtrAdquirente,
+		},
+		Receiver: models.ReceiverV4BindingModel{
+			Rfc:                   c.Receptor.Rfc,
+			Name:                  c.Receptor.Nombre,
+			CfdiUse:               c.Receptor.UsoCFDI,
+			FiscalRegime:          c.Receptor.RegimenFiscalReceptor,
+			TaxZipCode:            c.Receptor.DomicilioFiscalReceptor,
+			TaxResidence:          c.Receptor.ResidenciaFiscal,
+			TaxRegistrationNumber: c.Receptor.NumRegIdTrib,
+		},
+	}
+
+	if c.TipoCambio != "" {
+		request.CurrencyExchangeRate = parseAmount(&errs, "TipoCambio", c.TipoCambio)
+	}
+
+	if global := c.InformacionGlobal; global != nil {
+		year, err := strconv.Atoi(global.Año)
+		if err != nil {
+			errs.Add("InformacionGlobal.Año", CodeFormat, "InformacionGlobal.Año must be a year", "InformacionGlobal.Año debe ser un año")
+		}
+		request.GlobalInformation = &models.GlobalInformationV4Model{Periodicity: global.Periodicidad, Months: global.Meses, Year: year}
+	}
+
+	switch len(c.CfdiRelacionados) {
+	case 0:
+	case 1:
+		relacionados := c.CfdiRelacionados[0]
+		request.Relations = &models.Cfdiv4Relations{Type: relacionados.TipoRelacion}
+		for _, relacionado := range relacionados.CfdiRelacionado {
+			request.Relations.Cfdis = append(request.Relations.Cfdis, models.CfdiUuidID{Uuid: relacionado.UUID})
+		}
+	default:
+		return CreateCfdiV4Request{}, ez.New(op, ez.ENOTIMPLEMENTED, "The request supports a single type of relation", nil)
+	}
+
+	if c.Complemento != nil && len(c.Complemento.Others) > 0 {
+		complement, err := complementFromXML(&errs, c.Complemento.Others)
+		if err != nil {
+			return CreateCfdiV4Request{}, ez.Wrap(op, err)
+		}
+		request.Complemento = complement
+	}
+
+	for i, concepto := range c.Conceptos {
+		path := fmt.Sprintf("Conceptos[%d]", i)
+		item := itemFromConcepto(&errs, path, concepto)
+
+		if concepto.ComplementoConcepto != nil && len(concepto.ComplementoConcepto.Elements) > 0 {
+			complement, err := itemComplementFromXML(&errs, path, concepto.ComplementoConcepto.Elements)
+			if err != nil {
+				return CreateCfdiV4Request{}, ez.Wrap(op, err)
+			}
+			item.Complement = complement
+		}
+
+		request.Items = append(request.Items, item)
+	}
+
+	err := errs.Err(op)
+	if err != nil {
+		return CreateCfdiV4Request{}, err
+	}
+
+	_, err = request.Calculate()
+	if err != nil {
+		return CreateCfdiV4Request{}, ez.Wrap(op, err)
+	}
+
+	err = applyConvertOptions(&request, c.UUID(), options)
+	if err != nil {
+		return CreateCfdiV4Request{}, ez.Wrap(op, err)
+	}
+
+	return request, nil
+}
+
+// RequestFromCfdi builds the request to issue again a CFDI returned by the
+// API. The model has less data than the XML: the product and unit codes,
+// tax object and taxes of the items, and the CFDI use, fiscal regime and zip
+// code of the receiver are left empty for the caller to fill, Validate
+// reports them. The model has no complements either: item complements are
+// left empty and a payment CFDI, which cannot be issued without its Pagos
+// complement, returns an ENOTIMPLEMENTED error. Use RequestFromXML with the
+// xml file for a complete request.
+func RequestFromCfdi(cfdi models.CfdiInfoModel, options ...ConvertOption) (CreateCfdiV4Request, error) {
+	const op = "multiemissor.RequestFromCfdi"
+
+	if cfdiTypeCode(cfdi) == "P" {
+		return CreateCfdiV4Request{}, ez.New(op, ez.ENOTIMPLEMENTED, "The CFDI model has no payments complement, use RequestFromXML", nil)
+	}
+
+	request := CreateCfdiV4Request{
+		Serie:                cfdi.Serie,
+		Folio:                cfdi.Folio,
+		CfdiType:             cfdiTypeCode(cfdi),
+		PaymentForm:          catalogCode(cfdi.PaymentTerms),
+		PaymentMethod:        catalogCode(cfdi.PaymentMethod),
+		PaymentConditions:    cfdi.PaymentConditions,
+		PaymentAccountNumber: cfdi.PaymentAccountNumber,
+		PaymentBankName:      cfdi.PaymentBankName,
+		Currency:             cfdi.Currency,
+		ExpeditionPlace:      cfdi.ExpeditionPlace,
+		Observations:         cfdi.Observations,
+		OrderNumber:          cfdi.OrderNumber,
+		Issuer: models.IssuerV4BindingModel{
+			Rfc:          cfdi.Issuer.Rfc,
+			Name:         cfdi.Issuer.TaxName,
+			FiscalRegime: cfdi.Issuer.FiscalRegime,
+		},
+		Receiver: models.ReceiverV4BindingModel{
+			Rfc:  cfdi.Receiver.Rfc,
+			Name: cfdi.Receiver.Name,
+		},
+	}
+
+	// The API reports an exchange rate of 1 for MXN
+	if currency := strings.ToUpper(cfdi.Currency); currency != "" && currency != "MXN" {
+		request.CurrencyExchangeRate = cfdi.ExchangeRate
+	}
+
+	for _, item := range cfdi.Items {
+		request.Items = append(request.Items, models.ItemFullBindingModel{
+			Description: item.Description,
+			Unit:        item.Unit,
+			UnitPrice:   item.UnitValue,
+			Quantity:    item.Quantity,
+			Discount:    item.Discount,
+		})
+	}
+
+	err := applyConvertOptions(&request, cfdi.Complement.TaxStamp.UUID, options)
+	if err != nil {
+		return CreateCfdiV4Request{}, ez.Wrap(op, err)
+	}
+
+	return request, nil
+}
+
+// itemFromConcepto maps a Concepto to an item, the derived amounts are left
+// for Calculate
+func itemFromConcepto(errs *ValidationErrors, path string, concepto cfdixml.Concepto) models.ItemFullBindingModel {
+	item := models.ItemFullBindingModel{
+		ProductCode:          concepto.ClaveProdServ,
+		IdentificationNumber: concepto.NoIdentificacion,
+		Description:          concepto.Descripcion,
+		Unit:                 concepto.Unidad,
+		UnitCode:             concepto.ClaveUnidad,
+		UnitPrice:            parseAmount(errs, path+".ValorUnitario", concepto.ValorUnitario),
+		Quantity:             parseAmount(errs, path+".Cantidad", concepto.Cantidad),
+		TaxObject:            concepto.ObjetoImp,
+	}
+
+	if concepto.Descuento != "" {
+		item.Discount = parseAmount(errs, path+".Descuento", concepto.Descuento)
+	}
+
+	if impuestos := concepto.Impuestos; impuestos != nil {
+		for j, traslado := range impuestos.Traslados {
+			taxPath := fmt.Sprintf("%s.Traslados[%d]", path, j)
+			item.Taxes = append(item.Taxes, taxFromXML(errs, taxPath, traslado.Impuesto, traslado.TipoFactor, traslado.TasaOCuota, traslado.Base, false))
+		}
+		for j, retencion := range impuestos.Retenciones {
+			taxPath := fmt.Sprintf("%s.Retenciones[%d]", path, j)
+			item.Taxes = append(item.Taxes, taxFromXML(errs, taxPath, retencion.Impuesto, retencion.TipoFactor, retencion.TasaOCuota, retencion.Base, true))
+		}
+	}
+
+	if terceros := concepto.ACuentaTerceros; terceros != nil {
+		item.ThirdPartyAccount = &models.ThirdPartyAccountModel{
+			Rfc:          terceros.RfcACuentaTerceros,
+			Name:         terceros.NombreACuentaTerceros,
+			FiscalRegime: terceros.RegimenFiscalACuentaTerceros,
+			TaxZipCode:   terceros.DomicilioFiscalACuentaTerceros,
+		}
+	}
+
+	for _, aduana := range concepto.InformacionAduanera {
+		item.NumerosPedimento = append(item.NumerosPedimento, aduana.NumeroPedimento)
+	}
+
+	for _, predial := range concepto.CuentaPredial {
+		item.PropertyTaxIDNumber = append(item.PropertyTaxIDNumber, predial.Numero)
+	}
+
+	for j, parte := range concepto.Parte {
+		partPath := fmt.Sprintf("%s.Parte[%d]", path, j)
+		part := models.ItemPartBindingModel{
+			ProductCode:          parte.ClaveProdServ,
+			IdentificationNumber: parte.NoIdentificacion,
+			Quantity:             parseAmount(errs, partPath+".Cantidad", parte.Cantidad),
+			UnitCode:             parte.Unidad,
+			Description:          parte.Descripcion,
+		}
+		if parte.ValorUnitario != "" {
+			part.UnitPrice = parseAmount(errs, partPath+".ValorUnitario", parte.ValorUnitario)
+		}
+		if parte.Importe != "" {
+			part.Amount = parseAmount(errs, partPath+".Importe", parte.Importe)
+		}
+		for _, aduana := range parte.InformacionAduanera {
+			part.CustomsInformation = append(part.CustomsInformation, models.CustomsInformationModel{Number: aduana.NumeroPedimento})
+		}
+		item.Parts = append(item.Parts, part)
+	}
+
+	return item
+}
+
+// Names of the complements the request models
+var (
+	pagosName          = xml.Name{Space: cfdixml.NamespacePagos, Local: "Pagos"}
+	instEducativasName = xml.Name{Space: cfdixml.NamespaceIEDU, Local: "instEducativas"}
+	tercerosName       = xml.Name{Space: namespaceTerceros, Local: "PorCuentadeTerceros"}
+)
+
+// namespaceTerceros is the namespace of the terceros 1.1 item complement
+const namespaceTerceros = "http://www.sat.gob.mx/terceros"
+
+// complementFromXML maps the complements of the CFDI besides the
+// TimbreFiscalDigital. It returns an ENOTIMPLEMENTED error for the
+// complements the request does not model.
+func complementFromXML(errs *ValidationErrors, elements []cfdixml.Element) (*models.Complementv4, error) {
+	const op = "multiemissor.complementFromXML"
+
+	complement := &models.Complementv4{}
+	for i := range elements {
+		element := &elements[i]
+
+		switch element.XMLName {
+		case pagosName:
+			for j, pago := range element.ChildrenNamed("Pago") {
+				path := fmt.Sprintf("Pagos.Pago[%d]", j)
+				complement.Payments = append(complement.Payments, paymentFromXML(errs, path, pago))
+			}
+		default:
+			msg := fmt.Sprintf("The complement {%s}%s is not supported", element.XMLName.Space, element.XMLName.Local)
+			return nil, ez.New(op, ez.ENOTIMPLEMENTED, msg, nil)
+		}
+	}
+
+	return complement, nil
+}
+
+// paymentFromXML maps a Pago of the Pagos 2.0 complement
+func paymentFromXML(errs *ValidationErrors, path string, pago *cfdixml.Element) models.PaymentModel {
+	payment := models.PaymentModel{
+		Date:                          pago.Attr("FechaPago"),
+		PaymentForm:                   pago.Attr("FormaDePagoP"),
+		Currency:                      pago.Attr("MonedaP"),
+		Amount:                        parseAmount(errs, path+".Monto", pago.Attr("Monto")),
+		OperationNumber:               pago.Attr("NumOperacion"),
+		RfcIssuerPayerAccount:         pago.Attr("RfcEmisorCtaOrd"),
+		ForeignAccountNamePayer:       pago.Attr("NomBancoOrdExt"),
+		PayerAccount:                  pago.Attr("CtaOrdenante"),
+		RfcReceiverBeneficiaryAccount: pago.Attr("RfcEmisorCtaBen"),
+		BeneficiaryAccount:            pago.Attr("CtaBeneficiario"),
+		StringTypePayment:             pago.Attr("TipoCadPago"),
+		CertPayment:                   pago.Attr("CertPago"),
+		OriginalString:                pago.Attr("CadPago"),
+		SignPayment:                   pago.Attr("SelloPago"),
+	}
+
+	if rate := pago.Attr("TipoCambioP"); rate != "" {
+		payment.ExchangeRate = parseAmount(errs, path+".TipoCambioP", rate)
+	}
+
+	for j, docto := range pago.ChildrenNamed("DoctoRelacionado") {
+		docPath := fmt.Sprintf("%s.DoctoRelacionado[%d]", path, j)
+		payment.RelatedDocuments = append(payment.RelatedDocuments, relatedDocumentFromXML(errs, docPath, docto))
+	}
+
+	return payment
+}
+
+// relatedDocumentFromXML maps a DoctoRelacionado of a Pago, the remaining
+// balance is derived from the previous balance and the amount paid
+func relatedDocumentFromXML(errs *ValidationErrors, path string, docto *cfdixml.Element) models.RelatedDocumentModel {
+	document := models.RelatedDocumentModel{
+		Uuid:                  docto.Attr("IdDocumento"),
+		Serie:                 docto.Attr("Serie"),
+		Folio:                 docto.Attr("Folio"),
+		Currency:              docto.Attr("MonedaDR"),
+		PreviousBalanceAmount: parseAmount(errs, path+".ImpSaldoAnt", docto.Attr("ImpSaldoAnt")),
+		AmountPaid:            parseAmount(errs, path+".ImpPagado", docto.Attr("ImpPagado")),
+		TaxObject:             docto.Attr("ObjetoImpDR"),
+	}
+
+	if equivalence := docto.Attr("EquivalenciaDR"); equivalence != "" {
+		document.EquivalenceDocRel = parseAmount(errs, path+".EquivalenciaDR", equivalence)
+	}
+
+	partiality, err := strconv.Atoi(docto.Attr("NumParcialidad"))
+	if err != nil {
+		errs.Add(path+".NumParcialidad", CodeFormat, path+".NumParcialidad must be a number", path+".NumParcialidad debe ser un número")
+	}
+	document.PartialityNumber = partiality
+
+	for _, impuestos := range docto.ChildrenNamed("ImpuestosDR") {
+		for _, traslados := range impuestos.ChildrenNamed("TrasladosDR") {
+			for k, traslado := range traslados.ChildrenNamed("TrasladoDR") {
+				taxPath := fmt.Sprintf("%s.TrasladosDR[%d]", path, k)
+				document.Taxes = append(document.Taxes, paymentTaxFromXML(errs, taxPath, traslado, false))
+			}
+		}
+		for _, retenciones := range impuestos.ChildrenNamed("RetencionesDR") {
+			for k, retencion := range retenciones.ChildrenNamed("RetencionDR") {
+				taxPath := fmt.Sprintf("%s.RetencionesDR[%d]", path, k)
+				document.Taxes = append(document.Taxes, paymentTaxFromXML(errs, taxPath, retencion, true))
+			}
+		}
+	}
+
+	return document
+}
+
+// paymentTaxFromXML maps a tax of a related document with its base and amount
+func paymentTaxFromXML(errs *ValidationErrors, path string, impuesto *cfdixml.Element, isRetention bool) models.TaxBindingModel {
+	tax := taxFromXML(errs, path, impuesto.Attr("ImpuestoDR"), impuesto.Attr("TipoFactorDR"), impuesto.Attr("TasaOCuotaDR"),
+		impuesto.Attr("BaseDR"), isRetention)
+	tax.Base = parseAmount(errs, path+".BaseDR", impuesto.Attr("BaseDR"))
+
+	// Exempt taxes have no amount
+	if importe := impuesto.Attr("ImporteDR"); importe != "" {
+		tax.Total = parseAmount(errs, path+".ImporteDR", importe)
+	}
+
+	return tax
+}
+
+// itemComplementFromXML maps the complements of an item. It returns an
+// ENOTIMPLEMENTED error for the complements the request does not model.
+func itemComplementFromXML(errs *ValidationErrors, path string, elements []cfdixml.Element) (*models.ItemComplementModel, error) {
+	const op = "multiemissor.itemComplementFromXML"
+
+	complement := &models.ItemComplementModel{}
+	for i := range elements {
+		element := &elements[i]
+
+		switch element.XMLName {
+		case instEducativasName:
+			complement.EducationalInstitution = &models.EducationalInstitutionModel{
+				StudentsName:   element.Attr("nombreAlumno"),
+				Curp:           element.Attr("CURP"),
+				EducationLevel: element.Attr("nivelEducativo"),
+				AutRvoe:        element.Attr("autRVOE"),
+				PaymentRfc:     element.Attr("rfcPago"),
+			}
+		case tercerosName:
+			third, err := thirdPartyFromXML(errs, path+".PorCuentadeTerceros", element)
+			if err != nil {
+				return nil, ez.Wrap(op, err)
+			}
+			complement.ThirdPartyAccount = third
+		default:
+			msg := fmt.Sprintf("%s: the item complement {%s}%s is not supported", path, element.XMLName.Space, element.XMLName.Local)
+			return nil, ez.New(op, ez.ENOTIMPLEMENTED, msg, nil)
+		}
+	}
+
+	return complement, nil
+}
+
+// thirdPartyFromXML maps a terceros 1.1 complement. The complement has no
+// fiscal regime nor tax zip code, and the model has no retained taxes, so
+// retentions return an ENOTIMPLEMENTED error.
+func thirdPartyFromXML(errs *ValidationErrors, path string, terceros *cfdixml.Element) (*models.ThirdPartyAccountFullModel, error) {
+	const op = "multiemissor.thirdPartyFromXML"
+
+	third := &models.ThirdPartyAccountFullModel{
+		Rfc:  terceros.Attr("rfc"),
+		Name: terceros.Attr("nombre"),
+	}
+
+	for _, info := range terceros.ChildrenNamed("InformacionFiscalTercero") {
+		third.ThirdTaxInformation = &models.ThirdTaxInformationModel{
+			Street:         info.Attr("calle"),
+			ExteriorNumber: info.Attr("noExterior"),
+			InteriorNumber: info.Attr("noInterior"),
+			Neighborhood:   info.Attr("colonia"),
+			Locality:       info.Attr("localidad"),
+			Reference:      info.Attr("referencia"),
+			Municipality:   info.Attr("municipio"),
+			State:          info.Attr("estado"),
+			Country:        info.Attr("pais"),
+			PostalCode:     info.Attr("codigoPostal"),
+		}
+	}
+
+	for _, aduana := range terceros.ChildrenNamed("InformacionAduanera") {
+		third.CustomsInformation = customsFromXML(aduana)
+	}
+
+	for j, parte := range terceros.ChildrenNamed("Parte") {
+		partPath := fmt.Sprintf("%s.Parte[%d]", path, j)
+		part := models.PartModel{
+			Quantity:             parseAmount(errs, partPath+".cantidad", parte.Attr("cantidad")),
+			Unit:                 parte.Attr("unidad"),
+			IdentificationNumber: parte.Attr("noIdentificacion"),
+			Description:          parte.Attr("descripcion"),
+		}
+		if value := parte.Attr("valorUnitario"); value != "" {
+			part.UnitPrce = parseAmount(errs, partPath+".valorUnitario", value)
+		}
+		if importe := parte.Attr("importe"); importe != "" {
+			part.Amount = parseAmount(errs, partPath+".importe", importe)
+		}
+		for _, aduana := range parte.ChildrenNamed("InformacionAduanera") {
+			part.CustomsInformation = append(part.CustomsInformation, *customsFromXML(aduana))
+		}
+		third.Parts = append(third.Parts, part)
+	}
+
+	for _, predial := range terceros.ChildrenNamed("CuentaPredial") {
+		third.PropertyTaxNumber = predial.Attr("numero")
+	}
+
+	for _, impuestos := range terceros.ChildrenNamed("Impuestos") {
+		if len(impuestos.ChildrenNamed("Retenciones")) > 0 {
+			return nil, ez.New(op, ez.ENOTIMPLEMENTED, path+": retained third party taxes are not supported", nil)
+		}
+		for _, traslados := range impuestos.ChildrenNamed("Traslados") {
+			for k, traslado := range traslados.ChildrenNamed("Traslado") {
+				taxPath := fmt.Sprintf("%s.Traslados[%d]", path, k)
+				third.Taxes = append(third.Taxes, models.ThirdPartyTaxModel{
+					Name:   traslado.Attr("impuesto"),
+					Rate:   parseAmount(errs, taxPath+".tasa", traslado.Attr("tasa")),
+					Amount: parseAmount(errs, taxPath+".importe", traslado.Attr("importe")),
+				})
+			}
+		}
+	}
+
+	return third, nil
+}
+
+// customsFromXML maps the customs information of the terceros complement
+func customsFromXML(aduana *cfdixml.Element) *models.CustomsInformationModel {
+	return &models.CustomsInformationModel{
+		Number:  aduana.Attr("numero"),
+		Date:    aduana.Attr("fecha"),
+		Customs: aduana.Attr("aduana"),
+	}
+}
+
+// taxFromXML maps a tax of a Concepto to its definition. Quotas keep their
+// base, the quantity the quota is charged on.
+func taxFromXML(errs *ValidationErrors, path, impuesto, tipoFactor, tasaOCuota, base string, isRetention bool) models.TaxBindingModel {
+	tax := models.TaxBindingModel{IsRetention: isRetention}
+
+	switch impuesto {
+	case taxCodes[TaxISR]:
+		tax.Name = TaxISR
+	case taxCodes[TaxIVA]:
+		tax.Name = TaxIVA
+		if tipoFactor == factorExempt {
+			tax.Name = TaxIVAExempt
+		}
+	case taxCodes[TaxIEPS]:
+		tax.Name = TaxIEPS
+	default:
+		errs.Add(path+".Impuesto", CodeNotInCatalog, fmt.Sprintf("%s.Impuesto %q is not a tax", path, impuesto),
+			fmt.Sprintf("%s.Impuesto %q no es un impuesto", path, impuesto))
+	}
+
+	if tasaOCuota != "" {
+		tax.Rate = parseAmount(errs, path+".TasaOCuota", tasaOCuota)
+	}
+
+	if tipoFactor == factorQuota {
+		tax.IsQuota = true
+		tax.Base = parseAmount(errs, path+".Base", base)
+	}
+
+	return tax
+}
+
+// parseAmount parses an amount of the XML, reporting it if it is not a number
+func parseAmount(errs *ValidationErrors, path, value string) decimal.Decimal {
+	amount, err := decimal.NewFromString(strings.TrimSpace(value))
+	if err != nil {
+		errs.Add(path, CodeFormat, fmt.Sprintf("%s %q is not a number", path, value), fmt.Sprintf("%s %q no es un número", path, value))
+	}
+
+	return amount
+}
+
+// applyConvertOptions sets the folio, date and relation of the new CFDI
+func applyConvertOptions(request *CreateCfdiV4Request, uuid string, options []ConvertOption) error {
+	const op = "multiemissor.applyConvertOptions"
+
+	var o convertOptions
+	for _, option := range options {
+		option(&o)
+	}
+
+	switch {
+	case o.folio != "":
+		request.Folio = o.folio
+	case o.nextFolio:
+		folio, ok := nextFolio(request.Folio)
+		if !ok {
+			return ez.New(op, ez.EINVALID, fmt.Sprintf("The folio %q does not end with a number", request.Folio), nil)
+		}
+		request.Folio = folio
+	}
+
+	request.Date = ""
+	if !o.date.IsZero() {
+		request.Date = o.date.In(cfdixml.MexicoCentral).Format(cfdixml.DateLayout)
+	}
+
+	if o.relationType != "" {
+		if uuid == "" {
+			return ez.New(op, ez.EINVALID, "The CFDI has no UUID to relate to, it is not stamped", nil)
+		}
+		relations, err := relatedTo(request.Relations, o.relationType, uuid)
+		if err != nil {
+			return ez.Wrap(op, err)
+		}
+		request.Relations = relations
+	}
+
+	return nil
+}
+
+// relatedTo returns the relations with the UUID added with the relation
+// type. The request holds a single type, so relations of another type return
+// an ENOTIMPLEMENTED error instead of being dropped.
+func relatedTo(relations *models.Cfdiv4Relations, relationType, uuid string) (*models.Cfdiv4Relations, error) {
+	const op = "multiemissor.relatedTo"
+
+	related := &models.Cfdiv4Relations{Type: relationType}
+	if relations != nil && len(relations.Cfdis) > 0 {
+		if relations.Type != relationType {
+			msg := fmt.Sprintf("The CFDI has relations of type %s, the request supports a single type of relation", relations.Type)
+			return nil, ez.New(op, ez.ENOTIMPLEMENTED, msg, nil)
+		}
+		related.Cfdis = append(related.Cfdis, relations.Cfdis...)
+	}
+
+	for _, cfdi := range related.Cfdis {
+		if strings.EqualFold(cfdi.Uuid, uuid) {
+			return related, nil
+		}
+	}
+	related.Cfdis = append(related.Cfdis, models.CfdiUuidID{Uuid: uuid})
+
+	return related, nil
+}
+
+// nextFolio increments the digits at the end of a folio keeping their width.
+// It is false if the folio does not end with a digit.
+func nextFolio(folio string) (string, bool) {
+	start := len(folio)
+	for start > 0 && folio[start-1] >= '0' && folio[start-1] <= '9' {
+		start--
+	}
+	if start == len(folio) {
+		return "", false
+	}
+
+	digits := []byte(folio[start:])
+	for i := len(digits) - 1; i >= 0; i-- {
+		if digits[i] < '9' {
+			digits[i]++
+			return folio[:start] + string(digits), true
+		}
+		digits[i] = '0'
+	}
+
+	// Every digit was a nine, the number gets one more digit
+	return folio[:start] + "1" + string(digits), true
+}
+
+// catalogCode returns the code of a catalog value that may include its
+// description, e.g. "03 - Transferencia electrónica de fondos"
+func catalogCode(value string) string {
+	fields := strings.FieldsFunc(value, func(r rune) bool { return r == '-' || unicode.IsSpace(r) })
+	if len(fields) == 0 {
+		return ""
+	}
+
+	return fields[0]
+}
@@ -0,0 +1,265 @@
+package multiemissor
+
+import (
+	"encoding/xml"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vanclief/ez"
+	"github.com/vanclief/go-facturama/api/models"
+	"github.com/vanclief/go-facturama/cfdixml"
+	"github.com/vanclief/go-facturama/csd"
+	"github.com/vanclief/go-facturama/csd/csdtest"
+)
+
+func TestRequestFromXML(t *testing.T) {
+	files, err := csdtest.Generate(csdtest.Options{
+		NotBefore: time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:  time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC),
+	})
+	require.NoError(t, err)
+
+	credential, err := csd.Load(files.Certificate, files.PrivateKey, files.Password)
+	require.NoError(t, err)
+
+	signer, err := NewSigner(credential)
+	require.NoError(t, err)
+
+	original := validCfdiRequest()
+	original.Date = "2025-06-01T10:00:00"
+	original.Folio = "A-099"
+	original.Relations = &models.Cfdiv4Relations{Type: "07", Cfdis: []models.CfdiUuidID{{Uuid: "5FB2822E-396D-4725-8521-CDC4BDD20CCF"}}}
+	original.Items = append(original.Items, models.ItemFullBindingModel{
+		ProductCode:          "50202201",
+		IdentificationNumber: "SKU-2",
+		Description:          "Cerveza",
+		Unit:                 "Litro",
+		UnitCode:             "LTR",
+		UnitPrice:            d("20.5"),
+		Quantity:             d("12"),
+		Discount:             d("6"),
+		TaxObject:            TaxObjectYes,
+		Taxes: []models.TaxBindingModel{
+			{Name: TaxIEPS, Rate: d("0.5"), IsQuota: true, Base: d("12")},
+			{Name: TaxIVA, Rate: d("0.16")},
+			{Name: TaxIVA, Rate: d("0.106667"), IsRetention: true},
+		},
+		NumerosPedimento: []string{"25  47  3807  8003832"},
+	})
+
+	signed, err := signer.Sign(original)
+	require.NoError(t, err)
+
+	c, err := cfdixml.Parse(signed.XML)
+	require.NoError(t, err)
+	c.Complemento = &cfdixml.Complemento{TimbreFiscalDigital: &cfdixml.TimbreFiscalDigital{UUID: "9F8C1B2A-3D4E-4F50-8A6B-7C8D9E0F1A2B"}}
+
+	// Without options the request issues the same CFDI with the current date
+	request, err := RequestFromXML(c)
+	require.NoError(t, err)
+
+	expected := original
+	expected.Date = ""
+	_, err = expected.Calculate()
+	require.NoError(t, err)
+	assert.Equal(t, expected, request)
+
+	// Issuing the converted request again seals the same cadena original
+	request.Date = original.Date
+	again, err := signer.Sign(request)
+	require.NoError(t, err)
+	assert.Equal(t, signed.CadenaOriginal, again.CadenaOriginal)
+
+	// Options
+	date := time.Date(2025, time.June, 2, 18, 30, 0, 0, time.UTC)
+
+	// The 07 relations of the original are not dropped for another type
+	_, err = RequestFromXML(c, WithRelation(RelationSubstitution))
+	assert.Equal(t, ez.ENOTIMPLEMENTED, ez.ErrorCode(err))
+
+	request, err = RequestFromXML(c, WithFolio("B-1"), WithRelation("07"))
+	require.NoError(t, err)
+	assert.Equal(t, "B-1", request.Folio)
+	assert.Len(t, request.Relations.Cfdis, 2)
+
+	relations := c.CfdiRelacionados
+	c.CfdiRelacionados = nil
+	request, err = RequestFromXML(c, WithNextFolio(), WithDate(date), WithRelation(RelationSubstitution))
+	require.NoError(t, err)
+	assert.Equal(t, "A-100", request.Folio)
+	assert.Equal(t, "2025-06-02T12:30:00", request.Date)
+	assert.Equal(t, &models.Cfdiv4Relations{Type: RelationSubstitution, Cfdis: []models.CfdiUuidID{{Uuid: c.UUID()}}}, request.Relations)
+	c.CfdiRelacionados = relations
+
+	// A CFDI that is not stamped has no UUID to relate to
+	c.Complemento = nil
+	_, err = RequestFromXML(c, WithRelation(RelationSubstitution))
+	assert.Equal(t, ez.EINVALID, ez.ErrorCode(err))
+
+	c.Conceptos[0].Cantidad = "uno"
+	_, err = RequestFromXML(c)
+	assert.Equal(t, ez.EINVALID, ez.ErrorCode(err))
+}
+
+func TestRequestFromXMLComplements(t *testing.T) {
+	data, err := os.ReadFile("../../cfdixml/testdata/cfdi.xml")
+	require.NoError(t, err)
+
+	c, err := cfdixml.Parse(data)
+	require.NoError(t, err)
+
+	_, err = RequestFromXML(c)
+	assert.Equal(t, ez.ENOTIMPLEMENTED, ez.ErrorCode(err))
+
+	c.Complemento.Others = nil
+	request, err := RequestFromXML(c)
+	require.NoError(t, err)
+	assert.Equal(t, "ESCUELA KEMPER URGATE", request.Issuer.Name)
+	assert.Equal(t, "1000", request.Items[0].Subtotal.String())
+	assert.Equal(t, "953.33", request.Items[0].Total.String())
+}
+
+func TestRequestFromXMLPayments(t *testing.T) {
+	data, err := os.ReadFile("../../cfdixml/testdata/pago.xml")
+	require.NoError(t, err)
+
+	c, err := cfdixml.Parse(data)
+	require.NoError(t, err)
+
+	request, err := RequestFromXML(c, WithNextFolio())
+	require.NoError(t, err)
+	assert.Equal(t, "P", request.CfdiType)
+	assert.Equal(t, "13", request.Folio)
+	require.NotNil(t, request.Complemento)
+	require.Len(t, request.Complemento.Payments, 1)
+
+	payment := request.Complemento.Payments[0]
+	assert.Equal(t, "2025-06-14T12:00:00", payment.Date)
+	assert.Equal(t, "03", payment.PaymentForm)
+	assert.Equal(t, "1060", payment.Amount.String())
+	assert.Equal(t, "SPEI-001", payment.OperationNumber)
+	require.Len(t, payment.RelatedDocuments, 1)
+
+	document := payment.RelatedDocuments[0]
+	assert.Equal(t, "9F8C1B2A-3D4E-4F50-8A6B-7C8D9E0F1A2B", document.Uuid)
+	assert.Equal(t, 1, document.PartialityNumber)
+	assert.Equal(t, "1060", document.PreviousBalanceAmount.String())
+	assert.Equal(t, "1060", document.AmountPaid.String())
+	assert.Equal(t, []models.TaxBindingModel{
+		{Name: TaxIVA, Base: d("1000"), Rate: d("0.16"), Total: d("160")},
+		{Name: TaxISR, Base: d("1000"), Rate: d("0.1"), Total: d("100"), IsRetention: true},
+	}, document.Taxes)
+
+	// The API model has no payments to issue the CFDI again
+	_, err = RequestFromCfdi(models.CfdiInfoModel{CfdiType: "pago"})
+	assert.Equal(t, ez.ENOTIMPLEMENTED, ez.ErrorCode(err))
+}
+
+func TestRequestFromXMLItemComplements(t *testing.T) {
+	data, err := os.ReadFile("../../cfdixml/testdata/colegiatura.xml")
+	require.NoError(t, err)
+
+	c, err := cfdixml.Parse(data)
+	require.NoError(t, err)
+
+	// The request does not model the ImpuestosLocales complement
+	_, err = RequestFromXML(c)
+	assert.Equal(t, ez.ENOTIMPLEMENTED, ez.ErrorCode(err))
+
+	c.Complemento = nil
+	request, err := RequestFromXML(c)
+	require.NoError(t, err)
+	assert.Nil(t, request.Complemento)
+	require.NotNil(t, request.Items[0].Complement)
+	assert.Equal(t, &models.EducationalInstitutionModel{
+		StudentsName:   "Ana López Pérez",
+		Curp:           "LOPA100101MSPPRNA9",
+		EducationLevel: "Primaria",
+		AutRvoe:        "SEP-1234",
+	}, request.Items[0].Complement.EducationalInstitution)
+
+	terceros := cfdixml.Element{
+		XMLName: xml.Name{Space: namespaceTerceros, Local: "PorCuentadeTerceros"},
+		Attrs:   []xml.Attr{{Name: xml.Name{Local: "version"}, Value: "1.1"}, {Name: xml.Name{Local: "rfc"}, Value: "URE180429TM6"}},
+		Children: []cfdixml.Element{{
+			XMLName: xml.Name{Space: namespaceTerceros, Local: "Impuestos"},
+			Children: []cfdixml.Element{{
+				XMLName: xml.Name{Space: namespaceTerceros, Local: "Traslados"},
+				Children: []cfdixml.Element{{
+					XMLName: xml.Name{Space: namespaceTerceros, Local: "Traslado"},
+					Attrs: []xml.Attr{
+						{Name: xml.Name{Local: "impuesto"}, Value: "IVA"},
+						{Name: xml.Name{Local: "tasa"}, Value: "16.00"},
+						{Name: xml.Name{Local: "importe"}, Value: "800.00"},
+					},
+				}},
+			}},
+		}},
+	}
+	c.Conceptos[0].ComplementoConcepto.Elements = []cfdixml.Element{terceros}
+
+	request, err = RequestFromXML(c)
+	require.NoError(t, err)
+	third := request.Items[0].Complement.ThirdPartyAccount
+	require.NotNil(t, third)
+	assert.Equal(t, "URE180429TM6", third.Rfc)
+	assert.Equal(t, []models.ThirdPartyTaxModel{{Name: TaxIVA, Rate: d("16"), Amount: d("800")}}, third.Taxes)
+
+	// Complements without a model are rejected
+	c.Conceptos[0].ComplementoConcepto.Elements = []cfdixml.Element{{XMLName: xml.Name{Space: "http://www.sat.gob.mx/ventavehiculos", Local: "VentaVehiculos"}}}
+	_, err = RequestFromXML(c)
+	assert.Equal(t, ez.ENOTIMPLEMENTED, ez.ErrorCode(err))
+}
+
+func TestRequestFromCfdi(t *testing.T) {
+	cfdi := models.CfdiInfoModel{
+		CfdiType:        "ingreso",
+		Serie:           "A",
+		Folio:           "9",
+		PaymentTerms:    "03 - Transferencia electrónica de fondos",
+		PaymentMethod:   "PUE",
+		ExpeditionPlace: "78116",
+		Currency:        "USD",
+		ExchangeRate:    d("18.5"),
+		Issuer:          models.TaxEntityInfoViewModel{Rfc: "EKU9003173C9", TaxName: "ESCUELA KEMPER URGATE", FiscalRegime: "601"},
+		Receiver:        models.ReceiverViewModel{Rfc: "URE180429TM6", Name: "UNIVERSIDAD ROBOTICA ESPAÑOLA"},
+		Items:           []models.ItemInfoModel{{Description: "Servicio", Unit: "Unidad de servicio", UnitValue: d("100"), Quantity: d("2")}},
+		Complement:      models.CfdiComplement{TaxStamp: models.CfdiTaxStamp{UUID: "9F8C1B2A-3D4E-4F50-8A6B-7C8D9E0F1A2B"}},
+	}
+
+	request, err := RequestFromCfdi(cfdi, WithNextFolio(), WithRelation(RelationSubstitution))
+	require.NoError(t, err)
+	assert.Equal(t, "I", request.CfdiType)
+	assert.Equal(t, "03", request.PaymentForm)
+	assert.Equal(t, "10", request.Folio)
+	assert.Equal(t, "18.5", request.CurrencyExchangeRate.String())
+	assert.Equal(t, "ESCUELA KEMPER URGATE", request.Issuer.Name)
+	assert.Equal(t, "100", request.Items[0].UnitPrice.String())
+	assert.Equal(t, RelationSubstitution, request.Relations.Type)
+
+	// The data the model does not have is reported by Validate
+	err = request.Validate()
+	errs, ok := AsValidationErrors(err)
+	require.True(t, ok)
+	assert.True(t, errs.Has("Receiver.CfdiUse"))
+	assert.True(t, errs.Has("Items[0].ProductCode"))
+
+	cfdi.Folio = "A"
+	_, err = RequestFromCfdi(cfdi, WithNextFolio())
+	assert.Equal(t, ez.EINVALID, ez.ErrorCode(err))
+}
+
+func TestNextFolio(t *testing.T) {
+	cases := map[string]string{"1": "2", "A-099": "A-100", "9": "10", "F99": "F100", "2025-0009": "2025-0010"}
+	for folio, expected := range cases {
+		next, ok := nextFolio(folio)
+		assert.True(t, ok, folio)
+		assert.Equal(t, expected, next, folio)
+	}
+
+	_, ok := nextFolio("A-")
+	assert.False(t, ok)
+}
@@ -3,145 +3,185 @@ package multiemissor
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"regexp"
 
 	"github.com/vanclief/ez"
+	"github.com/vanclief/go-facturama/api/common"
 	"github.com/vanclief/go-facturama/api/models"
+	"github.com/vanclief/go-facturama/catalogs"
+	"github.com/vanclief/go-facturama/decimal"
+	"github.com/vanclief/go-facturama/rfc"
 )
 
 // CreateCfdiV4Request represents a request to create a CFDI v4
 type CreateCfdiV4Request struct {
-	NameID               int                       `json:"NameId,omitempty"`
-	LogoURL              string                    `json:"LogoUrl,omitempty"`
-	Date                 string                    `json:"Date,omitempty"`
-	Serie                string                    `json:"Serie,omitempty"`
-	PaymentAccountNumber string                    `json:"PaymentAccountNumber,omitempty"`
-	CurrencyExchangeRate float64                   `json:"CurrencyExchangeRate,omitempty"`
-	Currency             string                    `json:"Currency,omitempty"`
-	ExpeditionPlace      string                    `json:"ExpeditionPlace"`
-	Exportation          string                    `json:"Exportation,omitempty"`
-	PaymentConditions    string                    `json:"PaymentConditions,omitempty"`
+	NameID               int                              `json:"NameId,omitempty"`
+	LogoURL              string                           `json:"LogoUrl,omitempty"`
+	Date                 string                           `json:"Date,omitempty"`
+	Serie                string                           `json:"Serie,omitempty"`
+	PaymentAccountNumber string                           `json:"PaymentAccountNumber,omitempty"`
+	CurrencyExchangeRate decimal.Decimal                  `json:"CurrencyExchangeRate,omitzero"`
+	Currency             string                           `json:"Currency,omitempty"`
+	ExpeditionPlace      string                           `json:"ExpeditionPlace"`
+	Exportation          string                           `json:"Exportation,omitempty"`
+	PaymentConditions    string                           `json:"PaymentConditions,omitempty"`
 	GlobalInformation    *models.GlobalInformationV4Model `json:"GlobalInformation,omitempty"`
 	Relations            *models.Cfdiv4Relations          `json:"Relations,omitempty"`
-	Folio                string                    `json:"Folio"`
-	CfdiType             string                    `json:"CfdiType"`
-	PaymentForm          string                    `json:"PaymentForm,omitempty"`
-	PaymentMethod        string                    `json:"PaymentMethod,omitempty"`
+	Folio                string                           `json:"Folio"`
+	CfdiType             string                           `json:"CfdiType"`
+	PaymentForm          string                           `json:"PaymentForm,omitempty"`
+	PaymentMethod        string                           `json:"PaymentMethod,omitempty"`
 	Issuer               models.IssuerV4BindingModel      `json:"Issuer"`
 	Receiver             models.ReceiverV4BindingModel    `json:"Receiver"`
 	Items                []models.ItemFullBindingModel    `json:"Items"`
 	Complemento          *models.Complementv4             `json:"Complemento,omitempty"`
-	Observations         string                    `json:"Observations,omitempty"`
-	OrderNumber          string                    `json:"OrderNumber,omitempty"`
-	PaymentBankName      string                    `json:"PaymentBankName,omitempty"`
+	Observations         string                           `json:"Observations,omitempty"`
+	OrderNumber          string                           `json:"OrderNumber,omitempty"`
+	PaymentBankName      string                           `json:"PaymentBankName,omitempty"`
 }
 
-// Validate validates the request to create a CFDI v4
+// Validate validates the request to create a CFDI v4. It reports every
+// violation at once, see ValidationErrors. Product codes, unit codes and zip
+// codes are only checked for their format unless the full SAT catalogs are
+// loaded, see the catalogs package.
 func (request *CreateCfdiV4Request) Validate() error {
 	const op = "CreateCfdiV4Request.Validate"
 
+	var errs ValidationErrors
+
 	// Required fields
-	if request.ExpeditionPlace == "" {
-		return ez.New(op, ez.EINVALID, "ExpeditionPlace is required", nil)
-	}
-	if request.Folio == "" {
-		return ez.New(op, ez.EINVALID, "Folio is required", nil)
+	if errs.required("ExpeditionPlace", request.ExpeditionPlace) && !zipCodePattern.MatchString(request.ExpeditionPlace) {
+		errs.Add("ExpeditionPlace", CodeFormat, "ExpeditionPlace must be a 5-digit zip code", "ExpeditionPlace debe ser un código postal de 5 dígitos")
 	}
-	if request.CfdiType == "" {
-		return ez.New(op, ez.EINVALID, "CfdiType is required", nil)
+	if errs.required("Folio", request.Folio) && len(request.Folio) > 40 {
+		errs.Add("Folio", CodeFormat, "Folio must be between 1 and 40 characters", "Folio debe tener entre 1 y 40 caracteres")
 	}
+	errs.required("CfdiType", request.CfdiType)
 
-	// Validate CfdiType (I|E|T|N|P)
-	if !regexp.MustCompile(`^[IETNP]$`).MatchString(request.CfdiType) {
-		return ez.New(op, ez.EINVALID, "CfdiType must be one of: I, E, T, N, P", nil)
-	}
+	// Validate Issuer
+	errs.checkRFC("Issuer.Rfc", request.Issuer.Rfc, true)
+	errs.required("Issuer.FiscalRegime", request.Issuer.FiscalRegime)
+
+	// Validate Receiver
+	errs.checkRFC("Receiver.Rfc", request.Receiver.Rfc, false)
+	errs.required("Receiver.Name", request.Receiver.Name)
+	errs.required("Receiver.CfdiUse", request.Receiver.CfdiUse)
+	errs.required("Receiver.FiscalRegime", request.Receiver.FiscalRegime)
+	errs.required("Receiver.TaxZipCode", request.Receiver.TaxZipCode)
 
-	// Validate ExpeditionPlace (5 digit zip code)
-	if !regexp.MustCompile(`^[0-9]{5}$`).MatchString(request.ExpeditionPlace) {
-		return ez.New(op, ez.EINVALID, "ExpeditionPlace must be a 5-digit zip code", nil)
+	// Validate Items
+	if len(request.Items) == 0 {
+		errs.Add("Items", CodeRequired, "At least one item is required", "Se requiere al menos un concepto")
 	}
 
-	// Validate Folio (1-40 chars)
-	if len(request.Folio) < 1 || len(request.Folio) > 40 {
-		return ez.New(op, ez.EINVALID, "Folio must be between 1 and 40 characters", nil)
+	for i, item := range request.Items {
+		validateItem(&errs, fmt.Sprintf("Items[%d]", i), item)
 	}
 
-	// Validate conditional fields
-	if request.PaymentForm != "" {
-		validPaymentForms := []string{"01", "02", "03", "04", "05", "06", "08", "12", "13", "14", "15", "17", "23", "24", "25", "26", "27", "28", "29", "30", "31", "99"}
-		isValid := false
-		for _, v := range validPaymentForms {
-			if request.PaymentForm == v {
-				isValid = true
-				break
-			}
-		}
-		if !isValid {
-			return ez.New(op, ez.EINVALID, "Invalid PaymentForm value", nil)
-		}
+	// Validate codes against the SAT catalogs
+	request.validateCatalogs(&errs)
+
+	// Validate the regime, CFDI use and global invoice rules
+	request.validateCompatibility(&errs)
+
+	return errs.Err(op)
+}
+
+// zipCodePattern is the format of Mexican zip codes
+var zipCodePattern = regexp.MustCompile(`^[0-9]{5}$`)
+
+// validateItem checks the required fields, amounts, taxes, third parties and
+// complements of an item. The path is the JSON path of the item.
+func validateItem(errs *ValidationErrors, path string, item models.ItemFullBindingModel) {
+	errs.required(path+".ProductCode", item.ProductCode)
+	errs.required(path+".Description", item.Description)
+	errs.required(path+".Unit", item.Unit)
+	errs.required(path+".UnitCode", item.UnitCode)
+	errs.required(path+".TaxObject", item.TaxObject)
+
+	if !item.Quantity.GreaterThan(decimal.Zero) {
+		errs.Add(path+".Quantity", CodeInvalid, path+".Quantity must be greater than zero", path+".Quantity debe ser mayor que cero")
+	}
+	if item.UnitPrice.IsNegative() {
+		errs.Add(path+".UnitPrice", CodeInvalid, path+".UnitPrice must not be negative", path+".UnitPrice no debe ser negativo")
 	}
 
-	if request.PaymentMethod != "" {
-		if request.PaymentMethod != "PUE" && request.PaymentMethod != "PPD" {
-			return ez.New(op, ez.EINVALID, "PaymentMethod must be either PUE or PPD", nil)
+	for j, tax := range item.Taxes {
+		taxPath := fmt.Sprintf("%s.Taxes[%d]", path, j)
+		errs.oneOf(taxPath+".Name", normalizeTaxName(tax.Name), TaxIVA, TaxISR, TaxIEPS, TaxIVAExempt)
+		if tax.Rate.IsNegative() {
+			errs.Add(taxPath+".Rate", CodeInvalid, taxPath+".Rate must not be negative", taxPath+".Rate no debe ser negativo")
 		}
 	}
 
-	// Validate Issuer
-	if request.Issuer.Rfc == "" {
-		return ez.New(op, ez.EINVALID, "Issuer.Rfc is required", nil)
-	}
-	if request.Issuer.FiscalRegime == "" {
-		return ez.New(op, ez.EINVALID, "Issuer.FiscalRegime is required", nil)
+	if item.ThirdPartyAccount != nil {
+		errs.checkRFC(path+".ThirdPartyAccount.Rfc", item.ThirdPartyAccount.Rfc, true)
 	}
 
-	// Validate Receiver
-	if request.Receiver.Rfc == "" {
-		return ez.New(op, ez.EINVALID, "Receiver.Rfc is required", nil)
+	if item.Complement == nil {
+		return
 	}
-	if request.Receiver.Name == "" {
-		return ez.New(op, ez.EINVALID, "Receiver.Name is required", nil)
-	}
-	if request.Receiver.CfdiUse == "" {
-		return ez.New(op, ez.EINVALID, "Receiver.CfdiUse is required", nil)
+
+	if third := item.Complement.ThirdPartyAccount; third != nil {
+		errs.checkRFC(path+".Complement.ThirdPartyAccount.Rfc", third.Rfc, true)
 	}
-	if request.Receiver.FiscalRegime == "" {
-		return ez.New(op, ez.EINVALID, "Receiver.FiscalRegime is required", nil)
+
+	if school := item.Complement.EducationalInstitution; school != nil {
+		errs.checkCURP(path+".Complement.EducationalInstitution.Curp", school.Curp)
+		if school.PaymentRfc != "" {
+			errs.checkRFC(path+".Complement.EducationalInstitution.PaymentRfc", school.PaymentRfc, false)
+		}
 	}
-	if request.Receiver.TaxZipCode == "" {
-		return ez.New(op, ez.EINVALID, "Receiver.TaxZipCode is required", nil)
+}
+
+// validateCatalogs checks that every catalog code of the request is in the
+// corresponding SAT catalog. Missing codes are reported as required elsewhere.
+func (request *CreateCfdiV4Request) validateCatalogs(errs *ValidationErrors) {
+	errs.checkCatalog(catalogs.TipoDeComprobante, "CfdiType", request.CfdiType)
+	errs.checkCatalog(catalogs.FormaPago, "PaymentForm", request.PaymentForm)
+	errs.checkCatalog(catalogs.MetodoPago, "PaymentMethod", request.PaymentMethod)
+	errs.checkCatalog(catalogs.Moneda, "Currency", request.Currency)
+	errs.checkCatalog(catalogs.Exportacion, "Exportation", request.Exportation)
+	errs.checkCatalog(catalogs.RegimenFiscal, "Issuer.FiscalRegime", request.Issuer.FiscalRegime)
+	errs.checkCatalog(catalogs.UsoCFDI, "Receiver.CfdiUse", request.Receiver.CfdiUse)
+	errs.checkCatalog(catalogs.RegimenFiscal, "Receiver.FiscalRegime", request.Receiver.FiscalRegime)
+	errs.checkCatalog(catalogs.Pais, "Receiver.TaxResidence", request.Receiver.TaxResidence)
+
+	// Zip codes with a wrong format are already reported
+	if zipCodePattern.MatchString(request.ExpeditionPlace) {
+		errs.checkCatalog(catalogs.CodigoPostal, "ExpeditionPlace", request.ExpeditionPlace)
 	}
+	errs.checkCatalog(catalogs.CodigoPostal, "Receiver.TaxZipCode", request.Receiver.TaxZipCode)
 
-	// Validate Items
-	if len(request.Items) == 0 {
-		return ez.New(op, ez.EINVALID, "At least one item is required", nil)
+	if request.GlobalInformation != nil {
+		errs.checkCatalog(catalogs.Periodicidad, "GlobalInformation.Periodicity", request.GlobalInformation.Periodicity)
+		errs.checkCatalog(catalogs.Meses, "GlobalInformation.Months", request.GlobalInformation.Months)
+	}
+	if request.Relations != nil {
+		errs.checkCatalog(catalogs.TipoRelacion, "Relations.Type", request.Relations.Type)
 	}
 
 	for i, item := range request.Items {
-		if item.ProductCode == "" {
-			return ez.New(op, ez.EINVALID, fmt.Sprintf("Items[%d].ProductCode is required", i), nil)
-		}
-		if item.Description == "" {
-			return ez.New(op, ez.EINVALID, fmt.Sprintf("Items[%d].Description is required", i), nil)
-		}
-		if item.Unit == "" {
-			return ez.New(op, ez.EINVALID, fmt.Sprintf("Items[%d].Unit is required", i), nil)
-		}
-		if item.UnitCode == "" {
-			return ez.New(op, ez.EINVALID, fmt.Sprintf("Items[%d].UnitCode is required", i), nil)
-		}
-		if item.TaxObject == "" {
-			return ez.New(op, ez.EINVALID, fmt.Sprintf("Items[%d].TaxObject is required", i), nil)
-		}
+		errs.checkCatalog(catalogs.ClaveProdServ, fmt.Sprintf("Items[%d].ProductCode", i), item.ProductCode)
+		errs.checkCatalog(catalogs.ClaveUnidad, fmt.Sprintf("Items[%d].UnitCode", i), item.UnitCode)
+		errs.checkCatalog(catalogs.ObjetoImp, fmt.Sprintf("Items[%d].TaxObject", i), item.TaxObject)
 	}
-
-	return nil
 }
 
 // CreateCfdiV4 creates a new CFDI v4 (Mexican digital invoice)
 // Endpoint: POST /api-lite/3/cfdis
+//
+// The POST is sent once. Retrying it is an opt-in: when the RetryPolicy of
+// the client includes POST in its Methods, transient failures are retried
+// without stamping twice. After a timeout or HTTP 5xx the CFDI may have been
+// stamped, so before posting again it is looked up by issuer RFC, Serie and
+// Folio and returned if found. Folios must therefore be unique per issuer and
+// serie. When the lookup fails or cannot confirm the issuer of a match, the
+// original failure is returned instead of posting again.
 func (c *Client) CreateCfdiV4(ctx context.Context, request CreateCfdiV4Request) (*models.CfdiInfoModel, error) {
 	const op = "multiemissor.CreateCfdiV4"
+	ctx = common.WithOp(ctx, op)
 
 	// Validate required fields
 	err := request.Validate()
@@ -149,14 +189,117 @@ func (c *Client) CreateCfdiV4(ctx context.Context, request CreateCfdiV4Request)
 		return nil, ez.Wrap(op, err)
 	}
 
+	stamped, err := c.createCfdi(ctx, request, nil)
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	return stamped, nil
+}
+
+// acceptFunc tells whether a CFDI with the issuer, Serie and Folio of a
+// request is the one the request stamped
+type acceptFunc func(ctx context.Context, cfdi *models.CfdiInfoModel) (bool, error)
+
+// createCfdi posts a validated request, retrying as CreateCfdiV4 describes.
+// The lookups before a retry only take the CFDIs accept takes, any CFDI if
+// it is nil.
+func (c *Client) createCfdi(ctx context.Context, request CreateCfdiV4Request, accept acceptFunc) (*models.CfdiInfoModel, error) {
+	const op = "multiemissor.createCfdi"
+
 	// Using /api-lite/3/cfdis as the endpoint for CFDI v4 creation
 	path := "/api-lite/3/cfdis"
-	var result models.CfdiInfoModel
 
-	err = c.Post(ctx, path, request, &result)
+	// Each attempt is a single POST, a retry of the transport would not look
+	// up the CFDI first and could stamp it twice
+	postCtx := common.WithoutRetry(ctx)
+	retries := c.Retry.Retries(http.MethodPost)
+
+	for attempt := 1; ; attempt++ {
+		var result models.CfdiInfoModel
+
+		err := c.Post(postCtx, path, request, &result)
+		if err == nil {
+			return &result, nil
+		}
+
+		if !retries || attempt >= c.Retry.MaxAttempts || !common.IsRetryable(err) {
+			return nil, ez.Wrap(op, err)
+		}
+
+		if c.Retry.Wait(ctx, attempt, err) != nil {
+			return nil, ez.Wrap(op, err)
+		}
+
+		// A throttled request was not processed, any other failure may have been
+		if ez.ErrorCode(err) == ez.ERESOURCEEXHAUSTED {
+			continue
+		}
+
+		stamped, lookupErr := c.findStamped(ctx, request, accept)
+		if lookupErr != nil {
+			// Posting again without knowing risks a duplicate, report the original failure
+			return nil, ez.Wrap(op, err)
+		}
+		if stamped != nil {
+			return stamped, nil
+		}
+	}
+}
+
+// findStamped returns the active CFDI with the issuer RFC, Serie and Folio of
+// the request that accept takes, or nil if it has not been stamped. The
+// issuer is checked on the full CFDI when the search result does not have
+// it, and a CONFLICT error is returned when it cannot be confirmed.
+func (c *Client) findStamped(ctx context.Context, request CreateCfdiV4Request, accept acceptFunc) (*models.CfdiInfoModel, error) {
+	const op = "multiemissor.findStamped"
+
+	cfdis, err := c.ListCfdis(ctx, ListCfdisRequest{
+		Status:     "active",
+		IssuerRfc:  request.Issuer.Rfc,
+		Serie:      request.Serie,
+		FolioStart: request.Folio,
+		FolioEnd:   request.Folio,
+	})
 	if err != nil {
 		return nil, ez.Wrap(op, err)
 	}
 
-	return &result, nil
+	issuer := rfc.Normalize(request.Issuer.Rfc)
+	for _, cfdi := range cfdis {
+		if cfdi.Serie != request.Serie || cfdi.Folio != request.Folio {
+			continue
+		}
+		if cfdi.Issuer.Rfc != "" && rfc.Normalize(cfdi.Issuer.Rfc) != issuer {
+			continue
+		}
+
+		stamped, err := c.GetCfdiById(ctx, GetCfdiByIdRequest{ID: cfdi.ID})
+		if err != nil {
+			return nil, ez.Wrap(op, err)
+		}
+
+		switch rfc.Normalize(stamped.Issuer.Rfc) {
+		case issuer:
+		case "":
+			msg := fmt.Sprintf("The issuer of the CFDI %s with the Serie and Folio of the request is unknown", cfdi.ID)
+			return nil, ez.New(op, ez.ECONFLICT, msg, nil)
+		default:
+			continue
+		}
+
+		if accept != nil {
+			ok, err := accept(ctx, stamped)
+			if err != nil {
+				return nil, ez.Wrap(op, err)
+			}
+			if !ok {
+				continue
+			}
+		}
+
+		return stamped, nil
+	}
+
+	return nil, nil
 }
@@ -0,0 +1,340 @@
+package multiemissor
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vanclief/ez"
+	"github.com/vanclief/go-facturama/api/common"
+	"github.com/vanclief/go-facturama/api/facturamatest"
+	"github.com/vanclief/go-facturama/api/models"
+	"github.com/vanclief/go-facturama/rfc"
+)
+
+// validCfdiRequest returns a request that passes Validate
+func validCfdiRequest() CreateCfdiV4Request {
+	return CreateCfdiV4Request{
+		ExpeditionPlace: "78116",
+		Folio:           "100",
+		CfdiType:        "I",
+		PaymentForm:     "03",
+		PaymentMethod:   "PUE",
+		Currency:        "MXN",
+		Exportation:     "01",
+		Issuer: models.IssuerV4BindingModel{
+			Rfc:          "EKU9003173C9",
+			Name:         "ESCUELA KEMPER URGATE",
+			FiscalRegime: "601",
+		},
+		Receiver: models.ReceiverV4BindingModel{
+			Rfc:          "URE180429TM6",
+			Name:         "UNIVERSIDAD ROBOTICA ESPAÑOLA",
+			CfdiUse:      "G03",
+			FiscalRegime: "601",
+			TaxZipCode:   "86991",
+		},
+		Items: []models.ItemFullBindingModel{
+			{
+				ProductCode: "84111506",
+				Description: "Servicio de facturación",
+				Unit:        "Unidad de servicio",
+				UnitCode:    "E48",
+				UnitPrice:   d("100"),
+				Quantity:    d("1"),
+				TaxObject:   TaxObjectYes,
+				Taxes:       []models.TaxBindingModel{{Name: TaxIVA, Rate: d("0.16")}},
+			},
+		},
+	}
+}
+
+func TestValidateCatalogs(t *testing.T) {
+	request := validCfdiRequest()
+	assert.NoError(t, request.Validate())
+
+	cases := map[string]func(*CreateCfdiV4Request){
+		"payment form":   func(r *CreateCfdiV4Request) { r.PaymentForm = "07" },
+		"payment method": func(r *CreateCfdiV4Request) { r.PaymentMethod = "PPU" },
+		"cfdi type":      func(r *CreateCfdiV4Request) { r.CfdiType = "X" },
+		"currency":       func(r *CreateCfdiV4Request) { r.Currency = "MXP" },
+		"exportation":    func(r *CreateCfdiV4Request) { r.Exportation = "05" },
+		"issuer regime":  func(r *CreateCfdiV4Request) { r.Issuer.FiscalRegime = "600" },
+		"cfdi use":       func(r *CreateCfdiV4Request) { r.Receiver.CfdiUse = "P01" },
+		"tax zip code":   func(r *CreateCfdiV4Request) { r.Receiver.TaxZipCode = "8699" },
+		"tax residence":  func(r *CreateCfdiV4Request) { r.Receiver.TaxResidence = "US" },
+		"product code":   func(r *CreateCfdiV4Request) { r.Items[0].ProductCode = "841115" },
+		"unit code":      func(r *CreateCfdiV4Request) { r.Items[0].UnitCode = "pieza" },
+		"tax object":     func(r *CreateCfdiV4Request) { r.Items[0].TaxObject = "09" },
+		"periodicity": func(r *CreateCfdiV4Request) {
+			r.GlobalInformation = &models.GlobalInformationV4Model{Periodicity: "06", Months: "01", Year: 2025}
+		},
+		"relation type": func(r *CreateCfdiV4Request) {
+			r.Relations = &models.Cfdiv4Relations{Type: "08"}
+		},
+	}
+
+	for name, mutate := range cases {
+		request := validCfdiRequest()
+		mutate(&request)
+		assert.Error(t, request.Validate(), name)
+	}
+}
+
+func TestValidateRFCs(t *testing.T) {
+	cases := map[string]func(*CreateCfdiV4Request){
+		"Issuer.Rfc":   func(r *CreateCfdiV4Request) { r.Issuer.Rfc = "EKU9003173C8" },
+		"Receiver.Rfc": func(r *CreateCfdiV4Request) { r.Receiver.Rfc = "NOSOYUNRFC" },
+		"Items[0].ThirdPartyAccount.Rfc": func(r *CreateCfdiV4Request) {
+			r.Items[0].ThirdPartyAccount = &models.ThirdPartyAccountModel{Rfc: rfc.GenericNational}
+		},
+		"Items[0].Complement.EducationalInstitution.Curp": func(r *CreateCfdiV4Request) {
+			r.Items[0].Complement = &models.ItemComplementModel{
+				EducationalInstitution: &models.EducationalInstitutionModel{Curp: "MAHJ280603MSPRRV08"},
+			}
+		},
+	}
+
+	for field, mutate := range cases {
+		request := validCfdiRequest()
+		mutate(&request)
+
+		errs, ok := AsValidationErrors(request.Validate())
+		require.True(t, ok, field)
+		require.Len(t, errs, 1, field)
+		assert.Equal(t, field, errs[0].Field)
+		assert.Contains(t, []string{CodeInvalidRFC, CodeInvalidCURP}, errs[0].Code, field)
+		assert.NotEmpty(t, errs[0].MessageES, field)
+	}
+
+	request := validCfdiRequest()
+	request.Receiver = models.ReceiverV4BindingModel{
+		Rfc:          rfc.GenericForeign,
+		Name:         "CLIENTE EXTRANJERO",
+		CfdiUse:      NoTaxEffectsUse,
+		FiscalRegime: GenericRegime,
+		TaxZipCode:   request.ExpeditionPlace,
+	}
+	assert.NoError(t, request.Validate())
+
+	get := GetCSDByRFCRequest{RFC: "NOSOYUNRFC"}
+	errs, ok := AsValidationErrors(get.Validate())
+	require.True(t, ok)
+	assert.Equal(t, "RFC: RFC NOSOYUNRFC must have 12 (persona moral) or 13 (persona física) characters", errs[0].Message)
+	assert.Equal(t, "RFC: el RFC NOSOYUNRFC debe tener 12 (persona moral) o 13 (persona física) caracteres", errs[0].MessageES)
+}
+
+func TestValidationErrors(t *testing.T) {
+	request := validCfdiRequest()
+	request.Folio = ""
+	request.Receiver.Name = ""
+	request.Items = append(request.Items, request.Items[0])
+	request.Items[1].UnitCode = "pieza"
+	request.Items[1].Taxes = []models.TaxBindingModel{{Name: "IVA", Rate: d("-0.16")}}
+
+	err := request.Validate()
+	assert.Equal(t, ez.EINVALID, ez.ErrorCode(err))
+
+	errs, ok := AsValidationErrors(err)
+	require.True(t, ok)
+	assert.Equal(t, []string{"Folio", "Receiver.Name", "Items[1].Taxes[0].Rate", "Items[1].UnitCode"}, errs.Fields())
+	assert.Equal(t, CodeRequired, errs[0].Code)
+	assert.Equal(t, "Folio es obligatorio", errs[0].MessageES)
+	assert.Equal(t, CodeFormat, errs[3].Code)
+	assert.Equal(t, errs.Error(), ez.ErrorMessage(err))
+
+	// Client methods keep the violations when they wrap the error
+	_, err = (&Client{}).CreateCfdiV4(context.Background(), request)
+	errs, ok = AsValidationErrors(err)
+	require.True(t, ok)
+	assert.True(t, errs.Has("Items[1].UnitCode"))
+
+	request = validCfdiRequest()
+	_, ok = AsValidationErrors(request.Validate())
+	assert.False(t, ok)
+}
+
+func TestValidateCompatibility(t *testing.T) {
+	generalPublic := func(r *CreateCfdiV4Request) {
+		r.Receiver = models.ReceiverV4BindingModel{
+			Rfc:          rfc.GenericNational,
+			Name:         GeneralPublicName,
+			CfdiUse:      NoTaxEffectsUse,
+			FiscalRegime: GenericRegime,
+			TaxZipCode:   r.ExpeditionPlace,
+		}
+		r.GlobalInformation = &models.GlobalInformationV4Model{Periodicity: "04", Months: "05", Year: 2025}
+	}
+
+	request := validCfdiRequest()
+	generalPublic(&request)
+	assert.NoError(t, request.Validate())
+
+	cases := map[string]func(*CreateCfdiV4Request){
+		"fisica regime for moral issuer": func(r *CreateCfdiV4Request) { r.Issuer.FiscalRegime = "612" },
+		"moral regime for fisica receiver": func(r *CreateCfdiV4Request) {
+			r.Receiver.Rfc = "CACX7605101P8"
+		},
+		"use not allowed for moral": func(r *CreateCfdiV4Request) { r.Receiver.CfdiUse = "D01" },
+		"use not allowed for regime": func(r *CreateCfdiV4Request) {
+			r.Receiver.Rfc = "CACX7605101P8"
+			r.Receiver.FiscalRegime = "612"
+			r.Receiver.CfdiUse = "CN01"
+		},
+		"payment without CP01": func(r *CreateCfdiV4Request) { r.CfdiType = "P" },
+		"global without general public": func(r *CreateCfdiV4Request) {
+			r.GlobalInformation = &models.GlobalInformationV4Model{Periodicity: "04", Months: "05", Year: 2025}
+		},
+		"generic with G03": func(r *CreateCfdiV4Request) {
+			generalPublic(r)
+			r.Receiver.CfdiUse = "G03"
+		},
+		"generic with other zip code": func(r *CreateCfdiV4Request) {
+			generalPublic(r)
+			r.Receiver.TaxZipCode = "86991"
+		},
+		"general public without global information": func(r *CreateCfdiV4Request) {
+			generalPublic(r)
+			r.GlobalInformation = nil
+		},
+		"bimonthly outside RIF": func(r *CreateCfdiV4Request) {
+			generalPublic(r)
+			r.GlobalInformation.Periodicity = "05"
+			r.GlobalInformation.Months = "13"
+		},
+		"bimonthly months with monthly periodicity": func(r *CreateCfdiV4Request) {
+			generalPublic(r)
+			r.GlobalInformation.Months = "13"
+		},
+	}
+
+	for name, mutate := range cases {
+		request := validCfdiRequest()
+		mutate(&request)
+		assert.Error(t, request.Validate(), name)
+	}
+}
+
+func TestCreateCfdiV4Idempotent(t *testing.T) {
+	srv := facturamatest.NewServer(facturamatest.WithCSD(models.TaxEntityCSD{RFC: "EKU9003173C9"}))
+	defer srv.Close()
+
+	// hideIssuer blanks the issuer RFC of the lookup responses whose path has the prefix
+	var hideIssuer string
+	hide := func(next common.Handler) common.Handler {
+		return func(ctx context.Context, req *common.Request) (*common.Response, error) {
+			resp, err := next(ctx, req)
+			if resp != nil && hideIssuer != "" && req.Method == http.MethodGet && strings.HasPrefix(req.Path, hideIssuer) {
+				resp.Body = bytes.ReplaceAll(resp.Body, []byte(`"EKU9003173C9"`), []byte(`""`))
+			}
+			return resp, err
+		}
+	}
+
+	// Retrying the POST is an opt-in of the policy
+	policy := common.DefaultRetryPolicy()
+	policy.InitialBackoff = time.Millisecond
+	policy.Methods = append(policy.Methods, http.MethodPost)
+	client := NewClient(srv.Username, srv.Password, common.WithBaseURL(srv.URL), common.WithRetry(policy), common.WithMiddleware(hide))
+	ctx := context.Background()
+	path := "/api-lite/3/cfdis"
+
+	// The CFDI was stamped but the response was lost: it is returned, not stamped again
+	request := validCfdiRequest()
+	srv.InjectFailure(facturamatest.Failure{Method: http.MethodPost, Path: path, Handle: true, Drop: true})
+
+	cfdi, err := client.CreateCfdiV4(ctx, request)
+	require.NoError(t, err)
+	assert.Equal(t, request.Folio, cfdi.Folio)
+	assert.NotEmpty(t, cfdi.Complement.TaxStamp.UUID)
+	assert.Equal(t, 1, srv.RequestCount(http.MethodPost, path))
+
+	// The CFDI was not stamped: it is posted again
+	request.Folio = "101"
+	srv.InjectFailure(facturamatest.Failure{Method: http.MethodPost, Path: path, StatusCode: http.StatusBadGateway})
+
+	cfdi, err = client.CreateCfdiV4(ctx, request)
+	require.NoError(t, err)
+	assert.Equal(t, "101", cfdi.Folio)
+	assert.Equal(t, 3, srv.RequestCount(http.MethodPost, path))
+
+	cfdis, err := client.ListCfdis(ctx, ListCfdisRequest{IssuerRfc: request.Issuer.Rfc})
+	require.NoError(t, err)
+	assert.Len(t, cfdis, 2)
+
+	// The original failure is returned when the lookup fails
+	request.Folio = "102"
+	srv.InjectFailure(facturamatest.Failure{Method: http.MethodPost, Path: path, Drop: true})
+	srv.InjectFailure(facturamatest.Failure{Method: http.MethodGet, Path: "/api-lite/cfdis", StatusCode: http.StatusBadRequest})
+
+	_, err = client.CreateCfdiV4(ctx, request)
+	assert.True(t, common.IsUnavailable(err))
+	assert.Equal(t, 4, srv.RequestCount(http.MethodPost, path))
+
+	// The search does not report the issuer: it is confirmed on the CFDI
+	request.Folio = "103"
+	hideIssuer = "/api-lite/cfdis?"
+	srv.InjectFailure(facturamatest.Failure{Method: http.MethodPost, Path: path, Handle: true, Drop: true})
+
+	cfdi, err = client.CreateCfdiV4(ctx, request)
+	require.NoError(t, err)
+	assert.Equal(t, "103", cfdi.Folio)
+	assert.Equal(t, 5, srv.RequestCount(http.MethodPost, path))
+
+	// The issuer cannot be confirmed: the original failure is returned
+	request.Folio = "104"
+	hideIssuer = "/api-lite/cfdis"
+	srv.InjectFailure(facturamatest.Failure{Method: http.MethodPost, Path: path, Handle: true, Drop: true})
+
+	_, err = client.CreateCfdiV4(ctx, request)
+	assert.True(t, common.IsUnavailable(err))
+	assert.Equal(t, 6, srv.RequestCount(http.MethodPost, path))
+	hideIssuer = ""
+
+	// The default policy does not retry the POST
+	request.Folio = "105"
+	single := NewClient(srv.Username, srv.Password, common.WithBaseURL(srv.URL), common.WithRetry(common.DefaultRetryPolicy()))
+	srv.InjectFailure(facturamatest.Failure{Method: http.MethodPost, Path: path})
+
+	_, err = single.CreateCfdiV4(ctx, request)
+	assert.True(t, common.IsUnavailable(err))
+	assert.Equal(t, 7, srv.RequestCount(http.MethodPost, path))
+}
+
+func TestCreateCfdiV4RetriedPost(t *testing.T) {
+	srv := facturamatest.NewServer(facturamatest.WithCSD(models.TaxEntityCSD{RFC: "EKU9003173C9"}))
+	defer srv.Close()
+
+	// A policy that retries POST does not retry the create blindly
+	policy := common.DefaultRetryPolicy()
+	policy.InitialBackoff = time.Millisecond
+	policy.Methods = []string{http.MethodPost}
+	client := NewClient(srv.Username, srv.Password, common.WithBaseURL(srv.URL), common.WithRetry(policy))
+	ctx := context.Background()
+	path := "/api-lite/3/cfdis"
+
+	request := validCfdiRequest()
+	srv.InjectFailure(facturamatest.Failure{Method: http.MethodPost, Path: path, Handle: true, Drop: true})
+
+	cfdi, err := client.CreateCfdiV4(ctx, request)
+	require.NoError(t, err)
+	assert.Equal(t, request.Folio, cfdi.Folio)
+	assert.Equal(t, 1, srv.RequestCount(http.MethodPost, path))
+
+	cfdis, err := client.ListCfdis(ctx, ListCfdisRequest{IssuerRfc: request.Issuer.Rfc})
+	require.NoError(t, err)
+	assert.Len(t, cfdis, 1)
+
+	// Attempts are not multiplied by the retries of the transport
+	request.Folio = "101"
+	srv.InjectFailure(facturamatest.Failure{Method: http.MethodPost, Path: path, Times: policy.MaxAttempts})
+
+	_, err = client.CreateCfdiV4(ctx, request)
+	assert.True(t, common.IsUnavailable(err))
+	assert.Equal(t, 1+policy.MaxAttempts, srv.RequestCount(http.MethodPost, path))
+}
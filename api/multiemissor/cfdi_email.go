@@ -0,0 +1,143 @@
+package multiemissor
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+
+	"github.com/vanclief/ez"
+	"github.com/vanclief/go-facturama/api/common"
+	"github.com/vanclief/go-facturama/api/models"
+)
+
+// SendCfdiByEmailRequest represents a request to send a CFDI by email
+type SendCfdiByEmailRequest struct {
+	// ID of the CFDI
+	ID string
+	// CfdiType is issued, issuedLite or payroll. Defaults to issuedLite
+	CfdiType string
+	// Emails are the recipient addresses, duplicates are sent once
+	Emails []string
+}
+
+// Validate validates the request to send a CFDI by email
+func (request *SendCfdiByEmailRequest) Validate() error {
+	const op = "SendCfdiByEmailRequest.Validate"
+
+	var errs ValidationErrors
+
+	errs.required("ID", request.ID)
+
+	if request.CfdiType == "" {
+		request.CfdiType = "issuedLite"
+	}
+	errs.oneOf("CfdiType", request.CfdiType, "issued", "issuedLite", "payroll")
+
+	if len(request.Emails) == 0 {
+		errs.Add("Emails", CodeRequired, "At least one email is required", "Se requiere al menos un correo electrónico")
+	}
+
+	for i, email := range request.Emails {
+		field := fmt.Sprintf("Emails[%d]", i)
+		if !errs.required(field, strings.TrimSpace(email)) {
+			continue
+		}
+
+		// Only bare addresses are accepted, not "Name <address>"
+		address, err := mail.ParseAddress(email)
+		if err != nil || address.Address != strings.TrimSpace(email) {
+			errs.Add(field, CodeFormat,
+				fmt.Sprintf("%s %q is not a valid email address", field, email),
+				fmt.Sprintf("%s %q no es un correo electrónico válido", field, email))
+		}
+	}
+
+	return errs.Err(op)
+}
+
+// recipients returns the trimmed emails without duplicates, in order
+func (request *SendCfdiByEmailRequest) recipients() []string {
+	var emails []string
+	seen := make(map[string]bool)
+	for _, email := range request.Emails {
+		email = strings.TrimSpace(email)
+		if key := strings.ToLower(email); !seen[key] {
+			seen[key] = true
+			emails = append(emails, email)
+		}
+	}
+
+	return emails
+}
+
+// EmailDelivery is the outcome of sending a CFDI to one address
+type EmailDelivery struct {
+	Email string
+	// Sent reports whether Facturama accepted the email
+	Sent bool
+	// Message is the message returned by Facturama
+	Message string
+}
+
+// SendCfdiByEmailResult holds the outcome of sending a CFDI to each address
+type SendCfdiByEmailResult struct {
+	Deliveries []EmailDelivery
+}
+
+// AllSent reports whether the CFDI was sent to every address
+func (result *SendCfdiByEmailResult) AllSent() bool {
+	return len(result.Failed()) == 0 && len(result.Deliveries) > 0
+}
+
+// Failed returns the deliveries Facturama did not accept
+func (result *SendCfdiByEmailResult) Failed() []EmailDelivery {
+	var failed []EmailDelivery
+	for _, delivery := range result.Deliveries {
+		if !delivery.Sent {
+			failed = append(failed, delivery)
+		}
+	}
+
+	return failed
+}
+
+// SendCfdiByEmail sends a CFDI with its PDF and XML to each address. The API
+// takes one address per call, so addresses are sent in order; if a call fails
+// the deliveries made so far are returned along with the error.
+// Endpoint: POST /cfdi?cfdiType={type}&cfdiId={id}&email={email}
+func (c *Client) SendCfdiByEmail(ctx context.Context, request SendCfdiByEmailRequest) (*SendCfdiByEmailResult, error) {
+	const op = "multiemissor.SendCfdiByEmail"
+	ctx = common.WithOp(ctx, op)
+
+	// Validate request
+	err := request.Validate()
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	result := &SendCfdiByEmailResult{}
+
+	for _, email := range request.recipients() {
+		query := url.Values{}
+		query.Set("cfdiType", request.CfdiType)
+		query.Set("cfdiId", request.ID)
+		query.Set("email", email)
+
+		var response models.SendEmailResponse
+
+		err = c.Post(ctx, "/cfdi?"+query.Encode(), nil, &response)
+		if err != nil {
+			return result, ez.Wrap(op, err)
+		}
+
+		result.Deliveries = append(result.Deliveries, EmailDelivery{
+			Email:   email,
+			Sent:    response.Success,
+			Message: response.Message,
+		})
+	}
+
+	return result, nil
+}
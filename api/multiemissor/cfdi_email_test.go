@@ -0,0 +1,59 @@
+package multiemissor
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vanclief/go-facturama/api/common"
+	"github.com/vanclief/go-facturama/api/facturamatest"
+	"github.com/vanclief/go-facturama/api/models"
+)
+
+func TestSendCfdiByEmailValidate(t *testing.T) {
+	request := SendCfdiByEmailRequest{ID: "abc", Emails: []string{"cliente@example.com"}}
+	require.NoError(t, request.Validate())
+	assert.Equal(t, "issuedLite", request.CfdiType)
+
+	request = SendCfdiByEmailRequest{CfdiType: "received", Emails: []string{"cliente@", " ", "Cliente <cliente@example.com>"}}
+	errs, ok := AsValidationErrors(request.Validate())
+	require.True(t, ok)
+	assert.Equal(t, []string{"ID", "CfdiType", "Emails[0]", "Emails[1]", "Emails[2]"}, errs.Fields())
+
+	request = SendCfdiByEmailRequest{ID: "abc"}
+	errs, _ = AsValidationErrors(request.Validate())
+	assert.True(t, errs.Has("Emails"))
+}
+
+func TestSendCfdiByEmail(t *testing.T) {
+	srv := facturamatest.NewServer()
+	defer srv.Close()
+
+	id := srv.AddCfdi(models.CfdiInfoModel{Folio: "100"})
+	client := NewClient(srv.Username, srv.Password, common.WithBaseURL(srv.URL))
+	ctx := context.Background()
+
+	result, err := client.SendCfdiByEmail(ctx, SendCfdiByEmailRequest{
+		ID:     id,
+		Emails: []string{"cliente@example.com", " CLIENTE@example.com", "contabilidad@example.invalid"},
+	})
+	require.NoError(t, err)
+	require.Len(t, result.Deliveries, 2)
+	assert.False(t, result.AllSent())
+	assert.True(t, result.Deliveries[0].Sent)
+	assert.Equal(t, []EmailDelivery{result.Deliveries[1]}, result.Failed())
+	assert.NotEmpty(t, result.Failed()[0].Message)
+
+	assert.Equal(t, []facturamatest.SentEmail{{CfdiID: id, CfdiType: "issuedLite", Email: "cliente@example.com"}}, srv.SentEmails())
+
+	// A failed call stops the sending and returns the deliveries made so far
+	srv.InjectFailure(facturamatest.Failure{Method: http.MethodPost, Path: "/cfdi", StatusCode: http.StatusBadGateway})
+	result, err = client.SendCfdiByEmail(ctx, SendCfdiByEmailRequest{ID: id, Emails: []string{"a@example.com", "b@example.com"}})
+	assert.True(t, common.IsUnavailable(err))
+	assert.Empty(t, result.Deliveries)
+
+	_, err = client.SendCfdiByEmail(ctx, SendCfdiByEmailRequest{ID: "missing", Emails: []string{"a@example.com"}})
+	assert.True(t, common.IsNotFound(err))
+}
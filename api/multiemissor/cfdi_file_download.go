@@ -0,0 +1,449 @@
+package multiemissor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/vanclief/ez"
+	"github.com/vanclief/go-facturama/api/common"
+	"github.com/vanclief/go-facturama/api/models"
+	"github.com/vanclief/go-facturama/utils"
+)
+
+// DownloadCfdiFile streams a CFDI file to w, decoding the base64 Content as
+// the response arrives instead of holding the whole document in memory. The
+// returned file has the metadata of the response without Content. The
+// number of bytes written is checked against ContentLength, which may be the
+// length of the file or of its base64 encoding.
+// Endpoint: GET /cfdi/{format}/{type}/{id}
+func (c *Client) DownloadCfdiFile(ctx context.Context, request GetCfdiFileRequest, w io.Writer) (*models.FileViewModel, error) {
+	const op = "multiemissor.DownloadCfdiFile"
+	ctx = common.WithOp(ctx, op)
+
+	// Validate request
+	err := request.Validate()
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	path := fmt.Sprintf("/cfdi/%s/%s/%s", request.Format, request.CfdiType, request.ID)
+
+	var file models.FileViewModel
+	var written int64
+
+	err = c.Stream(ctx, http.MethodGet, path, nil, func(body io.Reader) error {
+		var decodeErr error
+		file, written, decodeErr = decodeFileStream(body, w)
+		return decodeErr
+	})
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	length := int64(file.ContentLength)
+	if length > 0 && written != length && int64(base64.StdEncoding.EncodedLen(int(written))) != length {
+		msg := fmt.Sprintf("The file has %d bytes but the API reported %d", written, file.ContentLength)
+		return nil, ez.New(op, ez.EINTERNAL, msg, nil)
+	}
+
+	return &file, nil
+}
+
+// SaveCfdiFile downloads a CFDI file to path with DownloadCfdiFile and
+// returns the path written. The extension of the format is added to the path
+// if it does not have it, and a directory saves the file as {ID}.{format}.
+// The file is written to a temporary file first, so a failed download does
+// not leave a partial file, and saved with mode 0644.
+func (c *Client) SaveCfdiFile(ctx context.Context, request GetCfdiFileRequest, path string) (string, error) {
+	const op = "multiemissor.SaveCfdiFile"
+
+	// Validate request, the format sets the extension
+	err := request.Validate()
+	if err != nil {
+		return "", ez.Wrap(op, err)
+	}
+
+	extension := "." + request.Format
+
+	info, err := os.Stat(path)
+	switch {
+	case err == nil && info.IsDir():
+		path = filepath.Join(path, request.ID+extension)
+	case !strings.EqualFold(filepath.Ext(path), extension):
+		path += extension
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return "", ez.New(op, ez.EINTERNAL, "Error creating the file", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = c.DownloadCfdiFile(ctx, request, tmp)
+	if err != nil {
+		tmp.Close()
+		return "", ez.Wrap(op, err)
+	}
+
+	// Temporary files are created with mode 0600
+	err = tmp.Chmod(0o644)
+	if err != nil {
+		tmp.Close()
+		return "", ez.New(op, ez.EINTERNAL, "Error setting the file mode", err)
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return "", ez.New(op, ez.EINTERNAL, "Error writing the file", err)
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return "", ez.New(op, ez.EINTERNAL, "Error saving the file", err)
+	}
+
+	return path, nil
+}
+
+// decodeFileStream reads a FileViewModel JSON object, writing its decoded
+// Content to w as it is read. The other fields are decoded with
+// encoding/json once the object ends.
+func decodeFileStream(body io.Reader, w io.Writer) (models.FileViewModel, int64, error) {
+	const op = "multiemissor.decodeFileStream"
+
+	var file models.FileViewModel
+	var written int64
+
+	d := &fileDecoder{r: bufio.NewReader(body)}
+	output := &trackedWriter{w: w}
+
+	// The fields other than Content are collected in a smaller object
+	var fields bytes.Buffer
+	fields.WriteByte('{')
+
+	err := d.expect('{')
+	if err != nil {
+		return file, 0, d.error(op, err)
+	}
+
+	for first := true; ; first = false {
+		c, err := d.next()
+		if err != nil {
+			return file, written, d.error(op, err)
+		}
+		if c == '}' && first {
+			break
+		}
+		d.r.UnreadByte()
+
+		key, err := d.readValue()
+		if err != nil {
+			return file, written, d.error(op, err)
+		}
+		err = d.expect(':')
+		if err != nil {
+			return file, written, d.error(op, err)
+		}
+
+		var name string
+		if json.Unmarshal(key, &name) == nil && strings.EqualFold(name, "Content") {
+			written, err = d.readContent(output)
+			if err != nil {
+				switch {
+				case output.err != nil:
+					return file, written, ez.New(op, ez.EINTERNAL, "Error writing the file", err)
+				case errors.As(err, new(base64.CorruptInputError)):
+					return file, written, ez.New(op, ez.EINTERNAL, "The file content is not valid base64", err)
+				default:
+					return file, written, d.error(op, err)
+				}
+			}
+		} else {
+			value, err := d.readValue()
+			if err != nil {
+				return file, written, d.error(op, err)
+			}
+			if fields.Len() > 1 {
+				fields.WriteByte(',')
+			}
+			fields.Write(key)
+			fields.WriteByte(':')
+			fields.Write(value)
+		}
+
+		c, err = d.next()
+		if err != nil {
+			return file, written, d.error(op, err)
+		}
+		if c == '}' {
+			break
+		}
+		if c != ',' {
+			return file, written, d.error(op, errInvalidJSON)
+		}
+	}
+
+	fields.WriteByte('}')
+
+	err = json.Unmarshal(fields.Bytes(), &file)
+	if err != nil {
+		return file, written, ez.New(op, ez.EINTERNAL, "Error unmarshaling response", err)
+	}
+
+	return file, written, nil
+}
+
+// errInvalidJSON is a response that is not a JSON object
+var errInvalidJSON = errors.New("invalid JSON")
+
+// fileDecoder reads the tokens of a JSON object one byte at a time
+type fileDecoder struct {
+	r *bufio.Reader
+}
+
+// error returns the error of a response that could not be read: malformed
+// JSON is an internal error, a truncated response or a failure reading it
+// is a transport failure
+func (d *fileDecoder) error(op string, err error) error {
+	if errors.Is(err, errInvalidJSON) {
+		return ez.New(op, ez.EINTERNAL, "Error unmarshaling response", err)
+	}
+
+	return ez.New(op, ez.EUNAVAILABLE, "Error reading response body", err)
+}
+
+// next returns the next byte that is not whitespace
+func (d *fileDecoder) next() (byte, error) {
+	for {
+		c, err := d.r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+			return c, nil
+		}
+	}
+}
+
+// expect reads the next byte that is not whitespace, which must be c
+func (d *fileDecoder) expect(c byte) error {
+	next, err := d.next()
+	if err != nil {
+		return err
+	}
+	if next != c {
+		return errInvalidJSON
+	}
+
+	return nil
+}
+
+// readValue returns the raw bytes of the next value
+func (d *fileDecoder) readValue() ([]byte, error) {
+	c, err := d.next()
+	if err != nil {
+		return nil, err
+	}
+
+	value := []byte{c}
+	switch c {
+	case '"':
+		return d.readString(value)
+	case '{', '[':
+		depth := 1
+		for depth > 0 {
+			c, err = d.r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			value = append(value, c)
+			switch c {
+			case '"':
+				value, err = d.readString(value)
+				if err != nil {
+					return nil, err
+				}
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+			}
+		}
+		return value, nil
+	case '}', ']', ',', ':':
+		return nil, errInvalidJSON
+	}
+
+	// Numbers and literals end at a delimiter
+	for {
+		c, err = d.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if strings.IndexByte(",}] \t\n\r", c) >= 0 {
+			d.r.UnreadByte()
+			return value, nil
+		}
+		value = append(value, c)
+	}
+}
+
+// readString appends the rest of a string whose opening quote was read
+func (d *fileDecoder) readString(value []byte) ([]byte, error) {
+	for {
+		c, err := d.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		value = append(value, c)
+		switch c {
+		case '\\':
+			c, err = d.r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			value = append(value, c)
+		case '"':
+			return value, nil
+		}
+	}
+}
+
+// readContent decodes the base64 string value to w, a null value is empty
+func (d *fileDecoder) readContent(w io.Writer) (int64, error) {
+	c, err := d.next()
+	if err != nil {
+		return 0, err
+	}
+
+	switch c {
+	case '"':
+	case 'n':
+		d.r.UnreadByte()
+		value, err := d.readValue()
+		if err != nil {
+			return 0, err
+		}
+		if string(value) != "null" {
+			return 0, errInvalidJSON
+		}
+		return 0, nil
+	default:
+		return 0, errInvalidJSON
+	}
+
+	content := &stringReader{r: d.r}
+
+	written, err := utils.Base64ToWriter(content, w)
+	if err != nil {
+		return written, err
+	}
+
+	// The decoder may stop before the closing quote
+	_, err = io.Copy(io.Discard, content)
+
+	return written, err
+}
+
+// stringReader reads the unescaped content of a JSON string up to its
+// closing quote. Base64 only needs the ASCII escapes.
+type stringReader struct {
+	r    *bufio.Reader
+	done bool
+}
+
+func (s *stringReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if s.done {
+			break
+		}
+
+		c, err := s.r.ReadByte()
+		if err == io.EOF {
+			return n, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return n, err
+		}
+
+		switch c {
+		case '"':
+			s.done = true
+			continue
+		case '\\':
+			c, err = s.unescape()
+			if err != nil {
+				return n, err
+			}
+		}
+
+		p[n] = c
+		n++
+	}
+
+	if n == 0 && s.done {
+		return 0, io.EOF
+	}
+
+	return n, nil
+}
+
+// unescape reads an escape sequence after its backslash
+func (s *stringReader) unescape() (byte, error) {
+	c, err := s.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+
+	switch c {
+	case '"', '\\', '/':
+		return c, nil
+	case 'n':
+		return '\n', nil
+	case 'r':
+		return '\r', nil
+	case 't':
+		return '\t', nil
+	case 'u':
+		hex := make([]byte, 4)
+		_, err = io.ReadFull(s.r, hex)
+		if err != nil {
+			return 0, err
+		}
+		code, err := strconv.ParseUint(string(hex), 16, 16)
+		if err != nil || code >= 0x80 {
+			return 0, errInvalidJSON
+		}
+		return byte(code), nil
+	}
+
+	return 0, errInvalidJSON
+}
+
+// trackedWriter records the errors of the writer, to tell them apart from
+// the errors reading the response
+type trackedWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (t *trackedWriter) Write(p []byte) (int, error) {
+	n, err := t.w.Write(p)
+	if err != nil {
+		t.err = err
+	}
+
+	return n, err
+}
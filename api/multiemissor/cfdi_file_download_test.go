@@ -0,0 +1,177 @@
+package multiemissor
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vanclief/ez"
+	"github.com/vanclief/go-facturama/api/common"
+	"github.com/vanclief/go-facturama/api/facturamatest"
+	"github.com/vanclief/go-facturama/api/models"
+)
+
+func TestDownloadCfdiFile(t *testing.T) {
+	srv := facturamatest.NewServer()
+	defer srv.Close()
+
+	policy := common.DefaultRetryPolicy()
+	policy.InitialBackoff = time.Millisecond
+	client := NewClient(srv.Username, srv.Password, common.WithBaseURL(srv.URL), common.WithRetry(policy))
+	ctx := context.Background()
+
+	id := srv.AddCfdi(models.CfdiInfoModel{Issuer: models.TaxEntityInfoViewModel{Rfc: "EKU9003173C9"}})
+	request := GetCfdiFileRequest{ID: id, Format: "PDF", CfdiType: "issued"}
+
+	file, err := client.GetCfdiFile(ctx, request)
+	require.NoError(t, err)
+	content, err := base64.StdEncoding.DecodeString(file.Content)
+	require.NoError(t, err)
+
+	// The streamed file is the decoded content, transient failures before the
+	// response are retried
+	srv.InjectFailure(facturamatest.Failure{Method: http.MethodGet, StatusCode: http.StatusServiceUnavailable})
+
+	var buf bytes.Buffer
+	downloaded, err := client.DownloadCfdiFile(ctx, request, &buf)
+	require.NoError(t, err)
+	assert.Equal(t, content, buf.Bytes())
+	assert.Equal(t, "pdf", downloaded.ContentType)
+	assert.Equal(t, file.ContentLength, downloaded.ContentLength)
+	assert.Empty(t, downloaded.Content)
+
+	// Saving adds the extension, or uses the ID in a directory
+	dir := t.TempDir()
+
+	path, err := client.SaveCfdiFile(ctx, request, filepath.Join(dir, "factura"))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "factura.pdf"), path)
+	saved, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, content, saved)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o644), info.Mode().Perm())
+
+	path, err = client.SaveCfdiFile(ctx, request, dir)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, id+".pdf"), path)
+
+	// A failed download leaves no file
+	request.ID = "missing"
+	_, err = client.SaveCfdiFile(ctx, request, filepath.Join(dir, "missing.pdf"))
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	_, err = client.DownloadCfdiFile(ctx, GetCfdiFileRequest{ID: id, Format: "docx", CfdiType: "issued"}, &buf)
+	assert.Equal(t, ez.EINVALID, ez.ErrorCode(err))
+}
+
+func TestDownloadCfdiFileInterrupted(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"ContentType":"pdf","Content":"JVBER`))
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}))
+	defer srv.Close()
+
+	policy := common.DefaultRetryPolicy()
+	policy.InitialBackoff = time.Millisecond
+	client := NewClient("user", "pass", common.WithBaseURL(srv.URL), common.WithRetry(policy))
+
+	// A partly written file is not downloaded again, though the failure is transient
+	var buf bytes.Buffer
+	_, err := client.DownloadCfdiFile(context.Background(), GetCfdiFileRequest{ID: "1", Format: "pdf", CfdiType: "issued"}, &buf)
+	assert.Equal(t, ez.EUNAVAILABLE, ez.ErrorCode(err))
+	assert.Equal(t, int32(1), requests.Load())
+}
+
+func TestDownloadCfdiFileLength(t *testing.T) {
+	// lengths are the ContentLength of "hello", encoded as aGVsbG8=
+	var length int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"ContentType":"xml","ContentLength":%d,"Content":"aGVsbG8="}`, length)
+	}))
+	defer srv.Close()
+
+	client := NewClient("user", "pass", common.WithBaseURL(srv.URL))
+	request := GetCfdiFileRequest{ID: "1", Format: "xml", CfdiType: "issued"}
+
+	// The length of the file or of its base64 encoding
+	for _, length = range []int{0, 5, 8} {
+		var buf bytes.Buffer
+		_, err := client.DownloadCfdiFile(context.Background(), request, &buf)
+		require.NoError(t, err, length)
+		assert.Equal(t, "hello", buf.String())
+	}
+
+	length = 6
+	_, err := client.DownloadCfdiFile(context.Background(), request, io.Discard)
+	assert.Equal(t, ez.EINTERNAL, ez.ErrorCode(err))
+}
+
+// failingWriter fails every write
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestDecodeFileStream(t *testing.T) {
+	cases := map[string]struct {
+		body    string
+		content string
+		length  int
+		code    string
+	}{
+		"fields after content": {body: `{"Content":"aGVsbG8=","ContentType":"xml","ContentLength":5}`, content: "hello", length: 5},
+		"escaped content":      {body: " {\n \"ContentLength\": 3, \"Content\": \"\\/\\/\\/\\u002B\\r\\n\", \"Extra\": {\"a\": [1, \"}\"]}}", content: "\xff\xff\xfe", length: 3},
+		"null content":         {body: `{"Content":null,"ContentLength":0}`},
+		"empty object":         {body: `{}`},
+		"not an object":        {body: `[]`, code: ez.EINTERNAL},
+		"truncated":            {body: `{"Content":"aGVsbG8=`, code: ez.EUNAVAILABLE},
+		"invalid base64":       {body: `{"Content":"!!!!"}`, code: ez.EINTERNAL},
+		"missing comma":        {body: `{"Content":"" "ContentLength":0}`, code: ez.EINTERNAL},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			file, written, err := decodeFileStream(bytes.NewReader([]byte(tc.body)), &buf)
+			if tc.code != "" {
+				assert.Equal(t, tc.code, ez.ErrorCode(err))
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.content, buf.String())
+			assert.Equal(t, int64(len(tc.content)), written)
+			assert.Equal(t, tc.length, file.ContentLength)
+			assert.Empty(t, file.Content)
+		})
+	}
+
+	_, _, err := decodeFileStream(bytes.NewReader([]byte(`{"Content":"aGVsbG8="}`)), failingWriter{})
+	assert.Equal(t, ez.EINTERNAL, ez.ErrorCode(err))
+	assert.Contains(t, ez.ErrorMessage(err), "writing")
+}
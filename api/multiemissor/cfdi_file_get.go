@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"github.com/vanclief/ez"
+	"github.com/vanclief/go-facturama/api/common"
 	"github.com/vanclief/go-facturama/api/models"
 )
 
@@ -20,29 +21,28 @@ type GetCfdiFileRequest struct {
 func (request *GetCfdiFileRequest) Validate() error {
 	const op = "GetCfdiFileRequest.Validate"
 
+	var errs ValidationErrors
+
 	// Validate required parameters
 	if request.ID == "" {
-		return ez.New(op, ez.EINVALID, "CFDI ID is required", nil)
+		errs.Add("ID", CodeRequired, "CFDI ID is required", "El ID del CFDI es obligatorio")
 	}
 
 	// Validate format parameter
 	request.Format = strings.ToLower(request.Format)
-	if request.Format != "pdf" && request.Format != "html" && request.Format != "xml" {
-		return ez.New(op, ez.EINVALID, "Format must be one of: pdf, html, xml", nil)
-	}
+	errs.oneOf("Format", request.Format, "pdf", "html", "xml")
 
 	// Validate cfdiType parameter
-	if request.CfdiType != "payroll" && request.CfdiType != "received" && request.CfdiType != "issued" && request.CfdiType != "issuedLite" {
-		return ez.New(op, ez.EINVALID, "Type must be one of: payroll, received, issued, issuedLite", nil)
-	}
+	errs.oneOf("CfdiType", request.CfdiType, "payroll", "received", "issued", "issuedLite")
 
-	return nil
+	return errs.Err(op)
 }
 
 // GetCfdiFile retrieves a CFDI file in the specified format
 // Endpoint: GET /cfdi/{format}/{type}/{id}
 func (c *Client) GetCfdiFile(ctx context.Context, request GetCfdiFileRequest) (*models.FileViewModel, error) {
 	const op = "multiemissor.GetCfdiFile"
+	ctx = common.WithOp(ctx, op)
 
 	// Validate request
 	err := request.Validate()
@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	"github.com/vanclief/ez"
+	"github.com/vanclief/go-facturama/api/common"
 	"github.com/vanclief/go-facturama/api/models"
 )
 
@@ -17,18 +18,21 @@ type GetCfdiByIdRequest struct {
 func (request *GetCfdiByIdRequest) Validate() error {
 	const op = "GetCfdiByIdRequest.Validate"
 
+	var errs ValidationErrors
+
 	// Validate required parameters
 	if request.ID == "" {
-		return ez.New(op, ez.EINVALID, "CFDI ID is required", nil)
+		errs.Add("ID", CodeRequired, "CFDI ID is required", "El ID del CFDI es obligatorio")
 	}
 
-	return nil
+	return errs.Err(op)
 }
 
 // GetCfdiById retrieves the details of a CFDI (Mexican digital invoice) by its ID
 // Endpoint: GET /api-lite/cfdis/{id}
 func (c *Client) GetCfdiById(ctx context.Context, request GetCfdiByIdRequest) (*models.CfdiInfoModel, error) {
 	const op = "multiemissor.GetCfdiById"
+	ctx = common.WithOp(ctx, op)
 
 	// Validate request
 	err := request.Validate()
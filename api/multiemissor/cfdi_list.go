@@ -0,0 +1,161 @@
+package multiemissor
+
+import (
+	"context"
+	"iter"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/vanclief/ez"
+	"github.com/vanclief/go-facturama/api/common"
+	"github.com/vanclief/go-facturama/api/models"
+)
+
+// listCfdisDateLayout is the date format expected by the CFDI search filters
+const listCfdisDateLayout = "02/01/2006"
+
+// ListCfdisRequest represents a request to search issued CFDIs
+type ListCfdisRequest struct {
+	// Type of CFDI to search: issued, issuedLite, payroll or received. Defaults to issuedLite
+	Type string
+	// Keyword matches against folio, serie, RFC and names
+	Keyword string
+	// Status of the CFDIs: all, active or canceled. Defaults to all
+	Status string
+	// IssuerRfc filters by the RFC of the issuer
+	IssuerRfc string
+	// ReceiverRfc filters by the RFC of the receiver
+	ReceiverRfc string
+	// DateStart and DateEnd filter by the issue date (inclusive, day precision)
+	DateStart time.Time
+	DateEnd   time.Time
+	// FolioStart and FolioEnd filter by a folio range, use the same value for an exact folio
+	FolioStart string
+	FolioEnd   string
+	// Serie filters by the CFDI serie
+	Serie string
+	// Page is the zero based page to fetch
+	Page int
+}
+
+// Validate validates the request to search CFDIs
+func (request *ListCfdisRequest) Validate() error {
+	const op = "ListCfdisRequest.Validate"
+
+	var errs ValidationErrors
+
+	if request.Type == "" {
+		request.Type = "issuedLite"
+	}
+	errs.oneOf("Type", request.Type, "payroll", "received", "issued", "issuedLite")
+
+	if request.Status == "" {
+		request.Status = "all"
+	}
+	errs.oneOf("Status", request.Status, "all", "active", "canceled")
+
+	if !request.DateStart.IsZero() && !request.DateEnd.IsZero() && request.DateEnd.Before(request.DateStart) {
+		errs.Add("DateEnd", CodeInvalid, "DateEnd must not be before DateStart", "DateEnd no debe ser anterior a DateStart")
+	}
+
+	if request.Page < 0 {
+		errs.Add("Page", CodeInvalid, "Page must not be negative", "Page no debe ser negativo")
+	}
+
+	if request.IssuerRfc != "" {
+		errs.checkRFC("IssuerRfc", request.IssuerRfc, false)
+	}
+	if request.ReceiverRfc != "" {
+		errs.checkRFC("ReceiverRfc", request.ReceiverRfc, false)
+	}
+
+	return errs.Err(op)
+}
+
+// query builds the query string for the request
+func (request *ListCfdisRequest) query() url.Values {
+	query := url.Values{}
+	query.Set("type", request.Type)
+	query.Set("status", request.Status)
+	query.Set("page", strconv.Itoa(request.Page))
+
+	setIfNotEmpty := func(key, value string) {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+
+	setIfNotEmpty("keyword", request.Keyword)
+	setIfNotEmpty("rfcIssuer", request.IssuerRfc)
+	setIfNotEmpty("rfc", request.ReceiverRfc)
+	setIfNotEmpty("folioStart", request.FolioStart)
+	setIfNotEmpty("folioEnd", request.FolioEnd)
+	setIfNotEmpty("serie", request.Serie)
+
+	if !request.DateStart.IsZero() {
+		query.Set("dateStart", request.DateStart.Format(listCfdisDateLayout))
+	}
+	if !request.DateEnd.IsZero() {
+		query.Set("dateEnd", request.DateEnd.Format(listCfdisDateLayout))
+	}
+
+	return query
+}
+
+// ListCfdis retrieves a single page of CFDIs matching the request filters
+// Endpoint: GET /api-lite/cfdis?type={type}&keyword={keyword}&status={status}&...&page={page}
+func (c *Client) ListCfdis(ctx context.Context, request ListCfdisRequest) ([]models.CfdiInfoModel, error) {
+	const op = "multiemissor.ListCfdis"
+	ctx = common.WithOp(ctx, op)
+
+	err := request.Validate()
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	path := "/api-lite/cfdis?" + request.query().Encode()
+	var response []models.CfdiSearchViewModel
+
+	err = c.Get(ctx, path, &response)
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	cfdis := make([]models.CfdiInfoModel, 0, len(response))
+	for i := range response {
+		cfdis = append(cfdis, response[i].ToCfdiInfo())
+	}
+
+	return cfdis, nil
+}
+
+// AllCfdis returns an iterator over every CFDI matching the request filters,
+// starting at request.Page and fetching the following pages on demand until
+// an empty page is returned. Iteration stops after the first error is yielded.
+func (c *Client) AllCfdis(ctx context.Context, request ListCfdisRequest) iter.Seq2[models.CfdiInfoModel, error] {
+	const op = "multiemissor.AllCfdis"
+
+	return func(yield func(models.CfdiInfoModel, error) bool) {
+		for page := request.Page; ; page++ {
+			pageRequest := request
+			pageRequest.Page = page
+
+			cfdis, err := c.ListCfdis(ctx, pageRequest)
+			if err != nil {
+				yield(models.CfdiInfoModel{}, ez.Wrap(op, err))
+				return
+			}
+
+			if len(cfdis) == 0 {
+				return
+			}
+
+			for _, cfdi := range cfdis {
+				if !yield(cfdi, nil) {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package multiemissor
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/vanclief/ez"
+	"github.com/vanclief/go-facturama/api/common"
+	"github.com/vanclief/go-facturama/api/models"
+	"github.com/vanclief/go-facturama/cfdixml"
+	"github.com/vanclief/go-facturama/rfc"
+)
+
+// MotiveWithRelation is the cancellation motive of a CFDI issued with errors
+// that is replaced by another one
+const MotiveWithRelation = "01"
+
+// ReplaceCfdiRequest represents a request to replace a CFDI
+type ReplaceCfdiRequest struct {
+	// ID of the CFDI to replace
+	ID string
+	// Replacement is the corrected CFDI. Its 04 relation to the original is
+	// added by ReplaceCfdi.
+	Replacement CreateCfdiV4Request
+}
+
+// Validate validates the request to replace a CFDI
+func (request *ReplaceCfdiRequest) Validate() error {
+	const op = "ReplaceCfdiRequest.Validate"
+
+	var errs ValidationErrors
+
+	errs.required("ID", request.ID)
+
+	if relations := request.Replacement.Relations; relations != nil && relations.Type != RelationSubstitution {
+		errs.Add("Replacement.Relations.Type", CodeIncompatible,
+			fmt.Sprintf("Replacement.Relations.Type must be %s for a substitution", RelationSubstitution),
+			fmt.Sprintf("Replacement.Relations.Type debe ser %s para una sustitución", RelationSubstitution))
+	}
+
+	err := errs.Err(op)
+	if err != nil {
+		return err
+	}
+
+	err = request.Replacement.Validate()
+	if err != nil {
+		return ez.Wrap(op, err)
+	}
+
+	return nil
+}
+
+// ReplacementState is how far a replacement went
+type ReplacementState string
+
+const (
+	// ReplacementFailed is a replacement that changed nothing: the original
+	// could not be loaded or the replacement was not stamped
+	ReplacementFailed ReplacementState = "failed"
+	// ReplacementUnknown is a replacement that may have been stamped: the
+	// create request failed after it could have been processed and the
+	// replacement could not be looked up. Call ReplaceCfdi again to resume it.
+	ReplacementUnknown ReplacementState = "unknown"
+	// ReplacementStamped is a replacement that was stamped but the original is
+	// still active. Cancel it with CancelRequest to recover.
+	ReplacementStamped ReplacementState = "stamped"
+	// ReplacementPending is a replacement whose original cancellation waits for
+	// the receiver acceptance
+	ReplacementPending ReplacementState = "pending"
+	// ReplacementCompleted is a replacement whose original is cancelled
+	ReplacementCompleted ReplacementState = "completed"
+)
+
+// ReplaceCfdiResult holds the CFDIs involved in a replacement and how far it went
+type ReplaceCfdiResult struct {
+	State        ReplacementState
+	Original     *models.CfdiInfoModel
+	Replacement  *models.CfdiInfoModel
+	Cancellation *models.CancelationStatusLite
+}
+
+// CancelRequest returns the request that cancels the original referencing the
+// replacement, to finish a ReplacementStamped replacement by hand
+func (result *ReplaceCfdiResult) CancelRequest() CancelCfdiRequest {
+	request := CancelCfdiRequest{Motive: MotiveWithRelation}
+	if result.Original != nil {
+		request.ID = result.Original.ID
+	}
+	if result.Replacement != nil {
+		request.UUIDReplacement = result.Replacement.Complement.TaxStamp.UUID
+	}
+
+	return request
+}
+
+// ReplaceCfdi replaces a CFDI issued with errors: it stamps the replacement
+// with a 04 relation to the original and cancels the original with motive 01
+// referencing the replacement.
+//
+// The steps are not atomic. The returned result is never nil and its State
+// tells what must be recovered when an error is returned: a
+// ReplacementStamped result has a valid replacement and an active original.
+// Calling ReplaceCfdi again with the same request resumes a replacement that
+// was stamped: an active CFDI of the issuer with the Serie and Folio of the
+// replacement and a 04 relation to the original is taken as the replacement
+// instead of stamping another one.
+func (c *Client) ReplaceCfdi(ctx context.Context, request ReplaceCfdiRequest) (*ReplaceCfdiResult, error) {
+	const op = "multiemissor.ReplaceCfdi"
+	ctx = common.WithOp(ctx, op)
+
+	result := &ReplaceCfdiResult{State: ReplacementFailed}
+
+	// Validate request
+	err := request.Validate()
+	if err != nil {
+		return result, ez.Wrap(op, err)
+	}
+
+	original, err := c.GetCfdiById(ctx, GetCfdiByIdRequest{ID: request.ID})
+	if err != nil {
+		return result, ez.Wrap(op, err)
+	}
+	result.Original = original
+
+	uuid := original.Complement.TaxStamp.UUID
+	if uuid == "" {
+		msg := fmt.Sprintf("The CFDI %s has no UUID", request.ID)
+		return result, ez.New(op, ez.EINVALID, msg, nil)
+	}
+
+	if state := stateFromStatus(original.Status, StateActive); state != StateActive {
+		msg := fmt.Sprintf("The CFDI %s cannot be replaced, it is %s", request.ID, state)
+		return result, ez.New(op, ez.ECONFLICT, msg, nil)
+	}
+
+	replacement := request.Replacement
+	if rfc.Normalize(replacement.Issuer.Rfc) != rfc.Normalize(original.Issuer.Rfc) {
+		msg := fmt.Sprintf("The replacement issuer %s must be the issuer of the CFDI %s", replacement.Issuer.Rfc, request.ID)
+		return result, ez.New(op, ez.EINVALID, msg, nil)
+	}
+	replacement.Relations = substitutionRelations(replacement.Relations, uuid)
+
+	// A previous call may have stamped the replacement before failing. The
+	// replacement may keep the Serie and Folio of the original, so only a CFDI
+	// that substitutes the original is taken.
+	accept := c.substitutes(original)
+	stamped, err := c.findStamped(ctx, replacement, accept)
+	if err != nil {
+		return result, ez.Wrap(op, err)
+	}
+
+	if stamped == nil {
+		stamped, err = c.createCfdi(ctx, replacement, accept)
+		if err != nil && common.IsRetryable(err) {
+			// The replacement may have been stamped before the failure
+			found, lookupErr := c.findStamped(ctx, replacement, accept)
+			if lookupErr != nil {
+				result.State = ReplacementUnknown
+				return result, ez.Wrap(op, err)
+			}
+			if found != nil {
+				stamped, err = found, nil
+			}
+		}
+		if err != nil {
+			return result, ez.Wrap(op, err)
+		}
+	}
+	result.Replacement = stamped
+	result.State = ReplacementStamped
+
+	status, err := c.CancelCfdi(ctx, result.CancelRequest())
+	if err != nil {
+		msg := fmt.Sprintf("The replacement %s was stamped but the CFDI %s was not cancelled, cancel it with motive %s and UUID replacement %s",
+			stamped.ID, request.ID, MotiveWithRelation, stamped.Complement.TaxStamp.UUID)
+		return result, ez.New(op, ez.ErrorCode(err), msg, err)
+	}
+	result.Cancellation = status
+
+	result.State = ReplacementCompleted
+	if stateFromStatus(status.Status, StateCancelled) == StateCancelRequested {
+		result.State = ReplacementPending
+	}
+
+	return result, nil
+}
+
+// substitutes returns an acceptFunc that takes the CFDIs, other than the
+// original, whose xml has a 04 relation to the original UUID
+func (c *Client) substitutes(original *models.CfdiInfoModel) acceptFunc {
+	uuid := original.Complement.TaxStamp.UUID
+
+	return func(ctx context.Context, cfdi *models.CfdiInfoModel) (bool, error) {
+		const op = "multiemissor.substitutes"
+
+		if cfdi.ID == original.ID || strings.EqualFold(cfdi.Complement.TaxStamp.UUID, uuid) {
+			return false, nil
+		}
+
+		file, err := c.GetCfdiFile(ctx, GetCfdiFileRequest{Format: "xml", CfdiType: "issuedLite", ID: cfdi.ID})
+		if err != nil {
+			return false, ez.Wrap(op, err)
+		}
+
+		data, err := base64.StdEncoding.DecodeString(file.Content)
+		if err != nil {
+			return false, ez.New(op, ez.EINTERNAL, "The CFDI xml is not valid base64", err)
+		}
+
+		comprobante, err := cfdixml.Parse(data)
+		if err != nil {
+			return false, ez.Wrap(op, err)
+		}
+
+		for _, relacionados := range comprobante.CfdiRelacionados {
+			if relacionados.TipoRelacion != RelationSubstitution {
+				continue
+			}
+			for _, relacionado := range relacionados.CfdiRelacionado {
+				if strings.EqualFold(relacionado.UUID, uuid) {
+					return true, nil
+				}
+			}
+		}
+
+		return false, nil
+	}
+}
+
+// substitutionRelations returns the 04 relations to the original UUID,
+// keeping the UUIDs already related without modifying them
+func substitutionRelations(relations *models.Cfdiv4Relations, uuid string) *models.Cfdiv4Relations {
+	substitution := &models.Cfdiv4Relations{Type: RelationSubstitution}
+	if relations != nil {
+		substitution.Cfdis = append(substitution.Cfdis, relations.Cfdis...)
+	}
+
+	for _, related := range substitution.Cfdis {
+		if strings.EqualFold(related.Uuid, uuid) {
+			return substitution
+		}
+	}
+	substitution.Cfdis = append(substitution.Cfdis, models.CfdiUuidID{Uuid: uuid})
+
+	return substitution
+}
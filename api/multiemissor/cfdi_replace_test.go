@@ -0,0 +1,210 @@
+package multiemissor
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vanclief/ez"
+	"github.com/vanclief/go-facturama/api/common"
+	"github.com/vanclief/go-facturama/api/facturamatest"
+	"github.com/vanclief/go-facturama/api/models"
+)
+
+func TestReplaceCfdi(t *testing.T) {
+	srv := facturamatest.NewServer(facturamatest.WithCSD(models.TaxEntityCSD{RFC: "EKU9003173C9"}))
+	defer srv.Close()
+
+	client := NewClient(srv.Username, srv.Password, common.WithBaseURL(srv.URL))
+	ctx := context.Background()
+
+	issued := func() string {
+		return srv.AddCfdi(models.CfdiInfoModel{Issuer: models.TaxEntityInfoViewModel{Rfc: "EKU9003173C9"}})
+	}
+
+	// The replacement references the original and the original is cancelled
+	original := issued()
+	replacement := validCfdiRequest()
+	replacement.Folio = "200"
+
+	result, err := client.ReplaceCfdi(ctx, ReplaceCfdiRequest{ID: original, Replacement: replacement})
+	require.NoError(t, err)
+	assert.Equal(t, ReplacementCompleted, result.State)
+	assert.Equal(t, original, result.Original.ID)
+	assert.NotEmpty(t, result.Replacement.Complement.TaxStamp.UUID)
+	assert.NotNil(t, result.Cancellation)
+	assert.Nil(t, replacement.Relations)
+
+	status, _ := srv.CfdiStatus(original)
+	assert.Equal(t, facturamatest.StatusCanceled, status)
+
+	// The receiver must accept the cancellation
+	srv.CancelStatus = facturamatest.CancelStatusPending
+	replacement.Folio = "201"
+
+	result, err = client.ReplaceCfdi(ctx, ReplaceCfdiRequest{ID: issued(), Replacement: replacement})
+	require.NoError(t, err)
+	assert.Equal(t, ReplacementPending, result.State)
+	srv.CancelStatus = "Ok"
+
+	// The cancellation fails after stamping, the original is recovered by hand
+	original = issued()
+	replacement.Folio = "202"
+	srv.InjectFailure(facturamatest.Failure{Method: http.MethodDelete, StatusCode: http.StatusServiceUnavailable})
+
+	result, err = client.ReplaceCfdi(ctx, ReplaceCfdiRequest{ID: original, Replacement: replacement})
+	require.Error(t, err)
+	assert.Equal(t, ez.EUNAVAILABLE, ez.ErrorCode(err))
+	assert.Equal(t, ReplacementStamped, result.State)
+	require.NotNil(t, result.Replacement)
+
+	assert.Equal(t, result.Replacement.Complement.TaxStamp.UUID, result.CancelRequest().UUIDReplacement)
+
+	status, _ = srv.CfdiStatus(original)
+	assert.Equal(t, facturamatest.StatusActive, status)
+
+	// Calling it again resumes the replacement instead of stamping another one
+	creates := srv.RequestCount(http.MethodPost, "/api-lite/3/cfdis")
+	resumed, err := client.ReplaceCfdi(ctx, ReplaceCfdiRequest{ID: original, Replacement: replacement})
+	require.NoError(t, err)
+	assert.Equal(t, ReplacementCompleted, resumed.State)
+	assert.Equal(t, result.Replacement.ID, resumed.Replacement.ID)
+	assert.Equal(t, creates, srv.RequestCount(http.MethodPost, "/api-lite/3/cfdis"))
+
+	status, _ = srv.CfdiStatus(original)
+	assert.Equal(t, facturamatest.StatusCanceled, status)
+
+	// The replacement keeps the Serie and Folio of the original: the original
+	// is not taken as a stamped replacement
+	original = srv.AddCfdi(models.CfdiInfoModel{Serie: replacement.Serie, Folio: "400", Issuer: models.TaxEntityInfoViewModel{Rfc: "EKU9003173C9"}})
+	same := replacement
+	same.Folio = "400"
+	srv.InjectFailure(facturamatest.Failure{Method: http.MethodDelete, StatusCode: http.StatusServiceUnavailable})
+
+	creates = srv.RequestCount(http.MethodPost, "/api-lite/3/cfdis")
+	result, err = client.ReplaceCfdi(ctx, ReplaceCfdiRequest{ID: original, Replacement: same})
+	require.Error(t, err)
+	assert.Equal(t, ReplacementStamped, result.State)
+	assert.NotEqual(t, original, result.Replacement.ID)
+	assert.Equal(t, creates+1, srv.RequestCount(http.MethodPost, "/api-lite/3/cfdis"))
+
+	// Resuming it takes the CFDI that substitutes the original
+	resumed, err = client.ReplaceCfdi(ctx, ReplaceCfdiRequest{ID: original, Replacement: same})
+	require.NoError(t, err)
+	assert.Equal(t, ReplacementCompleted, resumed.State)
+	assert.Equal(t, result.Replacement.ID, resumed.Replacement.ID)
+	assert.NotEqual(t, resumed.Original.Complement.TaxStamp.UUID, resumed.CancelRequest().UUIDReplacement)
+	assert.Equal(t, creates+1, srv.RequestCount(http.MethodPost, "/api-lite/3/cfdis"))
+
+	status, _ = srv.CfdiStatus(original)
+	assert.Equal(t, facturamatest.StatusCanceled, status)
+
+	// A cancelled CFDI is not replaced
+	creates = srv.RequestCount(http.MethodPost, "/api-lite/3/cfdis")
+	result, err = client.ReplaceCfdi(ctx, ReplaceCfdiRequest{ID: original, Replacement: replacement})
+	assert.Equal(t, ez.ECONFLICT, ez.ErrorCode(err))
+	assert.Equal(t, ReplacementFailed, result.State)
+	assert.Equal(t, creates, srv.RequestCount(http.MethodPost, "/api-lite/3/cfdis"))
+
+	// The replacement must have the same issuer
+	other := replacement
+	other.Issuer.Rfc = "URE180429TM6"
+	_, err = client.ReplaceCfdi(ctx, ReplaceCfdiRequest{ID: issued(), Replacement: other})
+	assert.Equal(t, ez.EINVALID, ez.ErrorCode(err))
+
+	// Only substitution relations are allowed
+	other = replacement
+	other.Relations = &models.Cfdiv4Relations{Type: "07"}
+	_, err = client.ReplaceCfdi(ctx, ReplaceCfdiRequest{ID: issued(), Replacement: other})
+	verrs, ok := AsValidationErrors(err)
+	require.True(t, ok)
+	assert.True(t, verrs.Has("Replacement.Relations.Type"))
+}
+
+func TestReplaceCfdiLostResponse(t *testing.T) {
+	srv := facturamatest.NewServer(facturamatest.WithCSD(models.TaxEntityCSD{RFC: "EKU9003173C9"}))
+	defer srv.Close()
+
+	// failLookups makes the CFDI searches fail once a CFDI was posted
+	var posted, failLookups bool
+	lookups := func(next common.Handler) common.Handler {
+		return func(ctx context.Context, req *common.Request) (*common.Response, error) {
+			if req.Method == http.MethodPost {
+				posted = true
+			}
+			if failLookups && posted && strings.HasPrefix(req.Path, "/api-lite/cfdis?") {
+				return nil, ez.New("test", ez.EUNAVAILABLE, "Lookup failed", nil)
+			}
+			return next(ctx, req)
+		}
+	}
+
+	client := NewClient(srv.Username, srv.Password, common.WithBaseURL(srv.URL), common.WithMiddleware(lookups))
+	ctx := context.Background()
+	path := "/api-lite/3/cfdis"
+
+	issued := func() string {
+		return srv.AddCfdi(models.CfdiInfoModel{Issuer: models.TaxEntityInfoViewModel{Rfc: "EKU9003173C9"}})
+	}
+
+	// The replacement was stamped but the response was lost: it is found and
+	// the original is cancelled
+	original := issued()
+	replacement := validCfdiRequest()
+	replacement.Folio = "300"
+	srv.InjectFailure(facturamatest.Failure{Method: http.MethodPost, Path: path, Handle: true, Drop: true})
+
+	result, err := client.ReplaceCfdi(ctx, ReplaceCfdiRequest{ID: original, Replacement: replacement})
+	require.NoError(t, err)
+	assert.Equal(t, ReplacementCompleted, result.State)
+	assert.Equal(t, "300", result.Replacement.Folio)
+	assert.Equal(t, 1, srv.RequestCount(http.MethodPost, path))
+
+	status, _ := srv.CfdiStatus(original)
+	assert.Equal(t, facturamatest.StatusCanceled, status)
+
+	// The replacement cannot be looked up: it may have been stamped
+	original = issued()
+	replacement.Folio = "301"
+	posted, failLookups = false, true
+	srv.InjectFailure(facturamatest.Failure{Method: http.MethodPost, Path: path, Handle: true, Drop: true})
+
+	result, err = client.ReplaceCfdi(ctx, ReplaceCfdiRequest{ID: original, Replacement: replacement})
+	assert.True(t, common.IsUnavailable(err))
+	assert.Equal(t, ReplacementUnknown, result.State)
+	assert.Nil(t, result.Replacement)
+
+	// Calling it again resumes the replacement
+	failLookups = false
+	result, err = client.ReplaceCfdi(ctx, ReplaceCfdiRequest{ID: original, Replacement: replacement})
+	require.NoError(t, err)
+	assert.Equal(t, ReplacementCompleted, result.State)
+	assert.Equal(t, "301", result.Replacement.Folio)
+	assert.Equal(t, 2, srv.RequestCount(http.MethodPost, path))
+
+	// The replacement was not stamped: nothing changed
+	original = issued()
+	replacement.Folio = "302"
+	srv.InjectFailure(facturamatest.Failure{Method: http.MethodPost, Path: path, Drop: true})
+
+	result, err = client.ReplaceCfdi(ctx, ReplaceCfdiRequest{ID: original, Replacement: replacement})
+	assert.True(t, common.IsUnavailable(err))
+	assert.Equal(t, ReplacementFailed, result.State)
+
+	status, _ = srv.CfdiStatus(original)
+	assert.Equal(t, facturamatest.StatusActive, status)
+}
+
+func TestSubstitutionRelations(t *testing.T) {
+	relations := &models.Cfdiv4Relations{Type: RelationSubstitution, Cfdis: []models.CfdiUuidID{{Uuid: "A"}}}
+
+	merged := substitutionRelations(relations, "B")
+	assert.Equal(t, []models.CfdiUuidID{{Uuid: "A"}, {Uuid: "B"}}, merged.Cfdis)
+	assert.Len(t, relations.Cfdis, 1)
+
+	assert.Equal(t, []models.CfdiUuidID{{Uuid: "A"}}, substitutionRelations(relations, "a").Cfdis)
+	assert.Equal(t, []models.CfdiUuidID{{Uuid: "B"}}, substitutionRelations(nil, "B").Cfdis)
+}
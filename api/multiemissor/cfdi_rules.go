@@ -0,0 +1,164 @@
+package multiemissor
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/vanclief/go-facturama/catalogs"
+	"github.com/vanclief/go-facturama/rfc"
+)
+
+// Receiver values required by the SAT for generic RFCs and global invoices
+const (
+	// GeneralPublicName is the receiver name of global invoices (público en general)
+	GeneralPublicName = "PUBLICO EN GENERAL"
+	// GenericRegime is the fiscal regime of the generic RFCs (Sin obligaciones fiscales)
+	GenericRegime = "616"
+	// NoTaxEffectsUse is the CFDI use of the generic RFCs (Sin efectos fiscales)
+	NoTaxEffectsUse = "S01"
+	// PaymentsUse is the CFDI use of payment CFDIs (type P)
+	PaymentsUse = "CP01"
+	// PayrollUse is the CFDI use of payroll CFDIs (type N)
+	PayrollUse = "CN01"
+)
+
+const (
+	// bimonthlyPeriodicity (Bimestral) is only allowed for the Incorporación Fiscal regime
+	bimonthlyPeriodicity = "05"
+	// incorporationRegime is Régimen de Incorporación Fiscal
+	incorporationRegime = "621"
+)
+
+// validateCompatibility checks the SAT rules relating the RFCs, the fiscal
+// regimes and the CFDI use, and the rules of global invoices. Rules whose
+// inputs are missing or invalid are skipped, those are reported elsewhere.
+func (request *CreateCfdiV4Request) validateCompatibility(errs *ValidationErrors) {
+	issuer, issuerErr := rfc.Parse(request.Issuer.Rfc)
+	receiver, receiverErr := rfc.Parse(request.Receiver.Rfc)
+
+	if issuerErr == nil {
+		validateRegimeForRFC(errs, "Issuer.FiscalRegime", request.Issuer.FiscalRegime, issuer)
+	}
+	if receiverErr == nil {
+		validateRegimeForRFC(errs, "Receiver.FiscalRegime", request.Receiver.FiscalRegime, receiver)
+	}
+
+	// CFDI use vs receiver RFC type and regime
+	use := request.Receiver.CfdiUse
+	regime := request.Receiver.FiscalRegime
+	if entry, ok := catalogs.Lookup(catalogs.UsoCFDI, use); ok {
+		if receiverErr == nil && !entry.Flag(string(receiver.Type)) {
+			errs.Add("Receiver.CfdiUse", CodeIncompatible,
+				fmt.Sprintf("Receiver.CfdiUse %s is not allowed for a persona %s", use, receiver.Type),
+				fmt.Sprintf("Receiver.CfdiUse %s no aplica para una persona %s", use, receiver.Type))
+		}
+		if catalogs.IsValid(catalogs.RegimenFiscal, regime) && !slices.Contains(entry.List("regimenes"), regime) {
+			errs.Add("Receiver.CfdiUse", CodeIncompatible,
+				fmt.Sprintf("Receiver.CfdiUse %s is not allowed for the fiscal regime %s", use, regime),
+				fmt.Sprintf("Receiver.CfdiUse %s no aplica para el régimen fiscal %s", use, regime))
+		}
+	}
+
+	switch {
+	case request.CfdiType == "P" && use != PaymentsUse:
+		errs.Add("Receiver.CfdiUse", CodeIncompatible,
+			"Receiver.CfdiUse must be CP01 for payment CFDIs",
+			"Receiver.CfdiUse debe ser CP01 para los CFDI de pago")
+	case request.CfdiType == "N" && use != PayrollUse:
+		errs.Add("Receiver.CfdiUse", CodeIncompatible,
+			"Receiver.CfdiUse must be CN01 for payroll CFDIs",
+			"Receiver.CfdiUse debe ser CN01 para los CFDI de nómina")
+	}
+
+	if receiverErr == nil && receiver.IsGeneric() {
+		request.validateGenericReceiver(errs)
+	}
+
+	if receiverErr == nil {
+		request.validateGlobalInformation(errs, receiver)
+	}
+}
+
+// validateRegimeForRFC checks that a fiscal regime applies to the kind of
+// taxpayer of the RFC. Generic RFCs are personas físicas.
+func validateRegimeForRFC(errs *ValidationErrors, field, regime string, taxpayer rfc.RFC) {
+	entry, ok := catalogs.Lookup(catalogs.RegimenFiscal, regime)
+	if !ok {
+		return
+	}
+
+	if !entry.Flag(string(taxpayer.Type)) {
+		errs.Add(field, CodeIncompatible,
+			fmt.Sprintf("%s %s is not allowed for a persona %s RFC", field, regime, taxpayer.Type),
+			fmt.Sprintf("%s %s no aplica para el RFC de una persona %s", field, regime, taxpayer.Type))
+	}
+}
+
+// validateGenericReceiver checks the rules for XAXX010101000 and XEXX010101000
+// receivers: regime 616, CFDI use S01 (CP01 for payments) and the expedition
+// place as the tax zip code
+func (request *CreateCfdiV4Request) validateGenericReceiver(errs *ValidationErrors) {
+	if request.Receiver.FiscalRegime != GenericRegime {
+		errs.Add("Receiver.FiscalRegime", CodeIncompatible,
+			"Receiver.FiscalRegime must be 616 for a generic RFC",
+			"Receiver.FiscalRegime debe ser 616 para un RFC genérico")
+	}
+
+	if request.Receiver.CfdiUse != NoTaxEffectsUse && request.Receiver.CfdiUse != PaymentsUse {
+		errs.Add("Receiver.CfdiUse", CodeIncompatible,
+			"Receiver.CfdiUse must be S01, or CP01 for payments, for a generic RFC",
+			"Receiver.CfdiUse debe ser S01, o CP01 para pagos, para un RFC genérico")
+	}
+
+	if request.Receiver.TaxZipCode != request.ExpeditionPlace {
+		errs.Add("Receiver.TaxZipCode", CodeIncompatible,
+			"Receiver.TaxZipCode must be the ExpeditionPlace for a generic RFC",
+			"Receiver.TaxZipCode debe ser igual a ExpeditionPlace para un RFC genérico")
+	}
+}
+
+// validateGlobalInformation checks the rules of global invoices: they are
+// issued to XAXX010101000 with the name PUBLICO EN GENERAL, which in turn
+// requires GlobalInformation, and the bimonthly periodicity is reserved to
+// the Incorporación Fiscal regime
+func (request *CreateCfdiV4Request) validateGlobalInformation(errs *ValidationErrors, receiver rfc.RFC) {
+	generalPublic := receiver.Value == rfc.GenericNational && strings.TrimSpace(request.Receiver.Name) == GeneralPublicName
+
+	global := request.GlobalInformation
+	if global == nil {
+		if generalPublic {
+			errs.Add("GlobalInformation", CodeRequired,
+				"GlobalInformation is required for invoices to PUBLICO EN GENERAL",
+				"GlobalInformation es obligatorio para las facturas a PUBLICO EN GENERAL")
+		}
+
+		return
+	}
+
+	if !generalPublic {
+		errs.Add("GlobalInformation", CodeIncompatible,
+			"GlobalInformation requires Receiver.Rfc XAXX010101000 and Receiver.Name PUBLICO EN GENERAL",
+			"GlobalInformation requiere Receiver.Rfc XAXX010101000 y Receiver.Name PUBLICO EN GENERAL")
+	}
+
+	if global.Periodicity == bimonthlyPeriodicity && request.Issuer.FiscalRegime != incorporationRegime {
+		errs.Add("GlobalInformation.Periodicity", CodeIncompatible,
+			"GlobalInformation.Periodicity 05 is only allowed for the fiscal regime 621",
+			"GlobalInformation.Periodicity 05 solo aplica para el régimen fiscal 621")
+	}
+
+	// Months 13 to 18 are the bimonthly periods
+	bimonthly := global.Months >= "13" && global.Months <= "18"
+	if bimonthly != (global.Periodicity == bimonthlyPeriodicity) {
+		errs.Add("GlobalInformation.Months", CodeIncompatible,
+			"GlobalInformation.Months 13 to 18 must be used with, and only with, Periodicity 05",
+			"GlobalInformation.Months 13 a 18 se deben usar con, y solo con, Periodicity 05")
+	}
+
+	if global.Year < 2022 {
+		errs.Add("GlobalInformation.Year", CodeInvalid,
+			"GlobalInformation.Year must be 2022 or later",
+			"GlobalInformation.Year debe ser 2022 o posterior")
+	}
+}
@@ -0,0 +1,387 @@
+package multiemissor
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/vanclief/ez"
+	"github.com/vanclief/go-facturama/api/models"
+	"github.com/vanclief/go-facturama/cfdixml"
+	"github.com/vanclief/go-facturama/csd"
+	"github.com/vanclief/go-facturama/decimal"
+)
+
+// ExportationNone is the default Exportacion (c_Exportacion): not an export
+const ExportationNone = "01"
+
+// taxCodes maps the tax names to their codes in c_Impuesto
+var taxCodes = map[string]string{
+	TaxISR:       "001",
+	TaxIVA:       "002",
+	TaxIVAExempt: "002",
+	TaxIEPS:      "003",
+}
+
+// Tax factors (c_TipoFactor)
+const (
+	factorRate   = "Tasa"
+	factorQuota  = "Cuota"
+	factorExempt = "Exento"
+)
+
+// SignedCfdi is a CFDI sealed locally, before it is stamped by a PAC
+type SignedCfdi struct {
+	Comprobante *cfdixml.Comprobante
+	// XML is the serialized Comprobante
+	XML []byte
+	// CadenaOriginal is the string the Sello signs
+	CadenaOriginal string
+	// Summary are the amounts calculated from the items
+	Summary *CfdiSummary
+}
+
+// Signer seals CFDIs with a CSD loaded locally, without the Facturama API
+type Signer struct {
+	credential *csd.Credential
+	now        func() time.Time
+}
+
+// NewSigner creates a signer that seals with the credential
+func NewSigner(credential *csd.Credential) (*Signer, error) {
+	const op = "multiemissor.NewSigner"
+
+	if credential == nil || credential.Certificate == nil || credential.PrivateKey == nil {
+		return nil, ez.New(op, ez.EINVALID, "A certificate and its private key are required", nil)
+	}
+
+	if !credential.KeyMatches() {
+		return nil, ez.New(op, ez.EINVALID, "The private key does not belong to the certificate", nil)
+	}
+
+	return &Signer{credential: credential, now: time.Now}, nil
+}
+
+// Sign builds the CFDI 4.0 XML of a request and seals it: the amounts and
+// taxes are calculated as by Calculate, NoCertificado and Certificado are
+// taken from the CSD and the Sello is the SHA256withRSA signature of the
+// cadena original. The request is not modified.
+//
+// The Fecha is the request Date, or the current time in central Mexico when
+// empty, and the certificate must be valid at that time and belong to the
+// issuer. Issuer.Name is required because the Facturama profile that fills
+// it is not available. Complements are not supported.
+func (s *Signer) Sign(request CreateCfdiV4Request) (*SignedCfdi, error) {
+	const op = "multiemissor.Signer.Sign"
+
+	err := request.Validate()
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	if request.Issuer.Name == "" {
+		return nil, ez.New(op, ez.EINVALID, "Issuer.Name is required to seal a CFDI locally", nil)
+	}
+
+	err = checkSignComplements(request)
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	fecha := s.now().In(cfdixml.MexicoCentral)
+	if request.Date != "" {
+		var ok bool
+		fecha, ok = parseCfdiDate(request.Date)
+		if !ok {
+			return nil, ez.New(op, ez.EINVALID, fmt.Sprintf("Date %q is not a valid CFDI date", request.Date), nil)
+		}
+		fecha = fecha.In(cfdixml.MexicoCentral)
+	}
+
+	err = s.credential.Verify(request.Issuer.Rfc, fecha)
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	// Calculate fills the items, which are copied so the request is not modified
+	request.Items = cloneItems(request.Items)
+
+	summary, err := request.Calculate()
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	c := buildComprobante(request, summary, fecha.Format(cfdixml.DateLayout))
+	c.NoCertificado = s.credential.Certificate.Number
+	c.Certificado = base64.StdEncoding.EncodeToString(s.credential.Certificate.Raw.Raw)
+
+	cadenaOriginal, err := c.CadenaOriginal()
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	digest := sha256.Sum256([]byte(cadenaOriginal))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.credential.PrivateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return nil, ez.New(op, ez.EINTERNAL, "The CFDI could not be sealed", err)
+	}
+	c.Sello = base64.StdEncoding.EncodeToString(signature)
+
+	data, err := cfdixml.Marshal(c)
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	return &SignedCfdi{Comprobante: c, XML: data, CadenaOriginal: cadenaOriginal, Summary: summary}, nil
+}
+
+// checkSignComplements returns an ENOTIMPLEMENTED error if the request has
+// complements, which the signer does not serialize
+func checkSignComplements(request CreateCfdiV4Request) error {
+	const op = "multiemissor.checkSignComplements"
+
+	if request.Complemento != nil {
+		return ez.New(op, ez.ENOTIMPLEMENTED, "Complements are not supported when sealing locally", nil)
+	}
+
+	for i, item := range request.Items {
+		if item.Complement != nil {
+			msg := fmt.Sprintf("Items[%d]: item complements are not supported when sealing locally", i)
+			return ez.New(op, ez.ENOTIMPLEMENTED, msg, nil)
+		}
+	}
+
+	return nil
+}
+
+// cloneItems copies the items and their taxes
+func cloneItems(items []models.ItemFullBindingModel) []models.ItemFullBindingModel {
+	items = slices.Clone(items)
+	for i := range items {
+		items[i].Taxes = slices.Clone(items[i].Taxes)
+	}
+
+	return items
+}
+
+// buildComprobante maps a calculated request to its CFDI 4.0, without seal
+func buildComprobante(request CreateCfdiV4Request, summary *CfdiSummary, fecha string) *cfdixml.Comprobante {
+	places := decimal.CurrencyPlaces(summary.Currency)
+
+	c := &cfdixml.Comprobante{
+		Version:           cfdixml.Version,
+		Serie:             request.Serie,
+		Folio:             request.Folio,
+		Fecha:             fecha,
+		FormaPago:         request.PaymentForm,
+		CondicionesDePago: request.PaymentConditions,
+		SubTotal:          summary.Subtotal.StringFixed(places),
+		Moneda:            summary.Currency,
+		Total:             summary.Total.StringFixed(places),
+		TipoDeComprobante: request.CfdiType,
+		Exportacion:       request.Exportation,
+		MetodoPago:        request.PaymentMethod,
+		LugarExpedicion:   request.ExpeditionPlace,
+		Emisor: cfdixml.Emisor{
+			Rfc:              request.Issuer.Rfc,
+			Nombre:           request.Issuer.Name,
+			RegimenFiscal:    request.Issuer.FiscalRegime,
+			FacAtrAdquirente: request.Issuer.FacAtrAcquirer,
+		},
+		Receptor: cfdixml.Receptor{
+			Rfc:                     request.Receiver.Rfc,
+			Nombre:                  request.Receiver.Name,
+			DomicilioFiscalReceptor: request.Receiver.TaxZipCode,
+			ResidenciaFiscal:        request.Receiver.TaxResidence,
+			NumRegIdTrib:            request.Receiver.TaxRegistrationNumber,
+			RegimenFiscalReceptor:   request.Receiver.FiscalRegime,
+			UsoCFDI:                 request.Receiver.CfdiUse,
+		},
+	}
+
+	if c.Exportacion == "" {
+		c.Exportacion = ExportationNone
+	}
+
+	// Descuento is present when any item has one
+	for _, item := range request.Items {
+		if !item.Discount.IsZero() {
+			c.Descuento = summary.Discount.StringFixed(places)
+			break
+		}
+	}
+
+	if summary.Currency != "MXN" && summary.Currency != "XXX" && !request.CurrencyExchangeRate.IsZero() {
+		c.TipoCambio = request.CurrencyExchangeRate.String()
+	}
+
+	if global := request.GlobalInformation; global != nil {
+		c.InformacionGlobal = &cfdixml.InformacionGlobal{
+			Periodicidad: global.Periodicity,
+			Meses:        global.Months,
+			Año:          strconv.Itoa(global.Year),
+		}
+	}
+
+	if relations := request.Relations; relations != nil && len(relations.Cfdis) > 0 {
+		relacionados := cfdixml.CfdiRelacionados{TipoRelacion: relations.Type}
+		for _, cfdi := range relations.Cfdis {
+			relacionados.CfdiRelacionado = append(relacionados.CfdiRelacionado, cfdixml.CfdiRelacionado{UUID: cfdi.Uuid})
+		}
+		c.CfdiRelacionados = []cfdixml.CfdiRelacionados{relacionados}
+	}
+
+	for _, item := range request.Items {
+		c.Conceptos = append(c.Conceptos, buildConcepto(item, places))
+	}
+
+	c.Impuestos = buildImpuestos(summary, places)
+
+	return c
+}
+
+// buildConcepto maps a calculated item to its Concepto
+func buildConcepto(item models.ItemFullBindingModel, places int) cfdixml.Concepto {
+	concepto := cfdixml.Concepto{
+		ClaveProdServ:    item.ProductCode,
+		NoIdentificacion: item.IdentificationNumber,
+		Cantidad:         item.Quantity.String(),
+		ClaveUnidad:      item.UnitCode,
+		Unidad:           item.Unit,
+		Descripcion:      item.Description,
+		ValorUnitario:    amount(item.UnitPrice, places),
+		Importe:          item.Subtotal.StringFixed(places),
+		ObjetoImp:        item.TaxObject,
+	}
+
+	if !item.Discount.IsZero() {
+		concepto.Descuento = item.Discount.StringFixed(places)
+	}
+
+	if len(item.Taxes) > 0 {
+		impuestos := &cfdixml.ConceptoImpuestos{}
+		for _, tax := range item.Taxes {
+			traslado := buildTraslado(tax.Name, tax.Rate, tax.IsQuota, tax.Base, tax.Total, places)
+			if !tax.IsRetention {
+				impuestos.Traslados = append(impuestos.Traslados, traslado)
+				continue
+			}
+			impuestos.Retenciones = append(impuestos.Retenciones, cfdixml.Retencion{
+				Base:       traslado.Base,
+				Impuesto:   traslado.Impuesto,
+				TipoFactor: traslado.TipoFactor,
+				TasaOCuota: traslado.TasaOCuota,
+				Importe:    traslado.Importe,
+			})
+		}
+		concepto.Impuestos = impuestos
+	}
+
+	if third := item.ThirdPartyAccount; third != nil {
+		concepto.ACuentaTerceros = &cfdixml.ACuentaTerceros{
+			RfcACuentaTerceros:             third.Rfc,
+			NombreACuentaTerceros:          third.Name,
+			RegimenFiscalACuentaTerceros:   third.FiscalRegime,
+			DomicilioFiscalACuentaTerceros: third.TaxZipCode,
+		}
+	}
+
+	for _, number := range item.NumerosPedimento {
+		concepto.InformacionAduanera = append(concepto.InformacionAduanera, cfdixml.InformacionAduanera{NumeroPedimento: number})
+	}
+
+	for _, number := range item.PropertyTaxIDNumber {
+		concepto.CuentaPredial = append(concepto.CuentaPredial, cfdixml.CuentaPredial{Numero: number})
+	}
+
+	for _, part := range item.Parts {
+		parte := cfdixml.Parte{
+			ClaveProdServ:    part.ProductCode,
+			NoIdentificacion: part.IdentificationNumber,
+			Cantidad:         part.Quantity.String(),
+			Unidad:           part.UnitCode,
+			Descripcion:      part.Description,
+		}
+		if !part.UnitPrice.IsZero() {
+			parte.ValorUnitario = amount(part.UnitPrice, places)
+		}
+		if !part.Amount.IsZero() {
+			parte.Importe = part.Amount.StringFixed(places)
+		}
+		for _, customs := range part.CustomsInformation {
+			parte.InformacionAduanera = append(parte.InformacionAduanera, cfdixml.InformacionAduanera{NumeroPedimento: customs.Number})
+		}
+		concepto.Parte = append(concepto.Parte, parte)
+	}
+
+	return concepto
+}
+
+// buildImpuestos maps the tax lines of the summary to the CFDI tax totals,
+// nil when no item has taxes
+func buildImpuestos(summary *CfdiSummary, places int) *cfdixml.Impuestos {
+	if len(summary.Transferred) == 0 && len(summary.Retained) == 0 {
+		return nil
+	}
+
+	impuestos := &cfdixml.Impuestos{}
+
+	for _, line := range summary.Retained {
+		impuestos.Retenciones = append(impuestos.Retenciones, cfdixml.Retencion{
+			Impuesto: taxCodes[line.Name],
+			Importe:  line.Total.StringFixed(places),
+		})
+	}
+	if len(summary.Retained) > 0 {
+		impuestos.TotalImpuestosRetenidos = summary.RetainedTaxes.StringFixed(places)
+	}
+
+	exemptOnly := true
+	for _, line := range summary.Transferred {
+		impuestos.Traslados = append(impuestos.Traslados, buildTraslado(line.Name, line.Rate, line.IsQuota, line.Base, line.Total, places))
+		if line.Name != TaxIVAExempt {
+			exemptOnly = false
+		}
+	}
+	// TotalImpuestosTrasladados is omitted when every transfer is exempt
+	if !exemptOnly {
+		impuestos.TotalImpuestosTrasladados = summary.TransferredTaxes.StringFixed(places)
+	}
+
+	return impuestos
+}
+
+// buildTraslado maps a calculated tax to a Traslado. Rates and quotas are
+// written with six decimals, exempt taxes have no rate nor amount.
+func buildTraslado(name string, rate decimal.Decimal, isQuota bool, base, total decimal.Decimal, places int) cfdixml.Traslado {
+	traslado := cfdixml.Traslado{
+		Base:       amount(base, places),
+		Impuesto:   taxCodes[name],
+		TipoFactor: factorRate,
+		TasaOCuota: rate.StringFixed(decimal.Places),
+		Importe:    total.StringFixed(places),
+	}
+
+	switch {
+	case name == TaxIVAExempt:
+		traslado.TipoFactor = factorExempt
+		traslado.TasaOCuota = ""
+		traslado.Importe = ""
+	case isQuota:
+		traslado.TipoFactor = factorQuota
+	}
+
+	return traslado
+}
+
+// amount formats a value with at least the currency places, keeping any
+// additional precision, e.g. unit prices with six decimals
+func amount(value decimal.Decimal, places int) string {
+	return value.StringFixed(max(places, value.Places()))
+}
@@ -0,0 +1,141 @@
+package multiemissor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vanclief/ez"
+	"github.com/vanclief/go-facturama/api/models"
+	"github.com/vanclief/go-facturama/cfdixml"
+	"github.com/vanclief/go-facturama/csd"
+	"github.com/vanclief/go-facturama/csd/csdtest"
+)
+
+func TestSigner(t *testing.T) {
+	files, err := csdtest.Generate(csdtest.Options{
+		NotBefore: time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:  time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC),
+	})
+	require.NoError(t, err)
+
+	credential, err := csd.Load(files.Certificate, files.PrivateKey, files.Password)
+	require.NoError(t, err)
+
+	signer, err := NewSigner(credential)
+	require.NoError(t, err)
+
+	request := validCfdiRequest()
+	request.Date = "2025-06-01T10:00:00"
+	request.Relations = &models.Cfdiv4Relations{Type: RelationSubstitution, Cfdis: []models.CfdiUuidID{{Uuid: "5FB2822E-396D-4725-8521-CDC4BDD20CCF"}}}
+	request.Items = append(request.Items, models.ItemFullBindingModel{
+		ProductCode: "84111506",
+		Description: "Asesoría",
+		Unit:        "Unidad de servicio",
+		UnitCode:    "E48",
+		UnitPrice:   d("33.333333"),
+		Quantity:    d("3"),
+		Discount:    d("10"),
+		TaxObject:   TaxObjectYes,
+		Taxes: []models.TaxBindingModel{
+			{Name: "iva", Rate: d("0.16")},
+			{Name: TaxISR, Rate: d("0.10"), IsRetention: true},
+		},
+	})
+
+	signed, err := signer.Sign(request)
+	require.NoError(t, err)
+
+	// The request is not modified
+	assert.True(t, request.Items[1].Subtotal.IsZero())
+	assert.Equal(t, "iva", request.Items[1].Taxes[0].Name)
+
+	c := signed.Comprobante
+	assert.Equal(t, "2025-06-01T10:00:00", c.Fecha)
+	assert.Equal(t, csdtest.DefaultNumber, c.NoCertificado)
+	assert.Equal(t, files.CertificateBase64(), c.Certificado)
+	assert.Equal(t, "200.00", c.SubTotal)
+	assert.Equal(t, "10.00", c.Descuento)
+	assert.Equal(t, "211.40", c.Total)
+	assert.Equal(t, "33.333333", c.Conceptos[1].ValorUnitario)
+	assert.Equal(t, "100.00", c.Conceptos[1].Importe)
+	assert.Equal(t, cfdixml.Traslado{Base: "90.00", Impuesto: "002", TipoFactor: "Tasa", TasaOCuota: "0.160000", Importe: "14.40"},
+		c.Conceptos[1].Impuestos.Traslados[0])
+	assert.Equal(t, cfdixml.Retencion{Base: "90.00", Impuesto: "001", TipoFactor: "Tasa", TasaOCuota: "0.100000", Importe: "9.00"},
+		c.Conceptos[1].Impuestos.Retenciones[0])
+	assert.Equal(t, &cfdixml.Impuestos{
+		TotalImpuestosRetenidos:   "9.00",
+		TotalImpuestosTrasladados: "30.40",
+		Retenciones:               []cfdixml.Retencion{{Impuesto: "001", Importe: "9.00"}},
+		Traslados:                 []cfdixml.Traslado{{Base: "190.00", Impuesto: "002", TipoFactor: "Tasa", TasaOCuota: "0.160000", Importe: "30.40"}},
+	}, c.Impuestos)
+
+	// The serialized CFDI passes the seal checks
+	parsed, err := cfdixml.Parse(signed.XML)
+	require.NoError(t, err)
+
+	report, err := cfdixml.Verify(parsed, nil)
+	require.NoError(t, err)
+	assert.Equal(t, signed.CadenaOriginal, report.CadenaOriginal)
+	for _, name := range []string{cfdixml.CheckCertificate, cfdixml.CheckCertificateRFC, cfdixml.CheckCertificateValidity, cfdixml.CheckSello} {
+		check, _ := report.Check(name)
+		assert.Equal(t, cfdixml.CheckPassed, check.Status, name)
+	}
+
+	// Exempt taxes have no rate nor amount
+	exempt := validCfdiRequest()
+	exempt.Date = request.Date
+	exempt.Items[0].Taxes = []models.TaxBindingModel{{Name: TaxIVAExempt}}
+	signed, err = signer.Sign(exempt)
+	require.NoError(t, err)
+	assert.Equal(t, []cfdixml.Traslado{{Base: "100.00", Impuesto: "002", TipoFactor: "Exento"}}, signed.Comprobante.Impuestos.Traslados)
+	assert.Empty(t, signed.Comprobante.Impuestos.TotalImpuestosTrasladados)
+	assert.Equal(t, "100.00", signed.Comprobante.Total)
+}
+
+func TestSignerErrors(t *testing.T) {
+	files, err := csdtest.Generate(csdtest.Options{
+		NotBefore: time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:  time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC),
+	})
+	require.NoError(t, err)
+
+	credential, err := csd.Load(files.Certificate, files.PrivateKey, files.Password)
+	require.NoError(t, err)
+
+	_, err = NewSigner(nil)
+	assert.Equal(t, ez.EINVALID, ez.ErrorCode(err))
+
+	other, err := csdtest.Generate(csdtest.Options{})
+	require.NoError(t, err)
+	_, err = NewSigner(&csd.Credential{Certificate: credential.Certificate, PrivateKey: other.Key})
+	assert.Equal(t, ez.EINVALID, ez.ErrorCode(err))
+
+	signer, err := NewSigner(credential)
+	require.NoError(t, err)
+
+	cases := map[string]struct {
+		modify func(*CreateCfdiV4Request)
+		code   string
+	}{
+		"invalid request":     {func(r *CreateCfdiV4Request) { r.Folio = "" }, ez.EINVALID},
+		"missing issuer name": {func(r *CreateCfdiV4Request) { r.Issuer.Name = "" }, ez.EINVALID},
+		"invalid date":        {func(r *CreateCfdiV4Request) { r.Date = "01/06/2025" }, ez.EINVALID},
+		"expired certificate": {func(r *CreateCfdiV4Request) { r.Date = "2026-06-01T10:00:00" }, ez.EINVALID},
+		"another issuer":      {func(r *CreateCfdiV4Request) { r.Issuer.Rfc = "URE180429TM6" }, ez.EINVALID},
+		"invalid tax":         {func(r *CreateCfdiV4Request) { r.Items[0].Taxes[0].Rate = d("0.15") }, ez.EINVALID},
+		"complement":          {func(r *CreateCfdiV4Request) { r.Complemento = &models.Complementv4{} }, ez.ENOTIMPLEMENTED},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			request := validCfdiRequest()
+			request.Date = "2025-06-01T10:00:00"
+			tc.modify(&request)
+
+			_, err := signer.Sign(request)
+			assert.Equal(t, tc.code, ez.ErrorCode(err))
+		})
+	}
+}
@@ -2,9 +2,16 @@ package multiemissor
 
 import (
 	"github.com/vanclief/go-facturama/api/models"
+	"github.com/vanclief/go-facturama/decimal"
 )
 
 func (s *APIClientSuite) TestCreateCfdiV4() {
+	// The issuer regime must match the kind of taxpayer of the RFC
+	fiscalRegime := "601" // General de Ley Personas Morales
+	if len(s.RFC) == 13 {
+		fiscalRegime = "612" // Persona Física con actividad empresarial
+	}
+
 	// Step 1: Create a CFDI para PUBLIC EN GENERAL
 	request := CreateCfdiV4Request{
 		NameID:          1,
@@ -22,7 +29,7 @@ func (s *APIClientSuite) TestCreateCfdiV4() {
 		Issuer: models.IssuerV4BindingModel{
 			Name:         "FRANCO VALENCIA ADAN",
 			Rfc:          s.RFC, // Test RFC for sandbox
-			FiscalRegime: "612", // Persona Física con actividad empresarial
+			FiscalRegime: fiscalRegime,
 		},
 		Receiver: models.ReceiverV4BindingModel{
 			Rfc:          "XAXX010101000", // RFC genérico nacional
@@ -37,17 +44,17 @@ func (s *APIClientSuite) TestCreateCfdiV4() {
 				Description: "Test product",
 				Unit:        "PIECE",
 				UnitCode:    "H87", // Pieza
-				UnitPrice:   100.0,
-				Quantity:    1.0,
-				Subtotal:    100.0,
-				Total:       116.0, // Price with tax
-				TaxObject:   "02",  // Sí objeto de impuesto
+				UnitPrice:   decimal.NewFromInt(100),
+				Quantity:    decimal.NewFromInt(1),
+				Subtotal:    decimal.NewFromInt(100),
+				Total:       decimal.NewFromInt(116), // Price with tax
+				TaxObject:   "02",                    // Sí objeto de impuesto
 				Taxes: []models.TaxBindingModel{
 					{
 						Name:        "IVA",
-						Base:        100.0,
-						Rate:        0.16,
-						Total:       16.0,
+						Base:        decimal.NewFromInt(100),
+						Rate:        decimal.RequireFromString("0.16"),
+						Total:       decimal.NewFromInt(16),
 						IsRetention: false,
 						IsQuota:     false,
 					},
@@ -124,3 +131,39 @@ func (s *APIClientSuite) TestGetCfdiFile() {
 	s.Greater(file.ContentLength, 0, "Expected content length > 0")
 	s.NotEmpty(file.Content, "Content should not be empty")
 }
+
+func (s *APIClientSuite) TestListCfdis() {
+	request := ListCfdisRequest{
+		IssuerRfc: s.RFC,
+		Status:    "all",
+	}
+
+	// Call the method being tested
+	cfdis, err := s.Client.ListCfdis(s.Context, request)
+
+	// Validate results
+	s.Nil(err, "Error listing CFDIs")
+	s.NotEmpty(cfdis, "Expected at least one CFDI")
+
+	for _, cfdi := range cfdis {
+		s.NotEmpty(cfdi.ID, "ID should not be empty")
+		s.Equal(s.RFC, cfdi.Issuer.Rfc, "Expected CFDIs from the requested issuer")
+	}
+
+	// The iterator should yield the same first page
+	count := 0
+	for cfdi, err := range s.Client.AllCfdis(s.Context, request) {
+		s.Nil(err, "Error iterating CFDIs")
+		s.Equal(cfdis[count].ID, cfdi.ID, "Expected iterator to follow the page order")
+
+		count++
+		if count == len(cfdis) {
+			break
+		}
+	}
+	s.Equal(len(cfdis), count, "Expected iterator to yield the first page")
+
+	// Invalid filters are rejected locally
+	_, err = s.Client.ListCfdis(s.Context, ListCfdisRequest{Status: "pending"})
+	s.Error(err, "Should error with invalid status")
+}
@@ -2,8 +2,11 @@ package multiemissor
 
 import (
 	"context"
+	"time"
 
 	"github.com/vanclief/ez"
+	"github.com/vanclief/go-facturama/api/common"
+	"github.com/vanclief/go-facturama/csd"
 )
 
 // CreateCSDRequest represents a request to create a new CSD
@@ -12,32 +15,51 @@ type CreateCSDRequest struct {
 	Certificate        string `json:"Certificate"`
 	PrivateKey         string `json:"PrivateKey"`
 	PrivateKeyPassword string `json:"PrivateKeyPassword"`
+
+	// VerifyLocally decrypts and inspects the certificate and key before
+	// sending them, rejecting wrong passwords, mismatched pairs, FIEL
+	// certificates, expired certificates and certificates for another RFC
+	VerifyLocally bool `json:"-"`
 }
 
+// Validate validates the request to create or update a CSD
 func (request *CreateCSDRequest) Validate() error {
 	const op = "CreateCSDRequest.Validate"
 
+	var errs ValidationErrors
+
 	// Validate required fields
-	if request.RFC == "" {
-		return ez.New(op, ez.EINVALID, "RFC is required", nil)
-	}
-	if request.Certificate == "" {
-		return ez.New(op, ez.EINVALID, "Certificate is required", nil)
-	}
-	if request.PrivateKey == "" {
-		return ez.New(op, ez.EINVALID, "PrivateKey is required", nil)
+	errs.checkRFC("Rfc", request.RFC, true)
+	errs.required("Certificate", request.Certificate)
+	errs.required("PrivateKey", request.PrivateKey)
+	errs.required("PrivateKeyPassword", request.PrivateKeyPassword)
+
+	if request.VerifyLocally && len(errs) == 0 {
+		errs.verifyCredential(request)
 	}
-	if request.PrivateKeyPassword == "" {
-		return ez.New(op, ez.EINVALID, "PrivateKeyPassword is required", nil)
+
+	return errs.Err(op)
+}
+
+// verifyCredential adds a violation when the certificate and key do not form
+// a valid CSD for the RFC
+func (errs *ValidationErrors) verifyCredential(request *CreateCSDRequest) {
+	credential, err := csd.LoadBase64(request.Certificate, request.PrivateKey, request.PrivateKeyPassword)
+	if err == nil {
+		err = credential.Verify(request.RFC, time.Now())
 	}
 
-	return nil
+	if err != nil {
+		errs.Add("Certificate", CodeInvalidCredential, ez.ErrorMessage(err),
+			"El certificado y la llave privada no forman un CSD vigente para el RFC")
+	}
 }
 
 // CreateCSD uploads a new CSD (Certificado de Sello Digital)
 // Endpoint: POST /api-lite/csds
 func (c *Client) CreateCSD(ctx context.Context, request CreateCSDRequest) error {
 	const op = "multiemissor.CreateCSD"
+	ctx = common.WithOp(ctx, op)
 
 	err := request.Validate()
 	if err != nil {
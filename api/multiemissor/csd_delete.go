@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	"github.com/vanclief/ez"
+	"github.com/vanclief/go-facturama/api/common"
 )
 
 // DeleteCSDRequest represents a request to delete a CSD by RFC
@@ -16,18 +17,17 @@ type DeleteCSDRequest struct {
 func (request *DeleteCSDRequest) Validate() error {
 	const op = "DeleteCSDRequest.Validate"
 
-	// Validate RFC parameter
-	if request.RFC == "" {
-		return ez.New(op, ez.EINVALID, "RFC is required", nil)
-	}
+	var errs ValidationErrors
+	errs.checkRFC("RFC", request.RFC, true)
 
-	return nil
+	return errs.Err(op)
 }
 
 // DeleteCSD deletes a CSD (Certificado de Sello Digital) by RFC
 // Endpoint: DELETE /api-lite/csds/{rfc}
 func (c *Client) DeleteCSD(ctx context.Context, request DeleteCSDRequest) error {
 	const op = "multiemissor.DeleteCSD"
+	ctx = common.WithOp(ctx, op)
 
 	// Validate request
 	err := request.Validate()
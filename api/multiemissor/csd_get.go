@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	"github.com/vanclief/ez"
+	"github.com/vanclief/go-facturama/api/common"
 	"github.com/vanclief/go-facturama/api/models"
 )
 
@@ -17,17 +18,17 @@ type GetCSDByRFCRequest struct {
 func (request *GetCSDByRFCRequest) Validate() error {
 	const op = "GetCSDByRFCRequest.Validate"
 
-	if request.RFC == "" {
-		return ez.New(op, ez.EINVALID, "RFC is required", nil)
-	}
+	var errs ValidationErrors
+	errs.checkRFC("RFC", request.RFC, true)
 
-	return nil
+	return errs.Err(op)
 }
 
 // GetCSDByRFC retrieves a CSD (Certificado de Sello Digital) by RFC (Registro Federal de Contribuyentes)
 // Endpoint: GET /api-lite/csds/{rfc}
 func (c *Client) GetCSDByRFC(ctx context.Context, request GetCSDByRFCRequest) (*models.TaxEntityCSD, error) {
 	const op = "multiemissor.GetCSDByRFC"
+	ctx = common.WithOp(ctx, op)
 
 	// Validate request
 	err := request.Validate()
@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/vanclief/ez"
+	"github.com/vanclief/go-facturama/api/common"
 	"github.com/vanclief/go-facturama/api/models"
 )
 
@@ -11,6 +12,7 @@ import (
 // Endpoint: GET /api-lite/csds
 func (c *Client) ListCSDs(ctx context.Context) ([]models.TaxEntityCSD, error) {
 	const op = "multiemissor.ListCSDs"
+	ctx = common.WithOp(ctx, op)
 
 	path := "/api-lite/csds"
 	var response []models.TaxEntityCSD
@@ -1,5 +1,9 @@
 package multiemissor
 
+import (
+	"github.com/vanclief/go-facturama/csd/csdtest"
+)
+
 // TODO: Uncomment once everything is set
 
 // func (s *APIClientSuite) TestCSDCreationAndDeletion() {
@@ -110,3 +114,48 @@ package multiemissor
 // 	s.Error(err, "Should error with random RFC")
 // 	s.Nil(csd, "CSD should be nil")
 // }
+
+func (s *APIClientSuite) TestCSDVerifyLocally() {
+	files, err := csdtest.Generate(csdtest.Options{RFC: s.RFC})
+	s.Require().Nil(err, "Error generating test credential")
+
+	request := CreateCSDRequest{
+		RFC:                s.RFC,
+		Certificate:        files.CertificateBase64(),
+		PrivateKey:         files.PrivateKeyBase64(),
+		PrivateKeyPassword: files.Password,
+		VerifyLocally:      true,
+	}
+	s.Nil(request.Validate(), "Should accept a valid credential")
+
+	// Wrong password
+	wrongPassword := request
+	wrongPassword.PrivateKeyPassword = "wrong password"
+	err = s.Client.CreateCSD(s.Context, wrongPassword)
+	s.Error(err, "Should error with a wrong password")
+
+	// Credential issued to a different RFC, a valid issuer RFC so the
+	// certificate check is the one that fails
+	wrongRFC := request
+	wrongRFC.RFC = "URE180429TM6"
+	if s.RFC == wrongRFC.RFC {
+		wrongRFC.RFC = "EKU9003173C9"
+	}
+	err = s.Client.UpdateCSD(s.Context, wrongRFC)
+	s.Error(err, "Should error with a different RFC")
+	errs, ok := AsValidationErrors(err)
+	s.Require().True(ok, "Should be a validation error")
+	s.Equal([]string{"Certificate"}, errs.Fields())
+	s.Equal(CodeInvalidCredential, errs[0].Code)
+
+	// FIEL instead of CSD
+	fiel, err := csdtest.Generate(csdtest.Options{RFC: s.RFC, FIEL: true})
+	s.Require().Nil(err, "Error generating test credential")
+
+	fielRequest := request
+	fielRequest.Certificate = fiel.CertificateBase64()
+	fielRequest.PrivateKey = fiel.PrivateKeyBase64()
+	err = s.Client.CreateCSD(s.Context, fielRequest)
+	s.Error(err, "Should error with a FIEL")
+	s.Contains(err.Error(), "FIEL")
+}
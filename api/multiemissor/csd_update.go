@@ -5,12 +5,14 @@ import (
 	"fmt"
 
 	"github.com/vanclief/ez"
+	"github.com/vanclief/go-facturama/api/common"
 )
 
 // UpdateCSD updates an existing CSD (Certificado de Sello Digital)
 // Endpoint: PUT /api-lite/csds/{rfc}
 func (c *Client) UpdateCSD(ctx context.Context, request CreateCSDRequest) error {
 	const op = "multiemissor.UpdateCSD"
+	ctx = common.WithOp(ctx, op)
 
 	err := request.Validate()
 	if err != nil {
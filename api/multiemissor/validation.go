@@ -0,0 +1,208 @@
+package multiemissor
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vanclief/ez"
+	"github.com/vanclief/go-facturama/catalogs"
+	"github.com/vanclief/go-facturama/rfc"
+)
+
+// Validation error codes
+const (
+	// CodeRequired is a missing required field
+	CodeRequired = "required"
+	// CodeInvalid is a value outside the allowed values or range
+	CodeInvalid = "invalid"
+	// CodeFormat is a value with the wrong format or length
+	CodeFormat = "format"
+	// CodeNotInCatalog is a code missing from its SAT catalog
+	CodeNotInCatalog = "not_in_catalog"
+	// CodeIncompatible is a value not allowed in combination with other fields
+	CodeIncompatible = "incompatible"
+	// CodeInvalidRFC is an RFC with a wrong structure, date or check digit
+	CodeInvalidRFC = "invalid_rfc"
+	// CodeInvalidCURP is a CURP with a wrong structure, date, state or check digit
+	CodeInvalidCURP = "invalid_curp"
+	// CodeInvalidCredential is a CSD that does not pass the local verification
+	CodeInvalidCredential = "invalid_credential"
+)
+
+// ValidationError is a single violation found while validating a request
+type ValidationError struct {
+	// Field is the JSON path of the field, e.g. Items[2].Taxes[0].Rate
+	Field string `json:"field"`
+	// Code is the machine readable kind of violation, one of the Code constants
+	Code string `json:"code"`
+	// Message describes the violation in English
+	Message string `json:"message"`
+	// MessageES describes the violation in Spanish
+	MessageES string `json:"message_es"`
+}
+
+// Error returns the English message
+func (e ValidationError) Error() string {
+	return e.Message
+}
+
+// ValidationErrors are all the violations found while validating a request.
+// Validate methods return them as the cause of an EINVALID ez error, use
+// AsValidationErrors to get them back.
+type ValidationErrors []ValidationError
+
+// Error returns the English messages separated by semicolons
+func (errs ValidationErrors) Error() string {
+	messages := make([]string, len(errs))
+	for i, e := range errs {
+		messages[i] = e.Message
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+// Fields returns the paths of the invalid fields, in order and without duplicates
+func (errs ValidationErrors) Fields() []string {
+	var fields []string
+	seen := make(map[string]bool)
+	for _, e := range errs {
+		if !seen[e.Field] {
+			seen[e.Field] = true
+			fields = append(fields, e.Field)
+		}
+	}
+
+	return fields
+}
+
+// Has reports whether there is a violation for the field
+func (errs ValidationErrors) Has(field string) bool {
+	for _, e := range errs {
+		if e.Field == field {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Add appends a violation
+func (errs *ValidationErrors) Add(field, code, message, messageES string) {
+	*errs = append(*errs, ValidationError{Field: field, Code: code, Message: message, MessageES: messageES})
+}
+
+// Err returns nil when there are no violations, otherwise an EINVALID ez
+// error whose message lists every violation and whose cause is errs
+func (errs ValidationErrors) Err(op string) error {
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return ez.New(op, ez.EINVALID, errs.Error(), errs)
+}
+
+// AsValidationErrors returns the violations carried by an error returned by
+// a Validate method, or by a client method that failed validation
+func AsValidationErrors(err error) (ValidationErrors, bool) {
+	for err != nil {
+		switch e := err.(type) {
+		case ValidationErrors:
+			return e, true
+		case *ez.Error:
+			err = e.Err
+		default:
+			return nil, false
+		}
+	}
+
+	return nil, false
+}
+
+// required adds a CodeRequired violation when the value is empty and
+// reports whether it was present
+func (errs *ValidationErrors) required(field, value string) bool {
+	if value != "" {
+		return true
+	}
+
+	errs.Add(field, CodeRequired, field+" is required", field+" es obligatorio")
+	return false
+}
+
+// oneOf adds a CodeInvalid violation when the value is not one of the allowed values
+func (errs *ValidationErrors) oneOf(field, value string, allowed ...string) {
+	for _, v := range allowed {
+		if value == v {
+			return
+		}
+	}
+
+	list := strings.Join(allowed, ", ")
+	errs.Add(field, CodeInvalid,
+		fmt.Sprintf("%s must be one of: %s", field, list),
+		fmt.Sprintf("%s debe ser uno de: %s", field, list))
+}
+
+// checkCatalog adds a CodeNotInCatalog violation when a present code is not in
+// the SAT catalog. Catalogs that are not complete only check the format of the
+// code and add a CodeFormat violation instead, see catalogs.IsValid.
+func (errs *ValidationErrors) checkCatalog(name, field, code string) {
+	if code == "" || catalogs.IsValid(name, code) {
+		return
+	}
+
+	if !catalogs.IsComplete(name) {
+		errs.Add(field, CodeFormat,
+			fmt.Sprintf("%s %q does not have the format of the SAT catalog %s", field, code, name),
+			fmt.Sprintf("%s %q no tiene el formato del catálogo %s del SAT", field, code, name))
+		return
+	}
+
+	errs.Add(field, CodeNotInCatalog,
+		fmt.Sprintf("%s %q is not in the SAT catalog %s", field, code, name),
+		fmt.Sprintf("%s %q no existe en el catálogo %s del SAT", field, code, name))
+}
+
+// rfcMessagesES are the Spanish messages of the rfc package reasons
+var rfcMessagesES = map[rfc.Reason]string{
+	rfc.ReasonLength:     "debe tener 12 (persona moral) o 13 (persona física) caracteres",
+	rfc.ReasonFormat:     "no tiene una estructura válida",
+	rfc.ReasonDate:       "tiene una fecha inválida",
+	rfc.ReasonState:      "tiene una clave de entidad federativa inválida",
+	rfc.ReasonCheckDigit: "tiene un dígito verificador inválido",
+	rfc.ReasonGeneric:    "no puede ser un RFC genérico",
+}
+
+// checkRFC adds a violation when the RFC is missing or invalid. Issuers must not
+// use the generic RFCs, which can only identify receivers.
+func (errs *ValidationErrors) checkRFC(field, value string, issuer bool) {
+	if !errs.required(field, value) {
+		return
+	}
+
+	validate := rfc.Validate
+	if issuer {
+		validate = rfc.ValidateIssuer
+	}
+
+	err := validate(value)
+	if err != nil {
+		errs.Add(field, CodeInvalidRFC,
+			fmt.Sprintf("%s: %s", field, ez.ErrorMessage(err)),
+			fmt.Sprintf("%s: el RFC %s %s", field, rfc.Normalize(value), rfcMessagesES[rfc.ErrorReason(err)]))
+	}
+}
+
+// checkCURP adds a violation when the CURP is missing or invalid
+func (errs *ValidationErrors) checkCURP(field, value string) {
+	if !errs.required(field, value) {
+		return
+	}
+
+	err := rfc.ValidateCURP(value)
+	if err != nil {
+		errs.Add(field, CodeInvalidCURP,
+			fmt.Sprintf("%s: %s", field, ez.ErrorMessage(err)),
+			fmt.Sprintf("%s: la CURP %s %s", field, rfc.Normalize(value), rfcMessagesES[rfc.ErrorReason(err)]))
+	}
+}
@@ -0,0 +1,358 @@
+// Package otelfacturama instruments the Facturama clients with OpenTelemetry.
+// Instrument is a common.Option, so it applies to every client built on
+// common.Client:
+//
+//	client := multiemissor.NewClient(username, password, otelfacturama.Instrument())
+//
+// Every request becomes a span named after the operation that made it, e.g.
+// multiemissor.CreateCfdiV4, and each of its attempts a child client span
+// named after the HTTP method, so retries show up as siblings under the
+// request. The span context of the attempt is propagated in the request
+// headers.
+package otelfacturama
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/vanclief/ez"
+	"github.com/vanclief/go-facturama/api/common"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ScopeName is the instrumentation scope of the tracer and meter
+const ScopeName = "github.com/vanclief/go-facturama/api/otelfacturama"
+
+// Attribute keys specific to Facturama
+const (
+	// OpKey is the operation that made the request
+	OpKey = attribute.Key("facturama.op")
+	// AttemptKey is the attempt number of the request
+	AttemptKey = attribute.Key("facturama.attempt")
+	// SATCodesKey are the SAT validation codes of a rejected CFDI
+	SATCodesKey = attribute.Key("facturama.sat_codes")
+	// CfdiIDKey is the Facturama ID of the CFDI
+	CfdiIDKey = attribute.Key("facturama.cfdi.id")
+	// CfdiUUIDKey is the fiscal folio (UUID) of the stamped CFDI
+	CfdiUUIDKey = attribute.Key("facturama.cfdi.uuid")
+	// IssuerRFCKey is the RFC of the CFDI issuer
+	IssuerRFCKey = attribute.Key("facturama.issuer.rfc")
+	// ErrorClassKey is the class of a failure, see ErrorClass
+	ErrorClassKey = attribute.Key("error.type")
+)
+
+// Semantic convention attribute keys
+const (
+	methodKey = attribute.Key("http.request.method")
+	statusKey = attribute.Key("http.response.status_code")
+	resendKey = attribute.Key("http.request.resend_count")
+	pathKey   = attribute.Key("url.path")
+)
+
+// Error classes reported by ErrorClass
+const (
+	ClassUnavailable    = "unavailable"
+	ClassRateLimited    = "rate_limited"
+	ClassAuth           = "auth"
+	ClassNotFound       = "not_found"
+	ClassConflict       = "conflict"
+	ClassSATRejection   = "sat_rejection"
+	ClassInvalid        = "invalid"
+	ClassInternal       = "internal"
+	ClassNotImplemented = "not_implemented"
+)
+
+// config holds the providers used by the middleware
+type config struct {
+	tracerProvider trace.TracerProvider
+	meterProvider  metric.MeterProvider
+	propagator     propagation.TextMapPropagator
+}
+
+// Option configures the middleware
+type Option func(*config)
+
+// WithTracerProvider sets the tracer provider, defaults to the global one
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(c *config) {
+		c.tracerProvider = provider
+	}
+}
+
+// WithMeterProvider sets the meter provider, defaults to the global one
+func WithMeterProvider(provider metric.MeterProvider) Option {
+	return func(c *config) {
+		c.meterProvider = provider
+	}
+}
+
+// WithPropagator sets the propagator that injects the span context in the
+// request headers, defaults to the global one
+func WithPropagator(propagator propagation.TextMapPropagator) Option {
+	return func(c *config) {
+		c.propagator = propagator
+	}
+}
+
+// newConfig returns the configuration of the options, using the global
+// providers by default
+func newConfig(options []Option) config {
+	cfg := config{
+		tracerProvider: otel.GetTracerProvider(),
+		meterProvider:  otel.GetMeterProvider(),
+		propagator:     otel.GetTextMapPropagator(),
+	}
+	for _, option := range options {
+		option(&cfg)
+	}
+
+	return cfg
+}
+
+// Instrument returns a client option that adds the OperationMiddleware and
+// the Middleware, so attempts are traced as children of their request
+func Instrument(options ...Option) common.Option {
+	return func(c *common.Client) {
+		common.WithOperationMiddleware(OperationMiddleware(options...))(c)
+		common.WithMiddleware(Middleware(options...))(c)
+	}
+}
+
+// OperationMiddleware returns a common.Middleware for
+// common.WithOperationMiddleware that traces every request as a span named
+// after its operation, around its attempts. The CFDI ID, UUID and issuer RFC
+// of the request and response bodies are recorded on this span only, once
+// per request rather than per attempt.
+func OperationMiddleware(options ...Option) common.Middleware {
+	cfg := newConfig(options)
+	tracer := cfg.tracerProvider.Tracer(ScopeName)
+
+	return func(next common.Handler) common.Handler {
+		return func(ctx context.Context, req *common.Request) (*common.Response, error) {
+			name := req.Op
+			if name == "" {
+				name = "facturama " + req.Method
+			}
+
+			ctx, span := tracer.Start(ctx, name,
+				trace.WithSpanKind(trace.SpanKindInternal),
+				trace.WithAttributes(
+					OpKey.String(req.Op),
+					methodKey.String(req.Method),
+					pathKey.String(pathOnly(req.Path)),
+				))
+			defer span.End()
+
+			inspect := carriesCfdi(req.Path)
+			if inspect && req.Body != nil {
+				if body, err := json.Marshal(req.Body); err == nil {
+					span.SetAttributes(cfdiAttributes(body)...)
+				}
+			}
+
+			resp, err := next(ctx, req)
+
+			if resp != nil {
+				span.SetAttributes(statusKey.Int(resp.StatusCode))
+				if inspect && err == nil {
+					span.SetAttributes(cfdiAttributes(resp.Body)...)
+				}
+			}
+			if err != nil {
+				recordError(span, err)
+			}
+
+			return resp, err
+		}
+	}
+}
+
+// instruments are the metrics recorded by the middleware
+type instruments struct {
+	requests metric.Int64Counter
+	duration metric.Float64Histogram
+	errors   metric.Int64Counter
+}
+
+// Middleware returns a common.Middleware that traces every attempt as a
+// client span named after the HTTP method and records:
+//
+//   - facturama.client.requests: counter of attempts
+//   - facturama.client.duration: histogram of the attempt latency, in seconds
+//   - facturama.client.errors: counter of failed attempts by error.type
+//
+// Metrics carry the op, method and status code attributes.
+func Middleware(options ...Option) common.Middleware {
+	cfg := newConfig(options)
+
+	tracer := cfg.tracerProvider.Tracer(ScopeName)
+	meter := cfg.meterProvider.Meter(ScopeName)
+
+	var inst instruments
+	var err error
+
+	// Instruments that fail to be created are no-ops, the error goes to the otel handler
+	inst.requests, err = meter.Int64Counter("facturama.client.requests",
+		metric.WithDescription("Requests sent to the Facturama API, counting every attempt"))
+	handle(err)
+	inst.duration, err = meter.Float64Histogram("facturama.client.duration",
+		metric.WithDescription("Latency of the requests to the Facturama API"),
+		metric.WithUnit("s"))
+	handle(err)
+	inst.errors, err = meter.Int64Counter("facturama.client.errors",
+		metric.WithDescription("Failed requests to the Facturama API by error class"))
+	handle(err)
+
+	return func(next common.Handler) common.Handler {
+		return func(ctx context.Context, req *common.Request) (*common.Response, error) {
+			ctx, span := tracer.Start(ctx, req.Method,
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithAttributes(
+					OpKey.String(req.Op),
+					AttemptKey.Int(req.Attempt),
+					methodKey.String(req.Method),
+					pathKey.String(pathOnly(req.Path)),
+				))
+			defer span.End()
+
+			if req.Attempt > 1 {
+				span.SetAttributes(resendKey.Int(req.Attempt - 1))
+			}
+
+			if req.Header == nil {
+				req.Header = make(http.Header)
+			}
+			cfg.propagator.Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+			start := time.Now()
+			resp, err := next(ctx, req)
+			elapsed := time.Since(start).Seconds()
+
+			attrs := []attribute.KeyValue{OpKey.String(req.Op), methodKey.String(req.Method)}
+			if resp != nil {
+				attrs = append(attrs, statusKey.Int(resp.StatusCode))
+				span.SetAttributes(statusKey.Int(resp.StatusCode))
+			}
+
+			if err != nil {
+				class := recordError(span, err)
+				inst.errors.Add(ctx, 1, metric.WithAttributes(append(attrs, ErrorClassKey.String(class))...))
+			}
+
+			inst.requests.Add(ctx, 1, metric.WithAttributes(attrs...))
+			inst.duration.Record(ctx, elapsed, metric.WithAttributes(attrs...))
+
+			return resp, err
+		}
+	}
+}
+
+// recordError sets the error status and attributes of a span, returning the
+// error class
+func recordError(span trace.Span, err error) string {
+	class := ErrorClass(err)
+	span.SetAttributes(ErrorClassKey.String(class))
+	span.SetStatus(codes.Error, ez.ErrorMessage(err))
+	if sat := common.SATCodes(err); len(sat) > 0 {
+		span.SetAttributes(SATCodesKey.StringSlice(sat))
+	}
+
+	return class
+}
+
+// ErrorClass returns the class of a failed request, one of the Class constants
+func ErrorClass(err error) string {
+	if common.IsSATRejection(err) {
+		return ClassSATRejection
+	}
+
+	switch ez.ErrorCode(err) {
+	case ez.EUNAVAILABLE:
+		return ClassUnavailable
+	case ez.ERESOURCEEXHAUSTED:
+		return ClassRateLimited
+	case ez.ENOTAUTHENTICATED, ez.ENOTAUTHORIZED:
+		return ClassAuth
+	case ez.ENOTFOUND:
+		return ClassNotFound
+	case ez.ECONFLICT:
+		return ClassConflict
+	case ez.EINVALID:
+		return ClassInvalid
+	case ez.ENOTIMPLEMENTED:
+		return ClassNotImplemented
+	default:
+		return ClassInternal
+	}
+}
+
+// cfdiFields are the fields of a CFDI payload recorded as span attributes
+type cfdiFields struct {
+	ID     string `json:"Id"`
+	Issuer struct {
+		Rfc string `json:"Rfc"`
+	} `json:"Issuer"`
+	Complement struct {
+		TaxStamp struct {
+			UUID string `json:"Uuid"`
+		} `json:"TaxStamp"`
+	} `json:"Complement"`
+}
+
+// cfdiAttributes returns the CFDI ID, UUID and issuer RFC of a JSON object
+func cfdiAttributes(body []byte) []attribute.KeyValue {
+	var fields cfdiFields
+	if len(body) == 0 || body[0] != '{' || json.Unmarshal(body, &fields) != nil {
+		return nil
+	}
+
+	var attrs []attribute.KeyValue
+	if fields.ID != "" {
+		attrs = append(attrs, CfdiIDKey.String(fields.ID))
+	}
+	if fields.Complement.TaxStamp.UUID != "" {
+		attrs = append(attrs, CfdiUUIDKey.String(fields.Complement.TaxStamp.UUID))
+	}
+	if fields.Issuer.Rfc != "" {
+		attrs = append(attrs, IssuerRFCKey.String(fields.Issuer.Rfc))
+	}
+
+	return attrs
+}
+
+// carriesCfdi reports whether the bodies of a request path may hold CFDI
+// attributes. File and CSD endpoints carry large base64 contents and no CFDI
+// fields, so they are not decoded.
+func carriesCfdi(path string) bool {
+	for _, prefix := range []string{"/cfdi/", "/acuse/", "/api-lite/csds"} {
+		if strings.HasPrefix(path, prefix) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// pathOnly strips the query of a request path, search filters may hold RFCs
+func pathOnly(path string) string {
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		return path[:i]
+	}
+
+	return path
+}
+
+// handle reports an instrument creation error to the otel error handler
+func handle(err error) {
+	if err != nil {
+		otel.Handle(err)
+	}
+}
@@ -0,0 +1,348 @@
+package otelfacturama_test
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vanclief/go-facturama/api/common"
+	"github.com/vanclief/go-facturama/api/facturamatest"
+	"github.com/vanclief/go-facturama/api/models"
+	"github.com/vanclief/go-facturama/api/multiemissor"
+	"github.com/vanclief/go-facturama/api/otelfacturama"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestMiddleware(t *testing.T) {
+	srv := facturamatest.NewServer()
+	defer srv.Close()
+
+	id := srv.AddCfdi(models.CfdiInfoModel{
+		Folio:  "100",
+		Issuer: models.TaxEntityInfoViewModel{Rfc: "EKU9003173C9"},
+	})
+
+	spans := newSpanRecorder()
+	metrics := newMetricRecorder()
+
+	var traceparent string
+	capture := func(next common.Handler) common.Handler {
+		return func(ctx context.Context, req *common.Request) (*common.Response, error) {
+			traceparent = req.Header.Get("traceparent")
+			return next(ctx, req)
+		}
+	}
+
+	client := multiemissor.NewClient(srv.Username, srv.Password,
+		common.WithBaseURL(srv.URL),
+		common.WithMiddleware(otelfacturama.Middleware(
+			otelfacturama.WithTracerProvider(spans),
+			otelfacturama.WithMeterProvider(metrics),
+			otelfacturama.WithPropagator(propagation.TraceContext{}),
+		), capture),
+	)
+
+	ctx := context.Background()
+	_, err := client.GetCfdiById(ctx, multiemissor.GetCfdiByIdRequest{ID: id})
+	require.NoError(t, err)
+	assert.NotEmpty(t, traceparent)
+
+	_, err = client.GetCfdiById(ctx, multiemissor.GetCfdiByIdRequest{ID: "missing"})
+	require.Error(t, err)
+
+	ended := spans.Ended()
+	require.Len(t, ended, 2)
+
+	ok := ended[0]
+	assert.Equal(t, http.MethodGet, ok.name)
+	assert.Equal(t, trace.SpanKindClient, ok.kind)
+	assertAttr(t, ok.attrs, "facturama.op", attribute.StringValue("multiemissor.GetCfdiById"))
+	assertAttr(t, ok.attrs, "http.response.status_code", attribute.IntValue(http.StatusOK))
+	assertAttr(t, ok.attrs, "url.path", attribute.StringValue("/api-lite/cfdis/"+id))
+	assert.NotContains(t, ok.attrs, otelfacturama.CfdiUUIDKey, "The bodies are only inspected by the operation span")
+
+	// The context of the attempt span is propagated
+	failed := ended[1]
+	assert.Contains(t, traceparent, failed.context.SpanID().String())
+	assert.Equal(t, codes.Error, failed.status)
+	assertAttr(t, failed.attrs, "error.type", attribute.StringValue(otelfacturama.ClassNotFound))
+
+	assert.Equal(t, map[string]int64{
+		"facturama.client.requests": 2,
+		"facturama.client.duration": 2,
+		"facturama.client.errors":   1,
+	}, metrics.Counts())
+}
+
+func TestInstrument(t *testing.T) {
+	srv := facturamatest.NewServer()
+	defer srv.Close()
+
+	spans := newSpanRecorder()
+
+	policy := common.DefaultRetryPolicy()
+	policy.InitialBackoff = time.Millisecond
+	client := multiemissor.NewClient(srv.Username, srv.Password,
+		common.WithBaseURL(srv.URL),
+		common.WithRetry(policy),
+		otelfacturama.Instrument(
+			otelfacturama.WithTracerProvider(spans),
+			otelfacturama.WithMeterProvider(metricnoop.NewMeterProvider()),
+		),
+	)
+
+	// The attempts are children of the request span
+	srv.InjectFailure(facturamatest.Failure{Method: http.MethodGet, StatusCode: http.StatusServiceUnavailable, Times: 2})
+	_, err := client.ListCSDs(context.Background())
+	require.NoError(t, err)
+
+	ended := spans.Ended()
+	require.Len(t, ended, 4)
+
+	operation := ended[3]
+	assert.Equal(t, "multiemissor.ListCSDs", operation.name)
+	assert.Equal(t, trace.SpanKindInternal, operation.kind)
+	assert.False(t, operation.parent.IsValid())
+	assert.Equal(t, codes.Unset, operation.status)
+
+	for i, attempt := range ended[:3] {
+		assert.Equal(t, http.MethodGet, attempt.name)
+		assert.Equal(t, trace.SpanKindClient, attempt.kind)
+		assert.Equal(t, operation.context.SpanID(), attempt.parent, i)
+		assertAttr(t, attempt.attrs, "facturama.attempt", attribute.IntValue(i+1))
+	}
+	assert.Equal(t, codes.Error, ended[0].status)
+	assertAttr(t, ended[2].attrs, "http.request.resend_count", attribute.IntValue(2))
+
+	// The CFDI attributes are recorded on the request span
+	id := srv.AddCfdi(models.CfdiInfoModel{
+		Folio:  "100",
+		Issuer: models.TaxEntityInfoViewModel{Rfc: "EKU9003173C9"},
+	})
+	stored, _ := srv.Cfdi(id)
+
+	_, err = client.GetCfdiById(context.Background(), multiemissor.GetCfdiByIdRequest{ID: id})
+	require.NoError(t, err)
+
+	ended = spans.Ended()
+	require.Len(t, ended, 6)
+	operation = ended[5]
+	assertAttr(t, operation.attrs, "facturama.cfdi.uuid", attribute.StringValue(stored.Complement.TaxStamp.UUID))
+	assertAttr(t, operation.attrs, "facturama.issuer.rfc", attribute.StringValue("EKU9003173C9"))
+	assert.NotContains(t, ended[4].attrs, otelfacturama.CfdiUUIDKey)
+
+	// The request span fails with its last attempt
+	srv.InjectFailure(facturamatest.Failure{Method: http.MethodGet, StatusCode: http.StatusBadRequest})
+	_, err = client.ListCSDs(context.Background())
+	require.Error(t, err)
+
+	ended = spans.Ended()
+	require.Len(t, ended, 8)
+	assert.Equal(t, ended[7].context.SpanID(), ended[6].parent)
+	assert.Equal(t, codes.Error, ended[7].status)
+	assertAttr(t, ended[7].attrs, "error.type", attribute.StringValue(otelfacturama.ClassInvalid))
+}
+
+func TestErrorClass(t *testing.T) {
+	srv := facturamatest.NewServer()
+	defer srv.Close()
+
+	client := common.NewClient(srv.Username, srv.Password, common.WithBaseURL(srv.URL))
+
+	cases := map[int]string{
+		http.StatusServiceUnavailable: otelfacturama.ClassUnavailable,
+		http.StatusTooManyRequests:    otelfacturama.ClassRateLimited,
+		http.StatusUnauthorized:       otelfacturama.ClassAuth,
+		http.StatusBadRequest:         otelfacturama.ClassInvalid,
+	}
+	for status, class := range cases {
+		srv.InjectFailure(facturamatest.Failure{StatusCode: status})
+		err := client.Get(context.Background(), "/api-lite/csds", nil)
+		assert.Equal(t, class, otelfacturama.ErrorClass(err), status)
+	}
+
+	srv.InjectFailure(facturamatest.Failure{
+		StatusCode: http.StatusBadRequest,
+		Response:   &common.ErrorResponse{Message: "CFDI40145 - El RFC del receptor no es válido."},
+	})
+	err := client.Get(context.Background(), "/api-lite/csds", nil)
+	assert.Equal(t, otelfacturama.ClassSATRejection, otelfacturama.ErrorClass(err))
+}
+
+func assertAttr(t *testing.T, attrs map[attribute.Key]attribute.Value, key string, expected attribute.Value) {
+	t.Helper()
+
+	value, ok := attrs[attribute.Key(key)]
+	require.True(t, ok, key)
+	assert.Equal(t, expected, value, key)
+}
+
+// spanRecorder is a TracerProvider that records the spans, built on the API
+// only so the tests do not depend on the SDK
+type spanRecorder struct {
+	trace.TracerProvider
+
+	mu    sync.Mutex
+	next  byte
+	ended []*recordedSpan
+}
+
+func newSpanRecorder() *spanRecorder {
+	return &spanRecorder{TracerProvider: noop.NewTracerProvider()}
+}
+
+func (r *spanRecorder) Tracer(string, ...trace.TracerOption) trace.Tracer {
+	return recordingTracer{Tracer: noop.NewTracerProvider().Tracer(""), recorder: r}
+}
+
+// Ended returns the ended spans in the order they ended
+func (r *spanRecorder) Ended() []*recordedSpan {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]*recordedSpan(nil), r.ended...)
+}
+
+type recordingTracer struct {
+	trace.Tracer
+	recorder *spanRecorder
+}
+
+func (t recordingTracer) Start(ctx context.Context, name string, options ...trace.SpanStartOption) (context.Context, trace.Span) {
+	cfg := trace.NewSpanStartConfig(options...)
+	parent := trace.SpanContextFromContext(ctx)
+
+	t.recorder.mu.Lock()
+	t.recorder.next++
+	spanID := trace.SpanID{t.recorder.next}
+	t.recorder.mu.Unlock()
+
+	traceID := parent.TraceID()
+	if !parent.IsValid() {
+		traceID = trace.TraceID{spanID[0]}
+	}
+
+	span := &recordedSpan{
+		Span:     noop.Span{},
+		recorder: t.recorder,
+		name:     name,
+		kind:     cfg.SpanKind(),
+		parent:   parent.SpanID(),
+		context:  trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID, TraceFlags: trace.FlagsSampled}),
+		attrs:    make(map[attribute.Key]attribute.Value),
+	}
+	span.SetAttributes(cfg.Attributes()...)
+
+	return trace.ContextWithSpan(ctx, span), span
+}
+
+type recordedSpan struct {
+	trace.Span
+	recorder *spanRecorder
+
+	name    string
+	kind    trace.SpanKind
+	parent  trace.SpanID
+	context trace.SpanContext
+	attrs   map[attribute.Key]attribute.Value
+	status  codes.Code
+}
+
+func (s *recordedSpan) SpanContext() trace.SpanContext { return s.context }
+
+func (s *recordedSpan) IsRecording() bool { return true }
+
+func (s *recordedSpan) SetStatus(code codes.Code, _ string) { s.status = code }
+
+func (s *recordedSpan) SetAttributes(attrs ...attribute.KeyValue) {
+	for _, attr := range attrs {
+		s.attrs[attr.Key] = attr.Value
+	}
+}
+
+func (s *recordedSpan) End(...trace.SpanEndOption) {
+	s.recorder.mu.Lock()
+	defer s.recorder.mu.Unlock()
+
+	s.recorder.ended = append(s.recorder.ended, s)
+}
+
+// metricRecorder is a MeterProvider that counts the measurements of each
+// instrument
+type metricRecorder struct {
+	metric.MeterProvider
+
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+func newMetricRecorder() *metricRecorder {
+	return &metricRecorder{MeterProvider: metricnoop.NewMeterProvider(), counts: make(map[string]int64)}
+}
+
+func (r *metricRecorder) Meter(string, ...metric.MeterOption) metric.Meter {
+	return recordingMeter{Meter: metricnoop.Meter{}, recorder: r}
+}
+
+// Counts returns the sum of the counters and the number of histogram records
+func (r *metricRecorder) Counts() map[string]int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	counts := make(map[string]int64, len(r.counts))
+	for name, count := range r.counts {
+		counts[name] = count
+	}
+
+	return counts
+}
+
+func (r *metricRecorder) add(name string, value int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.counts[name] += value
+}
+
+type recordingMeter struct {
+	metric.Meter
+	recorder *metricRecorder
+}
+
+func (m recordingMeter) Int64Counter(name string, _ ...metric.Int64CounterOption) (metric.Int64Counter, error) {
+	return recordingCounter{Int64Counter: metricnoop.Int64Counter{}, name: name, recorder: m.recorder}, nil
+}
+
+func (m recordingMeter) Float64Histogram(name string, _ ...metric.Float64HistogramOption) (metric.Float64Histogram, error) {
+	return recordingHistogram{Float64Histogram: metricnoop.Float64Histogram{}, name: name, recorder: m.recorder}, nil
+}
+
+type recordingCounter struct {
+	metric.Int64Counter
+	name     string
+	recorder *metricRecorder
+}
+
+func (c recordingCounter) Add(_ context.Context, value int64, _ ...metric.AddOption) {
+	c.recorder.add(c.name, value)
+}
+
+type recordingHistogram struct {
+	metric.Float64Histogram
+	name     string
+	recorder *metricRecorder
+}
+
+func (h recordingHistogram) Record(context.Context, float64, ...metric.RecordOption) {
+	h.recorder.add(h.name, 1)
+}
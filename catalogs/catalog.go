@@ -0,0 +1,174 @@
+package catalogs
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/vanclief/ez"
+)
+
+// Entry is a row of a catalog
+type Entry struct {
+	Code        string
+	Description string
+	// Fields holds the extra columns of the catalog by header name, e.g.
+	// "fisica" and "moral" in c_RegimenFiscal
+	Fields map[string]string
+}
+
+// Field returns the value of an extra column, or "" if the column does not exist
+func (e Entry) Field(name string) string {
+	return e.Fields[name]
+}
+
+// Flag reports whether an extra column holds "Sí", the SAT notation for yes
+func (e Entry) Flag(name string) bool {
+	value := strings.TrimSpace(e.Fields[name])
+	return strings.EqualFold(value, "Sí") || strings.EqualFold(value, "Si")
+}
+
+// List returns an extra column holding comma separated values, e.g. the
+// receiver regimes of c_UsoCFDI
+func (e Entry) List(name string) []string {
+	var values []string
+	for _, value := range strings.Split(e.Fields[name], ",") {
+		value = strings.TrimSpace(value)
+		if value != "" {
+			values = append(values, value)
+		}
+	}
+
+	return values
+}
+
+// Catalog is a SAT catalog indexed by code
+type Catalog struct {
+	Name    string
+	Version string
+	// Complete is false when only a subset of the catalog is available, in
+	// which case codes are validated by their format only, even the codes
+	// missing from the subset
+	Complete bool
+	Columns  []string
+
+	entries []Entry
+	index   map[string]int
+}
+
+// Parse reads a catalog from CSV. The first row is the header, the first two
+// columns are the code and the description and any other column is exposed in
+// Entry.Fields. The parsed catalog is marked as complete.
+func Parse(name, version string, r io.Reader) (*Catalog, error) {
+	const op = "catalogs.Parse"
+
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, ez.New(op, ez.EINVALID, fmt.Sprintf("Catalog %s has no header", name), err)
+	}
+	if len(header) < 2 {
+		return nil, ez.New(op, ez.EINVALID, fmt.Sprintf("Catalog %s must have code and description columns", name), nil)
+	}
+
+	catalog := &Catalog{
+		Name:     name,
+		Version:  version,
+		Complete: true,
+		Columns:  header,
+		index:    make(map[string]int),
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, ez.New(op, ez.EINVALID, fmt.Sprintf("Catalog %s is not valid CSV", name), err)
+		}
+
+		entry := Entry{
+			Code:        strings.TrimSpace(record[0]),
+			Description: strings.TrimSpace(record[1]),
+		}
+		if entry.Code == "" {
+			continue
+		}
+
+		if len(header) > 2 {
+			entry.Fields = make(map[string]string, len(header)-2)
+			for i := 2; i < len(header); i++ {
+				entry.Fields[header[i]] = strings.TrimSpace(record[i])
+			}
+		}
+
+		if _, ok := catalog.index[entry.Code]; ok {
+			return nil, ez.New(op, ez.EINVALID, fmt.Sprintf("Catalog %s has duplicated code %s", name, entry.Code), nil)
+		}
+
+		catalog.index[entry.Code] = len(catalog.entries)
+		catalog.entries = append(catalog.entries, entry)
+	}
+
+	return catalog, nil
+}
+
+// Lookup returns the entry for a code
+func (c *Catalog) Lookup(code string) (Entry, bool) {
+	i, ok := c.index[strings.TrimSpace(code)]
+	if !ok {
+		return Entry{}, false
+	}
+
+	return c.entries[i], true
+}
+
+// Contains reports whether the code is in the catalog
+func (c *Catalog) Contains(code string) bool {
+	_, ok := c.Lookup(code)
+	return ok
+}
+
+// Len returns the number of entries of the catalog
+func (c *Catalog) Len() int {
+	return len(c.entries)
+}
+
+// Entries returns a copy of the entries in catalog order
+func (c *Catalog) Entries() []Entry {
+	return append([]Entry(nil), c.entries...)
+}
+
+// Search returns up to limit entries whose code starts with the query or whose
+// description contains it, ignoring case and accents. A limit <= 0 returns
+// every match.
+func (c *Catalog) Search(query string, limit int) []Entry {
+	query = fold(strings.TrimSpace(query))
+
+	var matches []Entry
+	for _, entry := range c.entries {
+		if limit > 0 && len(matches) >= limit {
+			break
+		}
+
+		if strings.HasPrefix(fold(entry.Code), query) || strings.Contains(fold(entry.Description), query) {
+			matches = append(matches, entry)
+		}
+	}
+
+	return matches
+}
+
+// folder removes the Spanish accents
+var folder = strings.NewReplacer(
+	"á", "a", "é", "e", "í", "i", "ó", "o", "ú", "u", "ü", "u", "ñ", "n",
+)
+
+// fold normalizes text for case and accent insensitive comparisons
+func fold(s string) string {
+	return folder.Replace(strings.ToLower(s))
+}
@@ -0,0 +1,304 @@
+// Package catalogs embeds the SAT CFDI 4.0 catalogs (catCFDI) so invoices can
+// be validated offline, and lets applications replace them with newer
+// versions published by the SAT.
+//
+// The c_ClaveProdServ, c_ClaveUnidad and c_CodigoPostal catalogs are not
+// embedded in full. Until the full catalogs are loaded with LoadDir, LoadFS
+// or Register, IsValid and Validate only check the format of their codes: any
+// 8-digit product code or 5-digit zip code is accepted. The catalogsgen
+// command converts the catCFDI workbook published by the SAT into compressed
+// files for LoadDir and LoadFS:
+//
+//	go run github.com/vanclief/go-facturama/catalogs/cmd/catalogsgen -version 2025.3 -out catalogs c_*.csv
+package catalogs
+
+import (
+	"compress/gzip"
+	"embed"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/vanclief/ez"
+)
+
+// Catalog names, as published by the SAT
+const (
+	ClaveProdServ     = "c_ClaveProdServ"
+	ClaveUnidad       = "c_ClaveUnidad"
+	CodigoPostal      = "c_CodigoPostal"
+	Exportacion       = "c_Exportacion"
+	FormaPago         = "c_FormaPago"
+	Impuesto          = "c_Impuesto"
+	Meses             = "c_Meses"
+	MetodoPago        = "c_MetodoPago"
+	Moneda            = "c_Moneda"
+	ObjetoImp         = "c_ObjetoImp"
+	Pais              = "c_Pais"
+	Periodicidad      = "c_Periodicidad"
+	RegimenFiscal     = "c_RegimenFiscal"
+	TipoDeComprobante = "c_TipoDeComprobante"
+	TipoFactor        = "c_TipoFactor"
+	TipoRelacion      = "c_TipoRelacion"
+	UsoCFDI           = "c_UsoCFDI"
+)
+
+// EmbeddedVersion identifies the catalog data compiled into the package. The
+// catalogs that are not complete report PartialVersion instead, since they
+// are not the SAT release.
+const EmbeddedVersion = "2024.1"
+
+// PartialVersion is the version of the embedded catalogs that only ship a
+// few codes
+const PartialVersion = "partial"
+
+//go:embed data/*.csv
+var data embed.FS
+
+var (
+	// partial are the embedded catalogs that only ship a few codes for Lookup
+	// and Search, the full versions have tens of thousands of rows and are
+	// meant to be loaded with LoadDir or Register
+	partial = map[string]bool{
+		ClaveProdServ: true,
+		ClaveUnidad:   true,
+		CodigoPostal:  true,
+	}
+
+	// formats are the only check of the codes of catalogs that are not complete
+	formats = map[string]*regexp.Regexp{
+		ClaveProdServ: regexp.MustCompile(`^[0-9]{8}$`),
+		ClaveUnidad:   regexp.MustCompile(`^[A-Z0-9]{1,3}$`),
+		CodigoPostal:  regexp.MustCompile(`^[0-9]{5}$`),
+	}
+
+	mu       sync.RWMutex
+	registry = make(map[string]*Catalog)
+)
+
+func init() {
+	entries, err := data.ReadDir("data")
+	if err != nil {
+		panic(err)
+	}
+
+	for _, file := range entries {
+		name := strings.TrimSuffix(file.Name(), ".csv")
+
+		f, err := data.Open("data/" + file.Name())
+		if err != nil {
+			panic(err)
+		}
+
+		version := EmbeddedVersion
+		if partial[name] {
+			version = PartialVersion
+		}
+
+		catalog, err := Parse(name, version, f)
+		f.Close()
+		if err != nil {
+			panic(err)
+		}
+
+		catalog.Complete = !partial[name]
+		registry[name] = catalog
+	}
+}
+
+// Get returns the catalog with the given name
+func Get(name string) (*Catalog, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	catalog, ok := registry[name]
+	return catalog, ok
+}
+
+// Names returns the names of the available catalogs, sorted
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Lookup returns the entry for a code of the named catalog
+func Lookup(name, code string) (Entry, bool) {
+	catalog, ok := Get(name)
+	if !ok {
+		return Entry{}, false
+	}
+
+	return catalog.Lookup(code)
+}
+
+// Search searches the named catalog, see Catalog.Search
+func Search(name, query string, limit int) []Entry {
+	catalog, ok := Get(name)
+	if !ok {
+		return nil
+	}
+
+	return catalog.Search(query, limit)
+}
+
+// IsValid reports whether a code is valid for the named catalog. The codes of
+// incomplete catalogs are only checked against the format of the catalog
+// codes, so a code that has the format is valid even if the SAT did not
+// publish it.
+func IsValid(name, code string) bool {
+	catalog, ok := Get(name)
+	if !ok {
+		return false
+	}
+
+	if catalog.Contains(code) {
+		return true
+	}
+
+	if catalog.Complete {
+		return false
+	}
+
+	format, ok := formats[name]
+	return ok && format.MatchString(code)
+}
+
+// Validate returns an EINVALID error naming the field when the code is not
+// valid for the named catalog, see IsValid
+func Validate(name, field, code string) error {
+	const op = "catalogs.Validate"
+
+	if IsValid(name, code) {
+		return nil
+	}
+
+	if !IsComplete(name) {
+		return ez.New(op, ez.EINVALID, fmt.Sprintf("%s %q does not have the format of the SAT catalog %s", field, code, name), nil)
+	}
+
+	return ez.New(op, ez.EINVALID, fmt.Sprintf("%s %q is not in the SAT catalog %s", field, code, name), nil)
+}
+
+// IsComplete reports whether the named catalog has every code, so IsValid
+// checks codes against it instead of their format only
+func IsComplete(name string) bool {
+	catalog, ok := Get(name)
+	return ok && catalog.Complete
+}
+
+// Register adds or replaces a catalog. It is the update mechanism for newer
+// SAT catalogs: parse them with Parse and register them at startup.
+func Register(catalog *Catalog) error {
+	const op = "catalogs.Register"
+
+	if catalog == nil || catalog.Name == "" {
+		return ez.New(op, ez.EINVALID, "Catalog name is required", nil)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	registry[catalog.Name] = catalog
+	return nil
+}
+
+// LoadDir registers every <catalog name>.csv or <catalog name>.csv.gz file of
+// a directory, using the content of the VERSION file of the directory as the
+// catalogs version
+func LoadDir(dir string) error {
+	const op = "catalogs.LoadDir"
+
+	err := LoadFS(os.DirFS(dir), ".")
+	if err != nil {
+		return ez.Wrap(op, err)
+	}
+
+	return nil
+}
+
+// LoadFS is like LoadDir for a directory of a file system, e.g. an embed.FS
+// holding the full catalogs
+func LoadFS(fsys fs.FS, dir string) error {
+	const op = "catalogs.LoadFS"
+
+	version, err := fs.ReadFile(fsys, path.Join(dir, "VERSION"))
+	if err != nil {
+		return ez.New(op, ez.EINVALID, "Catalogs directory must contain a VERSION file", err)
+	}
+
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return ez.New(op, ez.EINTERNAL, "Could not list catalog files", err)
+	}
+
+	var catalogs []*Catalog
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !(strings.HasSuffix(name, ".csv") || strings.HasSuffix(name, ".csv.gz")) {
+			continue
+		}
+
+		catalog, err := loadFile(fsys, path.Join(dir, name), strings.TrimSpace(string(version)))
+		if err != nil {
+			return ez.Wrap(op, err)
+		}
+
+		catalogs = append(catalogs, catalog)
+	}
+
+	// Register only once every file parsed, so a bad file does not leave the
+	// catalogs half updated
+	for _, catalog := range catalogs {
+		err = Register(catalog)
+		if err != nil {
+			return ez.Wrap(op, err)
+		}
+	}
+
+	return nil
+}
+
+// loadFile parses a catalog CSV file named after the catalog, decompressing
+// it when its name ends in .gz
+func loadFile(fsys fs.FS, name, version string) (*Catalog, error) {
+	const op = "catalogs.loadFile"
+
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, ez.New(op, ez.EINTERNAL, fmt.Sprintf("Could not open %s", name), err)
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(name, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, ez.New(op, ez.EINVALID, fmt.Sprintf("%s is not a gzip file", name), err)
+		}
+		defer gz.Close()
+
+		r = gz
+	}
+
+	catalogName := strings.TrimSuffix(strings.TrimSuffix(path.Base(name), ".gz"), ".csv")
+	catalog, err := Parse(catalogName, version, r)
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	return catalog, nil
+}
@@ -0,0 +1,121 @@
+package catalogs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmbeddedCatalogs(t *testing.T) {
+	regime, ok := Lookup(RegimenFiscal, "616")
+	require.True(t, ok)
+	assert.Equal(t, "Sin obligaciones fiscales", regime.Description)
+	assert.True(t, regime.Flag("fisica"))
+	assert.False(t, regime.Flag("moral"))
+
+	use, ok := Lookup(UsoCFDI, "CN01")
+	require.True(t, ok)
+	assert.Equal(t, []string{"605"}, use.List("regimenes"))
+
+	currency, ok := Lookup(Moneda, "JPY")
+	require.True(t, ok)
+	assert.Equal(t, "0", currency.Field("decimales"))
+
+	assert.True(t, IsValid(FormaPago, "03"))
+	assert.False(t, IsValid(FormaPago, "07"))
+	assert.True(t, IsValid(Pais, "USA"))
+	assert.False(t, IsValid("c_Unknown", "01"))
+
+	for _, name := range Names() {
+		catalog, _ := Get(name)
+		if catalog.Complete {
+			assert.Equal(t, EmbeddedVersion, catalog.Version, name)
+		} else {
+			assert.Equal(t, PartialVersion, catalog.Version, name, "partial data is not labelled as the SAT release")
+		}
+	}
+}
+
+func TestPartialCatalogs(t *testing.T) {
+	catalog, ok := Get(ClaveProdServ)
+	require.True(t, ok)
+	assert.False(t, catalog.Complete)
+
+	// Codes of a partial catalog are only validated by format
+	assert.False(t, IsComplete(ClaveProdServ))
+	assert.True(t, IsComplete(FormaPago))
+	assert.True(t, IsValid(ClaveProdServ, "84111506"))
+	assert.True(t, IsValid(ClaveProdServ, "43231500"))
+	assert.False(t, IsValid(ClaveProdServ, "4323150"))
+	assert.True(t, IsValid(ClaveUnidad, "XYZ"))
+	assert.False(t, IsValid(ClaveUnidad, "pieza"))
+	assert.True(t, IsValid(CodigoPostal, "78116"))
+	assert.True(t, IsValid(CodigoPostal, "00000"))
+	err := Validate(CodigoPostal, "ExpeditionPlace", "7811")
+	assert.ErrorContains(t, err, "format")
+}
+
+func TestSearch(t *testing.T) {
+	results := Search(FormaPago, "TARJETA", 0)
+	require.Len(t, results, 3)
+	assert.Equal(t, "04", results[0].Code)
+
+	// Accents are ignored
+	results = Search(RegimenFiscal, "regimen simplificado", 1)
+	require.Len(t, results, 1)
+	assert.Equal(t, "626", results[0].Code)
+
+	assert.Len(t, Search(Pais, "M", 2), 2)
+}
+
+func TestLoadDir(t *testing.T) {
+	original, _ := Get(CodigoPostal)
+	defer Register(original)
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "VERSION"), []byte("2025.3\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, CodigoPostal+".csv"), []byte(
+		"code,description,estado,municipio,localidad\n78116,,SLP,028,\n"), 0o644))
+
+	require.NoError(t, LoadDir(dir))
+
+	catalog, ok := Get(CodigoPostal)
+	require.True(t, ok)
+	assert.True(t, catalog.Complete)
+	assert.Equal(t, "2025.3", catalog.Version)
+	assert.True(t, IsValid(CodigoPostal, "78116"))
+	assert.False(t, IsValid(CodigoPostal, "00000"), "a complete catalog rejects unknown codes")
+
+	entry, _ := catalog.Lookup("78116")
+	assert.Equal(t, "SLP", entry.Field("estado"))
+
+	// Compressed catalogs
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write([]byte("code,description,estado,municipio,localidad\n01000,,DIF,010,\n"))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	require.NoError(t, os.Remove(filepath.Join(dir, CodigoPostal+".csv")))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, CodigoPostal+".csv.gz"), buf.Bytes(), 0o644))
+
+	require.NoError(t, LoadDir(dir))
+	assert.True(t, IsValid(CodigoPostal, "01000"))
+	assert.False(t, IsValid(CodigoPostal, "78116"))
+}
+
+func TestParseErrors(t *testing.T) {
+	_, err := Parse("c_Test", "1", strings.NewReader("code\n01\n"))
+	assert.Error(t, err)
+
+	_, err = Parse("c_Test", "1", strings.NewReader("code,description\n01,A\n01,B\n"))
+	assert.Error(t, err)
+
+	_, err = Parse("c_Test", "1", strings.NewReader("code,description\n01,A,extra\n"))
+	assert.Error(t, err)
+}
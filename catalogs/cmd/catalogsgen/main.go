@@ -0,0 +1,230 @@
+// Command catalogsgen converts the sheets of the catCFDI workbook published by
+// the SAT into a directory for catalogs.LoadDir or catalogs.LoadFS.
+//
+// Export every sheet of the workbook to a UTF-8 CSV file, e.g. with
+// LibreOffice, and run:
+//
+//	catalogsgen -version 2025.3 -out catalogs c_*.csv
+//
+// The rows above the header of each sheet, such as the catalog version, are
+// skipped. Sheets split in parts, such as c_CodigoPostal_Parte_1 and
+// c_CodigoPostal_Parte_2, are merged. Every catalog is written as a gzip
+// compressed <catalog name>.csv.gz file with the code, description and extra
+// columns, along with the VERSION file.
+package main
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// widths are the digits of the numeric codes of catalogs whose leading zeros
+// are lost when the workbook stores them as numbers
+var widths = map[string]int{
+	"c_ClaveProdServ": 8,
+	"c_CodigoPostal":  5,
+}
+
+// sheet is a catalog read from an exported sheet
+type sheet struct {
+	name   string
+	header []string
+	rows   [][]string
+}
+
+func main() {
+	version := flag.String("version", "", "version of the catalogs, written to the VERSION file")
+	out := flag.String("out", ".", "output directory")
+	flag.Parse()
+
+	if *version == "" || flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: catalogsgen -version <version> [-out <dir>] <sheet.csv>...")
+		os.Exit(2)
+	}
+
+	err := generate(*out, *version, flag.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "catalogsgen:", err)
+		os.Exit(1)
+	}
+}
+
+// generate converts the sheets and writes the catalogs and VERSION file to dir
+func generate(dir, version string, paths []string) error {
+	catalogs := make(map[string]*sheet)
+
+	for _, path := range paths {
+		s, err := readSheet(path)
+		if err != nil {
+			return err
+		}
+
+		catalog, ok := catalogs[s.name]
+		if !ok {
+			catalogs[s.name] = s
+			continue
+		}
+
+		if strings.Join(catalog.header, ",") != strings.Join(s.header, ",") {
+			return fmt.Errorf("%s: the columns differ from the other parts of %s", path, s.name)
+		}
+		catalog.rows = append(catalog.rows, s.rows...)
+	}
+
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(catalogs))
+	for name := range catalogs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		err = writeCatalog(filepath.Join(dir, name+".csv.gz"), catalogs[name])
+		if err != nil {
+			return err
+		}
+	}
+
+	return os.WriteFile(filepath.Join(dir, "VERSION"), []byte(version+"\n"), 0o644)
+}
+
+// readSheet reads an exported sheet, mapping its columns to the code,
+// description and extra columns expected by catalogs.Parse
+func readSheet(path string) (*sheet, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	// The header is the first row that starts with the catalog name
+	start := -1
+	for i, record := range records {
+		if len(record) > 0 && strings.HasPrefix(clean(record[0]), "c_") {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		return nil, fmt.Errorf("%s: no header row starting with the catalog name", path)
+	}
+
+	columns := records[start]
+	s := &sheet{name: clean(columns[0])}
+
+	// Units are described by their name, the other catalogs by their description
+	description := -1
+	for i, column := range columns {
+		switch clean(column) {
+		case "Nombre":
+			description = i
+		case "Descripción", "Descripcion":
+			if description < 0 {
+				description = i
+			}
+		}
+	}
+
+	var extras []int
+	s.header = []string{"code", "description"}
+	for i := 1; i < len(columns); i++ {
+		name := strings.ToLower(strings.TrimPrefix(clean(columns[i]), "c_"))
+		if i == description || name == "" {
+			continue
+		}
+
+		extras = append(extras, i)
+		s.header = append(s.header, name)
+	}
+
+	for _, record := range records[start+1:] {
+		code := cell(record, 0)
+		if code == "" {
+			continue
+		}
+		if width := widths[s.name]; len(code) < width {
+			code = strings.Repeat("0", width-len(code)) + code
+		}
+
+		row := []string{code, cell(record, description)}
+		for _, i := range extras {
+			row = append(row, cell(record, i))
+		}
+		s.rows = append(s.rows, row)
+	}
+
+	return s, nil
+}
+
+// writeCatalog writes a sheet as a gzip compressed CSV file
+func writeCatalog(path string, s *sheet) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	err = writeCSV(gz, s)
+	if err != nil {
+		return err
+	}
+
+	err = gz.Close()
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// writeCSV writes the header and rows of a sheet
+func writeCSV(w io.Writer, s *sheet) error {
+	writer := csv.NewWriter(w)
+
+	err := writer.Write(s.header)
+	if err != nil {
+		return err
+	}
+
+	err = writer.WriteAll(s.rows)
+	if err != nil {
+		return err
+	}
+
+	return writer.Error()
+}
+
+// cell returns a trimmed cell of a record, or "" if it does not exist
+func cell(record []string, i int) string {
+	if i < 0 || i >= len(record) {
+		return ""
+	}
+
+	return clean(record[i])
+}
+
+// clean trims the spaces and byte order mark of a cell
+func clean(value string) string {
+	return strings.TrimSpace(strings.TrimPrefix(value, "\ufeff"))
+}
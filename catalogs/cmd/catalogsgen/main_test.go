@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vanclief/go-facturama/catalogs"
+)
+
+func TestGenerate(t *testing.T) {
+	original, _ := catalogs.Get(catalogs.CodigoPostal)
+	defer catalogs.Register(original)
+	units, _ := catalogs.Get(catalogs.ClaveUnidad)
+	defer catalogs.Register(units)
+
+	sheets := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(sheets, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+		return path
+	}
+
+	// Sheets as exported from the workbook: version rows above the header and
+	// zip codes stored as numbers
+	paths := []string{
+		write("c_CodigoPostal_Parte_1.csv", "\ufeffCatálogo de códigos postales,,,,\nVersión,2025.3,,,\nc_CodigoPostal,c_Estado,c_Municipio,c_Localidad,Estímulo Franja Fronteriza\n1000,DIF,010,,0\n"),
+		write("c_CodigoPostal_Parte_2.csv", "c_CodigoPostal,c_Estado,c_Municipio,c_Localidad,Estímulo Franja Fronteriza\n78116,SLP,028,,0\n"),
+		write("c_ClaveUnidad.csv", "c_ClaveUnidad,Nombre,Descripción,Símbolo\nH87,Pieza,Una unidad de conteo,\n,,,\n"),
+	}
+
+	out := filepath.Join(t.TempDir(), "catalogs")
+	require.NoError(t, generate(out, "2025.3", paths))
+	require.NoError(t, catalogs.LoadDir(out))
+
+	catalog, ok := catalogs.Get(catalogs.CodigoPostal)
+	require.True(t, ok)
+	assert.Equal(t, "2025.3", catalog.Version)
+	assert.True(t, catalog.Complete)
+
+	entry, ok := catalog.Lookup("01000")
+	require.True(t, ok, "the leading zero is restored")
+	assert.Equal(t, "DIF", entry.Field("estado"))
+	assert.Equal(t, "010", entry.Field("municipio"))
+	assert.True(t, catalogs.IsValid(catalogs.CodigoPostal, "78116"))
+
+	unit, ok := catalogs.Lookup(catalogs.ClaveUnidad, "H87")
+	require.True(t, ok)
+	assert.Equal(t, "Pieza", unit.Description)
+	assert.Equal(t, "Una unidad de conteo", unit.Field("descripción"))
+
+	// Parts of a catalog must have the same columns
+	mismatched := write("c_CodigoPostal_Parte_3.csv", "c_CodigoPostal,c_Estado\n99999,YUC\n")
+	assert.Error(t, generate(out, "2025.3", append(paths, mismatched)))
+}
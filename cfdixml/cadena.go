@@ -0,0 +1,394 @@
+package cfdixml
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+
+	"github.com/vanclief/ez"
+)
+
+// cadena builds a cadena original: the values of the sealed attributes in the
+// order of the SAT XSLT, separated by pipes and enclosed in double pipes
+type cadena struct {
+	b strings.Builder
+}
+
+// required adds a value, with its whitespace normalized as by the XSLT
+// normalize-space function
+func (c *cadena) required(value string) {
+	c.b.WriteByte('|')
+	c.b.WriteString(strings.Join(strings.Fields(value), " "))
+}
+
+// optional adds a value if the attribute is present
+func (c *cadena) optional(value string) {
+	if value != "" {
+		c.required(value)
+	}
+}
+
+// String returns the cadena original
+func (c *cadena) String() string {
+	return "|" + c.b.String() + "||"
+}
+
+// CadenaOriginal returns the cadena original of the CFDI, the string its
+// Sello signs, as built by the SAT cadenaoriginal_4_0 XSLT. The Sello,
+// Certificado, the TimbreFiscalDigital and the addenda are not part of it.
+// The Pagos 2.0, ImpLocal and LeyendasFiscales complements and the iedu item
+// complement are supported, other complements return an ENOTIMPLEMENTED
+// error.
+func (c *Comprobante) CadenaOriginal() (string, error) {
+	const op = "cfdixml.Comprobante.CadenaOriginal"
+
+	err := c.checkComplements()
+	if err != nil {
+		return "", ez.Wrap(op, err)
+	}
+
+	var s cadena
+
+	s.required(c.Version)
+	s.optional(c.Serie)
+	s.optional(c.Folio)
+	s.required(c.Fecha)
+	s.optional(c.FormaPago)
+	s.required(c.NoCertificado)
+	s.optional(c.CondicionesDePago)
+	s.required(c.SubTotal)
+	s.optional(c.Descuento)
+	s.required(c.Moneda)
+	s.optional(c.TipoCambio)
+	s.required(c.Total)
+	s.required(c.TipoDeComprobante)
+	s.required(c.Exportacion)
+	s.optional(c.MetodoPago)
+	s.required(c.LugarExpedicion)
+	s.optional(c.Confirmacion)
+
+	if global := c.InformacionGlobal; global != nil {
+		s.required(global.Periodicidad)
+		s.required(global.Meses)
+		s.required(global.Año)
+	}
+
+	for _, relacionados := range c.CfdiRelacionados {
+		s.required(relacionados.TipoRelacion)
+		for _, relacionado := range relacionados.CfdiRelacionado {
+			s.required(relacionado.UUID)
+		}
+	}
+
+	s.required(c.Emisor.Rfc)
+	s.required(c.Emisor.Nombre)
+	s.required(c.Emisor.RegimenFiscal)
+	s.optional(c.Emisor.FacAtrAdquirente)
+
+	s.required(c.Receptor.Rfc)
+	s.required(c.Receptor.Nombre)
+	s.required(c.Receptor.DomicilioFiscalReceptor)
+	s.optional(c.Receptor.ResidenciaFiscal)
+	s.optional(c.Receptor.NumRegIdTrib)
+	s.required(c.Receptor.RegimenFiscalReceptor)
+	s.required(c.Receptor.UsoCFDI)
+
+	for _, concepto := range c.Conceptos {
+		concepto.cadena(&s)
+	}
+
+	if impuestos := c.Impuestos; impuestos != nil {
+		for _, retencion := range impuestos.Retenciones {
+			s.required(retencion.Impuesto)
+			s.required(retencion.Importe)
+		}
+		s.optional(impuestos.TotalImpuestosRetenidos)
+		for _, traslado := range impuestos.Traslados {
+			traslado.cadena(&s)
+		}
+		s.optional(impuestos.TotalImpuestosTrasladados)
+	}
+
+	if c.Complemento != nil {
+		for i := range c.Complemento.Others {
+			complement := &c.Complemento.Others[i]
+			complementTemplates[complement.XMLName](&s, complement)
+		}
+	}
+
+	return s.String(), nil
+}
+
+// cadena adds the item to a cadena original
+func (concepto *Concepto) cadena(s *cadena) {
+	s.required(concepto.ClaveProdServ)
+	s.optional(concepto.NoIdentificacion)
+	s.required(concepto.Cantidad)
+	s.required(concepto.ClaveUnidad)
+	s.optional(concepto.Unidad)
+	s.required(concepto.Descripcion)
+	s.required(concepto.ValorUnitario)
+	s.required(concepto.Importe)
+	s.optional(concepto.Descuento)
+	s.required(concepto.ObjetoImp)
+
+	if impuestos := concepto.Impuestos; impuestos != nil {
+		for _, traslado := range impuestos.Traslados {
+			traslado.cadena(s)
+		}
+		for _, retencion := range impuestos.Retenciones {
+			s.required(retencion.Base)
+			s.required(retencion.Impuesto)
+			s.required(retencion.TipoFactor)
+			s.required(retencion.TasaOCuota)
+			s.required(retencion.Importe)
+		}
+	}
+
+	if terceros := concepto.ACuentaTerceros; terceros != nil {
+		s.required(terceros.RfcACuentaTerceros)
+		s.required(terceros.NombreACuentaTerceros)
+		s.required(terceros.RegimenFiscalACuentaTerceros)
+		s.required(terceros.DomicilioFiscalACuentaTerceros)
+	}
+
+	for _, aduana := range concepto.InformacionAduanera {
+		s.required(aduana.NumeroPedimento)
+	}
+
+	for _, predial := range concepto.CuentaPredial {
+		s.required(predial.Numero)
+	}
+
+	if concepto.ComplementoConcepto != nil {
+		for i := range concepto.ComplementoConcepto.Elements {
+			complement := &concepto.ComplementoConcepto.Elements[i]
+			itemComplementTemplates[complement.XMLName](s, complement)
+		}
+	}
+
+	for _, parte := range concepto.Parte {
+		s.required(parte.ClaveProdServ)
+		s.optional(parte.NoIdentificacion)
+		s.required(parte.Cantidad)
+		s.optional(parte.Unidad)
+		s.required(parte.Descripcion)
+		s.optional(parte.ValorUnitario)
+		s.optional(parte.Importe)
+		for _, aduana := range parte.InformacionAduanera {
+			s.required(aduana.NumeroPedimento)
+		}
+	}
+}
+
+// cadena adds the transferred tax to a cadena original
+func (traslado *Traslado) cadena(s *cadena) {
+	s.required(traslado.Base)
+	s.required(traslado.Impuesto)
+	s.required(traslado.TipoFactor)
+	s.optional(traslado.TasaOCuota)
+	s.optional(traslado.Importe)
+}
+
+// checkComplements returns an ENOTIMPLEMENTED error if the CFDI has
+// complements whose cadena original is not supported
+func (c *Comprobante) checkComplements() error {
+	const op = "cfdixml.Comprobante.checkComplements"
+
+	var unsupported []Element
+	if c.Complemento != nil {
+		for _, complement := range c.Complemento.Others {
+			if complementTemplates[complement.XMLName] == nil {
+				unsupported = append(unsupported, complement)
+			}
+		}
+	}
+	for _, concepto := range c.Conceptos {
+		if concepto.ComplementoConcepto == nil {
+			continue
+		}
+		for _, complement := range concepto.ComplementoConcepto.Elements {
+			if itemComplementTemplates[complement.XMLName] == nil {
+				unsupported = append(unsupported, complement)
+			}
+		}
+	}
+
+	if len(unsupported) == 0 {
+		return nil
+	}
+
+	names := make([]string, len(unsupported))
+	for i, element := range unsupported {
+		names[i] = fmt.Sprintf("{%s}%s", element.XMLName.Space, element.XMLName.Local)
+	}
+
+	msg := fmt.Sprintf("The cadena original of the complements %s is not supported", strings.Join(names, ", "))
+	return ez.New(op, ez.ENOTIMPLEMENTED, msg, nil)
+}
+
+// Namespaces of the complements whose cadena original is supported
+const (
+	NamespacePagos            = "http://www.sat.gob.mx/Pagos20"
+	NamespaceImpLocal         = "http://www.sat.gob.mx/implocal"
+	NamespaceLeyendasFiscales = "http://www.sat.gob.mx/leyendasFiscales"
+	NamespaceIEDU             = "http://www.sat.gob.mx/iedu"
+)
+
+// complementTemplates add the complements of the CFDI to a cadena original,
+// as the templates the cadenaoriginal_4_0 XSLT includes
+var complementTemplates = map[xml.Name]func(*cadena, *Element){
+	{Space: NamespacePagos, Local: "Pagos"}:                       pagosCadena,
+	{Space: NamespaceImpLocal, Local: "ImpuestosLocales"}:         impuestosLocalesCadena,
+	{Space: NamespaceLeyendasFiscales, Local: "LeyendasFiscales"}: leyendasFiscalesCadena,
+}
+
+// itemComplementTemplates add the complements of an item to a cadena original
+var itemComplementTemplates = map[xml.Name]func(*cadena, *Element){
+	{Space: NamespaceIEDU, Local: "instEducativas"}: instEducativasCadena,
+}
+
+// pagosCadena adds a Pagos 2.0 complement, as the SAT Pagos20 XSLT
+func pagosCadena(s *cadena, pagos *Element) {
+	s.required(pagos.Attr("Version"))
+
+	for _, totales := range pagos.ChildrenNamed("Totales") {
+		s.optional(totales.Attr("TotalRetencionesIVA"))
+		s.optional(totales.Attr("TotalRetencionesISR"))
+		s.optional(totales.Attr("TotalRetencionesIEPS"))
+		s.optional(totales.Attr("TotalTrasladosBaseIVA16"))
+		s.optional(totales.Attr("TotalTrasladosImpuestoIVA16"))
+		s.optional(totales.Attr("TotalTrasladosBaseIVA8"))
+		s.optional(totales.Attr("TotalTrasladosImpuestoIVA8"))
+		s.optional(totales.Attr("TotalTrasladosBaseIVA0"))
+		s.optional(totales.Attr("TotalTrasladosImpuestoIVA0"))
+		s.optional(totales.Attr("TotalTrasladosBaseIVAExento"))
+		s.required(totales.Attr("MontoTotalPagos"))
+	}
+
+	for _, pago := range pagos.ChildrenNamed("Pago") {
+		s.required(pago.Attr("FechaPago"))
+		s.required(pago.Attr("FormaDePagoP"))
+		s.required(pago.Attr("MonedaP"))
+		s.optional(pago.Attr("TipoCambioP"))
+		s.required(pago.Attr("Monto"))
+		s.optional(pago.Attr("NumOperacion"))
+		s.optional(pago.Attr("RfcEmisorCtaOrd"))
+		s.optional(pago.Attr("NomBancoOrdExt"))
+		s.optional(pago.Attr("CtaOrdenante"))
+		s.optional(pago.Attr("RfcEmisorCtaBen"))
+		s.optional(pago.Attr("CtaBeneficiario"))
+		s.optional(pago.Attr("TipoCadPago"))
+		s.optional(pago.Attr("CertPago"))
+		s.optional(pago.Attr("CadPago"))
+		s.optional(pago.Attr("SelloPago"))
+
+		for _, docto := range pago.ChildrenNamed("DoctoRelacionado") {
+			s.required(docto.Attr("IdDocumento"))
+			s.optional(docto.Attr("Serie"))
+			s.optional(docto.Attr("Folio"))
+			s.required(docto.Attr("MonedaDR"))
+			s.optional(docto.Attr("EquivalenciaDR"))
+			s.required(docto.Attr("NumParcialidad"))
+			s.required(docto.Attr("ImpSaldoAnt"))
+			s.required(docto.Attr("ImpPagado"))
+			s.required(docto.Attr("ImpSaldoInsoluto"))
+			s.required(docto.Attr("ObjetoImpDR"))
+
+			for _, impuestos := range docto.ChildrenNamed("ImpuestosDR") {
+				for _, retenciones := range impuestos.ChildrenNamed("RetencionesDR") {
+					for _, retencion := range retenciones.ChildrenNamed("RetencionDR") {
+						s.required(retencion.Attr("BaseDR"))
+						s.required(retencion.Attr("ImpuestoDR"))
+						s.required(retencion.Attr("TipoFactorDR"))
+						s.required(retencion.Attr("TasaOCuotaDR"))
+						s.required(retencion.Attr("ImporteDR"))
+					}
+				}
+				for _, traslados := range impuestos.ChildrenNamed("TrasladosDR") {
+					for _, traslado := range traslados.ChildrenNamed("TrasladoDR") {
+						s.required(traslado.Attr("BaseDR"))
+						s.required(traslado.Attr("ImpuestoDR"))
+						s.required(traslado.Attr("TipoFactorDR"))
+						s.optional(traslado.Attr("TasaOCuotaDR"))
+						s.optional(traslado.Attr("ImporteDR"))
+					}
+				}
+			}
+		}
+
+		for _, impuestos := range pago.ChildrenNamed("ImpuestosP") {
+			for _, retenciones := range impuestos.ChildrenNamed("RetencionesP") {
+				for _, retencion := range retenciones.ChildrenNamed("RetencionP") {
+					s.required(retencion.Attr("ImpuestoP"))
+					s.required(retencion.Attr("ImporteP"))
+				}
+			}
+			for _, traslados := range impuestos.ChildrenNamed("TrasladosP") {
+				for _, traslado := range traslados.ChildrenNamed("TrasladoP") {
+					s.required(traslado.Attr("BaseP"))
+					s.required(traslado.Attr("ImpuestoP"))
+					s.required(traslado.Attr("TipoFactorP"))
+					s.optional(traslado.Attr("TasaOCuotaP"))
+					s.optional(traslado.Attr("ImporteP"))
+				}
+			}
+		}
+	}
+}
+
+// impuestosLocalesCadena adds an ImpLocal complement, as the SAT implocal XSLT
+func impuestosLocalesCadena(s *cadena, impuestos *Element) {
+	s.required(impuestos.Attr("version"))
+	s.required(impuestos.Attr("TotaldeRetenciones"))
+	s.required(impuestos.Attr("TotaldeTraslados"))
+
+	for _, retencion := range impuestos.ChildrenNamed("RetencionesLocales") {
+		s.required(retencion.Attr("ImpLocRetenido"))
+		s.required(retencion.Attr("TasadeRetencion"))
+		s.required(retencion.Attr("Importe"))
+	}
+	for _, traslado := range impuestos.ChildrenNamed("TrasladosLocales") {
+		s.required(traslado.Attr("ImpLocTrasladado"))
+		s.required(traslado.Attr("TasadeTraslado"))
+		s.required(traslado.Attr("Importe"))
+	}
+}
+
+// leyendasFiscalesCadena adds a LeyendasFiscales complement, as the SAT
+// leyendasFisc XSLT
+func leyendasFiscalesCadena(s *cadena, leyendas *Element) {
+	s.required(leyendas.Attr("version"))
+
+	for _, leyenda := range leyendas.ChildrenNamed("Leyenda") {
+		s.optional(leyenda.Attr("disposicionFiscal"))
+		s.optional(leyenda.Attr("norma"))
+		s.required(leyenda.Attr("textoLeyenda"))
+	}
+}
+
+// instEducativasCadena adds an iedu item complement, as the SAT iedu XSLT
+func instEducativasCadena(s *cadena, iedu *Element) {
+	s.required(iedu.Attr("version"))
+	s.required(iedu.Attr("nombreAlumno"))
+	s.required(iedu.Attr("CURP"))
+	s.required(iedu.Attr("nivelEducativo"))
+	s.required(iedu.Attr("autRVOE"))
+	s.optional(iedu.Attr("rfcPago"))
+}
+
+// CadenaOriginal returns the cadena original of the stamp, the string its
+// SelloSAT signs, as built by the SAT cadenaoriginal_TFD_1_1 XSLT
+func (tfd *TimbreFiscalDigital) CadenaOriginal() string {
+	var s cadena
+
+	s.required(tfd.Version)
+	s.required(tfd.UUID)
+	s.required(tfd.FechaTimbrado)
+	s.required(tfd.RfcProvCertif)
+	s.optional(tfd.Leyenda)
+	s.required(tfd.SelloCFD)
+	s.required(tfd.NoCertificadoSAT)
+
+	return s.String()
+}
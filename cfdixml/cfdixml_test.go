@@ -0,0 +1,132 @@
+package cfdixml_test
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vanclief/ez"
+	"github.com/vanclief/go-facturama/cfdixml"
+)
+
+func TestParse(t *testing.T) {
+	data, err := os.ReadFile("testdata/cfdi.xml")
+	require.NoError(t, err)
+
+	c, err := cfdixml.Parse(data)
+	require.NoError(t, err)
+
+	assert.Equal(t, cfdixml.Version, c.Version)
+	assert.Equal(t, "1053.33", c.Total)
+	assert.Equal(t, "EKU9003173C9", c.Emisor.Rfc)
+	assert.Equal(t, "UNIVERSIDAD ROBOTICA ESPAÑOLA", c.Receptor.Nombre)
+	require.Len(t, c.CfdiRelacionados, 1)
+	assert.Equal(t, "04", c.CfdiRelacionados[0].TipoRelacion)
+
+	require.Len(t, c.Conceptos, 1)
+	concepto := c.Conceptos[0]
+	assert.Equal(t, "Servicio de facturación & soporte", concepto.Descripcion)
+	assert.Equal(t, "0.160000", concepto.Impuestos.Traslados[0].TasaOCuota)
+	assert.Len(t, concepto.Impuestos.Retenciones, 2)
+
+	assert.Equal(t, "206.67", c.Impuestos.TotalImpuestosRetenidos)
+	assert.Equal(t, "100.00", c.Impuestos.Retenciones[0].Importe)
+	assert.Empty(t, c.Impuestos.Retenciones[0].Base)
+
+	assert.Equal(t, "9F8C1B2A-3D4E-4F50-8A6B-7C8D9E0F1A2B", c.UUID())
+	assert.Equal(t, cfdixml.TFDVersion, c.TimbreFiscalDigital().Version)
+
+	require.Len(t, c.Complemento.Others, 1)
+	leyendas := c.Complemento.Others[0]
+	assert.Equal(t, xml.Name{Space: "http://www.sat.gob.mx/leyendasFiscales", Local: "LeyendasFiscales"}, leyendas.XMLName)
+	assert.Equal(t, []xml.Attr{{Name: xml.Name{Local: "version"}, Value: "1.0"}}, leyendas.Attrs)
+	assert.Len(t, leyendas.Children, 1)
+
+	require.Len(t, c.Addenda.Elements, 1)
+	assert.Equal(t, "Entregar en almacén", c.Addenda.Elements[0].Children[0].Text)
+
+	assert.Equal(t, map[string]string{"leyendasFisc": "http://www.sat.gob.mx/leyendasFiscales"}, c.Namespaces)
+
+	encoded, err := cfdixml.ParseBase64(base64.StdEncoding.EncodeToString(data))
+	require.NoError(t, err)
+	assert.Equal(t, c, encoded)
+}
+
+func TestParseInvalid(t *testing.T) {
+	_, err := cfdixml.Parse([]byte("not xml"))
+	assert.Equal(t, ez.EINVALID, ez.ErrorCode(err))
+
+	// CFDI 3.3 has another namespace
+	_, err = cfdixml.Parse([]byte(`<cfdi:Comprobante xmlns:cfdi="http://www.sat.gob.mx/cfd/3" Version="3.3"/>`))
+	assert.Equal(t, ez.EINVALID, ez.ErrorCode(err))
+
+	_, err = cfdixml.ParseBase64("%%%")
+	assert.Equal(t, ez.EINVALID, ez.ErrorCode(err))
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	data, err := os.ReadFile("testdata/cfdi.xml")
+	require.NoError(t, err)
+
+	c, err := cfdixml.Parse(data)
+	require.NoError(t, err)
+
+	out, err := cfdixml.Marshal(c)
+	require.NoError(t, err)
+
+	document := string(out)
+	assert.True(t, strings.HasPrefix(document, `<?xml version="1.0" encoding="UTF-8"?>`))
+	assert.Contains(t, document, `<cfdi:Comprobante xmlns:cfdi="http://www.sat.gob.mx/cfd/4" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:leyendasFisc="http://www.sat.gob.mx/leyendasFiscales" xsi:schemaLocation="`+c.SchemaLocation+`" Version="4.0"`)
+	assert.Contains(t, document, `<cfdi:Traslado Base="1000.000000" Impuesto="002" TipoFactor="Tasa" TasaOCuota="0.160000" Importe="160.000000"/>`)
+	assert.Contains(t, document, `<leyendasFisc:LeyendasFiscales version="1.0"><leyendasFisc:Leyenda`)
+	assert.Contains(t, document, `<tfd:TimbreFiscalDigital xmlns:tfd="http://www.sat.gob.mx/TimbreFiscalDigital" xsi:schemaLocation="`+cfdixml.SchemaLocationTFD+`" Version="1.1"`)
+	assert.Contains(t, document, `<cfdi:Addenda><Pedido numero="PO-77"><Nota>Entregar en almacén</Nota></Pedido></cfdi:Addenda>`)
+	assert.Contains(t, document, `Descripcion="Servicio de facturación &amp; soporte"`)
+
+	again, err := cfdixml.Parse(out)
+	require.NoError(t, err)
+	assert.Equal(t, c, again)
+
+	out2, err := cfdixml.Marshal(again)
+	require.NoError(t, err)
+	assert.Equal(t, document, string(out2))
+}
+
+func TestMarshalNew(t *testing.T) {
+	c := &cfdixml.Comprobante{
+		Version:           cfdixml.Version,
+		Fecha:             "2025-06-01T10:00:00",
+		SubTotal:          "100.00",
+		Moneda:            "MXN",
+		Total:             "116.00",
+		TipoDeComprobante: "I",
+		Exportacion:       "01",
+		LugarExpedicion:   "78116",
+		InformacionGlobal: &cfdixml.InformacionGlobal{Periodicidad: "04", Meses: "06", Año: "2025"},
+		Emisor:            cfdixml.Emisor{Rfc: "EKU9003173C9", Nombre: "ESCUELA KEMPER URGATE", RegimenFiscal: "601"},
+		Receptor: cfdixml.Receptor{Rfc: "XAXX010101000", Nombre: "PUBLICO EN GENERAL",
+			DomicilioFiscalReceptor: "78116", RegimenFiscalReceptor: "616", UsoCFDI: "S01"},
+		Conceptos: []cfdixml.Concepto{{ClaveProdServ: "01010101", Cantidad: "1", ClaveUnidad: "ACT",
+			Descripcion: "Venta", ValorUnitario: "100.00", Importe: "100.00", ObjetoImp: "01"}},
+		Complemento: &cfdixml.Complemento{TimbreFiscalDigital: &cfdixml.TimbreFiscalDigital{Version: cfdixml.TFDVersion, UUID: "A"}},
+	}
+
+	out, err := cfdixml.Marshal(c)
+	require.NoError(t, err)
+
+	document := string(out)
+	assert.Contains(t, document, `<cfdi:Comprobante xmlns:cfdi="http://www.sat.gob.mx/cfd/4" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:schemaLocation="`+cfdixml.SchemaLocationCFDI+`" Version="4.0"`)
+	assert.Contains(t, document, `<cfdi:InformacionGlobal Periodicidad="04" Meses="06" Año="2025"/>`)
+	assert.Contains(t, document, `<cfdi:Conceptos><cfdi:Concepto ClaveProdServ="01010101"`)
+	assert.Contains(t, document, `<tfd:TimbreFiscalDigital xmlns:tfd="http://www.sat.gob.mx/TimbreFiscalDigital" xsi:schemaLocation="`+cfdixml.SchemaLocationTFD+`"`)
+	assert.NotContains(t, document, "Descuento")
+
+	parsed, err := cfdixml.Parse(out)
+	require.NoError(t, err)
+	assert.Equal(t, "A", parsed.UUID())
+	assert.Equal(t, cfdixml.SchemaLocationCFDI, parsed.SchemaLocation)
+}
@@ -0,0 +1,342 @@
+// Package cfdixml models the CFDI 4.0 XML (Anexo 20) with its
+// TimbreFiscalDigital 1.1 complement. It parses stamped CFDIs, such as the
+// xml files returned by GetCfdiFile, into Go values and serializes them back
+// with the cfdi, tfd and xsi prefixes and schema locations the SAT expects.
+//
+// Amounts, quantities and rates are kept as written, so serializing a parsed
+// CFDI reproduces the values its seal covers. Use decimal.NewFromString to
+// compute with them.
+//
+// Verify checks the Sello and SelloSAT of an archived CFDI by building the
+// cadenas originales in Go, without an XSLT processor. The cadena original
+// covers the Pagos 2.0, ImpLocal, LeyendasFiscales and iedu complements, which
+// are kept as parsed Elements.
+package cfdixml
+
+import (
+	"encoding/xml"
+	"strings"
+)
+
+// Namespaces and schema locations
+const (
+	NamespaceCFDI = "http://www.sat.gob.mx/cfd/4"
+	NamespaceTFD  = "http://www.sat.gob.mx/TimbreFiscalDigital"
+	NamespaceXSI  = "http://www.w3.org/2001/XMLSchema-instance"
+
+	SchemaLocationCFDI = NamespaceCFDI + " http://www.sat.gob.mx/sitio_internet/cfd/4/cfdv40.xsd"
+	SchemaLocationTFD  = NamespaceTFD + " http://www.sat.gob.mx/sitio_internet/cfd/TimbreFiscalDigital/TimbreFiscalDigitalv11.xsd"
+)
+
+// Versions of the documents modeled by the package
+const (
+	Version    = "4.0"
+	TFDVersion = "1.1"
+)
+
+// Comprobante is the root element of a CFDI 4.0. Elements and attributes are
+// matched by local name when parsing.
+type Comprobante struct {
+	XMLName        xml.Name `xml:"Comprobante"`
+	SchemaLocation string   `xml:"http://www.w3.org/2001/XMLSchema-instance schemaLocation,attr,omitempty"`
+
+	Version           string `xml:"Version,attr"`
+	Serie             string `xml:"Serie,attr,omitempty"`
+	Folio             string `xml:"Folio,attr,omitempty"`
+	Fecha             string `xml:"Fecha,attr"`
+	Sello             string `xml:"Sello,attr"`
+	FormaPago         string `xml:"FormaPago,attr,omitempty"`
+	NoCertificado     string `xml:"NoCertificado,attr"`
+	Certificado       string `xml:"Certificado,attr"`
+	CondicionesDePago string `xml:"CondicionesDePago,attr,omitempty"`
+	SubTotal          string `xml:"SubTotal,attr"`
+	Descuento         string `xml:"Descuento,attr,omitempty"`
+	Moneda            string `xml:"Moneda,attr"`
+	TipoCambio        string `xml:"TipoCambio,attr,omitempty"`
+	Total             string `xml:"Total,attr"`
+	TipoDeComprobante string `xml:"TipoDeComprobante,attr"`
+	Exportacion       string `xml:"Exportacion,attr"`
+	MetodoPago        string `xml:"MetodoPago,attr,omitempty"`
+	LugarExpedicion   string `xml:"LugarExpedicion,attr"`
+	Confirmacion      string `xml:"Confirmacion,attr,omitempty"`
+
+	InformacionGlobal *InformacionGlobal `xml:"InformacionGlobal"`
+	CfdiRelacionados  []CfdiRelacionados `xml:"CfdiRelacionados"`
+	Emisor            Emisor             `xml:"Emisor"`
+	Receptor          Receptor           `xml:"Receptor"`
+	Conceptos         []Concepto         `xml:"Conceptos>Concepto"`
+	Impuestos         *Impuestos         `xml:"Impuestos"`
+	Complemento       *Complemento       `xml:"Complemento"`
+	Addenda           *Addenda           `xml:"Addenda"`
+
+	// Namespaces are the prefixes declared in the root element besides cfdi
+	// and xsi, e.g. those of the complements. They are declared again when
+	// serializing.
+	Namespaces map[string]string `xml:"-"`
+}
+
+// InformacionGlobal is the period of a global invoice to the general public
+type InformacionGlobal struct {
+	Periodicidad string `xml:"Periodicidad,attr"`
+	Meses        string `xml:"Meses,attr"`
+	Año          string `xml:"Año,attr"`
+}
+
+// CfdiRelacionados are the CFDIs related with one type of relation
+type CfdiRelacionados struct {
+	TipoRelacion    string            `xml:"TipoRelacion,attr"`
+	CfdiRelacionado []CfdiRelacionado `xml:"CfdiRelacionado"`
+}
+
+// CfdiRelacionado is the UUID of a related CFDI
+type CfdiRelacionado struct {
+	UUID string `xml:"UUID,attr"`
+}
+
+// Emisor is the issuer of the CFDI
+type Emisor struct {
+	Rfc              string `xml:"Rfc,attr"`
+	Nombre           string `xml:"Nombre,attr"`
+	RegimenFiscal    string `xml:"RegimenFiscal,attr"`
+	FacAtrAdquirente string `xml:"FacAtrAdquirente,attr,omitempty"`
+}
+
+// Receptor is the receiver of the CFDI
+type Receptor struct {
+	Rfc                     string `xml:"Rfc,attr"`
+	Nombre                  string `xml:"Nombre,attr"`
+	DomicilioFiscalReceptor string `xml:"DomicilioFiscalReceptor,attr"`
+	ResidenciaFiscal        string `xml:"ResidenciaFiscal,attr,omitempty"`
+	NumRegIdTrib            string `xml:"NumRegIdTrib,attr,omitempty"`
+	RegimenFiscalReceptor   string `xml:"RegimenFiscalReceptor,attr"`
+	UsoCFDI                 string `xml:"UsoCFDI,attr"`
+}
+
+// Concepto is an item of the CFDI
+type Concepto struct {
+	ClaveProdServ    string `xml:"ClaveProdServ,attr"`
+	NoIdentificacion string `xml:"NoIdentificacion,attr,omitempty"`
+	Cantidad         string `xml:"Cantidad,attr"`
+	ClaveUnidad      string `xml:"ClaveUnidad,attr"`
+	Unidad           string `xml:"Unidad,attr,omitempty"`
+	Descripcion      string `xml:"Descripcion,attr"`
+	ValorUnitario    string `xml:"ValorUnitario,attr"`
+	Importe          string `xml:"Importe,attr"`
+	Descuento        string `xml:"Descuento,attr,omitempty"`
+	ObjetoImp        string `xml:"ObjetoImp,attr"`
+
+	Impuestos           *ConceptoImpuestos    `xml:"Impuestos"`
+	ACuentaTerceros     *ACuentaTerceros      `xml:"ACuentaTerceros"`
+	InformacionAduanera []InformacionAduanera `xml:"InformacionAduanera"`
+	CuentaPredial       []CuentaPredial       `xml:"CuentaPredial"`
+	ComplementoConcepto *ComplementoConcepto  `xml:"ComplementoConcepto"`
+	Parte               []Parte               `xml:"Parte"`
+}
+
+// ConceptoImpuestos are the taxes of an item
+type ConceptoImpuestos struct {
+	Traslados   []Traslado  `xml:"Traslados>Traslado"`
+	Retenciones []Retencion `xml:"Retenciones>Retencion"`
+}
+
+// Traslado is a transferred tax. TasaOCuota and Importe are empty for
+// exempt taxes.
+type Traslado struct {
+	Base       string `xml:"Base,attr"`
+	Impuesto   string `xml:"Impuesto,attr"`
+	TipoFactor string `xml:"TipoFactor,attr"`
+	TasaOCuota string `xml:"TasaOCuota,attr,omitempty"`
+	Importe    string `xml:"Importe,attr,omitempty"`
+}
+
+// Retencion is a withheld tax. The retentions of the whole CFDI only have
+// Impuesto and Importe.
+type Retencion struct {
+	Base       string `xml:"Base,attr,omitempty"`
+	Impuesto   string `xml:"Impuesto,attr"`
+	TipoFactor string `xml:"TipoFactor,attr,omitempty"`
+	TasaOCuota string `xml:"TasaOCuota,attr,omitempty"`
+	Importe    string `xml:"Importe,attr"`
+}
+
+// ACuentaTerceros is the third party on whose behalf an item is billed
+type ACuentaTerceros struct {
+	RfcACuentaTerceros             string `xml:"RfcACuentaTerceros,attr"`
+	NombreACuentaTerceros          string `xml:"NombreACuentaTerceros,attr"`
+	RegimenFiscalACuentaTerceros   string `xml:"RegimenFiscalACuentaTerceros,attr"`
+	DomicilioFiscalACuentaTerceros string `xml:"DomicilioFiscalACuentaTerceros,attr"`
+}
+
+// InformacionAduanera is the customs document of an imported item
+type InformacionAduanera struct {
+	NumeroPedimento string `xml:"NumeroPedimento,attr"`
+}
+
+// CuentaPredial is the property tax account of a lease
+type CuentaPredial struct {
+	Numero string `xml:"Numero,attr"`
+}
+
+// Parte is a part of an item
+type Parte struct {
+	ClaveProdServ    string `xml:"ClaveProdServ,attr"`
+	NoIdentificacion string `xml:"NoIdentificacion,attr,omitempty"`
+	Cantidad         string `xml:"Cantidad,attr"`
+	Unidad           string `xml:"Unidad,attr,omitempty"`
+	Descripcion      string `xml:"Descripcion,attr"`
+	ValorUnitario    string `xml:"ValorUnitario,attr,omitempty"`
+	Importe          string `xml:"Importe,attr,omitempty"`
+
+	InformacionAduanera []InformacionAduanera `xml:"InformacionAduanera"`
+}
+
+// Impuestos are the tax totals of the CFDI
+type Impuestos struct {
+	TotalImpuestosRetenidos   string `xml:"TotalImpuestosRetenidos,attr,omitempty"`
+	TotalImpuestosTrasladados string `xml:"TotalImpuestosTrasladados,attr,omitempty"`
+
+	Retenciones []Retencion `xml:"Retenciones>Retencion"`
+	Traslados   []Traslado  `xml:"Traslados>Traslado"`
+}
+
+// Complemento holds the complements of the CFDI. The TimbreFiscalDigital is
+// modeled, the others are kept as parsed and written after it.
+type Complemento struct {
+	TimbreFiscalDigital *TimbreFiscalDigital `xml:"TimbreFiscalDigital"`
+	Others              []Element            `xml:",any"`
+}
+
+// ComplementoConcepto holds the complements of an item, kept as parsed
+type ComplementoConcepto struct {
+	Elements []Element `xml:",any"`
+}
+
+// Addenda holds the commercial information added after stamping, kept as parsed
+type Addenda struct {
+	Elements []Element `xml:",any"`
+}
+
+// TimbreFiscalDigital is the stamp of the PAC, version 1.1
+type TimbreFiscalDigital struct {
+	XMLName        xml.Name `xml:"TimbreFiscalDigital"`
+	SchemaLocation string   `xml:"http://www.w3.org/2001/XMLSchema-instance schemaLocation,attr,omitempty"`
+
+	Version          string `xml:"Version,attr"`
+	UUID             string `xml:"UUID,attr"`
+	FechaTimbrado    string `xml:"FechaTimbrado,attr"`
+	RfcProvCertif    string `xml:"RfcProvCertif,attr"`
+	Leyenda          string `xml:"Leyenda,attr,omitempty"`
+	SelloCFD         string `xml:"SelloCFD,attr"`
+	NoCertificadoSAT string `xml:"NoCertificadoSAT,attr"`
+	SelloSAT         string `xml:"SelloSAT,attr"`
+}
+
+// Element is an XML element the package does not model, such as a complement
+// other than the TimbreFiscalDigital or the content of an addenda. Its name
+// keeps the namespace URI, empty for elements without namespace. Text mixed
+// with child elements is joined and whitespace-only text is dropped.
+type Element struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr `xml:",any,attr"`
+	Children []Element  `xml:",any"`
+	Text     string     `xml:",chardata"`
+}
+
+// UnmarshalXML decodes the element without its namespace declarations, which
+// are written again when serializing
+func (e *Element) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	type element Element
+
+	err := d.DecodeElement((*element)(e), &start)
+	if err != nil {
+		return err
+	}
+
+	attrs := e.Attrs[:0]
+	for _, attr := range e.Attrs {
+		if !isNamespaceDeclaration(attr.Name) {
+			attrs = append(attrs, attr)
+		}
+	}
+	e.Attrs = attrs
+
+	if strings.TrimSpace(e.Text) == "" {
+		e.Text = ""
+	}
+
+	return nil
+}
+
+// MarshalXML encodes the element keeping elements without namespace apart
+// from the cfdi elements, which are written without namespace before the
+// prefixes are assigned
+func (e Element) MarshalXML(enc *xml.Encoder, start xml.StartElement) error {
+	start = xml.StartElement{Name: e.XMLName, Attr: e.Attrs}
+	if start.Name.Space == "" {
+		start.Name.Space = noNamespace
+	}
+
+	err := enc.EncodeToken(start)
+	if err != nil {
+		return err
+	}
+
+	if e.Text != "" {
+		err = enc.EncodeToken(xml.CharData(e.Text))
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, child := range e.Children {
+		err = enc.Encode(child)
+		if err != nil {
+			return err
+		}
+	}
+
+	return enc.EncodeToken(start.End())
+}
+
+// Attr returns the value of an attribute without namespace, empty if missing
+func (e *Element) Attr(local string) string {
+	for _, attr := range e.Attrs {
+		if attr.Name.Space == "" && attr.Name.Local == local {
+			return attr.Value
+		}
+	}
+
+	return ""
+}
+
+// ChildrenNamed returns the child elements in the namespace of the element with
+// the local name
+func (e *Element) ChildrenNamed(local string) []*Element {
+	var children []*Element
+	for i := range e.Children {
+		child := &e.Children[i]
+		if child.XMLName.Space == e.XMLName.Space && child.XMLName.Local == local {
+			children = append(children, child)
+		}
+	}
+
+	return children
+}
+
+// TimbreFiscalDigital returns the stamp of the CFDI, nil if it is not stamped
+func (c *Comprobante) TimbreFiscalDigital() *TimbreFiscalDigital {
+	if c.Complemento == nil {
+		return nil
+	}
+
+	return c.Complemento.TimbreFiscalDigital
+}
+
+// UUID returns the fiscal folio of a stamped CFDI
+func (c *Comprobante) UUID() string {
+	if tfd := c.TimbreFiscalDigital(); tfd != nil {
+		return tfd.UUID
+	}
+
+	return ""
+}
@@ -0,0 +1,264 @@
+package cfdixml
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/vanclief/ez"
+	"github.com/vanclief/go-facturama/csd"
+)
+
+// DateLayout is the date format of Fecha and FechaTimbrado
+const DateLayout = "2006-01-02T15:04:05"
+
+// MexicoCentral is the time zone of the CFDI dates, central Mexico has no
+// daylight saving time since 2022
+var MexicoCentral = time.FixedZone("CST", -6*60*60)
+
+// Verification checks, in the order they run
+const (
+	// CheckCertificate is the embedded Certificado parsing and matching NoCertificado
+	CheckCertificate = "certificate"
+	// CheckCertificateRFC is the certificate belonging to the Emisor RFC
+	CheckCertificateRFC = "certificate_rfc"
+	// CheckCertificateValidity is the certificate being valid at the Fecha of the CFDI
+	CheckCertificateValidity = "certificate_validity"
+	// CheckSello is the Sello signing the cadena original with the certificate
+	CheckSello = "sello"
+	// CheckStamp is the TimbreFiscalDigital being present with the SelloCFD of the CFDI
+	CheckStamp = "stamp"
+	// CheckSelloSAT is the SelloSAT signing the stamp cadena original with a SAT certificate
+	CheckSelloSAT = "sello_sat"
+)
+
+// CheckStatus is the result of a verification check
+type CheckStatus string
+
+const (
+	CheckPassed CheckStatus = "passed"
+	CheckFailed CheckStatus = "failed"
+	// CheckSkipped is a check that could not run, e.g. because an earlier one failed
+	CheckSkipped CheckStatus = "skipped"
+)
+
+// Check is the result of one verification check
+type Check struct {
+	Name    string
+	Status  CheckStatus
+	Message string
+}
+
+// VerificationReport is the result of Verify
+type VerificationReport struct {
+	UUID string
+	// CadenaOriginal and TFDCadenaOriginal are the strings the seals sign
+	CadenaOriginal    string
+	TFDCadenaOriginal string
+	// Certificate is the embedded issuer certificate
+	Certificate *csd.Certificate
+	// SATCertificate is the certificate of the SAT that signed the stamp
+	SATCertificate *csd.Certificate
+	Checks         []Check
+}
+
+// Valid reports whether every check passed
+func (report *VerificationReport) Valid() bool {
+	for _, check := range report.Checks {
+		if check.Status != CheckPassed {
+			return false
+		}
+	}
+
+	return len(report.Checks) > 0
+}
+
+// Failed returns the checks that did not pass
+func (report *VerificationReport) Failed() []Check {
+	var failed []Check
+	for _, check := range report.Checks {
+		if check.Status != CheckPassed {
+			failed = append(failed, check)
+		}
+	}
+
+	return failed
+}
+
+// Check returns the result of a check by name
+func (report *VerificationReport) Check(name string) (Check, bool) {
+	for _, check := range report.Checks {
+		if check.Name == name {
+			return check, true
+		}
+	}
+
+	return Check{}, false
+}
+
+// add records the result of a check
+func (report *VerificationReport) add(name string, status CheckStatus, format string, args ...interface{}) {
+	report.Checks = append(report.Checks, Check{Name: name, Status: status, Message: fmt.Sprintf(format, args...)})
+}
+
+// CertificateSet holds the SAT certificates that sign the stamps, by number
+type CertificateSet struct {
+	certificates map[string]*csd.Certificate
+}
+
+// NewCertificateSet creates a set with the certificates
+func NewCertificateSet(certificates ...*csd.Certificate) *CertificateSet {
+	set := &CertificateSet{certificates: make(map[string]*csd.Certificate)}
+	for _, certificate := range certificates {
+		set.Add(certificate)
+	}
+
+	return set
+}
+
+// Add adds a certificate to the set
+func (set *CertificateSet) Add(certificate *csd.Certificate) {
+	set.certificates[certificate.Number] = certificate
+}
+
+// Get returns the certificate with the number (NoCertificadoSAT)
+func (set *CertificateSet) Get(number string) (*csd.Certificate, bool) {
+	if set == nil {
+		return nil, false
+	}
+
+	certificate, ok := set.certificates[strings.TrimSpace(number)]
+
+	return certificate, ok
+}
+
+// Verify checks that a stamped CFDI is intact: the Sello against the
+// embedded Certificado and, when the set has the SAT certificate, the
+// SelloSAT of the TimbreFiscalDigital. The report lists every check, a nil
+// set skips the SelloSAT check. It only fails for CFDIs whose cadena
+// original is not supported, see CadenaOriginal.
+func Verify(c *Comprobante, satCertificates *CertificateSet) (*VerificationReport, error) {
+	const op = "cfdixml.Verify"
+
+	cadenaOriginal, err := c.CadenaOriginal()
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	report := &VerificationReport{UUID: c.UUID(), CadenaOriginal: cadenaOriginal}
+
+	report.verifySello(c)
+	report.verifyStamp(c, satCertificates)
+
+	return report, nil
+}
+
+// verifySello runs the checks of the issuer certificate and Sello
+func (report *VerificationReport) verifySello(c *Comprobante) {
+	certificate, err := csd.ParseCertificateBase64(strings.Join(strings.Fields(c.Certificado), ""))
+	switch {
+	case err != nil:
+		report.add(CheckCertificate, CheckFailed, "The Certificado is not valid: %s", ez.ErrorMessage(err))
+	case certificate.Number != strings.TrimSpace(c.NoCertificado):
+		report.add(CheckCertificate, CheckFailed, "The NoCertificado %s does not match the Certificado number %s", c.NoCertificado, certificate.Number)
+	default:
+		report.Certificate = certificate
+		report.add(CheckCertificate, CheckPassed, "Certificado %s", certificate.Number)
+	}
+
+	if report.Certificate == nil {
+		report.add(CheckCertificateRFC, CheckSkipped, "The certificate is not valid")
+		report.add(CheckCertificateValidity, CheckSkipped, "The certificate is not valid")
+		report.add(CheckSello, CheckSkipped, "The certificate is not valid")
+		return
+	}
+
+	if strings.EqualFold(strings.TrimSpace(c.Emisor.Rfc), certificate.RFC) {
+		report.add(CheckCertificateRFC, CheckPassed, "The certificate belongs to %s", certificate.RFC)
+	} else {
+		report.add(CheckCertificateRFC, CheckFailed, "The certificate belongs to %s, not to the Emisor %s", certificate.RFC, c.Emisor.Rfc)
+	}
+
+	fecha, err := time.ParseInLocation(DateLayout, strings.TrimSpace(c.Fecha), MexicoCentral)
+	switch {
+	case err != nil:
+		report.add(CheckCertificateValidity, CheckFailed, "The Fecha %q is not valid", c.Fecha)
+	case !certificate.IsValidAt(fecha):
+		report.add(CheckCertificateValidity, CheckFailed, "The certificate was not valid on %s, it is valid from %s to %s",
+			c.Fecha, certificate.NotBefore.Format(time.RFC3339), certificate.NotAfter.Format(time.RFC3339))
+	default:
+		report.add(CheckCertificateValidity, CheckPassed, "The certificate was valid on %s", c.Fecha)
+	}
+
+	err = verifySignature(certificate, report.CadenaOriginal, c.Sello)
+	if err != nil {
+		report.add(CheckSello, CheckFailed, "The Sello does not match the cadena original: %s", ez.ErrorMessage(err))
+	} else {
+		report.add(CheckSello, CheckPassed, "The Sello matches the cadena original")
+	}
+}
+
+// verifyStamp runs the checks of the TimbreFiscalDigital
+func (report *VerificationReport) verifyStamp(c *Comprobante, satCertificates *CertificateSet) {
+	tfd := c.TimbreFiscalDigital()
+	switch {
+	case tfd == nil:
+		report.add(CheckStamp, CheckFailed, "The CFDI is not stamped")
+		report.add(CheckSelloSAT, CheckSkipped, "The CFDI is not stamped")
+		return
+	case tfd.SelloCFD != c.Sello:
+		report.add(CheckStamp, CheckFailed, "The SelloCFD of the stamp is not the Sello of the CFDI")
+	default:
+		report.add(CheckStamp, CheckPassed, "Stamped with UUID %s", tfd.UUID)
+	}
+
+	report.TFDCadenaOriginal = tfd.CadenaOriginal()
+
+	if satCertificates == nil {
+		report.add(CheckSelloSAT, CheckSkipped, "No SAT certificates were supplied")
+		return
+	}
+
+	certificate, ok := satCertificates.Get(tfd.NoCertificadoSAT)
+	if !ok {
+		report.add(CheckSelloSAT, CheckFailed, "The SAT certificate %s is not in the set", tfd.NoCertificadoSAT)
+		return
+	}
+	report.SATCertificate = certificate
+
+	err := verifySignature(certificate, report.TFDCadenaOriginal, tfd.SelloSAT)
+	if err != nil {
+		report.add(CheckSelloSAT, CheckFailed, "The SelloSAT does not match the stamp cadena original: %s", ez.ErrorMessage(err))
+		return
+	}
+
+	report.add(CheckSelloSAT, CheckPassed, "The SelloSAT matches the stamp cadena original")
+}
+
+// verifySignature checks a base64 SHA-256 RSA seal of a cadena original
+func verifySignature(certificate *csd.Certificate, cadenaOriginal, seal string) error {
+	const op = "cfdixml.verifySignature"
+
+	publicKey, ok := certificate.Raw.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return ez.New(op, ez.EINVALID, "The certificate does not have an RSA key", nil)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(seal), ""))
+	if err != nil {
+		return ez.New(op, ez.EINVALID, "The seal is not valid base64", err)
+	}
+
+	digest := sha256.Sum256([]byte(cadenaOriginal))
+
+	err = rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signature)
+	if err != nil {
+		return ez.New(op, ez.EINVALID, "The seal is not valid", err)
+	}
+
+	return nil
+}
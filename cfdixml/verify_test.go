@@ -0,0 +1,249 @@
+package cfdixml_test
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vanclief/ez"
+	"github.com/vanclief/go-facturama/cfdixml"
+	"github.com/vanclief/go-facturama/csd"
+	"github.com/vanclief/go-facturama/csd/csdtest"
+)
+
+// seal signs a cadena original as the SAT seals do
+func seal(t *testing.T, key *rsa.PrivateKey, cadenaOriginal string) string {
+	digest := sha256.Sum256([]byte(cadenaOriginal))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	require.NoError(t, err)
+
+	return base64.StdEncoding.EncodeToString(signature)
+}
+
+// sampleCfdi returns a testdata CFDI
+func sampleCfdi(t *testing.T, name string) *cfdixml.Comprobante {
+	data, err := os.ReadFile("testdata/" + name)
+	require.NoError(t, err)
+
+	c, err := cfdixml.Parse(data)
+	require.NoError(t, err)
+
+	return c
+}
+
+func TestCadenaOriginal(t *testing.T) {
+	c := sampleCfdi(t, "cfdi.xml")
+
+	cadena, err := c.CadenaOriginal()
+	require.NoError(t, err)
+	assert.Equal(t, "||4.0|A|100|2025-06-01T10:00:00|03|30001000000500003416|1000.00|0.00|MXN|1053.33|I|01|PUE|78116"+
+		"|04|5FB2822E-396D-4725-8521-CDC4BDD20CCF"+
+		"|EKU9003173C9|ESCUELA KEMPER URGATE|601"+
+		"|URE180429TM6|UNIVERSIDAD ROBOTICA ESPAÑOLA|86991|601|G03"+
+		"|84111506|SKU-1|1.000000|E48|Unidad de servicio|Servicio de facturación & soporte|1000.000000|1000.000000|0.00|02"+
+		"|1000.000000|002|Tasa|0.160000|160.000000"+
+		"|1000.000000|001|Tasa|0.100000|100.000000|1000.000000|002|Tasa|0.106667|106.670000"+
+		"|001|100.00|002|106.67|206.67|1000.00|002|Tasa|0.160000|160.00|160.00"+
+		"|1.0|RESDERAUTH|Leyenda de prueba||", cadena)
+
+	// Whitespace is normalized
+	c.Emisor.Nombre = "  ESCUELA   KEMPER\nURGATE "
+	normalized, err := c.CadenaOriginal()
+	require.NoError(t, err)
+	assert.Equal(t, cadena, normalized)
+
+	assert.Equal(t, "||1.1|9F8C1B2A-3D4E-4F50-8A6B-7C8D9E0F1A2B|2025-06-01T10:00:05|SPR190613I52|c2VsbG8=|30001000000500003456||",
+		c.TimbreFiscalDigital().CadenaOriginal())
+
+	// Complements without a template are not supported
+	c.Complemento.Others = append(c.Complemento.Others, cfdixml.Element{XMLName: xml.Name{Space: "http://www.sat.gob.mx/ine", Local: "INE"}})
+	_, err = c.CadenaOriginal()
+	assert.Equal(t, ez.ENOTIMPLEMENTED, ez.ErrorCode(err))
+	assert.Contains(t, ez.ErrorMessage(err), "{http://www.sat.gob.mx/ine}INE")
+}
+
+func TestCadenaOriginalComplements(t *testing.T) {
+	cases := map[string]string{
+		"pago.xml": "||4.0|P|12|2025-06-15T09:30:00|30001000000500003416|0|XXX|0|P|01|78116" +
+			"|EKU9003173C9|ESCUELA KEMPER URGATE|601" +
+			"|URE180429TM6|UNIVERSIDAD ROBOTICA ESPAÑOLA|86991|601|CP01" +
+			"|84111506|1|ACT|Pago|0|0|01" +
+			"|2.0|100.00|1000.00|160.00|1060.00" +
+			"|2025-06-14T12:00:00|03|MXN|1|1060.00|SPEI-001" +
+			"|9F8C1B2A-3D4E-4F50-8A6B-7C8D9E0F1A2B|A|100|MXN|1|1|1060.00|1060.00|0.00|02" +
+			"|1000.00|001|Tasa|0.100000|100.00|1000.00|002|Tasa|0.160000|160.00" +
+			"|001|100.00|1000.00|002|Tasa|0.160000|160.00||",
+		"colegiatura.xml": "||4.0|C|7|2025-08-01T08:00:00|03|30001000000500003416|5000.00|MXN|4950.00|I|01|PUE|78116" +
+			"|EKU9003173C9|ESCUELA KEMPER URGATE|601" +
+			"|URE180429TM6|UNIVERSIDAD ROBOTICA ESPAÑOLA|86991|601|D10" +
+			"|86121500|1|E48|Unidad de servicio|Colegiatura agosto|5000.00|5000.00|01" +
+			"|1.0|Ana López Pérez|LOPA100101MSPPRNA9|Primaria|SEP-1234" +
+			"|1.0|50.00|0.00|Cedular|1.00|50.00||",
+	}
+
+	for name, expected := range cases {
+		c := sampleCfdi(t, name)
+
+		cadena, err := c.CadenaOriginal()
+		require.NoError(t, err, name)
+		assert.Equal(t, expected, cadena, name)
+
+		// The complements survive a serialization round trip
+		data, err := cfdixml.Marshal(c)
+		require.NoError(t, err, name)
+		parsed, err := cfdixml.Parse(data)
+		require.NoError(t, err, name)
+		cadena, err = parsed.CadenaOriginal()
+		require.NoError(t, err, name)
+		assert.Equal(t, expected, cadena, name)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	window := csdtest.Options{
+		NotBefore: time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:  time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC),
+	}
+	issuer, err := csdtest.Generate(window)
+	require.NoError(t, err)
+
+	window.RFC = "SAT970701NN3"
+	window.Number = "30001000000500003456"
+	sat, err := csdtest.Generate(window)
+	require.NoError(t, err)
+
+	satCertificate, err := csd.ParseCertificate(sat.Certificate)
+	require.NoError(t, err)
+	set := cfdixml.NewCertificateSet(satCertificate)
+
+	// sealedSample returns a sample CFDI sealed and stamped
+	sealedSample := func(name string) *cfdixml.Comprobante {
+		c := sampleCfdi(t, name)
+		c.Certificado = issuer.CertificateBase64()
+		c.NoCertificado = csdtest.DefaultNumber
+
+		cadena, err := c.CadenaOriginal()
+		require.NoError(t, err)
+		c.Sello = seal(t, issuer.Key, cadena)
+
+		tfd := c.TimbreFiscalDigital()
+		tfd.SelloCFD = c.Sello
+		tfd.SelloSAT = seal(t, sat.Key, tfd.CadenaOriginal())
+
+		return c
+	}
+	sealed := func() *cfdixml.Comprobante { return sealedSample("cfdi.xml") }
+
+	c := sealed()
+	report, err := cfdixml.Verify(c, set)
+	require.NoError(t, err)
+	assert.True(t, report.Valid(), report.Failed())
+	assert.Len(t, report.Checks, 6)
+	assert.Equal(t, c.UUID(), report.UUID)
+	assert.Equal(t, "SAT970701NN3", report.SATCertificate.RFC)
+
+	// The report survives a serialization round trip
+	data, err := cfdixml.Marshal(c)
+	require.NoError(t, err)
+	parsed, err := cfdixml.Parse(data)
+	require.NoError(t, err)
+	report, err = cfdixml.Verify(parsed, set)
+	require.NoError(t, err)
+	assert.True(t, report.Valid(), report.Failed())
+
+	// A payment CFDI seals its complement
+	c = sealedSample("pago.xml")
+	report, err = cfdixml.Verify(c, set)
+	require.NoError(t, err)
+	assert.True(t, report.Valid(), report.Failed())
+
+	c.Complemento.Others[0].Children[1].Attrs[4].Value = "1000.00"
+	report, err = cfdixml.Verify(c, set)
+	require.NoError(t, err)
+	require.Len(t, report.Failed(), 1)
+	assert.Equal(t, cfdixml.CheckSello, report.Failed()[0].Name)
+
+	// Without SAT certificates the stamp seal is skipped
+	report, err = cfdixml.Verify(c, nil)
+	require.NoError(t, err)
+	assert.False(t, report.Valid())
+	check, _ := report.Check(cfdixml.CheckSelloSAT)
+	assert.Equal(t, cfdixml.CheckSkipped, check.Status)
+
+	// A tampered total breaks the Sello only
+	c = sealed()
+	c.Total = "10.00"
+	report, err = cfdixml.Verify(c, set)
+	require.NoError(t, err)
+	require.Len(t, report.Failed(), 1)
+	assert.Equal(t, cfdixml.CheckSello, report.Failed()[0].Name)
+
+	// A tampered stamp breaks the SelloSAT
+	c = sealed()
+	c.TimbreFiscalDigital().UUID = "00000000-0000-0000-0000-000000000000"
+	report, err = cfdixml.Verify(c, set)
+	require.NoError(t, err)
+	require.Len(t, report.Failed(), 1)
+	assert.Equal(t, cfdixml.CheckSelloSAT, report.Failed()[0].Name)
+
+	// Another issuer and an unknown SAT certificate
+	c = sealed()
+	c.Emisor.Rfc = "URE180429TM6"
+	c.TimbreFiscalDigital().NoCertificadoSAT = "30001000000500009999"
+	report, err = cfdixml.Verify(c, set)
+	require.NoError(t, err)
+	failed := report.Failed()
+	require.Len(t, failed, 3)
+	assert.Equal(t, cfdixml.CheckCertificateRFC, failed[0].Name)
+	assert.Equal(t, cfdixml.CheckSello, failed[1].Name)
+	assert.Equal(t, cfdixml.CheckSelloSAT, failed[2].Name)
+
+	// A CFDI issued outside the certificate validity
+	c = sealed()
+	c.Fecha = "2027-01-01T00:00:00"
+	report, err = cfdixml.Verify(c, set)
+	require.NoError(t, err)
+	check, _ = report.Check(cfdixml.CheckCertificateValidity)
+	assert.Equal(t, cfdixml.CheckFailed, check.Status)
+
+	// A wrong certificate skips the seal
+	c = sealed()
+	c.NoCertificado = "1"
+	report, err = cfdixml.Verify(c, set)
+	require.NoError(t, err)
+	check, _ = report.Check(cfdixml.CheckSello)
+	assert.Equal(t, cfdixml.CheckSkipped, check.Status)
+}
+
+func TestVerifyStamped(t *testing.T) {
+	paths, err := filepath.Glob("testdata/stamped/*.xml")
+	require.NoError(t, err)
+	if len(paths) == 0 {
+		t.Skip("No PAC stamped CFDIs in testdata/stamped")
+	}
+
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err, path)
+
+		c, err := cfdixml.Parse(data)
+		require.NoError(t, err, path)
+
+		// The SAT certificates are not bundled, so only the issuer side is checked
+		report, err := cfdixml.Verify(c, nil)
+		require.NoError(t, err, path)
+
+		check, ok := report.Check(cfdixml.CheckSello)
+		require.True(t, ok, path)
+		assert.Equal(t, cfdixml.CheckPassed, check.Status, "%s: %s", path, check.Message)
+	}
+}
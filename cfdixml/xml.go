@@ -0,0 +1,343 @@
+package cfdixml
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/vanclief/ez"
+)
+
+// Prefixes written for the known namespaces
+const (
+	prefixCFDI = "cfdi"
+	prefixTFD  = "tfd"
+	prefixXSI  = "xsi"
+)
+
+// noNamespace marks the elements without namespace while serializing, so they
+// are not confused with the cfdi elements
+const noNamespace = "urn:cfdixml:no-namespace"
+
+// Parse decodes a CFDI 4.0 XML document
+func Parse(data []byte) (*Comprobante, error) {
+	const op = "cfdixml.Parse"
+
+	root, err := rootElement(data)
+	if err != nil {
+		return nil, ez.New(op, ez.EINVALID, "The CFDI is not a valid XML document", err)
+	}
+
+	if root.Name.Space != NamespaceCFDI || root.Name.Local != "Comprobante" {
+		msg := fmt.Sprintf("The document is not a CFDI 4.0, its root element is {%s}%s", root.Name.Space, root.Name.Local)
+		return nil, ez.New(op, ez.EINVALID, msg, nil)
+	}
+
+	var c Comprobante
+	err = xml.Unmarshal(data, &c)
+	if err != nil {
+		return nil, ez.New(op, ez.EINVALID, "The CFDI is not a valid XML document", err)
+	}
+
+	for _, attr := range root.Attr {
+		if attr.Name.Space != "xmlns" || attr.Name.Local == prefixCFDI || attr.Name.Local == prefixXSI {
+			continue
+		}
+		if c.Namespaces == nil {
+			c.Namespaces = make(map[string]string)
+		}
+		c.Namespaces[attr.Name.Local] = attr.Value
+	}
+
+	return &c, nil
+}
+
+// ParseBase64 decodes a base64 encoded CFDI, such as the content of the xml
+// file returned by GetCfdiFile
+func ParseBase64(encoded string) (*Comprobante, error) {
+	const op = "cfdixml.ParseBase64"
+
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ez.New(op, ez.EINVALID, "The CFDI is not valid base64", err)
+	}
+
+	c, err := Parse(data)
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	return c, nil
+}
+
+// Marshal encodes a CFDI as an XML document. Elements are written with the
+// cfdi and tfd prefixes, the root declares the cfdi, xsi and Namespaces
+// prefixes, and missing schema locations are set to SchemaLocationCFDI and
+// SchemaLocationTFD.
+func Marshal(c *Comprobante) ([]byte, error) {
+	const op = "cfdixml.Marshal"
+
+	raw, err := xml.Marshal(c)
+	if err != nil {
+		return nil, ez.New(op, ez.EINVALID, "The CFDI could not be encoded", err)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+
+	w := newPrefixWriter(&buf, c.Namespaces)
+	err = w.copy(xml.NewDecoder(bytes.NewReader(raw)))
+	if err != nil {
+		return nil, ez.New(op, ez.EINTERNAL, "The CFDI could not be encoded", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// rootElement returns the first element of an XML document
+func rootElement(data []byte) (xml.StartElement, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return xml.StartElement{}, err
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return start, nil
+		}
+	}
+}
+
+// isNamespaceDeclaration reports whether an attribute declares a namespace
+func isNamespaceDeclaration(name xml.Name) bool {
+	return name.Space == "xmlns" || (name.Space == "" && name.Local == "xmlns")
+}
+
+// prefixWriter rewrites the tokens of the document encoded by encoding/xml,
+// which has no prefixes, assigning a prefix to every namespace and declaring
+// each one where it is first needed
+type prefixWriter struct {
+	buf *bytes.Buffer
+	// prefixes maps the namespace URIs to their prefix
+	prefixes map[string]string
+	// root are the prefixes declared in the root element, in order
+	root []string
+	// open are the elements being written
+	open []openElement
+	// pending is a start tag that is closed with > or />
+	pending bool
+}
+
+// openElement is an element being written
+type openElement struct {
+	name     string
+	declared []string
+}
+
+// newPrefixWriter creates a writer that declares the cfdi, xsi and extra
+// prefixes in the root element
+func newPrefixWriter(buf *bytes.Buffer, namespaces map[string]string) *prefixWriter {
+	w := &prefixWriter{
+		buf: buf,
+		prefixes: map[string]string{
+			NamespaceCFDI: prefixCFDI,
+			NamespaceXSI:  prefixXSI,
+			NamespaceTFD:  prefixTFD,
+		},
+		root: []string{prefixCFDI, prefixXSI},
+	}
+
+	extra := make([]string, 0, len(namespaces))
+	for prefix := range namespaces {
+		extra = append(extra, prefix)
+	}
+	slices.Sort(extra)
+
+	for _, prefix := range extra {
+		uri := namespaces[prefix]
+		if known, ok := w.prefixes[uri]; ok {
+			// A known namespace is declared in the root only with its own prefix
+			if known == prefix && prefix != prefixCFDI && prefix != prefixXSI {
+				w.root = append(w.root, prefix)
+			}
+			continue
+		}
+		if w.uri(prefix) != "" {
+			continue
+		}
+		w.prefixes[uri] = prefix
+		w.root = append(w.root, prefix)
+	}
+
+	return w
+}
+
+// copy writes the tokens of the decoder
+func (w *prefixWriter) copy(decoder *xml.Decoder) error {
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			w.start(t)
+		case xml.EndElement:
+			w.end()
+		case xml.CharData:
+			w.closePending()
+			err = xml.EscapeText(w.buf, t)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// start writes a start tag, leaving it open until the next token
+func (w *prefixWriter) start(start xml.StartElement) {
+	w.closePending()
+
+	var declared []string
+	if len(w.open) == 0 {
+		declared = append(declared, w.root...)
+	}
+
+	space := start.Name.Space
+	switch space {
+	case noNamespace:
+		space = ""
+	case "":
+		space = NamespaceCFDI
+		if start.Name.Local == "TimbreFiscalDigital" {
+			space = NamespaceTFD
+		}
+	}
+
+	name := w.qualify(space, start.Name.Local, &declared)
+
+	var attrs []string
+	hasSchemaLocation := false
+	for _, attr := range start.Attr {
+		if isNamespaceDeclaration(attr.Name) {
+			continue
+		}
+		if attr.Name.Space == NamespaceXSI && attr.Name.Local == "schemaLocation" {
+			hasSchemaLocation = true
+		}
+		attrs = append(attrs, w.attr(attr, &declared))
+	}
+
+	// Missing schema locations of the root and the stamp are added
+	schemaLocation := ""
+	switch {
+	case len(w.open) == 0 && space == NamespaceCFDI:
+		schemaLocation = SchemaLocationCFDI
+	case space == NamespaceTFD && start.Name.Local == "TimbreFiscalDigital":
+		schemaLocation = SchemaLocationTFD
+	}
+	if schemaLocation != "" && !hasSchemaLocation {
+		location := xml.Attr{Name: xml.Name{Space: NamespaceXSI, Local: "schemaLocation"}, Value: schemaLocation}
+		attrs = append([]string{w.attr(location, &declared)}, attrs...)
+	}
+
+	w.buf.WriteString("<" + name)
+	for _, prefix := range declared {
+		w.buf.WriteString(" xmlns:" + prefix + `="`)
+		_ = xml.EscapeText(w.buf, []byte(w.uri(prefix)))
+		w.buf.WriteString(`"`)
+	}
+	for _, attr := range attrs {
+		w.buf.WriteString(" " + attr)
+	}
+
+	w.open = append(w.open, openElement{name: name, declared: declared})
+	w.pending = true
+}
+
+// end writes the end tag of the last open element
+func (w *prefixWriter) end() {
+	element := w.open[len(w.open)-1]
+	w.open = w.open[:len(w.open)-1]
+
+	if w.pending {
+		w.buf.WriteString("/>")
+		w.pending = false
+		return
+	}
+
+	w.buf.WriteString("</" + element.name + ">")
+}
+
+// closePending closes the open start tag
+func (w *prefixWriter) closePending() {
+	if w.pending {
+		w.buf.WriteString(">")
+		w.pending = false
+	}
+}
+
+// attr returns an attribute as written in a start tag
+func (w *prefixWriter) attr(attr xml.Attr, declared *[]string) string {
+	var buf bytes.Buffer
+	buf.WriteString(w.qualify(attr.Name.Space, attr.Name.Local, declared))
+	buf.WriteString(`="`)
+	_ = xml.EscapeText(&buf, []byte(attr.Value))
+	buf.WriteString(`"`)
+
+	return buf.String()
+}
+
+// qualify returns the prefixed name, adding the prefix to the declarations of
+// the element if it is not declared yet
+func (w *prefixWriter) qualify(space, local string, declared *[]string) string {
+	if space == "" {
+		return local
+	}
+
+	prefix, ok := w.prefixes[space]
+	if !ok {
+		for i := 1; ; i++ {
+			prefix = fmt.Sprintf("ns%d", i)
+			if w.uri(prefix) == "" {
+				break
+			}
+		}
+		w.prefixes[space] = prefix
+	}
+
+	if !w.inScope(prefix) && !slices.Contains(*declared, prefix) {
+		*declared = append(*declared, prefix)
+	}
+
+	return prefix + ":" + local
+}
+
+// inScope reports whether a prefix is declared by an open element
+func (w *prefixWriter) inScope(prefix string) bool {
+	for _, element := range w.open {
+		if slices.Contains(element.declared, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// uri returns the namespace of a prefix, empty if it is not assigned
+func (w *prefixWriter) uri(prefix string) string {
+	for uri, assigned := range w.prefixes {
+		if assigned == prefix {
+			return uri
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,166 @@
+// Package csd inspects SAT digital seal certificates (CSD) and their private
+// keys locally, so problems can be detected before uploading them to Facturama.
+package csd
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"strings"
+	"time"
+
+	"github.com/vanclief/ez"
+)
+
+// CertificateType identifies the kind of SAT certificate
+type CertificateType string
+
+const (
+	// TypeCSD is a Certificado de Sello Digital, used to seal CFDIs
+	TypeCSD CertificateType = "CSD"
+	// TypeFIEL is an e.firma (FIEL) certificate, which cannot be used to seal CFDIs
+	TypeFIEL CertificateType = "FIEL"
+)
+
+var (
+	// oidUniqueIdentifier holds "RFC / RFC of the legal representative" in SAT certificates
+	oidUniqueIdentifier = asn1.ObjectIdentifier{2, 5, 4, 45}
+	// oidSerialNumber holds " / CURP of the holder or legal representative" in SAT certificates
+	oidSerialNumber = asn1.ObjectIdentifier{2, 5, 4, 5}
+)
+
+// Certificate is a parsed SAT certificate
+type Certificate struct {
+	// Raw is the parsed X.509 certificate
+	Raw *x509.Certificate
+
+	// RFC of the certificate holder
+	RFC string
+	// CURP of the certificate holder (persona física) or its legal representative
+	CURP string
+	// Name of the certificate holder
+	Name string
+	// BranchName is the organizational unit the CSD was issued for, if any
+	BranchName string
+
+	// Number is the certificate number (NoCertificado) used in CFDIs
+	Number string
+
+	// NotBefore and NotAfter delimit the validity window of the certificate
+	NotBefore time.Time
+	NotAfter  time.Time
+
+	// Type tells whether this is a CSD or a FIEL certificate
+	Type CertificateType
+}
+
+// ParseCertificate parses a SAT certificate in DER (.cer) or PEM format
+func ParseCertificate(data []byte) (*Certificate, error) {
+	const op = "csd.ParseCertificate"
+
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+
+	raw, err := x509.ParseCertificate(data)
+	if err != nil {
+		return nil, ez.New(op, ez.EINVALID, "The certificate is not a valid X.509 certificate", err)
+	}
+
+	certificate := &Certificate{
+		Raw:       raw,
+		Name:      raw.Subject.CommonName,
+		NotBefore: raw.NotBefore,
+		NotAfter:  raw.NotAfter,
+		Number:    certificateNumber(raw),
+		Type:      certificateType(raw),
+	}
+
+	if len(raw.Subject.OrganizationalUnit) > 0 {
+		certificate.BranchName = raw.Subject.OrganizationalUnit[0]
+	}
+
+	for _, name := range raw.Subject.Names {
+		value, ok := name.Value.(string)
+		if !ok {
+			continue
+		}
+
+		switch {
+		case name.Type.Equal(oidUniqueIdentifier):
+			certificate.RFC = firstToken(value)
+		case name.Type.Equal(oidSerialNumber):
+			certificate.CURP = lastToken(value)
+		}
+	}
+
+	if certificate.RFC == "" {
+		return nil, ez.New(op, ez.EINVALID, "The certificate does not contain an RFC, it was not issued by the SAT", nil)
+	}
+
+	return certificate, nil
+}
+
+// ParseCertificateBase64 parses a base64 encoded SAT certificate, as sent to the Facturama API
+func ParseCertificateBase64(data string) (*Certificate, error) {
+	const op = "csd.ParseCertificateBase64"
+
+	der, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, ez.New(op, ez.EINVALID, "The certificate is not valid base64", err)
+	}
+
+	certificate, err := ParseCertificate(der)
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	return certificate, nil
+}
+
+// IsValidAt reports whether the certificate validity window includes t
+func (certificate *Certificate) IsValidAt(t time.Time) bool {
+	return !t.Before(certificate.NotBefore) && !t.After(certificate.NotAfter)
+}
+
+// IsCSD reports whether the certificate can be used to seal CFDIs
+func (certificate *Certificate) IsCSD() bool {
+	return certificate.Type == TypeCSD
+}
+
+// certificateNumber returns the NoCertificado of a SAT certificate, which is
+// the certificate serial number interpreted as ASCII digits
+func certificateNumber(raw *x509.Certificate) string {
+	serial := raw.SerialNumber.Bytes()
+
+	for _, b := range serial {
+		if b < '0' || b > '9' {
+			return raw.SerialNumber.String()
+		}
+	}
+
+	return string(serial)
+}
+
+// certificateType tells a CSD from a FIEL. FIEL certificates allow key
+// agreement and data encipherment while CSDs are restricted to signatures.
+func certificateType(raw *x509.Certificate) CertificateType {
+	if raw.KeyUsage&(x509.KeyUsageKeyAgreement|x509.KeyUsageDataEncipherment) != 0 {
+		return TypeFIEL
+	}
+
+	return TypeCSD
+}
+
+// firstToken returns the first value of a "value / value" SAT subject attribute
+func firstToken(value string) string {
+	token, _, _ := strings.Cut(value, "/")
+	return strings.ToUpper(strings.TrimSpace(token))
+}
+
+// lastToken returns the last value of a "value / value" SAT subject attribute
+func lastToken(value string) string {
+	tokens := strings.Split(value, "/")
+	return strings.ToUpper(strings.TrimSpace(tokens[len(tokens)-1]))
+}
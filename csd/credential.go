@@ -0,0 +1,100 @@
+package csd
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/vanclief/ez"
+)
+
+// Credential is a SAT certificate together with its matching private key
+type Credential struct {
+	Certificate *Certificate
+	PrivateKey  *rsa.PrivateKey
+}
+
+// Load parses a certificate (.cer) and its private key (.key), and verifies
+// that the key belongs to the certificate
+func Load(certificate, privateKey []byte, password string) (*Credential, error) {
+	const op = "csd.Load"
+
+	cert, err := ParseCertificate(certificate)
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	key, err := ParsePrivateKey(privateKey, password)
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	credential := &Credential{Certificate: cert, PrivateKey: key}
+
+	if !credential.KeyMatches() {
+		return nil, ez.New(op, ez.EINVALID, "The private key does not belong to the certificate", nil)
+	}
+
+	return credential, nil
+}
+
+// LoadBase64 is like Load but takes base64 encoded files, as sent to the Facturama API
+func LoadBase64(certificate, privateKey, password string) (*Credential, error) {
+	const op = "csd.LoadBase64"
+
+	cert, err := base64.StdEncoding.DecodeString(certificate)
+	if err != nil {
+		return nil, ez.New(op, ez.EINVALID, "The certificate is not valid base64", err)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(privateKey)
+	if err != nil {
+		return nil, ez.New(op, ez.EINVALID, "The private key is not valid base64", err)
+	}
+
+	credential, err := Load(cert, key, password)
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	return credential, nil
+}
+
+// KeyMatches reports whether the private key belongs to the certificate
+func (credential *Credential) KeyMatches() bool {
+	publicKey, ok := credential.Certificate.Raw.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return false
+	}
+
+	return publicKey.Equal(&credential.PrivateKey.PublicKey)
+}
+
+// Verify checks that the credential can be used to seal CFDIs for the RFC at
+// the given time: it must be a CSD (not a FIEL), issued to the RFC and within
+// its validity window. An empty RFC skips the RFC check.
+func (credential *Credential) Verify(rfc string, at time.Time) error {
+	const op = "csd.Credential.Verify"
+
+	certificate := credential.Certificate
+
+	if !certificate.IsCSD() {
+		return ez.New(op, ez.EINVALID, "The certificate is a FIEL (e.firma), a CSD is required to seal CFDIs", nil)
+	}
+
+	if rfc != "" && !strings.EqualFold(strings.TrimSpace(rfc), certificate.RFC) {
+		return ez.New(op, ez.EINVALID, fmt.Sprintf("The certificate belongs to RFC %s, not %s", certificate.RFC, rfc), nil)
+	}
+
+	if at.Before(certificate.NotBefore) {
+		return ez.New(op, ez.EINVALID, fmt.Sprintf("The certificate is not valid until %s", certificate.NotBefore.Format(time.RFC3339)), nil)
+	}
+
+	if at.After(certificate.NotAfter) {
+		return ez.New(op, ez.EINVALID, fmt.Sprintf("The certificate expired on %s", certificate.NotAfter.Format(time.RFC3339)), nil)
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package csd_test
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vanclief/go-facturama/csd"
+	"github.com/vanclief/go-facturama/csd/csdtest"
+)
+
+func TestParseCertificate(t *testing.T) {
+	files, err := csdtest.Generate(csdtest.Options{CURP: "XIQB891116MGRMZR05"})
+	require.NoError(t, err)
+
+	certificate, err := csd.ParseCertificate(files.Certificate)
+	require.NoError(t, err)
+
+	assert.Equal(t, csdtest.DefaultRFC, certificate.RFC)
+	assert.Equal(t, "XIQB891116MGRMZR05", certificate.CURP)
+	assert.Equal(t, csdtest.DefaultNumber, certificate.Number)
+	assert.Equal(t, "ESCUELA KEMPER URGATE", certificate.Name)
+	assert.Equal(t, csd.TypeCSD, certificate.Type)
+	assert.True(t, certificate.IsValidAt(time.Now()))
+
+	_, err = csd.ParseCertificate([]byte("not a certificate"))
+	assert.Error(t, err)
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	files, err := csdtest.Generate(csdtest.Options{})
+	require.NoError(t, err)
+
+	key, err := csd.ParsePrivateKey(files.PrivateKey, files.Password)
+	require.NoError(t, err)
+	assert.True(t, key.Equal(files.Key))
+
+	_, err = csd.ParsePrivateKey(files.PrivateKey, "wrong password")
+	assert.Error(t, err, "Should not decrypt with a wrong password")
+
+	_, err = csd.ParsePrivateKeyBase64(files.PrivateKeyBase64(), files.Password)
+	assert.NoError(t, err)
+}
+
+func TestParsePrivateKeySAT(t *testing.T) {
+	// sat.key is encrypted the way the SAT issues keys: PBES2 with
+	// des-ede3-cbc and the default hmacWithSHA1 PBKDF2 PRF
+	data, err := os.ReadFile("testdata/sat.key")
+	require.NoError(t, err)
+
+	key, err := csd.ParsePrivateKey(data, "12345678a")
+	require.NoError(t, err)
+	require.NoError(t, key.Validate())
+
+	public, err := os.ReadFile("testdata/sat.pub.pem")
+	require.NoError(t, err)
+	block, _ := pem.Decode(public)
+	require.NotNil(t, block)
+	expected, err := x509.ParsePKIXPublicKey(block.Bytes)
+	require.NoError(t, err)
+	assert.True(t, key.PublicKey.Equal(expected))
+
+	_, err = csd.ParsePrivateKey(data, "wrong password")
+	assert.Error(t, err, "Should not decrypt with a wrong password")
+}
+
+func TestLoad(t *testing.T) {
+	files, err := csdtest.Generate(csdtest.Options{})
+	require.NoError(t, err)
+
+	credential, err := csd.LoadBase64(files.CertificateBase64(), files.PrivateKeyBase64(), files.Password)
+	require.NoError(t, err)
+	assert.NoError(t, credential.Verify(csdtest.DefaultRFC, time.Now()))
+	assert.Error(t, credential.Verify("XAXX010101000", time.Now()), "Should reject a different RFC")
+	assert.Error(t, credential.Verify("", time.Now().Add(2*365*24*time.Hour)), "Should reject an expired certificate")
+
+	// A key from a different pair must be rejected
+	other, err := csdtest.Generate(csdtest.Options{})
+	require.NoError(t, err)
+
+	_, err = csd.Load(files.Certificate, other.PrivateKey, other.Password)
+	assert.Error(t, err, "Should reject a mismatched key")
+}
+
+func TestFIEL(t *testing.T) {
+	files, err := csdtest.Generate(csdtest.Options{FIEL: true})
+	require.NoError(t, err)
+
+	credential, err := csd.Load(files.Certificate, files.PrivateKey, files.Password)
+	require.NoError(t, err)
+	assert.Equal(t, csd.TypeFIEL, credential.Certificate.Type)
+	assert.Error(t, credential.Verify(csdtest.DefaultRFC, time.Now()), "Should reject a FIEL")
+}
@@ -0,0 +1,128 @@
+// Package csdtest generates self-signed certificates and keys shaped like the
+// ones issued by the SAT, for use in tests.
+package csdtest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"math/big"
+	"time"
+
+	"github.com/vanclief/go-facturama/csd"
+)
+
+const (
+	// DefaultRFC is the RFC used when Options.RFC is empty, a SAT test RFC
+	DefaultRFC = "EKU9003173C9"
+	// DefaultNumber is the certificate number used when Options.Number is empty
+	DefaultNumber = "30001000000500003416"
+	// DefaultPassword is the private key password used when Options.Password is empty
+	DefaultPassword = "12345678a"
+)
+
+// Options configures the generated credential
+type Options struct {
+	RFC      string
+	CURP     string
+	Name     string
+	Number   string
+	Password string
+	// FIEL generates an e.firma certificate instead of a CSD
+	FIEL bool
+	// NotBefore and NotAfter default to a window of one year around now
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+// Files holds a generated certificate and private key as the SAT delivers them
+type Files struct {
+	// Certificate is the DER encoded certificate (.cer)
+	Certificate []byte
+	// PrivateKey is the DER encoded PKCS#8 encrypted private key (.key)
+	PrivateKey []byte
+	// Password of the private key
+	Password string
+	// Key is the unencrypted private key
+	Key *rsa.PrivateKey
+}
+
+// Generate creates a new certificate and private key
+func Generate(options Options) (*Files, error) {
+	if options.RFC == "" {
+		options.RFC = DefaultRFC
+	}
+	if options.Name == "" {
+		options.Name = "ESCUELA KEMPER URGATE"
+	}
+	if options.Number == "" {
+		options.Number = DefaultNumber
+	}
+	if options.Password == "" {
+		options.Password = DefaultPassword
+	}
+	if options.NotBefore.IsZero() {
+		options.NotBefore = time.Now().Add(-24 * time.Hour)
+	}
+	if options.NotAfter.IsZero() {
+		options.NotAfter = options.NotBefore.Add(365 * 24 * time.Hour)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	keyUsage := x509.KeyUsageDigitalSignature | x509.KeyUsageContentCommitment
+	if options.FIEL {
+		keyUsage |= x509.KeyUsageDataEncipherment | x509.KeyUsageKeyAgreement
+	}
+
+	subject := pkix.Name{
+		CommonName:   options.Name,
+		Organization: []string{options.Name},
+		ExtraNames: []pkix.AttributeTypeAndValue{
+			{Type: asn1.ObjectIdentifier{2, 5, 4, 45}, Value: options.RFC + " / "},
+			{Type: asn1.ObjectIdentifier{2, 5, 4, 5}, Value: " / " + options.CURP},
+		},
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: new(big.Int).SetBytes([]byte(options.Number)),
+		Subject:      subject,
+		Issuer:       pkix.Name{CommonName: "AC UAT"},
+		NotBefore:    options.NotBefore,
+		NotAfter:     options.NotAfter,
+		KeyUsage:     keyUsage,
+	}
+
+	certificate, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKey, err := csd.EncryptPrivateKey(key, options.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Files{
+		Certificate: certificate,
+		PrivateKey:  privateKey,
+		Password:    options.Password,
+		Key:         key,
+	}, nil
+}
+
+// CertificateBase64 returns the certificate encoded as sent to the Facturama API
+func (files *Files) CertificateBase64() string {
+	return base64.StdEncoding.EncodeToString(files.Certificate)
+}
+
+// PrivateKeyBase64 returns the private key encoded as sent to the Facturama API
+func (files *Files) PrivateKeyBase64() string {
+	return base64.StdEncoding.EncodeToString(files.PrivateKey)
+}
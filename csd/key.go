@@ -0,0 +1,300 @@
+package csd
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"hash"
+
+	"github.com/vanclief/ez"
+)
+
+var (
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidDESEDE3CBC     = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+	oidAES128CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// maxIterations caps the PBKDF2 iteration count read from a key file, so a
+// crafted key can't pin the CPU. SAT keys use 2048.
+const maxIterations = 1 << 20
+
+// encryptedPrivateKeyInfo is the PKCS#8 EncryptedPrivateKeyInfo structure
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+// pbes2Params are the PKCS#5 PBES2 parameters
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+// pbkdf2Params are the PKCS#5 PBKDF2 parameters
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// ParsePrivateKey decrypts and parses a SAT private key (.key), which is a
+// PKCS#8 encrypted RSA key in DER or PEM format. Unencrypted PKCS#8 keys are
+// also accepted, in which case the password is ignored.
+func ParsePrivateKey(data []byte, password string) (*rsa.PrivateKey, error) {
+	const op = "csd.ParsePrivateKey"
+
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+
+	var info encryptedPrivateKeyInfo
+	rest, err := asn1.Unmarshal(data, &info)
+	if err != nil || len(rest) > 0 {
+		// Not an encrypted key, try to parse it as a plain PKCS#8 key
+		key, err := x509.ParsePKCS8PrivateKey(data)
+		if err != nil {
+			return nil, ez.New(op, ez.EINVALID, "The private key is not a valid PKCS#8 key", err)
+		}
+		return rsaKey(op, key)
+	}
+
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, ez.New(op, ez.EINVALID, "The private key encryption algorithm is not supported", nil)
+	}
+
+	plain, err := decryptPBES2(info, []byte(password))
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(plain)
+	if err != nil {
+		return nil, ez.New(op, ez.EINVALID, "The private key password is incorrect", err)
+	}
+
+	return rsaKey(op, key)
+}
+
+// ParsePrivateKeyBase64 parses a base64 encoded SAT private key, as sent to the Facturama API
+func ParsePrivateKeyBase64(data, password string) (*rsa.PrivateKey, error) {
+	const op = "csd.ParsePrivateKeyBase64"
+
+	der, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, ez.New(op, ez.EINVALID, "The private key is not valid base64", err)
+	}
+
+	key, err := ParsePrivateKey(der, password)
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	return key, nil
+}
+
+// rsaKey asserts that a parsed PKCS#8 key is an RSA key, as required by the SAT
+func rsaKey(op string, key interface{}) (*rsa.PrivateKey, error) {
+	privateKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, ez.New(op, ez.EINVALID, "The private key is not an RSA key", nil)
+	}
+
+	return privateKey, nil
+}
+
+// decryptPBES2 decrypts a PBES2 protected PKCS#8 key
+func decryptPBES2(info encryptedPrivateKeyInfo, password []byte) ([]byte, error) {
+	const op = "csd.decryptPBES2"
+
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, ez.New(op, ez.EINVALID, "The private key encryption parameters are invalid", err)
+	}
+
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, ez.New(op, ez.EINVALID, "The private key derivation function is not supported", nil)
+	}
+
+	var kdf pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+		return nil, ez.New(op, ez.EINVALID, "The private key derivation parameters are invalid", err)
+	}
+
+	if kdf.IterationCount < 1 || kdf.IterationCount > maxIterations {
+		return nil, ez.New(op, ez.EINVALID, "The private key derivation iteration count is out of range", nil)
+	}
+
+	var prf func() hash.Hash
+	switch {
+	case len(kdf.PRF.Algorithm) == 0, kdf.PRF.Algorithm.Equal(oidHMACWithSHA1):
+		prf = sha1.New
+	case kdf.PRF.Algorithm.Equal(oidHMACWithSHA256):
+		prf = sha256.New
+	default:
+		return nil, ez.New(op, ez.EINVALID, "The private key derivation hash is not supported", nil)
+	}
+
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, ez.New(op, ez.EINVALID, "The private key encryption parameters are invalid", err)
+	}
+
+	var keyLength int
+	var newCipher func([]byte) (cipher.Block, error)
+	switch scheme := params.EncryptionScheme.Algorithm; {
+	case scheme.Equal(oidDESEDE3CBC):
+		keyLength, newCipher = 24, des.NewTripleDESCipher
+	case scheme.Equal(oidAES128CBC):
+		keyLength, newCipher = 16, aes.NewCipher
+	case scheme.Equal(oidAES192CBC):
+		keyLength, newCipher = 24, aes.NewCipher
+	case scheme.Equal(oidAES256CBC):
+		keyLength, newCipher = 32, aes.NewCipher
+	default:
+		return nil, ez.New(op, ez.EINVALID, "The private key encryption algorithm is not supported", nil)
+	}
+
+	key, err := deriveKey(prf, password, kdf.Salt, kdf.IterationCount, keyLength)
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	block, err := newCipher(key)
+	if err != nil {
+		return nil, ez.New(op, ez.EINTERNAL, "Error creating the private key cipher", err)
+	}
+
+	if len(iv) != block.BlockSize() || len(info.EncryptedData)%block.BlockSize() != 0 || len(info.EncryptedData) == 0 {
+		return nil, ez.New(op, ez.EINVALID, "The encrypted private key is malformed", nil)
+	}
+
+	plain := make([]byte, len(info.EncryptedData))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, info.EncryptedData)
+
+	plain, ok := unpad(plain, block.BlockSize())
+	if !ok {
+		return nil, ez.New(op, ez.EINVALID, "The private key password is incorrect", nil)
+	}
+
+	return plain, nil
+}
+
+// unpad removes PKCS#7 padding
+func unpad(data []byte, blockSize int) ([]byte, bool) {
+	if len(data) == 0 {
+		return nil, false
+	}
+
+	padding := int(data[len(data)-1])
+	if padding == 0 || padding > blockSize || padding > len(data) {
+		return nil, false
+	}
+
+	for _, b := range data[len(data)-padding:] {
+		if int(b) != padding {
+			return nil, false
+		}
+	}
+
+	return data[:len(data)-padding], true
+}
+
+// deriveKey derives a key from a password with PBKDF2 as defined in RFC 8018
+func deriveKey(prf func() hash.Hash, password, salt []byte, iterations, keyLength int) ([]byte, error) {
+	const op = "csd.deriveKey"
+
+	key, err := pbkdf2.Key(prf, string(password), salt, iterations, keyLength)
+	if err != nil {
+		return nil, ez.New(op, ez.EINTERNAL, "Error deriving the private key encryption key", err)
+	}
+
+	return key, nil
+}
+
+// EncryptPrivateKey encrypts an RSA private key as a PKCS#8 PBES2 key using
+// PBKDF2 with HMAC-SHA256 and AES-256-CBC, producing a DER .key file
+func EncryptPrivateKey(key *rsa.PrivateKey, password string) ([]byte, error) {
+	const op = "csd.EncryptPrivateKey"
+
+	plain, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, ez.New(op, ez.EINTERNAL, "Error marshaling the private key", err)
+	}
+
+	salt := make([]byte, 16)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, ez.New(op, ez.EINTERNAL, "Error generating the salt", err)
+	}
+	if _, err := rand.Read(iv); err != nil {
+		return nil, ez.New(op, ez.EINTERNAL, "Error generating the initialization vector", err)
+	}
+
+	const iterations = 2048
+	derived, err := deriveKey(sha256.New, []byte(password), salt, iterations, 32)
+	if err != nil {
+		return nil, ez.Wrap(op, err)
+	}
+
+	block, err := aes.NewCipher(derived)
+	if err != nil {
+		return nil, ez.New(op, ez.EINTERNAL, "Error creating the private key cipher", err)
+	}
+
+	padding := block.BlockSize() - len(plain)%block.BlockSize()
+	for i := 0; i < padding; i++ {
+		plain = append(plain, byte(padding))
+	}
+
+	encrypted := make([]byte, len(plain))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, plain)
+
+	kdfParams, err := asn1.Marshal(pbkdf2Params{
+		Salt:           salt,
+		IterationCount: iterations,
+		PRF:            pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue},
+	})
+	if err != nil {
+		return nil, ez.New(op, ez.EINTERNAL, "Error marshaling the key derivation parameters", err)
+	}
+
+	ivParams, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, ez.New(op, ez.EINTERNAL, "Error marshaling the encryption parameters", err)
+	}
+
+	params, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdfParams}},
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParams}},
+	})
+	if err != nil {
+		return nil, ez.New(op, ez.EINTERNAL, "Error marshaling the encryption parameters", err)
+	}
+
+	der, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm:     pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: params}},
+		EncryptedData: encrypted,
+	})
+	if err != nil {
+		return nil, ez.New(op, ez.EINTERNAL, "Error marshaling the encrypted private key", err)
+	}
+
+	return der, nil
+}
@@ -0,0 +1,74 @@
+package csd
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeriveKey(t *testing.T) {
+	// Known answers from RFC 6070
+	cases := []struct {
+		password, salt string
+		iterations     int
+		expected       string
+	}{
+		{"password", "salt", 1, "0c60c80f961f0e71f3a9b524af6012062fe037a6"},
+		{"password", "salt", 2, "ea6c014dc72d6f8ccd1ed92ace1d41f0d8de8957"},
+		{"password", "salt", 4096, "4b007901b765489abead49d926f721d065a429c1"},
+		{"passwordPASSWORDpassword", "saltSALTsaltSALTsaltSALTsaltSALTsalt", 4096, "3d2eec4fe41c849b80c8d83662c0e44a8b291a964cf2f07038"},
+	}
+
+	for _, c := range cases {
+		expected, err := hex.DecodeString(c.expected)
+		require.NoError(t, err)
+
+		key, err := deriveKey(sha1.New, []byte(c.password), []byte(c.salt), c.iterations, len(expected))
+		require.NoError(t, err)
+		assert.Equal(t, expected, key, "%s/%s/%d", c.password, c.salt, c.iterations)
+	}
+}
+
+func TestParsePrivateKeyIterations(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	encrypted, err := EncryptPrivateKey(privateKey, "12345678a")
+	require.NoError(t, err)
+
+	for _, iterations := range []int{0, maxIterations + 1} {
+		var info encryptedPrivateKeyInfo
+		_, err := asn1.Unmarshal(encrypted, &info)
+		require.NoError(t, err)
+
+		var params pbes2Params
+		_, err = asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params)
+		require.NoError(t, err)
+
+		var kdf pbkdf2Params
+		_, err = asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf)
+		require.NoError(t, err)
+
+		kdf.IterationCount = iterations
+		kdfParams, err := asn1.Marshal(kdf)
+		require.NoError(t, err)
+		params.KeyDerivationFunc.Parameters = asn1.RawValue{FullBytes: kdfParams}
+
+		encoded, err := asn1.Marshal(params)
+		require.NoError(t, err)
+		info.Algorithm = pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: encoded}}
+
+		data, err := asn1.Marshal(info)
+		require.NoError(t, err)
+
+		_, err = ParsePrivateKey(data, "12345678a")
+		assert.Error(t, err, "Should reject %d iterations", iterations)
+	}
+}
@@ -0,0 +1,82 @@
+package decimal
+
+import (
+	"strings"
+	"sync"
+)
+
+// DefaultCurrencyPlaces is the precision used for currencies without a known precision
+const DefaultCurrencyPlaces = 2
+
+var (
+	currencyMu sync.RWMutex
+
+	// currencyPlaces holds the decimal places of the currencies that do not use
+	// two decimals, as listed in the Decimales column of the SAT c_Moneda catalog
+	currencyPlaces = map[string]int{
+		"BHD": 3,
+		"BIF": 0,
+		"CLF": 4,
+		"CLP": 0,
+		"DJF": 0,
+		"GNF": 0,
+		"IQD": 3,
+		"ISK": 0,
+		"JOD": 3,
+		"JPY": 0,
+		"KMF": 0,
+		"KRW": 0,
+		"KWD": 3,
+		"LYD": 3,
+		"OMR": 3,
+		"PYG": 0,
+		"RWF": 0,
+		"TND": 3,
+		"UGX": 0,
+		"UYI": 0,
+		"VND": 0,
+		"VUV": 0,
+		"XAF": 0,
+		"XAG": 0,
+		"XAU": 0,
+		"XBA": 0,
+		"XBB": 0,
+		"XBC": 0,
+		"XBD": 0,
+		"XDR": 0,
+		"XOF": 0,
+		"XPD": 0,
+		"XPF": 0,
+		"XPT": 0,
+		"XSU": 0,
+		"XTS": 0,
+		"XUA": 0,
+		"XXX": 0,
+	}
+)
+
+// CurrencyPlaces returns the number of decimal places used by a currency
+// (ISO 4217 code). Unknown currencies use DefaultCurrencyPlaces.
+func CurrencyPlaces(currency string) int {
+	currencyMu.RLock()
+	defer currencyMu.RUnlock()
+
+	if places, ok := currencyPlaces[strings.ToUpper(currency)]; ok {
+		return places
+	}
+
+	return DefaultCurrencyPlaces
+}
+
+// SetCurrencyPlaces overrides the number of decimal places used by a currency
+func SetCurrencyPlaces(currency string, places int) {
+	currencyMu.Lock()
+	defer currencyMu.Unlock()
+
+	currencyPlaces[strings.ToUpper(currency)] = clampPlaces(places)
+}
+
+// RoundCurrency returns d rounded half away from zero to the decimal places of the currency
+func (d Decimal) RoundCurrency(currency string) Decimal {
+	return d.Round(CurrencyPlaces(currency))
+}
@@ -0,0 +1,415 @@
+// Package decimal provides an exact fixed-point number for CFDI amounts,
+// quantities, rates and exchange rates, avoiding the rounding drift of float64.
+package decimal
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/vanclief/ez"
+)
+
+// Places is the number of decimal places stored by a Decimal, the highest
+// precision used by Anexo 20 (Cantidad, ValorUnitario and TasaOCuota)
+const Places = 6
+
+// scale is 10^Places
+const scale = 1_000_000
+
+// Decimal is an exact fixed-point number with six decimal places. Values are
+// stored as an integer number of millionths, so the supported range is about
+// ±9.2 trillion. Arithmetic that leaves the range panics with ErrOverflow
+// rather than wrapping around, see Recover. The zero value is 0.
+type Decimal struct {
+	units int64
+}
+
+// ErrOverflow is the panic value of arithmetic whose result is out of range
+var ErrOverflow = errors.New("decimal: overflow")
+
+var (
+	// Zero is the decimal 0
+	Zero = Decimal{}
+	// One is the decimal 1
+	One = Decimal{units: scale}
+	// Hundred is the decimal 100
+	Hundred = Decimal{units: 100 * scale}
+)
+
+// New returns value × 10^-places, e.g. New(11599, 2) is 115.99. Digits
+// beyond six decimal places are rounded half away from zero.
+func New(value int64, places int) Decimal {
+	if places < 0 {
+		return Decimal{units: mul(mul(value, pow10(-places)), scale)}
+	}
+
+	if places <= Places {
+		return Decimal{units: mul(value, pow10(Places-places))}
+	}
+
+	return Decimal{units: roundDiv(big.NewInt(value), bigPow10(places-Places))}
+}
+
+// NewFromInt returns the decimal for an integer
+func NewFromInt(value int64) Decimal {
+	return Decimal{units: mul(value, scale)}
+}
+
+// NewFromFloat returns the decimal closest to a float64, rounded to six
+// decimal places. It is the compatibility path for code using float64 amounts.
+func NewFromFloat(value float64) Decimal {
+	d, err := NewFromString(strconv.FormatFloat(value, 'f', Places, 64))
+	if err != nil {
+		return Zero
+	}
+
+	return d
+}
+
+// NewFromString parses a decimal such as "116", "-0.16" or "1.5e2". Digits
+// beyond six decimal places are rounded half away from zero.
+func NewFromString(value string) (Decimal, error) {
+	const op = "decimal.NewFromString"
+
+	s := strings.TrimSpace(value)
+	if s == "" {
+		return Zero, ez.New(op, ez.EINVALID, "Empty decimal value", nil)
+	}
+
+	rat, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return Zero, ez.New(op, ez.EINVALID, fmt.Sprintf("Invalid decimal value %q", value), nil)
+	}
+
+	scaled := new(big.Rat).Mul(rat, new(big.Rat).SetInt64(scale))
+	units := roundRat(scaled)
+	if !units.IsInt64() {
+		return Zero, ez.New(op, ez.EINVALID, fmt.Sprintf("Decimal value %q is out of range", value), nil)
+	}
+
+	return Decimal{units: units.Int64()}, nil
+}
+
+// RequireFromString is like NewFromString but panics if the value is invalid.
+// It is intended for constants and tests.
+func RequireFromString(value string) Decimal {
+	d, err := NewFromString(value)
+	if err != nil {
+		panic(err)
+	}
+
+	return d
+}
+
+// Sum returns the sum of the given decimals
+func Sum(values ...Decimal) Decimal {
+	var total Decimal
+	for _, value := range values {
+		total = total.Add(value)
+	}
+
+	return total
+}
+
+// Add returns d + other
+func (d Decimal) Add(other Decimal) Decimal {
+	return Decimal{units: add(d.units, other.units)}
+}
+
+// Sub returns d - other
+func (d Decimal) Sub(other Decimal) Decimal {
+	return Decimal{units: sub(d.units, other.units)}
+}
+
+// Neg returns -d
+func (d Decimal) Neg() Decimal {
+	return Decimal{units: sub(0, d.units)}
+}
+
+// Abs returns |d|
+func (d Decimal) Abs() Decimal {
+	if d.units < 0 {
+		return d.Neg()
+	}
+
+	return d
+}
+
+// Mul returns d × other rounded half away from zero to six decimal places
+func (d Decimal) Mul(other Decimal) Decimal {
+	return d.MulRound(other, Places)
+}
+
+// MulRound returns d × other rounded half away from zero to the given
+// decimal places, rounding the exact product only once
+func (d Decimal) MulRound(other Decimal, places int) Decimal {
+	product := new(big.Int).Mul(big.NewInt(d.units), big.NewInt(other.units))
+	divisor := bigPow10(2*Places - clampPlaces(places))
+
+	return Decimal{units: mul(roundDiv(product, divisor), pow10(Places-clampPlaces(places)))}
+}
+
+// Div returns d ÷ other rounded half away from zero to six decimal places.
+// It panics if other is zero or the result is out of range.
+func (d Decimal) Div(other Decimal) Decimal {
+	if other.units == 0 {
+		panic("decimal: division by zero")
+	}
+
+	numerator := new(big.Int).Mul(big.NewInt(d.units), big.NewInt(scale))
+	return Decimal{units: roundDiv(numerator, big.NewInt(other.units))}
+}
+
+// Round returns d rounded half away from zero to the given decimal places (0 to 6)
+func (d Decimal) Round(places int) Decimal {
+	places = clampPlaces(places)
+	factor := pow10(Places - places)
+
+	return Decimal{units: mul(roundDiv(big.NewInt(d.units), big.NewInt(factor)), factor)}
+}
+
+// Truncate returns d with the digits beyond the given decimal places dropped
+func (d Decimal) Truncate(places int) Decimal {
+	factor := pow10(Places - clampPlaces(places))
+	return Decimal{units: d.units / factor * factor}
+}
+
+// Cmp returns -1, 0 or +1 depending on whether d is less than, equal to or greater than other
+func (d Decimal) Cmp(other Decimal) int {
+	switch {
+	case d.units < other.units:
+		return -1
+	case d.units > other.units:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Equal reports whether d == other
+func (d Decimal) Equal(other Decimal) bool {
+	return d.units == other.units
+}
+
+// LessThan reports whether d < other
+func (d Decimal) LessThan(other Decimal) bool {
+	return d.units < other.units
+}
+
+// GreaterThan reports whether d > other
+func (d Decimal) GreaterThan(other Decimal) bool {
+	return d.units > other.units
+}
+
+// IsZero reports whether d is 0. It also lets `omitzero` JSON tags skip zero values.
+func (d Decimal) IsZero() bool {
+	return d.units == 0
+}
+
+// IsNegative reports whether d < 0
+func (d Decimal) IsNegative() bool {
+	return d.units < 0
+}
+
+// Sign returns -1, 0 or +1 depending on the sign of d
+func (d Decimal) Sign() int {
+	return d.Cmp(Zero)
+}
+
+// Places returns the number of significant decimal places of d
+func (d Decimal) Places() int {
+	places := Places
+	for units := d.units; places > 0 && units%10 == 0; units /= 10 {
+		places--
+	}
+
+	return places
+}
+
+// Float64 returns the nearest float64 to d, for code still using float amounts
+func (d Decimal) Float64() float64 {
+	f, _ := strconv.ParseFloat(d.String(), 64)
+	return f
+}
+
+// String returns d without trailing zeros, e.g. "116", "0.16" or "-3.5"
+func (d Decimal) String() string {
+	return d.StringFixed(d.Places())
+}
+
+// StringFixed returns d rounded and padded to exactly the given decimal
+// places, e.g. StringFixed(2) of 116 is "116.00"
+func (d Decimal) StringFixed(places int) string {
+	places = clampPlaces(places)
+	rounded := d.Round(places).units
+
+	sign := ""
+	if rounded < 0 {
+		sign = "-"
+	}
+
+	abs := uint64(rounded)
+	if rounded < 0 {
+		abs = uint64(-rounded)
+	}
+
+	integer := abs / scale
+	fraction := abs % scale
+
+	if places == 0 {
+		return fmt.Sprintf("%s%d", sign, integer)
+	}
+
+	digits := fmt.Sprintf("%06d", fraction)[:places]
+	return fmt.Sprintf("%s%d.%s", sign, integer, digits)
+}
+
+// MarshalJSON encodes d as a JSON number
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalJSON decodes a JSON number, a quoted number or null
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	const op = "decimal.UnmarshalJSON"
+
+	s := strings.Trim(string(data), `"`)
+	if s == "null" || s == "" {
+		*d = Zero
+		return nil
+	}
+
+	value, err := NewFromString(s)
+	if err != nil {
+		return ez.Wrap(op, err)
+	}
+
+	*d = value
+	return nil
+}
+
+// MarshalText encodes d as text, used by XML attributes
+func (d Decimal) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText decodes d from text, used by XML attributes
+func (d *Decimal) UnmarshalText(text []byte) error {
+	const op = "decimal.UnmarshalText"
+
+	if len(strings.TrimSpace(string(text))) == 0 {
+		*d = Zero
+		return nil
+	}
+
+	value, err := NewFromString(string(text))
+	if err != nil {
+		return ez.Wrap(op, err)
+	}
+
+	*d = value
+	return nil
+}
+
+// Recover turns an ErrOverflow panic into an EINVALID error in *err. Deferred
+// by code computing amounts from user input:
+//
+//	defer decimal.Recover(op, &err)
+func Recover(op string, err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	if r != ErrOverflow {
+		panic(r)
+	}
+
+	*err = ez.New(op, ez.EINVALID, "The amount is out of the decimal range", ErrOverflow)
+}
+
+// clampPlaces limits the decimal places to the supported range
+func clampPlaces(places int) int {
+	if places < 0 {
+		return 0
+	}
+	if places > Places {
+		return Places
+	}
+
+	return places
+}
+
+// pow10 returns 10^n for small n
+func pow10(n int) int64 {
+	return int64(math.Pow10(n))
+}
+
+// bigPow10 returns 10^n
+func bigPow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+// roundDiv returns n ÷ divisor rounded half away from zero
+func roundDiv(n, divisor *big.Int) int64 {
+	quotient := roundRat(new(big.Rat).SetFrac(n, divisor))
+	if !quotient.IsInt64() {
+		panic(ErrOverflow)
+	}
+
+	return quotient.Int64()
+}
+
+// add returns a + b, panicking with ErrOverflow if it does not fit
+func add(a, b int64) int64 {
+	sum := a + b
+	if (sum > a) != (b > 0) {
+		panic(ErrOverflow)
+	}
+
+	return sum
+}
+
+// sub returns a - b, panicking with ErrOverflow if it does not fit
+func sub(a, b int64) int64 {
+	difference := a - b
+	if (difference < a) != (b > 0) {
+		panic(ErrOverflow)
+	}
+
+	return difference
+}
+
+// mul returns a × b, panicking with ErrOverflow if it does not fit
+func mul(a, b int64) int64 {
+	if a == 0 || b == 0 {
+		return 0
+	}
+
+	product := a * b
+	if product/b != a || (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) {
+		panic(ErrOverflow)
+	}
+
+	return product
+}
+
+// roundRat rounds a rational half away from zero
+func roundRat(r *big.Rat) *big.Int {
+	num := new(big.Int).Abs(r.Num())
+	den := r.Denom()
+
+	quotient, remainder := new(big.Int).QuoRem(num, den, new(big.Int))
+	if new(big.Int).Mul(remainder, big.NewInt(2)).Cmp(den) >= 0 {
+		quotient.Add(quotient, big.NewInt(1))
+	}
+
+	if r.Sign() < 0 {
+		quotient.Neg(quotient)
+	}
+
+	return quotient
+}
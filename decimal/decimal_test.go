@@ -0,0 +1,115 @@
+package decimal
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vanclief/ez"
+)
+
+func TestNewFromString(t *testing.T) {
+	cases := map[string]string{
+		"116":         "116",
+		"116.00":      "116",
+		"0.16":        "0.16",
+		"-3.50":       "-3.5",
+		"1.5e2":       "150",
+		"0.1234565":   "0.123457",
+		"-0.1234565":  "-0.123457",
+		"0.0000004":   "0",
+		" 12.345678 ": "12.345678",
+	}
+
+	for input, expected := range cases {
+		d, err := NewFromString(input)
+		require.NoError(t, err, input)
+		assert.Equal(t, expected, d.String(), input)
+	}
+
+	_, err := NewFromString("abc")
+	assert.Error(t, err)
+}
+
+func TestArithmetic(t *testing.T) {
+	// The classic float64 drift: 0.1 + 0.2
+	assert.Equal(t, "0.3", RequireFromString("0.1").Add(RequireFromString("0.2")).String())
+
+	price := RequireFromString("99.99")
+	quantity := RequireFromString("3")
+	assert.Equal(t, "299.97", price.Mul(quantity).String())
+
+	// 16% of 115.99 is 18.5584, rounded to cents once
+	tax := RequireFromString("115.99").MulRound(RequireFromString("0.16"), 2)
+	assert.Equal(t, "18.56", tax.String())
+
+	assert.Equal(t, "0.333333", One.Div(NewFromInt(3)).String())
+	assert.Equal(t, "-0.666667", NewFromInt(-2).Div(NewFromInt(3)).String())
+
+	assert.Equal(t, "2.5", New(25, 1).String())
+	assert.Equal(t, "115.99", New(11599, 2).String())
+	assert.Equal(t, "1200", New(12, -2).String())
+}
+
+func TestOverflow(t *testing.T) {
+	max := RequireFromString("9223372036854.775807")
+
+	assert.PanicsWithValue(t, ErrOverflow, func() { max.Add(RequireFromString("0.000001")) })
+	assert.PanicsWithValue(t, ErrOverflow, func() { max.Neg().Sub(RequireFromString("0.000002")) })
+	assert.PanicsWithValue(t, ErrOverflow, func() { max.Mul(NewFromInt(2)) })
+	assert.PanicsWithValue(t, ErrOverflow, func() { max.Div(RequireFromString("0.5")) })
+	assert.PanicsWithValue(t, ErrOverflow, func() { NewFromInt(10_000_000_000_000) })
+	assert.Equal(t, "9223372036854.775806", max.Sub(RequireFromString("0.000001")).String())
+
+	calculate := func() (err error) {
+		defer Recover("decimal_test", &err)
+		max.Add(max)
+		return nil
+	}
+	err := calculate()
+	require.Error(t, err)
+	assert.Equal(t, ez.EINVALID, ez.ErrorCode(err))
+}
+
+func TestRound(t *testing.T) {
+	assert.Equal(t, "2.35", RequireFromString("2.345").Round(2).String())
+	assert.Equal(t, "-2.35", RequireFromString("-2.345").Round(2).String())
+	assert.Equal(t, "2.34", RequireFromString("2.345").Truncate(2).String())
+	assert.Equal(t, "116.00", NewFromInt(116).StringFixed(2))
+	assert.Equal(t, "0.160000", RequireFromString("0.16").StringFixed(6))
+
+	assert.Equal(t, "1235", RequireFromString("1234.5").RoundCurrency("JPY").String())
+	assert.Equal(t, "1.235", RequireFromString("1.2345").RoundCurrency("KWD").String())
+	assert.Equal(t, "1.23", RequireFromString("1.2345").RoundCurrency("MXN").String())
+
+	SetCurrencyPlaces("XTS", 4)
+	assert.Equal(t, 4, CurrencyPlaces("xts"))
+}
+
+func TestFloatCompatibility(t *testing.T) {
+	assert.Equal(t, "115.99", NewFromFloat(115.99).String())
+	assert.Equal(t, "0.16", NewFromFloat(0.16).String())
+	assert.Equal(t, 115.99, RequireFromString("115.99").Float64())
+}
+
+func TestJSON(t *testing.T) {
+	type payload struct {
+		Total    Decimal `json:"Total"`
+		Discount Decimal `json:"Discount,omitzero"`
+	}
+
+	data, err := json.Marshal(payload{Total: RequireFromString("116.00")})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"Total":116}`, string(data))
+
+	var decoded payload
+	require.NoError(t, json.Unmarshal([]byte(`{"Total":115.99,"Discount":"0.5"}`), &decoded))
+	assert.Equal(t, "115.99", decoded.Total.String())
+	assert.Equal(t, "0.5", decoded.Discount.String())
+
+	require.NoError(t, json.Unmarshal([]byte(`{"Total":null}`), &decoded))
+	assert.True(t, decoded.Total.IsZero())
+
+	assert.Error(t, json.Unmarshal([]byte(`{"Total":"abc"}`), &decoded))
+}
@@ -0,0 +1,94 @@
+package rfc
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// curpPattern splits a CURP into name letters, birth date, sex, state,
+// consonants, differentiator and check digit
+var curpPattern = regexp.MustCompile(`^([A-Z][AEIOUX][A-Z]{2})([0-9]{6})([HMX])([A-Z]{2})([B-DF-HJ-NP-TV-Z]{3})([0-9A-Z])([0-9])$`)
+
+// curpStates are the birth state codes of the CURP, NE is born abroad
+var curpStates = map[string]bool{
+	"AS": true, "BC": true, "BS": true, "CC": true, "CL": true, "CM": true,
+	"CS": true, "CH": true, "DF": true, "DG": true, "GT": true, "GR": true,
+	"HG": true, "JC": true, "MC": true, "MN": true, "MS": true, "NT": true,
+	"NL": true, "OC": true, "PL": true, "QT": true, "QR": true, "SP": true,
+	"SL": true, "SR": true, "TC": true, "TS": true, "TL": true, "VZ": true,
+	"YN": true, "ZS": true, "NE": true,
+}
+
+// curpValues are the values of the characters used to compute the CURP check digit
+const curpValues = "0123456789ABCDEFGHIJKLMNÑOPQRSTUVWXYZ"
+
+// CURP is a parsed CURP (Clave Única de Registro de Población)
+type CURP struct {
+	// Value is the normalized CURP
+	Value string
+	// BirthDate is the birth date embedded in the CURP
+	BirthDate time.Time
+	// Sex is H (hombre), M (mujer) or X (no binario)
+	Sex string
+	// State is the birth state code, NE for people born abroad
+	State string
+}
+
+// ParseCURP validates a CURP and returns its parts. It checks the length, the
+// structure, the birth date, the state code and the check digit. Errors are
+// EINVALID and carry a Reason.
+func ParseCURP(value string) (CURP, error) {
+	const op = "rfc.ParseCURP"
+
+	normalized := Normalize(value)
+	if normalized == "" {
+		return CURP{}, invalid(op, ReasonEmpty, "CURP is required")
+	}
+
+	chars := []rune(normalized)
+	if len(chars) != 18 {
+		return CURP{}, invalid(op, ReasonLength, fmt.Sprintf("CURP %s must have 18 characters", normalized))
+	}
+
+	parts := curpPattern.FindStringSubmatch(normalized)
+	if parts == nil {
+		return CURP{}, invalid(op, ReasonFormat, fmt.Sprintf("CURP %s does not have a valid structure", normalized))
+	}
+
+	// The differentiator is a digit for people born before 2000 and a letter after
+	century := "20"
+	if parts[6][0] >= '0' && parts[6][0] <= '9' {
+		century = "19"
+	}
+
+	birthDate, ok := parseDate(parts[2], century)
+	if !ok {
+		return CURP{}, invalid(op, ReasonDate, fmt.Sprintf("CURP %s has an invalid birth date", normalized))
+	}
+
+	if !curpStates[parts[4]] {
+		return CURP{}, invalid(op, ReasonState, fmt.Sprintf("CURP %s has an invalid state code %s", normalized, parts[4]))
+	}
+
+	sum := 0
+	for i, c := range chars[:17] {
+		sum += runeIndex(curpValues, c) * (18 - i)
+	}
+	if digit := (10 - sum%10) % 10; rune('0'+digit) != chars[17] {
+		return CURP{}, invalid(op, ReasonCheckDigit, fmt.Sprintf("CURP %s has an invalid check digit", normalized))
+	}
+
+	return CURP{Value: normalized, BirthDate: birthDate, Sex: parts[3], State: parts[4]}, nil
+}
+
+// ValidateCURP returns an error if the CURP is not valid, see ParseCURP
+func ValidateCURP(value string) error {
+	_, err := ParseCURP(value)
+	return err
+}
+
+// IsValidCURP reports whether the CURP is valid, see ParseCURP
+func IsValidCURP(value string) bool {
+	return ValidateCURP(value) == nil
+}
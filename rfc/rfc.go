@@ -0,0 +1,227 @@
+// Package rfc validates Mexican tax identifiers: the RFC (Registro Federal de
+// Contribuyentes) of personas físicas and morales, the generic RFCs used for
+// the general public and foreign residents, and the CURP.
+package rfc
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/vanclief/ez"
+)
+
+// Generic RFCs accepted by the SAT in place of the receiver RFC
+const (
+	// GenericNational is used for sales to the general public (público en general)
+	GenericNational = "XAXX010101000"
+	// GenericForeign is used for foreign residents without a Mexican RFC
+	GenericForeign = "XEXX010101000"
+)
+
+// Type is the kind of taxpayer an RFC belongs to
+type Type string
+
+// RFC types
+const (
+	// TypeFisica is a persona física (individual), 13 characters
+	TypeFisica Type = "fisica"
+	// TypeMoral is a persona moral (legal entity), 12 characters
+	TypeMoral Type = "moral"
+)
+
+// Reason explains why an RFC or CURP is invalid. It is attached to the
+// returned errors and can be read with ErrorReason.
+type Reason string
+
+// Validation reasons
+const (
+	ReasonEmpty      Reason = "empty"
+	ReasonLength     Reason = "length"
+	ReasonFormat     Reason = "format"
+	ReasonDate       Reason = "date"
+	ReasonState      Reason = "state"
+	ReasonCheckDigit Reason = "check_digit"
+	ReasonGeneric    Reason = "generic"
+)
+
+// reasonKey is the ez error data key holding the Reason
+const reasonKey = "reason"
+
+// rfcPattern splits an RFC into name letters, date, homoclave and check digit
+var rfcPattern = regexp.MustCompile(`^([A-ZÑ&]{3,4})([0-9]{6})([A-Z0-9]{2})([0-9A])$`)
+
+// RFC is a parsed RFC
+type RFC struct {
+	// Value is the normalized RFC: upper case without spaces or dashes
+	Value string
+	Type  Type
+	// Date is the birth or incorporation date embedded in the RFC
+	Date time.Time
+}
+
+// IsGeneric reports whether the RFC is one of the generic RFCs
+func (r RFC) IsGeneric() bool {
+	return r.Value == GenericNational || r.Value == GenericForeign
+}
+
+// IsForeign reports whether the RFC is the generic RFC for foreign residents
+func (r RFC) IsForeign() bool {
+	return r.Value == GenericForeign
+}
+
+// IsFisica reports whether the RFC belongs to a persona física
+func (r RFC) IsFisica() bool {
+	return r.Type == TypeFisica
+}
+
+// IsMoral reports whether the RFC belongs to a persona moral
+func (r RFC) IsMoral() bool {
+	return r.Type == TypeMoral
+}
+
+// String returns the normalized RFC
+func (r RFC) String() string {
+	return r.Value
+}
+
+// Normalize returns the RFC in upper case without spaces or dashes
+func Normalize(value string) string {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	return strings.NewReplacer(" ", "", "-", "").Replace(value)
+}
+
+// Parse validates an RFC and returns its parts. It checks the length for a
+// persona física (13) or moral (12), the structure, the embedded date and the
+// homoclave check digit. The generic RFCs are accepted as personas físicas.
+// Errors are EINVALID and carry a Reason.
+func Parse(value string) (RFC, error) {
+	const op = "rfc.Parse"
+
+	normalized := Normalize(value)
+	if normalized == "" {
+		return RFC{}, invalid(op, ReasonEmpty, "RFC is required")
+	}
+
+	if normalized == GenericNational || normalized == GenericForeign {
+		return RFC{Value: normalized, Type: TypeFisica, Date: time.Date(2001, time.January, 1, 0, 0, 0, 0, time.UTC)}, nil
+	}
+
+	chars := []rune(normalized)
+	if len(chars) != 12 && len(chars) != 13 {
+		return RFC{}, invalid(op, ReasonLength, fmt.Sprintf("RFC %s must have 12 (persona moral) or 13 (persona física) characters", normalized))
+	}
+
+	parts := rfcPattern.FindStringSubmatch(normalized)
+	if parts == nil {
+		return RFC{}, invalid(op, ReasonFormat, fmt.Sprintf("RFC %s does not have a valid structure", normalized))
+	}
+
+	// RFCs do not encode the century, dates in the future belong to the 1900s
+	date, ok := parseDate(parts[2], "20")
+	if ok && date.After(time.Now()) {
+		date, ok = parseDate(parts[2], "19")
+	}
+	if !ok {
+		return RFC{}, invalid(op, ReasonDate, fmt.Sprintf("RFC %s has an invalid date", normalized))
+	}
+
+	if checkDigit(chars) != chars[len(chars)-1] {
+		return RFC{}, invalid(op, ReasonCheckDigit, fmt.Sprintf("RFC %s has an invalid check digit", normalized))
+	}
+
+	rfcType := TypeMoral
+	if len(chars) == 13 {
+		rfcType = TypeFisica
+	}
+
+	return RFC{Value: normalized, Type: rfcType, Date: date}, nil
+}
+
+// Validate returns an error if the RFC is not valid, see Parse
+func Validate(value string) error {
+	_, err := Parse(value)
+	return err
+}
+
+// ValidateIssuer is like Validate but also rejects the generic RFCs, which
+// can only identify receivers
+func ValidateIssuer(value string) error {
+	const op = "rfc.ValidateIssuer"
+
+	parsed, err := Parse(value)
+	if err != nil {
+		return ez.Wrap(op, err)
+	}
+
+	if parsed.IsGeneric() {
+		return invalid(op, ReasonGeneric, fmt.Sprintf("RFC %s is a generic RFC", parsed.Value))
+	}
+
+	return nil
+}
+
+// IsValid reports whether the RFC is valid, see Parse
+func IsValid(value string) bool {
+	return Validate(value) == nil
+}
+
+// ErrorReason returns the Reason attached to an error returned by this
+// package, or "" if there is none
+func ErrorReason(err error) Reason {
+	reason, _ := ez.ErrorData(err)[reasonKey].(Reason)
+	return reason
+}
+
+// rfcValues are the values of the characters used to compute the RFC check digit
+const rfcValues = "0123456789ABCDEFGHIJKLMN&OPQRSTUVWXYZ Ñ"
+
+// checkDigit computes the check digit of an RFC (modulo 11 over the first
+// 12 characters, morales are padded with a leading space)
+func checkDigit(chars []rune) rune {
+	body := chars[:len(chars)-1]
+	if len(body) == 11 {
+		body = append([]rune{' '}, body...)
+	}
+
+	sum := 0
+	for i, c := range body {
+		sum += runeIndex(rfcValues, c) * (13 - i)
+	}
+
+	switch digit := 11 - sum%11; digit {
+	case 11:
+		return '0'
+	case 10:
+		return 'A'
+	default:
+		return rune('0' + digit)
+	}
+}
+
+// parseDate validates a YYMMDD date in the given century ("19" or "20")
+func parseDate(value, century string) (time.Time, bool) {
+	date, err := time.Parse("20060102", century+value)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return date, true
+}
+
+// runeIndex returns the position of a character in a dictionary, counted in characters
+func runeIndex(dictionary string, c rune) int {
+	for i, r := range []rune(dictionary) {
+		if r == c {
+			return i
+		}
+	}
+
+	return 0
+}
+
+// invalid returns an EINVALID error carrying the reason
+func invalid(op string, reason Reason, message string) error {
+	return ez.New(op, ez.EINVALID, message, nil).AddData(reasonKey, reason)
+}
@@ -0,0 +1,70 @@
+package rfc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	moral, err := Parse("EKU9003173C9")
+	require.NoError(t, err)
+	assert.True(t, moral.IsMoral())
+	assert.Equal(t, time.Date(1990, time.March, 17, 0, 0, 0, 0, time.UTC), moral.Date)
+
+	fisica, err := Parse(" cacx-760510-1p8 ")
+	require.NoError(t, err)
+	assert.True(t, fisica.IsFisica())
+	assert.Equal(t, "CACX7605101P8", fisica.Value)
+
+	generic, err := Parse(GenericForeign)
+	require.NoError(t, err)
+	assert.True(t, generic.IsGeneric())
+	assert.True(t, generic.IsForeign())
+	assert.Equal(t, ReasonGeneric, ErrorReason(ValidateIssuer(GenericNational)))
+
+	assert.True(t, IsValid("URE180429TM6"))
+	assert.True(t, IsValid("XIA190128J61"))
+}
+
+func TestParseReasons(t *testing.T) {
+	cases := map[string]Reason{
+		"":              ReasonEmpty,
+		"NOSOYUNRFC":    ReasonLength,
+		"EKU90031X3C9":  ReasonFormat,
+		"EKU9002303C9":  ReasonDate,
+		"EKU9003173C8":  ReasonCheckDigit,
+		"CACX7613101P8": ReasonDate,
+	}
+
+	for value, reason := range cases {
+		err := Validate(value)
+		require.Error(t, err, value)
+		assert.Equal(t, reason, ErrorReason(err), value)
+	}
+}
+
+func TestParseCURP(t *testing.T) {
+	curp, err := ParseCURP("MAHJ280603MSPRRV09")
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(1928, time.June, 3, 0, 0, 0, 0, time.UTC), curp.BirthDate)
+	assert.Equal(t, "M", curp.Sex)
+	assert.Equal(t, "SP", curp.State)
+
+	cases := map[string]Reason{
+		"":                   ReasonEmpty,
+		"MAHJ280603MSPRRV0":  ReasonLength,
+		"MAHJ280603QSPRRV09": ReasonFormat,
+		"MAHJ280631MSPRRV09": ReasonDate,
+		"MAHJ280603MZZRRV09": ReasonState,
+		"MAHJ280603MSPRRV08": ReasonCheckDigit,
+	}
+
+	for value, reason := range cases {
+		err := ValidateCURP(value)
+		require.Error(t, err, value)
+		assert.Equal(t, reason, ErrorReason(err), value)
+	}
+}
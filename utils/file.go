@@ -49,3 +49,10 @@ func Base64EncodeToFile(inputPath, outputPath string) error {
 	// Use our writer function
 	return FileToBase64Writer(inputPath, out)
 }
+
+// Base64ToWriter decodes the base64 content read from encoded and writes it to
+// the provided writer, returning the number of decoded bytes written
+func Base64ToWriter(encoded io.Reader, output io.Writer) (int64, error) {
+	decoder := base64.NewDecoder(base64.StdEncoding, encoded)
+	return io.Copy(output, decoder)
+}